@@ -13,15 +13,33 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/rehmatworks/fastcp/internal/analytics"
 	"github.com/rehmatworks/fastcp/internal/api"
+	"github.com/rehmatworks/fastcp/internal/audit"
 	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/bandwidth"
 	"github.com/rehmatworks/fastcp/internal/caddy"
 	"github.com/rehmatworks/fastcp/internal/config"
 	"github.com/rehmatworks/fastcp/internal/database"
+	"github.com/rehmatworks/fastcp/internal/events"
 	"github.com/rehmatworks/fastcp/internal/jail"
+	"github.com/rehmatworks/fastcp/internal/ldap"
+	"github.com/rehmatworks/fastcp/internal/limits"
+	"github.com/rehmatworks/fastcp/internal/metrics"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/oidc"
+	"github.com/rehmatworks/fastcp/internal/packages"
 	"github.com/rehmatworks/fastcp/internal/php"
+	"github.com/rehmatworks/fastcp/internal/quota"
+	"github.com/rehmatworks/fastcp/internal/ratelimit"
+	"github.com/rehmatworks/fastcp/internal/rbac"
+	"github.com/rehmatworks/fastcp/internal/sessions"
+	"github.com/rehmatworks/fastcp/internal/sftp"
 	"github.com/rehmatworks/fastcp/internal/sites"
 	"github.com/rehmatworks/fastcp/internal/ssl"
+	"github.com/rehmatworks/fastcp/internal/teams"
+	"github.com/rehmatworks/fastcp/internal/twofactor"
 	"github.com/rehmatworks/fastcp/internal/upgrade"
 )
 
@@ -36,6 +54,15 @@ var (
 func main() {
 	flag.Parse()
 
+	// sshd starts jailed shells through the fastcp binary, as the user
+	if flag.Arg(0) == "jail-shell" {
+		if err := jail.ExecShell(os.Getenv("SSH_ORIGINAL_COMMAND")); err != nil {
+			fmt.Fprintln(os.Stderr, "fastcp:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Setup logger
 	logLevel := slog.LevelInfo
 	if *devMode {
@@ -84,6 +111,9 @@ func main() {
 
 	// Secure the base sites directory
 	sites.SecureBaseDirectory(cfg.SitesDir)
+	if err := siteManager.SecureLogDirectories(); err != nil {
+		logger.Warn("Failed to secure site log directories", "error", err)
+	}
 
 	// Setup SSH jail for SFTP-only users
 	if !config.IsDevMode() {
@@ -95,6 +125,10 @@ func main() {
 		} else {
 			logger.Info("SSH jail configuration verified")
 		}
+		// Place SSH/SFTP sessions into the user's cgroup
+		if err := limits.SetupSessionHook(); err != nil {
+			logger.Warn("Failed to setup cgroup session hook", "error", err)
+		}
 	}
 
 	// Initialize Caddy generator
@@ -139,6 +173,10 @@ func main() {
 
 	// Initialize per-user PHP manager
 	userPHPManager := php.NewUserPHPManager()
+	userPHPManager.SetSitesFunc(siteManager.GetAll)
+	userPHPManager.SetSandboxFunc(func(username string) bool {
+		return siteManager.GetUserLimit(username).Sandbox
+	})
 	// Recover any existing user PHP instances from PID files
 	if err := userPHPManager.RecoverInstances(); err != nil {
 		logger.Warn("Failed to recover user PHP instances", "error", err)
@@ -173,9 +211,136 @@ func main() {
 		logger,
 	)
 
+	// Background jobs stop when FastCP shuts down
+	bgCtx, bgCancel := context.WithCancel(context.Background())
+	defer bgCancel()
+
+	// Aggregate per-site access logs into hourly analytics buckets
+	analyticsCollector := analytics.NewCollector(cfg.DataDir, siteManager.GetAll, logger)
+	if err := analyticsCollector.Load(); err != nil {
+		logger.Warn("Failed to load analytics state", "error", err)
+	}
+
+	// Account monthly bandwidth per user and enforce bandwidth limits
+	bandwidthManager := bandwidth.NewManager(cfg.DataDir, siteManager, logger)
+	if err := bandwidthManager.Load(); err != nil {
+		logger.Warn("Failed to load bandwidth enforcement state", "error", err)
+	}
+	bandwidthManager.SetReloadFunc(phpManager.Reload)
+
+	analyticsCollector.Start(bgCtx, 5*time.Minute)
+	bandwidthManager.Start(bgCtx, 5*time.Minute)
+	apiServer.SetAnalytics(analyticsCollector)
+	apiServer.SetBandwidth(bandwidthManager)
+
+	// Enforce per-user disk limits with filesystem quotas or periodic scans
+	quotaMonitor := quota.NewMonitor(siteManager.GetUserLimit, siteManager.ListUserLimits, logger)
+	siteManager.SetDiskUsageFunc(quotaMonitor.UsedMB)
+	quotaMonitor.Start(bgCtx, 15*time.Minute)
+	apiServer.SetQuota(quotaMonitor)
+
+	// Record per-user resource usage history from the user cgroups
+	sampleInterval := time.Duration(cfg.UsageSampleSeconds) * time.Second
+	if sampleInterval <= 0 {
+		sampleInterval = 30 * time.Second
+	}
+	usageSampler := metrics.NewSampler(cfg.DataDir, sampleInterval, logger)
+	usageSampler.SetDiskUsageFunc(quotaMonitor.UsedMB)
+
+	// Record and notify about OOM kills and other limit hits seen by the sampler
+	eventManager := events.NewManager(cfg.DataDir, siteManager.GetUserLimit, logger)
+	usageSampler.OnSample(eventManager.Observe)
+
+	usageSampler.Start(bgCtx)
+	apiServer.SetUsageSampler(usageSampler)
+	apiServer.SetEvents(eventManager)
+
+	// Hosting packages bundle limits and features assigned to users
+	packageManager := packages.NewManager(cfg.DataDir)
+	if err := packageManager.Load(); err != nil {
+		logger.Warn("Failed to load packages", "error", err)
+	}
+	apiServer.SetPackages(packageManager)
+
+	// TOTP two-factor authentication for panel logins
+	twoFactorManager := twofactor.NewManager(cfg.DataDir)
+	if err := twoFactorManager.Load(); err != nil {
+		logger.Warn("Failed to load two-factor settings", "error", err)
+	}
+	apiServer.SetTwoFactor(twoFactorManager)
+
+	// Security key and passkey logins
+	webAuthn := auth.NewWebAuthn(cfg.DataDir)
+	if err := webAuthn.Load(); err != nil {
+		logger.Warn("Failed to load WebAuthn credentials", "error", err)
+	}
+	apiServer.SetWebAuthn(webAuthn)
+
+	// Revocable sessions with short-lived access tokens
+	sessionManager := sessions.NewManager(cfg.DataDir)
+	if err := sessionManager.Load(); err != nil {
+		logger.Warn("Failed to load sessions", "error", err)
+	}
+	apiServer.SetSessions(sessionManager)
+	middleware.SetSessionValidator(apiServer.SessionActive)
+
+	// Brute-force protection for logins and API keys
+	auditLog := audit.NewLog(cfg.DataDir)
+	apiServer.SetAudit(auditLog)
+	limiter, err := ratelimit.New(cfg.RateLimit)
+	if err != nil {
+		logger.Warn("Invalid rate limit settings, using defaults", "error", err)
+		limiter, _ = ratelimit.New(config.DefaultConfig().RateLimit)
+	}
+	apiServer.SetRateLimiter(limiter)
+
+	// OpenID Connect single sign-on, used when enabled in the config
+	apiServer.SetOIDC(oidc.NewProvider())
+
+	// LDAP directory logins, tried before local accounts when enabled
+	auth.SetDirectoryAuthenticator(ldap.Authenticator(func() models.LDAPSettings {
+		return config.Get().LDAP
+	}))
+	auth.SetUserProvisioner(apiServer.ProvisionDirectoryUser)
+
 	// Set API key validator
 	auth.SetAPIKeyValidator(api.ValidateAPIKey)
 
+	// Impersonation tokens end early when stopped or no longer allowed
+	middleware.SetImpersonationChecker(apiServer.ImpersonationActive)
+
+	// Custom roles add permissions to the built-in admin, reseller and user roles
+	roleManager := rbac.NewManager(cfg.DataDir)
+	if err := roleManager.Load(); err != nil {
+		logger.Warn("Failed to load roles", "error", err)
+	}
+	apiServer.SetRoles(roleManager)
+	middleware.SetPermissionChecker(apiServer.HasPermission)
+
+	// Team members get delegated access to other users' sites
+	teamManager := teams.NewManager(cfg.DataDir)
+	if err := teamManager.Load(); err != nil {
+		logger.Warn("Failed to load teams", "error", err)
+	}
+	apiServer.SetTeams(teamManager)
+
+	// Built-in SFTP server confining users to their web root
+	if cfg.SFTP.Enabled {
+		sftpServer, err := sftp.NewServer(cfg.SFTP, cfg.DataDir, logger)
+		if err != nil {
+			logger.Error("Failed to start SFTP server", "error", err)
+		} else {
+			sftpServer.SetQuota(quotaMonitor)
+			sftpServer.SetAudit(auditLog.Record)
+			sftpServer.SetLimiter(limiter)
+			go func() {
+				if err := sftpServer.ListenAndServe(bgCtx); err != nil {
+					logger.Error("SFTP server stopped", "error", err)
+				}
+			}()
+		}
+	}
+
 	// Setup HTTP server
 	server := &http.Server{
 		Addr:         cfg.ListenAddr,
@@ -262,6 +427,7 @@ func main() {
 	<-quit
 
 	logger.Info("Shutting down FastCP...")
+	bgCancel()
 
 	// Graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -9,6 +9,9 @@ require (
 	github.com/go-chi/cors v1.2.1
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/google/uuid v1.6.0
+	golang.org/x/crypto v0.46.0
+	golang.org/x/net v0.48.0
+	golang.org/x/sys v0.39.0
 )
 
 require github.com/msteinert/pam v1.2.0 // indirect
@@ -18,11 +21,8 @@ require (
 	github.com/go-acme/lego/v4 v4.30.1
 	github.com/go-jose/go-jose/v4 v4.1.3 // indirect
 	github.com/miekg/dns v1.1.69 // indirect
-	golang.org/x/crypto v0.46.0 // indirect
 	golang.org/x/mod v0.30.0 // indirect
-	golang.org/x/net v0.48.0 // indirect
 	golang.org/x/sync v0.19.0 // indirect
-	golang.org/x/sys v0.39.0 // indirect
 	golang.org/x/text v0.32.0 // indirect
 	golang.org/x/tools v0.39.0 // indirect
 )
@@ -246,8 +246,8 @@ install_dependencies() {
     
     $PKG_UPDATE
     
-    # Core dependencies
-    DEPS="curl acl"
+    # Core dependencies, bubblewrap and git for jailed shells
+    DEPS="curl acl bubblewrap git"
     
     $PKG_INSTALL $DEPS
     
@@ -0,0 +1,652 @@
+// Package analytics aggregates per-site proxy access logs into hourly
+// buckets stored on disk and answers traffic queries over them.
+package analytics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sitelogs"
+)
+
+// RetentionDays is how long hourly buckets are kept on disk
+var RetentionDays = 90
+
+// maxTopEntries caps the paths/referrers kept per bucket
+const maxTopEntries = 200
+
+// LatencyBounds are the upper bounds (ms) of the latency histogram buckets.
+// The histogram has one extra bucket for anything slower than the last bound.
+var LatencyBounds = []float64{1, 2, 3, 5, 7, 10, 15, 20, 30, 50, 75, 100, 150, 200, 300, 500, 750, 1000, 1500, 2000, 3000, 5000, 10000, 30000}
+
+// Bucket holds the traffic of one site during one hour
+type Bucket struct {
+	Hour      time.Time        `json:"hour"`
+	Requests  int64            `json:"requests"`
+	Bytes     int64            `json:"bytes"`
+	Status    map[string]int64 `json:"status"`
+	Paths     map[string]int64 `json:"paths"`
+	Referrers map[string]int64 `json:"referrers"`
+	Latency   []int64          `json:"latency"`
+}
+
+func newBucket(hour time.Time) *Bucket {
+	return &Bucket{
+		Hour:      hour,
+		Status:    make(map[string]int64),
+		Paths:     make(map[string]int64),
+		Referrers: make(map[string]int64),
+		Latency:   make([]int64, len(LatencyBounds)+1),
+	}
+}
+
+// add records one access log entry
+func (b *Bucket) add(e *sitelogs.Entry) {
+	b.Requests++
+	b.Bytes += e.Size
+	b.Status[strconv.Itoa(e.Status)]++
+	if p := e.Path(); p != "" {
+		b.Paths[p]++
+	}
+	if ref := refererHost(e); ref != "" {
+		b.Referrers[ref]++
+	}
+	b.Latency[latencyIndex(e.Duration*1000)]++
+}
+
+// merge adds the counts of o into b
+func (b *Bucket) merge(o *Bucket) {
+	b.Requests += o.Requests
+	b.Bytes += o.Bytes
+	for k, v := range o.Status {
+		b.Status[k] += v
+	}
+	for k, v := range o.Paths {
+		b.Paths[k] += v
+	}
+	for k, v := range o.Referrers {
+		b.Referrers[k] += v
+	}
+	for i := range o.Latency {
+		if i < len(b.Latency) {
+			b.Latency[i] += o.Latency[i]
+		}
+	}
+}
+
+// trim keeps only the most frequent paths and referrers
+func (b *Bucket) trim() {
+	b.Paths = topMap(b.Paths, maxTopEntries)
+	b.Referrers = topMap(b.Referrers, maxTopEntries)
+}
+
+// Count is a key with its number of occurrences
+type Count struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// Point is one entry of a report time series
+type Point struct {
+	Time     time.Time `json:"time"`
+	Requests int64     `json:"requests"`
+	Bytes    int64     `json:"bytes"`
+}
+
+// Report summarizes the traffic of a site over a time range
+type Report struct {
+	SiteID        string           `json:"site_id"`
+	From          time.Time        `json:"from"`
+	To            time.Time        `json:"to"`
+	Interval      string           `json:"interval"` // hour or day
+	Requests      int64            `json:"requests"`
+	Bytes         int64            `json:"bytes"`
+	StatusCodes   map[string]int64 `json:"status_codes"`
+	StatusClasses map[string]int64 `json:"status_classes"`
+	TopPaths      []Count          `json:"top_paths"`
+	TopReferrers  []Count          `json:"top_referrers"`
+	P50Ms         float64          `json:"p50_ms"`
+	P95Ms         float64          `json:"p95_ms"`
+	Series        []Point          `json:"series"`
+}
+
+// Position is how far a site's access log has been read
+type Position struct {
+	Offset int64  `json:"offset"`
+	Inode  uint64 `json:"inode"`
+}
+
+// Collector incrementally reads site access logs into hourly buckets
+type Collector struct {
+	dir      string
+	getSites func() []models.Site
+	logger   *slog.Logger
+
+	mu    sync.Mutex
+	state map[string]*Position
+}
+
+// NewCollector creates a collector storing buckets under dataDir/analytics
+func NewCollector(dataDir string, getSites func() []models.Site, logger *slog.Logger) *Collector {
+	return &Collector{
+		dir:      filepath.Join(dataDir, "analytics"),
+		getSites: getSites,
+		logger:   logger,
+		state:    make(map[string]*Position),
+	}
+}
+
+// Load reads the collector state from disk
+func (c *Collector) Load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := os.ReadFile(c.statePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, &c.state)
+}
+
+// Start collects periodically until ctx is done
+func (c *Collector) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := c.Collect(); err != nil {
+				c.logger.Warn("analytics collection failed", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Collect ingests new access log lines of all sites
+func (c *Collector) Collect() error {
+	var errs []string
+	for _, site := range c.getSites() {
+		if err := c.CollectSite(site); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", site.ID, err))
+		}
+	}
+	c.prune()
+	if len(errs) > 0 {
+		return fmt.Errorf("errors collecting analytics: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// CollectSite ingests new access log lines of one site
+func (c *Collector) CollectSite(site models.Site) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.state[site.ID]
+	if st == nil {
+		st = &Position{}
+		c.state[site.ID] = st
+	}
+
+	buckets := make(map[time.Time]*Bucket)
+	pos, err := ReadLog(site.ID, *st, func(e *sitelogs.Entry) {
+		hour := e.Timestamp().UTC().Truncate(time.Hour)
+		b := buckets[hour]
+		if b == nil {
+			b = newBucket(hour)
+			buckets[hour] = b
+		}
+		b.add(e)
+	})
+	if err != nil {
+		return err
+	}
+	if pos == *st {
+		return nil
+	}
+
+	if err := c.mergeBuckets(site.ID, buckets); err != nil {
+		return err
+	}
+	*st = pos
+	return c.saveStateUnlocked()
+}
+
+// ReadLog passes the entries appended to a site's access log since pos to
+// fn and returns the position just past the last complete line. When the
+// log was rolled since, the rest of the rolled file is read first.
+func ReadLog(siteID string, pos Position, fn func(*sitelogs.Entry)) (Position, error) {
+	path := sitelogs.AccessLogPath(siteID)
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return pos, nil
+		}
+		return pos, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return pos, err
+	}
+
+	// A new inode or a shorter file means the log was rotated
+	ino := inode(info)
+	if pos.Inode != ino || info.Size() < pos.Offset {
+		if pos.Inode != 0 && pos.Inode != ino {
+			if rotated := findRotated(path, pos.Inode); rotated != nil {
+				_, _ = readEntries(rotated, pos.Offset, fn)
+				rotated.Close()
+			}
+		}
+		pos = Position{Inode: ino}
+	}
+	if info.Size() == pos.Offset {
+		return pos, nil
+	}
+
+	pos.Offset, err = readEntries(file, pos.Offset, fn)
+	return pos, err
+}
+
+// readEntries passes the entries of complete lines from offset to fn and
+// returns the offset just past the last complete line
+func readEntries(file *os.File, offset int64, fn func(*sitelogs.Entry)) (int64, error) {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return offset, nil // partial lines are read on the next run
+		}
+		offset += int64(len(line))
+
+		e, perr := sitelogs.ParseEntry(line)
+		if perr != nil || e.Status == 0 {
+			continue
+		}
+		fn(e)
+	}
+}
+
+// findRotated opens the rotated sibling of path that has the given inode
+func findRotated(path string, ino uint64) *os.File {
+	ext := filepath.Ext(path)
+	pattern := strings.TrimSuffix(path, ext) + "-*" + ext
+	matches, _ := filepath.Glob(pattern)
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil || inode(info) != ino {
+			continue
+		}
+		if f, err := os.Open(m); err == nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// Query builds a report for a site between from and to
+func (c *Collector) Query(siteID string, from, to time.Time) (*Report, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from = from.UTC().Truncate(time.Hour)
+	to = to.UTC()
+
+	interval := "hour"
+	step := time.Hour
+	if to.Sub(from) > 7*24*time.Hour {
+		interval = "day"
+		step = 24 * time.Hour
+	}
+
+	report := &Report{
+		SiteID:        siteID,
+		From:          from,
+		To:            to,
+		Interval:      interval,
+		StatusCodes:   make(map[string]int64),
+		StatusClasses: make(map[string]int64),
+		TopPaths:      []Count{},
+		TopReferrers:  []Count{},
+	}
+
+	total := newBucket(from)
+	series := make(map[time.Time]*Point)
+	for day := from.Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		buckets, err := c.loadDay(siteID, day)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range buckets {
+			if b.Hour.Before(from) || b.Hour.After(to) {
+				continue
+			}
+			total.merge(b)
+			slot := b.Hour.Truncate(step)
+			p := series[slot]
+			if p == nil {
+				p = &Point{Time: slot}
+				series[slot] = p
+			}
+			p.Requests += b.Requests
+			p.Bytes += b.Bytes
+		}
+	}
+
+	// Emit a continuous series so gaps show up as zero
+	for t := from.Truncate(step); !t.After(to); t = t.Add(step) {
+		p := series[t]
+		if p == nil {
+			p = &Point{Time: t}
+		}
+		report.Series = append(report.Series, *p)
+	}
+
+	report.Requests = total.Requests
+	report.Bytes = total.Bytes
+	for code, n := range total.Status {
+		report.StatusCodes[code] = n
+		if len(code) == 3 {
+			report.StatusClasses[code[:1]+"xx"] += n
+		}
+	}
+	report.TopPaths = topCounts(total.Paths, 10)
+	report.TopReferrers = topCounts(total.Referrers, 10)
+	report.P50Ms = percentile(total.Latency, 0.50)
+	report.P95Ms = percentile(total.Latency, 0.95)
+
+	return report, nil
+}
+
+// DeleteSite removes all stored analytics of a site
+func (c *Collector) DeleteSite(siteID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.state, siteID)
+	if err := os.RemoveAll(c.siteDir(siteID)); err != nil {
+		return err
+	}
+	return c.saveStateUnlocked()
+}
+
+// mergeBuckets adds new buckets to the day files on disk (caller must hold lock)
+func (c *Collector) mergeBuckets(siteID string, buckets map[time.Time]*Bucket) error {
+	byDay := make(map[time.Time][]*Bucket)
+	for hour, b := range buckets {
+		day := hour.Truncate(24 * time.Hour)
+		byDay[day] = append(byDay[day], b)
+	}
+
+	for day, added := range byDay {
+		existing, err := c.loadDay(siteID, day)
+		if err != nil {
+			return err
+		}
+		index := make(map[time.Time]*Bucket, len(existing))
+		for _, b := range existing {
+			index[b.Hour] = b
+		}
+		for _, b := range added {
+			if cur, ok := index[b.Hour]; ok {
+				cur.merge(b)
+			} else {
+				merged := newBucket(b.Hour)
+				merged.merge(b)
+				index[b.Hour] = merged
+				existing = append(existing, merged)
+			}
+		}
+		sort.Slice(existing, func(i, j int) bool { return existing[i].Hour.Before(existing[j].Hour) })
+		for _, b := range existing {
+			b.trim()
+		}
+		if err := c.saveDay(siteID, day, existing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadDay reads the buckets of one day (caller must hold lock)
+func (c *Collector) loadDay(siteID string, day time.Time) ([]*Bucket, error) {
+	data, err := os.ReadFile(c.dayPath(siteID, day))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var buckets []*Bucket
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return nil, fmt.Errorf("corrupt analytics file %s: %w", c.dayPath(siteID, day), err)
+	}
+	for _, b := range buckets {
+		if b.Status == nil {
+			b.Status = make(map[string]int64)
+		}
+		if b.Paths == nil {
+			b.Paths = make(map[string]int64)
+		}
+		if b.Referrers == nil {
+			b.Referrers = make(map[string]int64)
+		}
+		if len(b.Latency) < len(LatencyBounds)+1 {
+			b.Latency = append(b.Latency, make([]int64, len(LatencyBounds)+1-len(b.Latency))...)
+		}
+	}
+	return buckets, nil
+}
+
+// saveDay writes the buckets of one day (caller must hold lock)
+func (c *Collector) saveDay(siteID string, day time.Time, buckets []*Bucket) error {
+	if err := os.MkdirAll(c.siteDir(siteID), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(buckets)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.dayPath(siteID, day), data, 0644)
+}
+
+// saveStateUnlocked persists read offsets (caller must hold lock)
+func (c *Collector) saveStateUnlocked() error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.statePath(), data, 0644)
+}
+
+// prune removes day files older than the retention period
+func (c *Collector) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -RetentionDays).Format("2006-01-02")
+	files, _ := filepath.Glob(filepath.Join(c.dir, "*", "*.json"))
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".json")
+		if len(name) == 10 && name < cutoff {
+			_ = os.Remove(f)
+		}
+	}
+}
+
+func (c *Collector) statePath() string {
+	return filepath.Join(c.dir, "state.json")
+}
+
+func (c *Collector) siteDir(siteID string) string {
+	return filepath.Join(c.dir, filepath.Base(siteID))
+}
+
+func (c *Collector) dayPath(siteID string, day time.Time) string {
+	return filepath.Join(c.siteDir(siteID), day.Format("2006-01-02")+".json")
+}
+
+// ErrInvalidRange is returned for unparsable or out-of-bounds ranges
+var ErrInvalidRange = errors.New("invalid range (use e.g. 24h, 7d or 30d)")
+
+// ParseRange parses a range such as "24h", "7d" or "30d" that must not
+// exceed max. Hours and days are supported.
+func ParseRange(s string, max time.Duration) (time.Duration, error) {
+	if len(s) < 2 {
+		return 0, ErrInvalidRange
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return 0, ErrInvalidRange
+	}
+	var d time.Duration
+	switch s[len(s)-1] {
+	case 'h':
+		d = time.Duration(n) * time.Hour
+	case 'd':
+		d = time.Duration(n) * 24 * time.Hour
+	default:
+		return 0, ErrInvalidRange
+	}
+	if d > max {
+		return 0, ErrInvalidRange
+	}
+	return d, nil
+}
+
+// latencyIndex returns the histogram bucket for a latency in ms
+func latencyIndex(ms float64) int {
+	for i, bound := range LatencyBounds {
+		if ms <= bound {
+			return i
+		}
+	}
+	return len(LatencyBounds)
+}
+
+// percentile estimates a latency percentile (ms) from the histogram by
+// interpolating linearly within the bucket that contains it
+func percentile(hist []int64, p float64) float64 {
+	var total int64
+	for _, n := range hist {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := p * float64(total)
+	var cum int64
+	for i, n := range hist {
+		if n == 0 {
+			continue
+		}
+		if float64(cum+n) >= rank {
+			lower := 0.0
+			if i > 0 {
+				lower = LatencyBounds[i-1]
+			}
+			if i >= len(LatencyBounds) {
+				return lower // open-ended bucket
+			}
+			upper := LatencyBounds[i]
+			return lower + (upper-lower)*(rank-float64(cum))/float64(n)
+		}
+		cum += n
+	}
+	return LatencyBounds[len(LatencyBounds)-1]
+}
+
+// refererHost returns the host of an external referer
+func refererHost(e *sitelogs.Entry) string {
+	ref := e.Referer()
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == strings.ToLower(stripPort(e.Request.Host)) {
+		return "" // internal navigation
+	}
+	return host
+}
+
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// topCounts returns the n most frequent keys
+func topCounts(m map[string]int64, n int) []Count {
+	counts := make([]Count, 0, len(m))
+	for k, v := range m {
+		counts = append(counts, Count{Key: k, Count: v})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Key < counts[j].Key
+	})
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// topMap keeps the n most frequent keys of a map
+func topMap(m map[string]int64, n int) map[string]int64 {
+	if len(m) <= n {
+		return m
+	}
+	out := make(map[string]int64, n)
+	for _, c := range topCounts(m, n) {
+		out[c.Key] = c.Count
+	}
+	return out
+}
+
+// inode returns the inode number of a file, used to detect rotation
+func inode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,160 @@
+package analytics
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sitelogs"
+)
+
+func setupCollector(t *testing.T, site models.Site) *Collector {
+	t.Helper()
+	os.Setenv("FASTCP_DEV", "1")
+	t.Cleanup(func() { os.Unsetenv("FASTCP_DEV") })
+
+	cfg, _ := config.Load("")
+	cfg.DataDir = t.TempDir()
+	cfg.LogDir = t.TempDir()
+	config.Update(cfg)
+
+	if err := os.MkdirAll(sitelogs.Dir(site.ID), 0755); err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewCollector(cfg.DataDir, func() []models.Site { return []models.Site{site} }, logger)
+}
+
+func writeEntry(t *testing.T, path string, ts time.Time, status int, uri, referer string, size int64, ms float64) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	headers := "{}"
+	if referer != "" {
+		headers = fmt.Sprintf(`{"Referer":[%q]}`, referer)
+	}
+	fmt.Fprintf(f, `{"level":"info","ts":%f,"msg":"handled request","request":{"host":"example.com","uri":%q,"headers":%s},"duration":%f,"size":%d,"status":%d}`+"\n",
+		float64(ts.UnixNano())/1e9, uri, headers, ms/1000, size, status)
+}
+
+func TestCollectAndQuery(t *testing.T) {
+	site := models.Site{ID: "site-1", Domain: "example.com"}
+	c := setupCollector(t, site)
+	path := sitelogs.AccessLogPath(site.ID)
+
+	now := time.Now().UTC()
+	earlier := now.Add(-3 * time.Hour)
+	for i := 0; i < 90; i++ {
+		writeEntry(t, path, earlier, 200, "/", "https://google.com/search?q=x", 1000, 10)
+	}
+	for i := 0; i < 10; i++ {
+		writeEntry(t, path, now, 404, "/missing?x=1", "https://example.com/", 100, 800)
+	}
+
+	if err := c.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	// A second run must not count the same lines again
+	if err := c.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	report, err := c.Query(site.ID, now.Add(-24*time.Hour), now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	if report.Requests != 100 || report.Bytes != 91000 {
+		t.Fatalf("expected 100 requests / 91000 bytes, got %d / %d", report.Requests, report.Bytes)
+	}
+	if report.StatusCodes["200"] != 90 || report.StatusClasses["4xx"] != 10 {
+		t.Fatalf("unexpected status distribution: %v %v", report.StatusCodes, report.StatusClasses)
+	}
+	if len(report.TopPaths) != 2 || report.TopPaths[0].Key != "/" || report.TopPaths[1].Key != "/missing" {
+		t.Fatalf("unexpected top paths: %v", report.TopPaths)
+	}
+	// Self-referrals are not counted
+	if len(report.TopReferrers) != 1 || report.TopReferrers[0].Key != "google.com" {
+		t.Fatalf("unexpected top referrers: %v", report.TopReferrers)
+	}
+	if report.P50Ms <= 7 || report.P50Ms > 10 {
+		t.Fatalf("expected p50 within the 7-10ms bucket, got %v", report.P50Ms)
+	}
+	if report.P95Ms <= 750 || report.P95Ms > 1000 {
+		t.Fatalf("expected p95 within the 750-1000ms bucket, got %v", report.P95Ms)
+	}
+	if report.Interval != "hour" || len(report.Series) < 24 {
+		t.Fatalf("expected hourly series, got %s with %d points", report.Interval, len(report.Series))
+	}
+
+	// Appended lines and rotation are picked up incrementally
+	writeEntry(t, path, now, 200, "/", "", 50, 5)
+	if err := os.Rename(path, filepath.Join(sitelogs.Dir(site.ID), "access-2026-01-01T00-00-00.000.log")); err != nil {
+		t.Fatal(err)
+	}
+	writeEntry(t, path, now, 200, "/", "", 50, 5)
+	if err := c.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	report, _ = c.Query(site.ID, now.Add(-24*time.Hour), now.Add(time.Minute))
+	if report.Requests != 102 {
+		t.Fatalf("expected 102 requests after rotation, got %d", report.Requests)
+	}
+}
+
+func TestCollectRotationMidRead(t *testing.T) {
+	site := models.Site{ID: "site-2", Domain: "example.com"}
+	c := setupCollector(t, site)
+	path := sitelogs.AccessLogPath(site.ID)
+	now := time.Now().UTC()
+
+	// Two lines and the start of a third that is still being written
+	writeEntry(t, path, now, 200, "/", "", 10, 5)
+	writeEntry(t, path, now, 200, "/", "", 10, 5)
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	fmt.Fprint(f, `{"level":"info","ts":`)
+	if err := c.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	// The line is finished and another written before the log is rolled
+	fmt.Fprintf(f, `%f,"msg":"handled request","request":{"host":"example.com","uri":"/"},"size":10,"status":200}`+"\n", float64(now.Unix()))
+	f.Close()
+	writeEntry(t, path, now, 200, "/", "", 10, 5)
+	if err := os.Rename(path, filepath.Join(sitelogs.Dir(site.ID), "access-2026-01-01T00-00-00.000.log")); err != nil {
+		t.Fatal(err)
+	}
+	writeEntry(t, path, now, 200, "/", "", 10, 5)
+	if err := c.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	report, _ := c.Query(site.ID, now.Add(-time.Hour), now.Add(time.Minute))
+	if report.Requests != 5 || report.Bytes != 50 {
+		t.Fatalf("expected 5 requests / 50 bytes across the rotation, got %d / %d", report.Requests, report.Bytes)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	max := 90 * 24 * time.Hour
+	if d, err := ParseRange("7d", max); err != nil || d != 7*24*time.Hour {
+		t.Fatalf("ParseRange(7d) = %v, %v", d, err)
+	}
+	if d, err := ParseRange("24h", max); err != nil || d != 24*time.Hour {
+		t.Fatalf("ParseRange(24h) = %v, %v", d, err)
+	}
+	for _, bad := range []string{"", "d", "0d", "-1h", "7w", "365d"} {
+		if _, err := ParseRange(bad, max); err != ErrInvalidRange {
+			t.Fatalf("ParseRange(%q) expected ErrInvalidRange, got %v", bad, err)
+		}
+	}
+}
@@ -3,9 +3,11 @@ package api
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"runtime"
 	"strconv"
 	"strings"
@@ -15,9 +17,16 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/bandwidth"
 	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/events"
+	"github.com/rehmatworks/fastcp/internal/ldap"
 	"github.com/rehmatworks/fastcp/internal/middleware"
 	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/oidc"
+	"github.com/rehmatworks/fastcp/internal/ratelimit"
+	"github.com/rehmatworks/fastcp/internal/rbac"
+	"github.com/rehmatworks/fastcp/internal/sshd"
 )
 
 var startTime = time.Now()
@@ -104,14 +113,32 @@ func (s *Server) getConfig(w http.ResponseWriter, r *http.Request) {
 	cfg := config.Get()
 
 	// Don't expose sensitive fields
+	oidcSettings := cfg.OIDC
+	if oidcSettings.ClientSecret != "" {
+		oidcSettings.ClientSecret = "********"
+	}
+	ldapSettings := cfg.LDAP
+	if ldapSettings.BindPassword != "" {
+		ldapSettings.BindPassword = "********"
+	}
 	safeCfg := map[string]interface{}{
-		"data_dir":       cfg.DataDir,
-		"sites_dir":      cfg.SitesDir,
-		"log_dir":        cfg.LogDir,
-		"listen_addr":    cfg.ListenAddr,
-		"proxy_port":     cfg.ProxyPort,
-		"proxy_ssl_port": cfg.ProxySSLPort,
-		"php_versions":   cfg.PHPVersions,
+		"data_dir":             cfg.DataDir,
+		"sites_dir":            cfg.SitesDir,
+		"log_dir":              cfg.LogDir,
+		"listen_addr":          cfg.ListenAddr,
+		"proxy_port":           cfg.ProxyPort,
+		"proxy_ssl_port":       cfg.ProxySSLPort,
+		"php_versions":         cfg.PHPVersions,
+		"site_logs":            cfg.SiteLogs,
+		"bandwidth":            cfg.Bandwidth,
+		"usage_sample_seconds": cfg.UsageSampleSeconds,
+		"notifications":        cfg.Notifications,
+		"require_2fa_admins":   cfg.RequireTwoFactorAdmins,
+		"webauthn":             cfg.WebAuthn,
+		"rate_limit":           cfg.RateLimit,
+		"oidc":                 oidcSettings,
+		"ldap":                 ldapSettings,
+		"sftp":                 cfg.SFTP,
 	}
 
 	s.success(w, safeCfg)
@@ -122,7 +149,16 @@ func (s *Server) updateConfig(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
 	var updates struct {
-		PHPVersions []models.PHPVersionConfig `json:"php_versions,omitempty"`
+		PHPVersions   []models.PHPVersionConfig    `json:"php_versions,omitempty"`
+		SiteLogs      *models.LogRotation          `json:"site_logs,omitempty"`
+		Bandwidth     *models.BandwidthPolicy      `json:"bandwidth,omitempty"`
+		Notifications *models.NotificationSettings `json:"notifications,omitempty"`
+		RequireTwoFA  *bool                        `json:"require_2fa_admins,omitempty"`
+		WebAuthn      *models.WebAuthnSettings     `json:"webauthn,omitempty"`
+		RateLimit     *models.RateLimitSettings    `json:"rate_limit,omitempty"`
+		OIDC          *models.OIDCSettings         `json:"oidc,omitempty"`
+		LDAP          *models.LDAPSettings         `json:"ldap,omitempty"`
+		SFTP          *models.SFTPSettings         `json:"sftp,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
@@ -135,6 +171,99 @@ func (s *Server) updateConfig(w http.ResponseWriter, r *http.Request) {
 	if updates.PHPVersions != nil {
 		cfg.PHPVersions = updates.PHPVersions
 	}
+	if updates.SiteLogs != nil {
+		if updates.SiteLogs.RollSizeMB < 0 || updates.SiteLogs.RollKeep < 0 || updates.SiteLogs.RollKeepDays < 0 {
+			s.error(w, http.StatusBadRequest, "site log rotation values must not be negative")
+			return
+		}
+		cfg.SiteLogs = *updates.SiteLogs
+	}
+	if updates.Bandwidth != nil {
+		if !bandwidth.ValidAction(updates.Bandwidth.Action) {
+			s.error(w, http.StatusBadRequest, bandwidth.ErrInvalidAction.Error())
+			return
+		}
+		if updates.Bandwidth.LimitCPUPercent < 1 {
+			s.error(w, http.StatusBadRequest, "limit_cpu_percent must be at least 1")
+			return
+		}
+		cfg.Bandwidth = *updates.Bandwidth
+	}
+	if n := updates.Notifications; n != nil {
+		if n.WebhookURL != "" {
+			if u, err := url.Parse(n.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				s.error(w, http.StatusBadRequest, "webhook_url must be an http or https URL")
+				return
+			}
+		}
+		if n.Email != "" && !events.ValidAddress(n.Email) {
+			s.error(w, http.StatusBadRequest, "invalid notification email")
+			return
+		}
+		if n.CooldownMinutes < 0 {
+			s.error(w, http.StatusBadRequest, "cooldown_minutes must not be negative")
+			return
+		}
+		cfg.Notifications = *n
+	}
+	if updates.RequireTwoFA != nil {
+		cfg.RequireTwoFactorAdmins = *updates.RequireTwoFA
+	}
+	if wa := updates.WebAuthn; wa != nil {
+		if wa.RPID != "" && len(wa.Origins) == 0 {
+			s.error(w, http.StatusBadRequest, "webauthn origins are required with rp_id")
+			return
+		}
+		for _, origin := range wa.Origins {
+			if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+				s.error(w, http.StatusBadRequest, "webauthn origins must be http or https origins")
+				return
+			}
+		}
+		cfg.WebAuthn = *wa
+	}
+	if rl := updates.RateLimit; rl != nil {
+		if err := ratelimit.Validate(*rl); err != nil {
+			s.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg.RateLimit = *rl
+		if s.limiter != nil {
+			s.limiter.Configure(*rl)
+		}
+	}
+	if o := updates.OIDC; o != nil {
+		// The secret is not shown, so keep it unless a new one is given
+		if o.ClientSecret == "" || o.ClientSecret == "********" {
+			o.ClientSecret = cfg.OIDC.ClientSecret
+		}
+		if err := oidc.Validate(*o); err != nil {
+			s.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg.OIDC = *o
+	}
+	if l := updates.LDAP; l != nil {
+		if l.BindPassword == "" || l.BindPassword == "********" {
+			l.BindPassword = cfg.LDAP.BindPassword
+		}
+		if err := ldap.Validate(*l); err != nil {
+			s.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg.LDAP = *l
+	}
+	if sf := updates.SFTP; sf != nil {
+		// Takes effect on the next start
+		if sf.ListenAddr == "" {
+			sf.ListenAddr = ":2222"
+		}
+		if _, _, err := net.SplitHostPort(sf.ListenAddr); err != nil {
+			s.error(w, http.StatusBadRequest, "sftp listen_addr must be host:port")
+			return
+		}
+		cfg.SFTP = *sf
+	}
 
 	config.Update(cfg)
 
@@ -226,7 +355,11 @@ func (s *Server) createAPIKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if req.Permissions == nil {
-		req.Permissions = []string{"sites:read", "sites:write"}
+		req.Permissions = []string{rbac.SitesRead, rbac.SitesWrite}
+	}
+	if err := rbac.ValidatePermissions(req.Permissions); err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	apiKey, err := auth.GenerateAPIKey(req.Name, claims.UserID, req.Permissions)
@@ -270,20 +403,24 @@ type SSHSettings struct {
 	PasswordAuthEnabled bool `json:"password_auth_enabled"`
 }
 
-const sshdConfigPath = "/etc/ssh/sshd_config"
-
-// getSSHSettings returns current SSH server settings (admin only)
+// getSSHSettings returns the SSH server settings sshd uses (admin only)
 func (s *Server) getSSHSettings(w http.ResponseWriter, r *http.Request) {
 	if runtime.GOOS != "linux" {
-		s.success(w, SSHSettings{PasswordAuthEnabled: true})
+		s.success(w, sshd.Status{PasswordAuthEnabled: true})
 		return
 	}
 
-	enabled := isSSHPasswordAuthEnabled()
-	s.success(w, SSHSettings{PasswordAuthEnabled: enabled})
+	status, err := sshd.GetStatus()
+	if err != nil {
+		s.logger.Error("failed to read SSH settings", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to read SSH settings")
+		return
+	}
+	s.success(w, status)
 }
 
-// updateSSHSettings updates SSH server settings (admin only)
+// updateSSHSettings updates SSH server settings (admin only). Changes sshd
+// rejects are rolled back.
 func (s *Server) updateSSHSettings(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
@@ -298,9 +435,18 @@ func (s *Server) updateSSHSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := setSSHPasswordAuth(req.PasswordAuthEnabled); err != nil {
+	if err := sshd.SetPasswordAuth(req.PasswordAuthEnabled); err != nil {
 		s.logger.Error("failed to update SSH settings", "error", err)
-		s.error(w, http.StatusInternalServerError, "failed to update SSH settings: "+err.Error())
+		switch {
+		case errors.Is(err, sshd.ErrInvalidConfig):
+			s.error(w, http.StatusUnprocessableEntity, err.Error()+", changes were rolled back")
+		case errors.Is(err, sshd.ErrOverridden):
+			s.error(w, http.StatusConflict, err.Error())
+		case errors.Is(err, sshd.ErrReloadFailed):
+			s.error(w, http.StatusInternalServerError, err.Error())
+		default:
+			s.error(w, http.StatusInternalServerError, "failed to update SSH settings: "+err.Error())
+		}
 		return
 	}
 
@@ -311,85 +457,3 @@ func (s *Server) updateSSHSettings(w http.ResponseWriter, r *http.Request) {
 	s.logger.Info("SSH password authentication "+status, "user", claims.Username)
 	s.success(w, map[string]string{"message": "SSH settings updated, password authentication " + status})
 }
-
-// isSSHPasswordAuthEnabled checks if password authentication is enabled in sshd_config
-func isSSHPasswordAuthEnabled() bool {
-	data, err := os.ReadFile(sshdConfigPath)
-	if err != nil {
-		return true // Default to enabled if can't read
-	}
-
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		// Skip comments
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-		// Check for PasswordAuthentication directive
-		if strings.HasPrefix(strings.ToLower(line), "passwordauthentication") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				return strings.ToLower(parts[1]) == "yes"
-			}
-		}
-	}
-
-	// Default is yes if not specified
-	return true
-}
-
-// setSSHPasswordAuth enables or disables password authentication in sshd_config
-func setSSHPasswordAuth(enabled bool) error {
-	data, err := os.ReadFile(sshdConfigPath)
-	if err != nil {
-		return err
-	}
-
-	lines := strings.Split(string(data), "\n")
-	found := false
-	newValue := "no"
-	if enabled {
-		newValue = "yes"
-	}
-
-	for i, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		// Check for PasswordAuthentication (including commented out)
-		lower := strings.ToLower(trimmed)
-		if strings.HasPrefix(lower, "passwordauthentication") ||
-			strings.HasPrefix(lower, "#passwordauthentication") {
-			lines[i] = "PasswordAuthentication " + newValue
-			found = true
-			break
-		}
-	}
-
-	// If not found, add it before any Match blocks or at the end
-	if !found {
-		// Find where to insert (before Match blocks if any)
-		insertIndex := len(lines)
-		for i, line := range lines {
-			if strings.HasPrefix(strings.TrimSpace(strings.ToLower(line)), "match ") {
-				insertIndex = i
-				break
-			}
-		}
-
-		// Insert the directive
-		newLine := "PasswordAuthentication " + newValue
-		lines = append(lines[:insertIndex], append([]string{newLine}, lines[insertIndex:]...)...)
-	}
-
-	// Write back
-	content := strings.Join(lines, "\n")
-	if err := os.WriteFile(sshdConfigPath, []byte(content), 0644); err != nil {
-		return err
-	}
-
-	// Reload SSH service
-	_ = exec.Command("systemctl", "reload", "sshd").Run()
-	_ = exec.Command("systemctl", "reload", "ssh").Run()
-
-	return nil
-}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rehmatworks/fastcp/internal/analytics"
+	"github.com/rehmatworks/fastcp/internal/teams"
+)
+
+// maxAnalyticsRange is the longest range served (matches on-disk retention)
+const maxAnalyticsRange = 90 * 24 * time.Hour
+
+// SetAnalytics attaches the traffic analytics collector
+func (s *Server) SetAnalytics(collector *analytics.Collector) {
+	s.analytics = collector
+}
+
+// getSiteAnalytics returns traffic analytics for a site
+func (s *Server) getSiteAnalytics(w http.ResponseWriter, r *http.Request) {
+	site, ok := s.loadSiteForRequest(w, r, chi.URLParam(r, "id"), teams.AccessLogs)
+	if !ok {
+		return
+	}
+
+	if s.analytics == nil {
+		s.error(w, http.StatusServiceUnavailable, "analytics not enabled")
+		return
+	}
+
+	rangeParam := r.URL.Query().Get("range")
+	if rangeParam == "" {
+		rangeParam = "24h"
+	}
+	d, err := analytics.ParseRange(rangeParam, maxAnalyticsRange)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Pick up lines written since the last collection run
+	if err := s.analytics.CollectSite(*site); err != nil {
+		s.logger.Warn("failed to collect site analytics", "id", site.ID, "error", err)
+	}
+
+	now := time.Now()
+	report, err := s.analytics.Query(site.ID, now.Add(-d), now)
+	if err != nil {
+		s.logger.Error("failed to query site analytics", "id", site.ID, "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to load analytics")
+		return
+	}
+
+	s.success(w, report)
+}
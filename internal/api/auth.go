@@ -13,8 +13,10 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/config"
 	"github.com/rehmatworks/fastcp/internal/middleware"
 	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sessions"
 )
 
 // LoginRequest represents a login request
@@ -25,14 +27,18 @@ type LoginRequest struct {
 
 // LoginResponse represents a login response
 type LoginResponse struct {
-	Token     string `json:"token"`
-	ExpiresIn int    `json:"expires_in"` // seconds
-	User      struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"` // Set when sessions are enabled
+	ExpiresIn    int    `json:"expires_in"`              // seconds
+	User         struct {
 		ID       string `json:"id"`
 		Username string `json:"username"`
 		Email    string `json:"email"`
 		Role     string `json:"role"`
 	} `json:"user"`
+
+	// Set when the login completed two-factor enrollment, shown only once
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
 }
 
 // login handles user authentication
@@ -48,14 +54,66 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.loginBlocked(w, r, req.Username) {
+		return
+	}
+
 	user, err := auth.Authenticate(req.Username, req.Password)
 	if err != nil {
 		s.logger.Warn("failed login attempt", "username", req.Username, "error", err)
+		s.loginFailed(r, req.Username)
 		s.error(w, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
 
-	token, err := auth.GenerateToken(user)
+	s.continueLogin(w, r, user)
+}
+
+// continueLogin finishes a login after the first factor. With two-factor
+// enabled (or required), it only earns a challenge that is exchanged for a
+// token in a second step.
+func (s *Server) continueLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if s.twoFactor != nil {
+		if s.twoFactor.Enabled(user.Username) {
+			s.loginChallenge(w, user, auth.PurposeTwoFactor)
+			return
+		}
+		if user.Role == "admin" && config.Get().RequireTwoFactorAdmins {
+			s.loginChallenge(w, user, auth.PurposeTwoFactorSetup)
+			return
+		}
+	}
+
+	s.completeLogin(w, r, user, nil)
+}
+
+// completeLogin issues the tokens for an authenticated user. With sessions
+// enabled, a session is started for the device and the short-lived access
+// token comes with a refresh token. Failed attempts of the username are
+// only forgotten here, once every factor passed.
+func (s *Server) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User, recoveryCodes []string) {
+	var token, refreshToken string
+	expiresIn := 86400 // 24 hours
+	var err error
+	if s.sessions != nil {
+		// Refreshing checks the groups again, against the role they gave
+		// now rather than one a directory or single sign-on mapped
+		groupRole := user.Role
+		if unixUser, err := auth.LookupUser(user.Username); err == nil {
+			groupRole = unixUser.Role
+		}
+		var session *sessions.Session
+		session, refreshToken, err = s.sessions.Create(user.ID, user.Username, user.Role, groupRole, clientIP(r), r.UserAgent())
+		if err != nil {
+			s.logger.Error("failed to create session", "error", err)
+			s.error(w, http.StatusInternalServerError, "failed to create session")
+			return
+		}
+		token, err = auth.GenerateSessionToken(user, session.ID)
+		expiresIn = int(auth.AccessTokenTTL.Seconds())
+	} else {
+		token, err = auth.GenerateToken(user)
+	}
 	if err != nil {
 		s.logger.Error("failed to generate token", "error", err)
 		s.error(w, http.StatusInternalServerError, "failed to generate token")
@@ -63,8 +121,9 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := LoginResponse{
-		Token:     token,
-		ExpiresIn: 86400, // 24 hours
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    expiresIn,
 		User: struct {
 			ID       string `json:"id"`
 			Username string `json:"username"`
@@ -76,14 +135,23 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 			Email:    user.Email,
 			Role:     user.Role,
 		},
+		RecoveryCodes: recoveryCodes,
 	}
 
+	s.loginSucceeded(user.Username)
 	s.logger.Info("user logged in", "username", user.Username)
 	s.success(w, resp)
 }
 
-// refreshToken refreshes an authentication token
+// refreshToken refreshes an authentication token. With sessions enabled the
+// session's refresh token is exchanged for a new access token and a new
+// refresh token.
 func (s *Server) refreshToken(w http.ResponseWriter, r *http.Request) {
+	if s.sessions != nil {
+		s.refreshSession(w, r)
+		return
+	}
+
 	claims := middleware.GetClaims(r)
 	if claims == nil {
 		s.error(w, http.StatusUnauthorized, "invalid token")
@@ -124,11 +192,18 @@ func (s *Server) getCurrentUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.success(w, map[string]interface{}{
-		"id":       claims.UserID,
-		"username": claims.Username,
-		"role":     claims.Role,
-	})
+	me := map[string]interface{}{
+		"id":          claims.UserID,
+		"username":    claims.Username,
+		"role":        claims.Role,
+		"permissions": s.permissionsOf(claims),
+	}
+	// Lets the UI show who is acting as the user
+	if claims.Impersonator != nil {
+		me["impersonator"] = claims.Impersonator
+		me["impersonation_expires_at"] = claims.ExpiresAt.Time
+	}
+	s.success(w, me)
 }
 
 // changePassword handles password change requests
@@ -173,6 +248,9 @@ func (s *Server) changePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Other devices have to log in with the new password
+	s.revokeUserSessions(claims.Username, claims.SessionID, "password changed")
+
 	s.logger.Info("password changed", "username", claims.Username)
 	s.success(w, map[string]string{
 		"message": "password changed successfully",
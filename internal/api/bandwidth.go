@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/rehmatworks/fastcp/internal/bandwidth"
+	"github.com/rehmatworks/fastcp/internal/caddy"
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+// SetBandwidth attaches the bandwidth accounting manager
+func (s *Server) SetBandwidth(manager *bandwidth.Manager) {
+	s.bandwidth = manager
+}
+
+// getBandwidthReport returns the monthly bandwidth usage of all users (admin only)
+func (s *Server) getBandwidthReport(w http.ResponseWriter, r *http.Request) {
+	if s.bandwidth == nil {
+		s.error(w, http.StatusServiceUnavailable, "bandwidth accounting not enabled")
+		return
+	}
+
+	month, err := bandwidth.ParseMonth(r.URL.Query().Get("month"))
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reports, err := s.bandwidth.Reports(month)
+	if err != nil {
+		s.logger.Error("failed to load bandwidth usage", "month", month, "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to load bandwidth usage")
+		return
+	}
+
+	s.success(w, map[string]interface{}{
+		"month": month,
+		"users": reports,
+	})
+}
+
+// whmcsUsage returns monthly bandwidth usage per account for billing.
+// Each account lists its domains so WHMCS can match services.
+func (s *Server) whmcsUsage(w http.ResponseWriter, r *http.Request) {
+	if s.bandwidth == nil {
+		s.json(w, http.StatusServiceUnavailable, models.WHMCSResponse{
+			Result:  "error",
+			Message: "bandwidth accounting not enabled",
+		})
+		return
+	}
+
+	month, err := bandwidth.ParseMonth(r.URL.Query().Get("month"))
+	if err != nil {
+		s.json(w, http.StatusBadRequest, models.WHMCSResponse{
+			Result:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	reports, err := s.bandwidth.Reports(month)
+	if err != nil {
+		s.logger.Error("failed to load bandwidth usage", "month", month, "error", err)
+		s.json(w, http.StatusInternalServerError, models.WHMCSResponse{
+			Result:  "error",
+			Message: "failed to load bandwidth usage",
+		})
+		return
+	}
+
+	domains := make(map[string][]string)
+	for _, site := range s.siteManager.GetAll() {
+		username := caddy.ExtractUsernameFromRootPath(site.RootPath)
+		domains[username] = append(domains[username], site.Domain)
+	}
+
+	accounts := make([]map[string]interface{}, 0, len(reports))
+	for _, report := range reports {
+		accounts = append(accounts, map[string]interface{}{
+			"username":           report.Username,
+			"domains":            domains[report.Username],
+			"bandwidth_used_mb":  report.UsedMB,
+			"bandwidth_limit_mb": report.LimitMB,
+			"overage_mb":         report.OverageMB,
+			"enforced":           report.Enforced,
+		})
+	}
+
+	s.json(w, http.StatusOK, models.WHMCSResponse{
+		Result: "success",
+		Data: map[string]interface{}{
+			"month":    month,
+			"accounts": accounts,
+		},
+	})
+}
+
+// bandwidthUsedMB returns a user's transfer in the current month
+func (s *Server) bandwidthUsedMB(username string) int64 {
+	if s.bandwidth == nil {
+		return 0
+	}
+	month, _ := bandwidth.ParseMonth("")
+	report, err := s.bandwidth.UserReport(username, month)
+	if err != nil {
+		return 0
+	}
+	return report.UsedMB
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/rehmatworks/fastcp/internal/database"
 	"github.com/rehmatworks/fastcp/internal/middleware"
 	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/teams"
 )
 
 // CreateDatabaseRequest represents a request to create a database
@@ -29,6 +30,10 @@ func (s *Server) listDatabases(w http.ResponseWriter, r *http.Request) {
 		databases = s.dbManager.List("")
 	} else {
 		databases = s.dbManager.List(claims.UserID)
+		if claims.Role == "reseller" {
+			databases = append(databases, s.customerDatabases(claims.Username)...)
+		}
+		databases = append(databases, s.sharedDatabases(claims)...)
 	}
 
 	// Don't return passwords in list
@@ -73,6 +78,22 @@ func (s *Server) createDatabase(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Linking gives the site's team members access, so only the owner
+	// can link a database to a site
+	if req.SiteID != "" {
+		if _, ok := s.loadSiteForRequest(w, r, req.SiteID, accessOwner); !ok {
+			return
+		}
+	}
+
+	// Check the database limit of the user's package
+	if limit := s.siteManager.GetUserLimit(claims.Username); limit.MaxDatabases > 0 {
+		if len(s.dbManager.List(claims.UserID)) >= limit.MaxDatabases {
+			s.error(w, http.StatusForbidden, "database limit reached")
+			return
+		}
+	}
+
 	db := &models.Database{
 		UserID:   claims.UserID,
 		SiteID:   req.SiteID,
@@ -104,22 +125,8 @@ func (s *Server) createDatabase(w http.ResponseWriter, r *http.Request) {
 
 // getDatabase returns a single database
 func (s *Server) getDatabase(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	claims := middleware.GetClaims(r)
-
-	db, err := s.dbManager.Get(id)
-	if err != nil {
-		if err == database.ErrDatabaseNotFound {
-			s.error(w, http.StatusNotFound, "database not found")
-			return
-		}
-		s.error(w, http.StatusInternalServerError, "failed to get database")
-		return
-	}
-
-	// Check ownership
-	if claims.Role != "admin" && db.UserID != claims.UserID {
-		s.error(w, http.StatusForbidden, "access denied")
+	db, ok := s.loadDatabaseForRequest(w, r, teams.AccessDatabases)
+	if !ok {
 		return
 	}
 
@@ -133,19 +140,9 @@ func (s *Server) deleteDatabase(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	claims := middleware.GetClaims(r)
 
-	db, err := s.dbManager.Get(id)
-	if err != nil {
-		if err == database.ErrDatabaseNotFound {
-			s.error(w, http.StatusNotFound, "database not found")
-			return
-		}
-		s.error(w, http.StatusInternalServerError, "failed to get database")
-		return
-	}
-
-	// Check ownership
-	if claims.Role != "admin" && db.UserID != claims.UserID {
-		s.error(w, http.StatusForbidden, "access denied")
+	// Team members cannot delete a database of a shared site
+	db, ok := s.loadDatabaseForRequest(w, r, accessOwner)
+	if !ok {
 		return
 	}
 
@@ -164,19 +161,8 @@ func (s *Server) resetDatabasePassword(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	claims := middleware.GetClaims(r)
 
-	db, err := s.dbManager.Get(id)
-	if err != nil {
-		if err == database.ErrDatabaseNotFound {
-			s.error(w, http.StatusNotFound, "database not found")
-			return
-		}
-		s.error(w, http.StatusInternalServerError, "failed to get database")
-		return
-	}
-
-	// Check ownership
-	if claims.Role != "admin" && db.UserID != claims.UserID {
-		s.error(w, http.StatusForbidden, "access denied")
+	db, ok := s.loadDatabaseForRequest(w, r, teams.AccessDatabases)
+	if !ok {
 		return
 	}
 
@@ -213,11 +199,6 @@ func (s *Server) getDatabaseStatus(w http.ResponseWriter, r *http.Request) {
 func (s *Server) installMySQL(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
-	if claims.Role != "admin" {
-		s.error(w, http.StatusForbidden, "admin access required")
-		return
-	}
-
 	// Check if installation is already in progress
 	if s.dbManager.IsInstalling("mysql") {
 		s.error(w, http.StatusConflict, "MySQL installation already in progress")
@@ -262,11 +243,6 @@ func (s *Server) installMySQL(w http.ResponseWriter, r *http.Request) {
 func (s *Server) installPostgreSQL(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
-	if claims.Role != "admin" {
-		s.error(w, http.StatusForbidden, "admin access required")
-		return
-	}
-
 	// Check if installation is already in progress
 	if s.dbManager.IsInstalling("postgresql") {
 		s.error(w, http.StatusConflict, "PostgreSQL installation already in progress")
@@ -331,3 +307,25 @@ func isValidDatabaseName(name string) bool {
 	}
 	return true
 }
+
+// loadDatabaseForRequest fetches the database of the request's ID and
+// checks that the caller has the given access to it. On failure the error
+// response has already been written.
+func (s *Server) loadDatabaseForRequest(w http.ResponseWriter, r *http.Request, access string) (*models.Database, bool) {
+	db, err := s.dbManager.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		if err == database.ErrDatabaseNotFound {
+			s.error(w, http.StatusNotFound, "database not found")
+			return nil, false
+		}
+		s.error(w, http.StatusInternalServerError, "failed to get database")
+		return nil, false
+	}
+
+	if !s.canAccessDatabase(middleware.GetClaims(r), db, access) {
+		s.error(w, http.StatusForbidden, "access denied")
+		return nil, false
+	}
+
+	return db, true
+}
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rehmatworks/fastcp/internal/caddy"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/sites"
+	"github.com/rehmatworks/fastcp/internal/teams"
+)
+
+// PageRequest represents a request to set a custom page
+type PageRequest struct {
+	Content string `json:"content"`
+}
+
+// ErrorPageSettingsRequest represents a request to change how a site's
+// custom error pages are applied
+type ErrorPageSettingsRequest struct {
+	InterceptErrors bool `json:"intercept_errors"`
+}
+
+// listSiteErrorPages returns which error pages are customized for a site
+func (s *Server) listSiteErrorPages(w http.ResponseWriter, r *http.Request) {
+	site, ok := s.loadSiteForRequest(w, r, chi.URLParam(r, "id"), teams.AccessDeploy)
+	if !ok {
+		return
+	}
+
+	custom := caddy.SiteErrorPages(site.ID)
+	if custom == nil {
+		custom = []int{}
+	}
+
+	s.success(w, map[string]interface{}{
+		"custom":           custom,
+		"available":        caddy.ErrorPageCodes,
+		"intercept_errors": site.InterceptErrors,
+	})
+}
+
+// updateSiteErrorPageSettings sets whether the custom pages also replace
+// error responses returned by the application. Without it they are only
+// served for errors raised by the proxy, such as an unreachable PHP instance.
+func (s *Server) updateSiteErrorPageSettings(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	site, ok := s.loadSiteForRequest(w, r, chi.URLParam(r, "id"), teams.AccessDeploy)
+	if !ok {
+		return
+	}
+
+	var req ErrorPageSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := s.siteManager.SetInterceptErrors(site.ID, req.InterceptErrors); err != nil {
+		if errors.Is(err, sites.ErrSiteNotFound) {
+			s.error(w, http.StatusNotFound, "site not found")
+			return
+		}
+		s.error(w, http.StatusInternalServerError, "failed to save error page settings")
+		return
+	}
+
+	s.reloadProxy()
+
+	s.logger.Info("site error page settings updated", "id", site.ID, "intercept_errors", req.InterceptErrors, "user", claims.Username)
+	s.success(w, map[string]interface{}{"intercept_errors": req.InterceptErrors})
+}
+
+// getSiteErrorPage returns the custom page for a status code
+func (s *Server) getSiteErrorPage(w http.ResponseWriter, r *http.Request) {
+	site, ok := s.loadSiteForRequest(w, r, chi.URLParam(r, "id"), teams.AccessDeploy)
+	if !ok {
+		return
+	}
+
+	code, ok := s.errorPageCode(w, r)
+	if !ok {
+		return
+	}
+
+	content, err := caddy.ReadSiteErrorPage(site.ID, code)
+	if err != nil {
+		s.pageError(w, err, "failed to read error page")
+		return
+	}
+
+	s.success(w, map[string]interface{}{
+		"code":    code,
+		"content": string(content),
+	})
+}
+
+// setSiteErrorPage stores a custom page for a status code
+func (s *Server) setSiteErrorPage(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	site, ok := s.loadSiteForRequest(w, r, chi.URLParam(r, "id"), teams.AccessDeploy)
+	if !ok {
+		return
+	}
+
+	code, ok := s.errorPageCode(w, r)
+	if !ok {
+		return
+	}
+
+	var req PageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Content == "" {
+		s.error(w, http.StatusBadRequest, "content is required")
+		return
+	}
+
+	if err := caddy.WriteSiteErrorPage(site.ID, code, []byte(req.Content)); err != nil {
+		s.pageError(w, err, "failed to save error page")
+		return
+	}
+
+	s.reloadProxy()
+
+	s.logger.Info("site error page updated", "id", site.ID, "code", code, "user", claims.Username)
+	s.success(w, map[string]string{"message": "error page saved"})
+}
+
+// deleteSiteErrorPage removes a custom page, restoring the default
+func (s *Server) deleteSiteErrorPage(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	site, ok := s.loadSiteForRequest(w, r, chi.URLParam(r, "id"), teams.AccessDeploy)
+	if !ok {
+		return
+	}
+
+	code, ok := s.errorPageCode(w, r)
+	if !ok {
+		return
+	}
+
+	if err := caddy.DeleteSiteErrorPage(site.ID, code); err != nil {
+		s.pageError(w, err, "failed to delete error page")
+		return
+	}
+
+	s.reloadProxy()
+
+	s.logger.Info("site error page removed", "id", site.ID, "code", code, "user", claims.Username)
+	s.success(w, map[string]string{"message": "error page removed"})
+}
+
+// getSuspendedPage returns the global suspended template, or a user's
+// template when the route has a username
+func (s *Server) getSuspendedPage(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.suspendedPageOwner(w, r)
+	if !ok {
+		return
+	}
+
+	content, err := caddy.ReadSuspendedPage(username)
+	if err != nil {
+		s.pageError(w, err, "failed to read suspended page")
+		return
+	}
+
+	s.success(w, map[string]interface{}{
+		"username": username,
+		"content":  string(content),
+	})
+}
+
+// setSuspendedPage stores the global or per-user suspended template
+func (s *Server) setSuspendedPage(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	username, ok := s.suspendedPageOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req PageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Content == "" {
+		s.error(w, http.StatusBadRequest, "content is required")
+		return
+	}
+
+	if err := caddy.WriteSuspendedPage(username, []byte(req.Content)); err != nil {
+		s.pageError(w, err, "failed to save suspended page")
+		return
+	}
+
+	s.reloadProxy()
+
+	s.logger.Info("suspended page updated", "username", username, "by", claims.Username)
+	s.success(w, map[string]string{"message": "suspended page saved"})
+}
+
+// deleteSuspendedPage removes the global or per-user suspended template
+func (s *Server) deleteSuspendedPage(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	username, ok := s.suspendedPageOwner(w, r)
+	if !ok {
+		return
+	}
+
+	if err := caddy.DeleteSuspendedPage(username); err != nil {
+		s.pageError(w, err, "failed to delete suspended page")
+		return
+	}
+
+	s.reloadProxy()
+
+	s.logger.Info("suspended page removed", "username", username, "by", claims.Username)
+	s.success(w, map[string]string{"message": "suspended page removed"})
+}
+
+// errorPageCode parses and validates the {code} URL parameter
+func (s *Server) errorPageCode(w http.ResponseWriter, r *http.Request) (int, bool) {
+	code, err := strconv.Atoi(chi.URLParam(r, "code"))
+	if err != nil || !caddy.IsErrorPageCode(code) {
+		s.error(w, http.StatusBadRequest, caddy.ErrInvalidErrorCode.Error())
+		return 0, false
+	}
+	return code, true
+}
+
+// suspendedPageOwner returns the username from the route (empty for the
+// global template) after checking that the user exists and is managed by
+// the caller
+func (s *Server) suspendedPageOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		return "", true
+	}
+	if !s.canManageUser(middleware.GetClaims(r), username) {
+		s.error(w, http.StatusNotFound, "user not found")
+		return "", false
+	}
+	if _, err := s.getFastCPUser(username); err != nil {
+		s.error(w, http.StatusNotFound, "user not found")
+		return "", false
+	}
+	return username, true
+}
+
+// pageError maps page storage errors to HTTP responses
+func (s *Server) pageError(w http.ResponseWriter, err error, msg string) {
+	switch {
+	case errors.Is(err, caddy.ErrPageNotFound):
+		s.error(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, caddy.ErrInvalidErrorCode), errors.Is(err, caddy.ErrPageTooLarge):
+		s.error(w, http.StatusBadRequest, err.Error())
+	default:
+		s.logger.Error(msg, "error", err)
+		s.error(w, http.StatusInternalServerError, msg)
+	}
+}
+
+// reloadProxy regenerates the proxy configuration so page changes take effect
+func (s *Server) reloadProxy() {
+	if s.phpManager == nil {
+		return
+	}
+	if err := s.phpManager.Reload(); err != nil {
+		s.logger.Warn("failed to reload PHP instances", "error", err)
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/analytics"
+	"github.com/rehmatworks/fastcp/internal/events"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+)
+
+// maxEvents caps the number of events returned by one request
+const maxEvents = 1000
+
+// SetEvents attaches the resource limit event manager
+func (s *Server) SetEvents(manager *events.Manager) {
+	s.events = manager
+}
+
+// listEvents returns resource limit events. Admins see every user (or the
+// one in ?user=), other users only their own events.
+func (s *Server) listEvents(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.events == nil {
+		s.error(w, http.StatusServiceUnavailable, "resource events not enabled")
+		return
+	}
+
+	q := r.URL.Query()
+	filter := events.Filter{
+		Username: q.Get("user"),
+		Type:     q.Get("type"),
+		Limit:    100,
+	}
+	if claims.Role != "admin" {
+		filter.Username = claims.Username
+	}
+	if filter.Type != "" && !events.ValidType(filter.Type) {
+		s.error(w, http.StatusBadRequest, events.ErrInvalidType.Error())
+		return
+	}
+
+	rangeParam := q.Get("range")
+	if rangeParam == "" {
+		rangeParam = "7d"
+	}
+	d, err := analytics.ParseRange(rangeParam, maxAnalyticsRange)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Since = time.Now().Add(-d)
+
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxEvents {
+			s.error(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		filter.Limit = n
+	}
+
+	list, err := s.events.List(filter)
+	if err != nil {
+		s.logger.Error("failed to list resource events", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to load events")
+		return
+	}
+
+	s.success(w, list)
+}
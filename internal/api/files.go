@@ -13,9 +13,11 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/rehmatworks/fastcp/internal/auth"
 	"github.com/rehmatworks/fastcp/internal/middleware"
 	"github.com/rehmatworks/fastcp/internal/models"
 	"github.com/rehmatworks/fastcp/internal/sites"
+	"github.com/rehmatworks/fastcp/internal/teams"
 )
 
 // FileInfo represents file/directory information
@@ -61,6 +63,7 @@ type FileOperationRequest struct {
 // FileManager provides secure file operations for sites
 type FileManager struct {
 	siteManager *sites.Manager
+	teams       *teams.Manager
 }
 
 // NewFileManager creates a new file manager
@@ -70,15 +73,15 @@ func NewFileManager(siteManager *sites.Manager) *FileManager {
 	}
 }
 
-// validateSiteAccess checks if user has access to the site
-func (fm *FileManager) validateSiteAccess(userID, siteID string) (*models.Site, error) {
+// validateSiteAccess checks if user has access to the site's files
+func (fm *FileManager) validateSiteAccess(claims *auth.Claims, siteID string) (*models.Site, error) {
 	site, err := fm.siteManager.Get(siteID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Admin can access all sites, users can only access their own
-	if userID != "admin" && site.UserID != userID {
+	// Admin can access all sites, users their own and the ones shared with them
+	if !canAccessSite(fm.siteManager, fm.teams, claims, site, teams.AccessFiles) {
 		return nil, fmt.Errorf("access denied")
 	}
 
@@ -142,7 +145,7 @@ func (s *Server) listFiles(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
 	// Validate site access
-	site, err := s.fileManager.validateSiteAccess(claims.UserID, siteID)
+	site, err := s.fileManager.validateSiteAccess(claims, siteID)
 	if err != nil {
 		s.error(w, http.StatusForbidden, "access denied")
 		return
@@ -210,7 +213,7 @@ func (s *Server) getFileContent(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
 	// Validate site access
-	site, err := s.fileManager.validateSiteAccess(claims.UserID, siteID)
+	site, err := s.fileManager.validateSiteAccess(claims, siteID)
 	if err != nil {
 		s.error(w, http.StatusForbidden, "access denied")
 		return
@@ -302,7 +305,7 @@ func (s *Server) saveFileContent(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
 	// Validate site access
-	site, err := s.fileManager.validateSiteAccess(claims.UserID, siteID)
+	site, err := s.fileManager.validateSiteAccess(claims, siteID)
 	if err != nil {
 		s.error(w, http.StatusForbidden, "access denied")
 		return
@@ -333,12 +336,19 @@ func (s *Server) saveFileContent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Refuse to grow the file when the owner is over their disk limit
+	growth := int64(len(req.Content)) - info.Size()
+	if !s.checkDiskQuota(w, site, growth) {
+		return
+	}
+
 	// Write the file
 	err = os.WriteFile(absPath, []byte(req.Content), info.Mode())
 	if err != nil {
 		s.error(w, http.StatusInternalServerError, "failed to save file")
 		return
 	}
+	s.accountDiskWrite(site, growth)
 
 	s.success(w, map[string]string{"message": "file saved successfully"})
 }
@@ -361,7 +371,7 @@ func (s *Server) createDirectory(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
 	// Validate site access
-	site, err := s.fileManager.validateSiteAccess(claims.UserID, siteID)
+	site, err := s.fileManager.validateSiteAccess(claims, siteID)
 	if err != nil {
 		s.error(w, http.StatusForbidden, "access denied")
 		return
@@ -410,7 +420,7 @@ func (s *Server) deleteFile(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
 	// Validate site access
-	site, err := s.fileManager.validateSiteAccess(claims.UserID, siteID)
+	site, err := s.fileManager.validateSiteAccess(claims, siteID)
 	if err != nil {
 		s.error(w, http.StatusForbidden, "access denied")
 		return
@@ -458,7 +468,7 @@ func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
 	// Validate site access
-	site, err := s.fileManager.validateSiteAccess(claims.UserID, siteID)
+	site, err := s.fileManager.validateSiteAccess(claims, siteID)
 	if err != nil {
 		s.error(w, http.StatusForbidden, "access denied")
 		return
@@ -491,7 +501,17 @@ func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Refuse uploads that would take the owner over their disk limit
+	var total int64
+	for _, fileHeader := range files {
+		total += fileHeader.Size
+	}
+	if !s.checkDiskQuota(w, site, total) {
+		return
+	}
+
 	uploaded := 0
+	var written int64
 	for _, fileHeader := range files {
 		file, err := fileHeader.Open()
 		if err != nil {
@@ -508,13 +528,15 @@ func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request) {
 		defer destFile.Close()
 
 		// Copy file content
-		_, err = io.Copy(destFile, file)
+		n, err := io.Copy(destFile, file)
+		written += n
 		if err != nil {
 			continue
 		}
 
 		uploaded++
 	}
+	s.accountDiskWrite(site, written)
 
 	s.success(w, map[string]interface{}{
 		"message":  "files uploaded successfully",
@@ -535,7 +557,7 @@ func (s *Server) downloadFile(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
 	// Validate site access
-	site, err := s.fileManager.validateSiteAccess(claims.UserID, siteID)
+	site, err := s.fileManager.validateSiteAccess(claims, siteID)
 	if err != nil {
 		s.error(w, http.StatusForbidden, "access denied")
 		return
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rehmatworks/fastcp/internal/audit"
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sessions"
+)
+
+// ImpersonationActive reports whether an impersonation token may still be
+// used: its session was not ended, the user still allows impersonation and
+// a reseller still owns the account. Used by the auth middleware.
+func (s *Server) ImpersonationActive(claims *auth.Claims) bool {
+	if claims.Impersonator == nil || s.sessions == nil {
+		return false
+	}
+	if !s.sessions.ImpersonationActive(claims.ID, claims.Username, claims.Impersonator.Username) {
+		return false
+	}
+	if s.siteManager != nil && s.siteManager.GetUserLimit(claims.Username).ImpersonationDisabled {
+		return false
+	}
+	if claims.Impersonator.Role == "reseller" {
+		return s.OwnsUser(claims.Impersonator.Username, claims.Username)
+	}
+	return claims.Impersonator.Role == "admin"
+}
+
+// startImpersonation issues a short-lived token acting as a user. Admins
+// may impersonate any non-admin user, resellers only their own customers.
+func (s *Server) startImpersonation(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	username := chi.URLParam(r, "username")
+
+	if s.sessions == nil {
+		s.error(w, http.StatusServiceUnavailable, "sessions not enabled")
+		return
+	}
+	if middleware.IsImpersonating(r) {
+		s.error(w, http.StatusForbidden, "cannot impersonate while impersonating")
+		return
+	}
+	if username == claims.Username {
+		s.error(w, http.StatusBadRequest, "cannot impersonate yourself")
+		return
+	}
+	if !s.canManageUser(claims, username) {
+		s.error(w, http.StatusForbidden, "cannot impersonate this user")
+		return
+	}
+
+	target, err := auth.LookupUser(username)
+	if err != nil {
+		s.error(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if target.Role == "admin" {
+		s.error(w, http.StatusForbidden, "admins cannot be impersonated")
+		return
+	}
+	if s.siteManager != nil && s.siteManager.GetUserLimit(username).ImpersonationDisabled {
+		s.error(w, http.StatusForbidden, "impersonation is disabled for this user")
+		return
+	}
+
+	token, impersonation, err := auth.GenerateImpersonationToken(target, claims)
+	if err != nil {
+		s.logger.Error("failed to generate impersonation token", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to start impersonation")
+		return
+	}
+	expiresAt := impersonation.ExpiresAt.Time
+
+	// The impersonation is a session of its own, so it can be ended and
+	// ends with the session it was started from
+	if err := s.sessions.CreateImpersonation(impersonation.ID, claims.SessionID, claims.Username,
+		target.ID, target.Username, target.Role, clientIP(r), r.UserAgent(), expiresAt); err != nil {
+		s.logger.Error("failed to record impersonation", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to start impersonation")
+		return
+	}
+
+	s.logger.Info("impersonation started", "user", username, "by", claims.Username)
+	s.recordAudit(models.AuditLog{
+		UserID:     claims.UserID,
+		Username:   claims.Username,
+		Action:     audit.ActionImpersonateStart,
+		Resource:   "user",
+		ResourceID: username,
+		Details:    fmt.Sprintf("until %s", expiresAt.UTC().Format(time.RFC3339)),
+		IP:         clientIP(r),
+	})
+	s.success(w, map[string]interface{}{
+		"token":      token,
+		"expires_at": expiresAt,
+		"user": map[string]string{
+			"id":       target.ID,
+			"username": target.Username,
+			"role":     target.Role,
+		},
+	})
+}
+
+// stopImpersonation ends the impersonation token of the request
+func (s *Server) stopImpersonation(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsImpersonating(r) {
+		s.error(w, http.StatusBadRequest, "not impersonating")
+		return
+	}
+	claims := middleware.GetClaims(r)
+	real := middleware.GetRealClaims(r)
+
+	if err := s.sessions.Revoke(claims.Username, claims.ID); err != nil && !errors.Is(err, sessions.ErrSessionNotFound) {
+		s.logger.Error("failed to end impersonation", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to stop impersonation")
+		return
+	}
+
+	s.logger.Info("impersonation stopped", "user", claims.Username, "by", real.Username)
+	s.recordAudit(models.AuditLog{
+		UserID:     real.UserID,
+		Username:   real.Username,
+		Action:     audit.ActionImpersonateStop,
+		Resource:   "user",
+		ResourceID: claims.Username,
+		IP:         clientIP(r),
+	})
+	s.success(w, map[string]string{"message": "impersonation stopped"})
+}
+
+// auditImpersonation records every request made while impersonating a
+// user, reads included, as they can expose the user's data
+func (s *Server) auditImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !middleware.IsImpersonating(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		real := middleware.GetRealClaims(r)
+		s.recordAudit(models.AuditLog{
+			UserID:     real.UserID,
+			Username:   real.Username,
+			Action:     audit.ActionImpersonateAction,
+			Resource:   "user",
+			ResourceID: middleware.GetClaims(r).Username,
+			Details:    fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, rec.status),
+			IP:         clientIP(r),
+		})
+	})
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os/user"
+	"testing"
+
+	"github.com/rehmatworks/fastcp/internal/audit"
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sessions"
+)
+
+func TestImpersonation(t *testing.T) {
+	loaded, _ := config.Load("")
+	cfg := *loaded
+	cfg.JWTSecret = "test-secret"
+	config.Update(&cfg)
+
+	auth.SetGroupChecker(func(u, g string) bool { return g == "fastcp" || (u == "root" && g == "root") })
+	auth.SetUserLookup(func(username string) (*user.User, error) {
+		return &user.User{Uid: "1001", Username: username}, nil
+	})
+	defer func() { auth.SetGroupChecker(nil); auth.SetUserLookup(nil) }()
+
+	s := NewServer(nil, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	log := audit.NewLog(t.TempDir())
+	s.SetAudit(log)
+	sessionDir := t.TempDir()
+	s.SetSessions(sessions.NewManager(sessionDir))
+	middleware.SetImpersonationChecker(s.ImpersonationActive)
+	defer middleware.SetImpersonationChecker(nil)
+
+	adminToken, _ := auth.GenerateToken(&models.User{ID: "0", Username: "root", Role: "admin"})
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+
+	// Nobody impersonates themselves
+	if w := do(http.MethodPost, "/api/v1/admin/impersonate/root", adminToken); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected impersonating yourself to fail, got %d", w.Code)
+	}
+
+	w := do(http.MethodPost, "/api/v1/admin/impersonate/alice", adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected impersonation to start, got %d: %s", w.Code, w.Body.String())
+	}
+	var started struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &started)
+	token := started.Token
+
+	// /me shows who is acting as the user
+	w = do(http.MethodGet, "/api/v1/me", token)
+	var me struct {
+		Username     string             `json:"username"`
+		UserID       string             `json:"id"`
+		Impersonator *auth.Impersonator `json:"impersonator"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &me)
+	if me.Username != "alice" || me.UserID != "1001" || me.Impersonator == nil || me.Impersonator.Username != "root" {
+		t.Fatalf("expected alice impersonated by root, got %s", w.Body.String())
+	}
+
+	// No impersonating further while impersonating
+	if w := do(http.MethodPost, "/api/v1/admin/impersonate/bob", token); w.Code != http.StatusForbidden {
+		t.Fatalf("expected nested impersonation to fail, got %d", w.Code)
+	}
+
+	if w := do(http.MethodDelete, "/api/v1/me/impersonation", token); w.Code != http.StatusOK {
+		t.Fatalf("expected impersonation to stop, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api/v1/me", token); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected stopped token to be refused, got %d", w.Code)
+	}
+
+	// The impersonation stays ended after a restart
+	restarted := sessions.NewManager(sessionDir)
+	if err := restarted.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	s.SetSessions(restarted)
+	if w := do(http.MethodGet, "/api/v1/me", token); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected stopped token to be refused after a restart, got %d", w.Code)
+	}
+
+	entries, err := log.List(audit.Filter{})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	actions := map[string]int{}
+	for _, e := range entries {
+		if e.UserID != "0" || e.Username != "root" || e.ResourceID != "alice" {
+			t.Fatalf("unexpected audit entry %+v", e)
+		}
+		actions[e.Action]++
+	}
+	// Reads are audited as well as changes
+	if actions[audit.ActionImpersonateStart] != 1 || actions[audit.ActionImpersonateStop] != 1 || actions[audit.ActionImpersonateAction] != 3 {
+		t.Fatalf("expected start, stop and three actions in the audit log, got %v", actions)
+	}
+}
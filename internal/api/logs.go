@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rehmatworks/fastcp/internal/caddy"
+	"github.com/rehmatworks/fastcp/internal/sitelogs"
+	"github.com/rehmatworks/fastcp/internal/teams"
+)
+
+const (
+	defaultLogLines = 100
+	maxLogLines     = 5000
+)
+
+// sseKeepAlive is how often an idle log stream sends a comment line
+var sseKeepAlive = 15 * time.Second
+
+// getSiteLogs returns the tail of a site's access or error log.
+// With follow=true the response is a Server-Sent Events stream: each event
+// carries one log line and its id is the file offset, so a reconnecting
+// client resumes where it left off via the Last-Event-ID header.
+func (s *Server) getSiteLogs(w http.ResponseWriter, r *http.Request) {
+	site, ok := s.loadSiteForRequest(w, r, chi.URLParam(r, "id"), teams.AccessLogs)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	logType := q.Get("type")
+	if logType == "" {
+		logType = sitelogs.TypeAccess
+	}
+	path, err := sitelogs.Path(caddy.ExtractUsernameFromRootPath(site.RootPath), site.ID, logType)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter, err := sitelogs.NewFilter(q.Get("status"), q.Get("path"))
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	lines := defaultLogLines
+	if v := q.Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.error(w, http.StatusBadRequest, "invalid lines parameter")
+			return
+		}
+		lines = min(n, maxLogLines)
+	}
+
+	follow := q.Get("follow") == "true" || q.Get("follow") == "1"
+	if !follow {
+		entries, offset, err := sitelogs.Tail(path, lines, filter)
+		if err != nil {
+			s.logger.Error("failed to read site log", "id", site.ID, "error", err)
+			s.error(w, http.StatusInternalServerError, "failed to read log")
+			return
+		}
+		s.success(w, map[string]interface{}{
+			"type":   logType,
+			"lines":  entries,
+			"offset": offset,
+		})
+		return
+	}
+
+	s.streamSiteLog(w, r, path, lines, filter)
+}
+
+// streamSiteLog writes the log as a Server-Sent Events stream
+func (s *Server) streamSiteLog(w http.ResponseWriter, r *http.Request, path string, lines int, filter *sitelogs.Filter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.error(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	// Resume from the last delivered offset, or send the recent backlog first
+	var (
+		backlog []string
+		offset  int64
+		err     error
+	)
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		offset, err = strconv.ParseInt(lastID, 10, 64)
+		if err != nil || offset < 0 {
+			s.error(w, http.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+	} else {
+		backlog, offset, err = sitelogs.Tail(path, lines, filter)
+		if err != nil {
+			s.error(w, http.StatusInternalServerError, "failed to read log")
+			return
+		}
+	}
+
+	// Long-lived response: lift the server write deadline
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 2000\n\n")
+	for _, line := range backlog {
+		fmt.Fprintf(w, "event: log\ndata: %s\n\n", line)
+	}
+	fmt.Fprintf(w, "id: %d\n\n", offset)
+	flusher.Flush()
+
+	lastWrite := time.Now()
+	err = sitelogs.Follow(r.Context(), path, offset, filter, func(line string, next int64) error {
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", next, line); err != nil {
+			return err
+		}
+		flusher.Flush()
+		lastWrite = time.Now()
+		return nil
+	}, func() error {
+		if time.Since(lastWrite) < sseKeepAlive {
+			return nil
+		}
+		if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		lastWrite = time.Now()
+		return nil
+	})
+	if err != nil && !errors.Is(err, r.Context().Err()) {
+		s.logger.Debug("site log stream ended", "path", path, "error", err)
+	}
+}
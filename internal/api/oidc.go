@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/oidc"
+)
+
+// OIDCCallbackRequest carries the code and state the identity provider
+// returned to the redirect URL
+type OIDCCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// SetOIDC attaches the OpenID Connect provider client
+func (s *Server) SetOIDC(provider *oidc.Provider) {
+	s.oidc = provider
+}
+
+// getOIDCInfo tells the login page whether single sign-on is available
+func (s *Server) getOIDCInfo(w http.ResponseWriter, r *http.Request) {
+	settings := config.Get().OIDC
+	name := settings.Name
+	if name == "" {
+		name = "single sign-on"
+	}
+	s.success(w, map[string]interface{}{
+		"enabled": s.oidc != nil && settings.Enabled,
+		"name":    name,
+	})
+}
+
+// beginOIDCLogin returns the identity provider URL to send the browser to
+func (s *Server) beginOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		s.error(w, http.StatusServiceUnavailable, "single sign-on not enabled")
+		return
+	}
+
+	authURL, state, err := s.oidc.Begin(r.Context(), config.Get().OIDC)
+	if err != nil {
+		s.oidcError(w, err)
+		return
+	}
+	s.success(w, map[string]string{
+		"authorization_url": authURL,
+		"state":             state,
+	})
+}
+
+// finishOIDCLogin exchanges the code from the identity provider and logs
+// the mapped Unix user in
+func (s *Server) finishOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		s.error(w, http.StatusServiceUnavailable, "single sign-on not enabled")
+		return
+	}
+
+	var req OIDCCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if s.loginBlocked(w, r, "") {
+		return
+	}
+
+	settings := config.Get().OIDC
+	identity, err := s.oidc.Finish(r.Context(), settings, req.State, req.Code)
+	if err != nil {
+		s.logger.Warn("failed single sign-on attempt", "error", err)
+		s.loginFailed(r, "")
+		s.oidcError(w, err)
+		return
+	}
+
+	username, explicit, err := oidc.Username(settings, identity)
+	if err != nil {
+		s.logger.Warn("failed single sign-on attempt", "subject", identity.Subject, "error", err)
+		s.oidcError(w, err)
+		return
+	}
+	// Root, system and admin accounts only through username_map
+	if !explicit && auth.ProtectedAccount(username) {
+		s.logger.Warn("failed single sign-on attempt", "username", username, "subject", identity.Subject, "error", "protected account not in username_map")
+		s.error(w, http.StatusForbidden, "no FastCP account for this identity")
+		return
+	}
+	role, mapped, err := oidc.Role(settings, identity)
+	if err != nil {
+		s.logger.Warn("failed single sign-on attempt", "username", username, "error", err)
+		s.oidcError(w, err)
+		return
+	}
+
+	// The Unix account must exist and be allowed to use FastCP
+	user, err := auth.LookupUser(username)
+	if err != nil {
+		s.logger.Warn("failed single sign-on attempt", "username", username, "error", err)
+		s.error(w, http.StatusForbidden, "no FastCP account for this identity")
+		return
+	}
+	if mapped {
+		user.Role = role
+	}
+	if user.Email == "" {
+		user.Email = identity.Email
+	}
+
+	s.logger.Info("single sign-on", "username", username, "subject", identity.Subject, "role", user.Role)
+	s.continueLogin(w, r, user)
+}
+
+// oidcError maps single sign-on errors to HTTP responses
+func (s *Server) oidcError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, oidc.ErrNotConfigured):
+		s.error(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, oidc.ErrInvalidState), errors.Is(err, oidc.ErrInvalidIDToken):
+		s.error(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, oidc.ErrNoUsername), errors.Is(err, oidc.ErrGroupNotAllowed):
+		s.error(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, oidc.ErrProvider):
+		s.logger.Error("identity provider request failed", "error", err)
+		s.error(w, http.StatusBadGateway, "identity provider request failed")
+	default:
+		s.logger.Error("single sign-on failed", "error", err)
+		s.error(w, http.StatusInternalServerError, "single sign-on failed")
+	}
+}
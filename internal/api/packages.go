@@ -0,0 +1,228 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/user"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/jail"
+	"github.com/rehmatworks/fastcp/internal/limits"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/packages"
+)
+
+// SetPackages attaches the hosting package manager
+func (s *Server) SetPackages(manager *packages.Manager) {
+	s.packages = manager
+}
+
+// listPackages returns all hosting packages (admin only)
+func (s *Server) listPackages(w http.ResponseWriter, r *http.Request) {
+	if s.packages == nil {
+		s.error(w, http.StatusServiceUnavailable, "packages not enabled")
+		return
+	}
+	s.success(w, s.packages.List())
+}
+
+// getPackage returns a hosting package by ID or name (admin only)
+func (s *Server) getPackage(w http.ResponseWriter, r *http.Request) {
+	if s.packages == nil {
+		s.error(w, http.StatusServiceUnavailable, "packages not enabled")
+		return
+	}
+
+	pkg, err := s.packages.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		s.error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.success(w, pkg)
+}
+
+// createPackage creates a hosting package (admin only)
+func (s *Server) createPackage(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.packages == nil {
+		s.error(w, http.StatusServiceUnavailable, "packages not enabled")
+		return
+	}
+
+	var req models.Package
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := validatePackagePHPVersions(req.PHPVersions); err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := s.packages.Create(&req)
+	if err != nil {
+		s.packageError(w, err)
+		return
+	}
+
+	s.logger.Info("package created", "id", created.ID, "name", created.Name, "user", claims.Username)
+	s.json(w, http.StatusCreated, created)
+}
+
+// updatePackage updates a hosting package and re-applies it to every user
+// assigned to it (admin only)
+func (s *Server) updatePackage(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.packages == nil {
+		s.error(w, http.StatusServiceUnavailable, "packages not enabled")
+		return
+	}
+
+	existing, err := s.packages.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		s.error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	var req models.Package
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := validatePackagePHPVersions(req.PHPVersions); err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updated, err := s.packages.Update(existing.ID, &req)
+	if err != nil {
+		s.packageError(w, err)
+		return
+	}
+
+	applied := 0
+	for _, l := range s.siteManager.ListUserLimits() {
+		if l.Package == updated.ID {
+			s.applyPackage(updated, l.Username)
+			applied++
+		}
+	}
+
+	s.logger.Info("package updated", "id", updated.ID, "name", updated.Name, "users", applied, "user", claims.Username)
+	s.success(w, updated)
+}
+
+// deletePackage deletes a hosting package that no user is assigned to (admin only)
+func (s *Server) deletePackage(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.packages == nil {
+		s.error(w, http.StatusServiceUnavailable, "packages not enabled")
+		return
+	}
+
+	pkg, err := s.packages.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		s.error(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	assigned := 0
+	for _, l := range s.siteManager.ListUserLimits() {
+		if l.Package == pkg.ID {
+			assigned++
+		}
+	}
+	if assigned > 0 {
+		s.error(w, http.StatusConflict, fmt.Sprintf("package is assigned to %d user(s)", assigned))
+		return
+	}
+
+	if err := s.packages.Delete(pkg.ID); err != nil {
+		s.packageError(w, err)
+		return
+	}
+
+	s.logger.Info("package deleted", "id", pkg.ID, "name", pkg.Name, "user", claims.Username)
+	s.success(w, map[string]string{"message": "package deleted"})
+}
+
+// resolvePackage looks up the package a user or WHMCS request refers to
+func (s *Server) resolvePackage(idOrName string) (*models.Package, error) {
+	if s.packages == nil {
+		return nil, errors.New("packages not enabled")
+	}
+	return s.packages.Get(idOrName)
+}
+
+// applyPackage assigns a package to a user: the package limits replace the
+// user's limits and are applied to the cgroup, disk quota and shell access
+func (s *Server) applyPackage(pkg *models.Package, username string) *models.UserLimits {
+	current := s.siteManager.GetUserLimit(username)
+	userLimits := packages.ApplyTo(pkg, current)
+	userLimits.Username = username
+
+	if err := s.siteManager.SetUserLimit(userLimits); err != nil {
+		s.logger.Warn("failed to save user limits", "user", username, "error", err)
+	}
+
+	limitsManager := limits.NewManager(s.logger)
+	if err := limitsManager.ApplyLimits(userLimits); err != nil {
+		s.logger.Warn("failed to apply system limits", "user", username, "error", err)
+	}
+	s.applyDiskQuota(userLimits)
+	s.applySandbox(username, current.Sandbox, userLimits.Sandbox)
+
+	if _, err := user.Lookup(username); err == nil {
+		s.setShellAccess(username, pkg.ShellAccess, jail.IsUserShellJailed(username))
+	}
+	return userLimits
+}
+
+// applySandbox moves the running PHP instances of a user into or out of
+// the sandbox after their package changed it
+func (s *Server) applySandbox(username string, was, now bool) {
+	if was != now {
+		s.reloadUserPHP(username)
+	}
+}
+
+// packageError maps package manager errors to HTTP responses
+func (s *Server) packageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, packages.ErrPackageNotFound):
+		s.error(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, packages.ErrPackageExists):
+		s.error(w, http.StatusConflict, err.Error())
+	case errors.Is(err, packages.ErrInvalidName), errors.Is(err, packages.ErrInvalidLimits):
+		s.error(w, http.StatusBadRequest, err.Error())
+	default:
+		s.logger.Error("failed to save package", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to save package")
+	}
+}
+
+// validatePackagePHPVersions checks that every allowed version is configured
+func validatePackagePHPVersions(versions []string) error {
+	cfg := config.Get()
+	for _, v := range versions {
+		found := false
+		for _, pv := range cfg.PHPVersions {
+			if pv.Version == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown PHP version: %s", v)
+		}
+	}
+	return nil
+}
@@ -60,12 +60,6 @@ func (s *Server) startPHPInstance(w http.ResponseWriter, r *http.Request) {
 	version := chi.URLParam(r, "version")
 	claims := middleware.GetClaims(r)
 
-	// Only admin can manage PHP instances
-	if claims.Role != "admin" {
-		s.error(w, http.StatusForbidden, "admin access required")
-		return
-	}
-
 	if err := s.phpManager.Start(version); err != nil {
 		s.logger.Error("failed to start PHP instance", "version", version, "error", err)
 		s.error(w, http.StatusInternalServerError, "failed to start PHP instance")
@@ -81,12 +75,6 @@ func (s *Server) stopPHPInstance(w http.ResponseWriter, r *http.Request) {
 	version := chi.URLParam(r, "version")
 	claims := middleware.GetClaims(r)
 
-	// Only admin can manage PHP instances
-	if claims.Role != "admin" {
-		s.error(w, http.StatusForbidden, "admin access required")
-		return
-	}
-
 	if err := s.phpManager.Stop(version); err != nil {
 		s.logger.Error("failed to stop PHP instance", "version", version, "error", err)
 		s.error(w, http.StatusInternalServerError, "failed to stop PHP instance")
@@ -102,12 +90,6 @@ func (s *Server) restartPHPInstance(w http.ResponseWriter, r *http.Request) {
 	version := chi.URLParam(r, "version")
 	claims := middleware.GetClaims(r)
 
-	// Only admin can manage PHP instances
-	if claims.Role != "admin" {
-		s.error(w, http.StatusForbidden, "admin access required")
-		return
-	}
-
 	if err := s.phpManager.Restart(version); err != nil {
 		s.logger.Error("failed to restart PHP instance", "version", version, "error", err)
 		s.error(w, http.StatusInternalServerError, "failed to restart PHP instance")
@@ -166,12 +148,6 @@ func (s *Server) downloadPHPVersion(w http.ResponseWriter, r *http.Request) {
 	version := chi.URLParam(r, "version")
 	claims := middleware.GetClaims(r)
 
-	// Only admin can download PHP versions
-	if claims.Role != "admin" {
-		s.error(w, http.StatusForbidden, "admin access required")
-		return
-	}
-
 	// Check if already downloading
 	downloadStatesMu.RLock()
 	state, exists := downloadStates[version]
@@ -279,12 +255,6 @@ type InstallPHPRequest struct {
 func (s *Server) installPHPVersion(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
-	// Only admin can install PHP versions
-	if claims.Role != "admin" {
-		s.error(w, http.StatusForbidden, "admin access required")
-		return
-	}
-
 	var req InstallPHPRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		s.error(w, http.StatusBadRequest, "invalid request body")
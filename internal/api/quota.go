@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/rehmatworks/fastcp/internal/caddy"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/quota"
+)
+
+// SetQuota attaches the disk quota monitor
+func (s *Server) SetQuota(monitor *quota.Monitor) {
+	s.quota = monitor
+}
+
+// applyDiskQuota applies a user's disk limit when quotas are enabled
+func (s *Server) applyDiskQuota(limits *models.UserLimits) {
+	if s.quota != nil {
+		s.quota.Apply(limits.Username, limits.MaxDiskMB)
+	}
+}
+
+// checkDiskQuota writes an error and returns false if writing size more
+// bytes to the site would take its owner over their disk limit
+func (s *Server) checkDiskQuota(w http.ResponseWriter, site *models.Site, size int64) bool {
+	if s.quota == nil {
+		return true
+	}
+	username := caddy.ExtractUsernameFromRootPath(site.RootPath)
+	if username == "" {
+		return true
+	}
+	if err := s.quota.Check(username, size); err != nil {
+		s.error(w, http.StatusInsufficientStorage, err.Error())
+		return false
+	}
+	return true
+}
+
+// accountDiskWrite records bytes written to a site since the last usage scan
+func (s *Server) accountDiskWrite(site *models.Site, size int64) {
+	if s.quota == nil {
+		return
+	}
+	if username := caddy.ExtractUsernameFromRootPath(site.RootPath); username != "" {
+		s.quota.Add(username, size)
+	}
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rehmatworks/fastcp/internal/analytics"
+	"github.com/rehmatworks/fastcp/internal/audit"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/ratelimit"
+)
+
+// maxAuditRange is the longest period the audit log can be queried for
+const maxAuditRange = 365 * 24 * time.Hour
+
+// SetRateLimiter attaches the login and API key rate limiter
+func (s *Server) SetRateLimiter(limiter *ratelimit.Limiter) {
+	s.limiter = limiter
+}
+
+// SetAudit attaches the audit log
+func (s *Server) SetAudit(log *audit.Log) {
+	s.audit = log
+}
+
+// loginBlocked answers with 429 and returns true when the client IP or the
+// username is locked out
+func (s *Server) loginBlocked(w http.ResponseWriter, r *http.Request, username string) bool {
+	if s.limiter == nil {
+		return false
+	}
+	wait := s.limiter.Check(clientIP(r), username)
+	if wait == 0 {
+		return false
+	}
+	s.tooManyRequests(w, wait, "too many failed attempts")
+	return true
+}
+
+// loginFailed records a failed login or API key and audits the lockouts it
+// caused
+func (s *Server) loginFailed(r *http.Request, username string) {
+	if s.limiter == nil {
+		return
+	}
+	ip := clientIP(r)
+	for _, block := range s.limiter.Fail(ip, username) {
+		action := audit.ActionLockout
+		if block.Reason == ratelimit.ReasonBan {
+			action = audit.ActionBan
+		}
+		s.logger.Warn("blocked after failed attempts", "type", block.Type, "value", block.Value,
+			"reason", block.Reason, "until", block.Until)
+		s.recordAudit(models.AuditLog{
+			Action:     action,
+			Resource:   block.Type,
+			ResourceID: block.Value,
+			Details:    fmt.Sprintf("%s until %s (lockout %d)", block.Reason, block.Until.UTC().Format(time.RFC3339), block.Lockouts),
+			IP:         ip,
+		})
+	}
+}
+
+// loginSucceeded forgets the failed attempts of a username
+func (s *Server) loginSucceeded(username string) {
+	if s.limiter != nil {
+		s.limiter.Succeed(username)
+	}
+}
+
+// limitAPIKeys throttles API key routes per IP and counts rejected keys as
+// failed attempts, so keys can't be guessed
+func (s *Server) limitAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.loginBlocked(w, r, "") {
+			return
+		}
+		if wait := s.limiter.AllowRequest(clientIP(r)); wait > 0 {
+			s.tooManyRequests(w, wait, "rate limit exceeded")
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status == http.StatusUnauthorized {
+			s.loginFailed(r, "")
+		}
+	})
+}
+
+// listBlocked returns the IPs and usernames that are currently blocked
+func (s *Server) listBlocked(w http.ResponseWriter, r *http.Request) {
+	if s.limiter == nil {
+		s.error(w, http.StatusServiceUnavailable, "rate limiting not enabled")
+		return
+	}
+	s.success(w, s.limiter.Blocked())
+}
+
+// unblock lifts a lockout or ban
+func (s *Server) unblock(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.limiter == nil {
+		s.error(w, http.StatusServiceUnavailable, "rate limiting not enabled")
+		return
+	}
+
+	blockType := chi.URLParam(r, "type")
+	value := chi.URLParam(r, "value")
+	if blockType != ratelimit.TypeIP && blockType != ratelimit.TypeUser {
+		s.error(w, http.StatusBadRequest, "type must be ip or user")
+		return
+	}
+
+	if err := s.limiter.Unblock(blockType, value); err != nil {
+		if errors.Is(err, ratelimit.ErrNotBlocked) {
+			s.error(w, http.StatusNotFound, err.Error())
+			return
+		}
+		s.error(w, http.StatusInternalServerError, "failed to unblock")
+		return
+	}
+
+	s.logger.Info("block lifted", "type", blockType, "value", value, "by", claims.Username)
+	s.recordAudit(models.AuditLog{
+		UserID:     claims.UserID,
+		Username:   claims.Username,
+		Action:     audit.ActionUnblock,
+		Resource:   blockType,
+		ResourceID: value,
+		IP:         clientIP(r),
+	})
+	s.success(w, map[string]string{"message": "unblocked"})
+}
+
+// listAudit returns audit log entries (?action=, ?range=30d, ?limit=100)
+func (s *Server) listAudit(w http.ResponseWriter, r *http.Request) {
+	if s.audit == nil {
+		s.error(w, http.StatusServiceUnavailable, "audit log not enabled")
+		return
+	}
+
+	q := r.URL.Query()
+	filter := audit.Filter{Action: q.Get("action"), Limit: 100}
+
+	rangeParam := q.Get("range")
+	if rangeParam == "" {
+		rangeParam = "30d"
+	}
+	d, err := analytics.ParseRange(rangeParam, maxAuditRange)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Since = time.Now().Add(-d)
+
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxEvents {
+			s.error(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		filter.Limit = n
+	}
+
+	entries, err := s.audit.List(filter)
+	if err != nil {
+		s.logger.Error("failed to list audit log", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to load audit log")
+		return
+	}
+	s.success(w, entries)
+}
+
+// getRecording serves a terminal recording referred to by the audit log,
+// in asciicast v2 format
+func (s *Server) getRecording(w http.ResponseWriter, r *http.Request) {
+	if s.audit == nil {
+		s.error(w, http.StatusServiceUnavailable, "audit log not enabled")
+		return
+	}
+
+	f, err := s.audit.OpenRecording(chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, audit.ErrRecordingNotFound) {
+			s.error(w, http.StatusNotFound, "recording not found")
+			return
+		}
+		s.logger.Error("failed to open recording", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to open recording")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	io.Copy(w, f)
+}
+
+// recordAudit writes an audit entry if the audit log is enabled
+func (s *Server) recordAudit(entry models.AuditLog) {
+	if s.audit == nil {
+		return
+	}
+	if err := s.audit.Record(entry); err != nil {
+		s.logger.Warn("failed to write audit log", "action", entry.Action, "error", err)
+	}
+}
+
+// tooManyRequests answers with 429 and a Retry-After header
+func (s *Server) tooManyRequests(w http.ResponseWriter, wait time.Duration, message string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	s.error(w, http.StatusTooManyRequests, fmt.Sprintf("%s, try again in %d seconds", message, seconds))
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
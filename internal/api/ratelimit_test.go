@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"testing"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/audit"
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/ratelimit"
+	"github.com/rehmatworks/fastcp/internal/twofactor"
+)
+
+func TestLogin_Lockout(t *testing.T) {
+	os.Unsetenv("FASTCP_DEV")
+	loaded, _ := config.Load("")
+	cfg := *loaded
+	cfg.JWTSecret = "test-secret"
+	config.Update(&cfg)
+
+	auth.SetPasswordVerifier(func(u, p string) bool { return p == "right" })
+	auth.SetGroupChecker(func(u, g string) bool { return g == "fastcp" })
+	auth.SetUserLookup(func(username string) (*user.User, error) {
+		return &user.User{Uid: "1000", Username: username}, nil
+	})
+	defer func() { auth.SetPasswordVerifier(nil); auth.SetGroupChecker(nil); auth.SetUserLookup(nil) }()
+
+	s := NewServer(nil, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	limiter, _ := ratelimit.New(models.RateLimitSettings{MaxFailuresPerUser: 2, WindowMinutes: 15, LockoutMinutes: 5})
+	s.SetRateLimiter(limiter)
+	log := audit.NewLog(t.TempDir())
+	s.SetAudit(log)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(LoginRequest{Username: "alice", Password: password})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", bytes.NewReader(data))
+		w := httptest.NewRecorder()
+		s.login(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := login("wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for a wrong password, got %d", w.Code)
+		}
+	}
+
+	// Locked out, even with the right password
+	w := login("right")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "300" {
+		t.Fatalf("expected 429 with Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	entries, err := log.List(audit.Filter{Action: audit.ActionLockout})
+	if err != nil || len(entries) != 1 || entries[0].ResourceID != "alice" {
+		t.Fatalf("expected a lockout audit entry, got %+v %v", entries, err)
+	}
+
+	if err := limiter.Unblock(ratelimit.TypeUser, "alice"); err != nil {
+		t.Fatalf("Unblock failed: %v", err)
+	}
+	if w := login("right"); w.Code != http.StatusOK {
+		t.Fatalf("expected login after unblock, got %d", w.Code)
+	}
+}
+
+func TestLogin_LockoutTwoFactor(t *testing.T) {
+	os.Unsetenv("FASTCP_DEV")
+	loaded, _ := config.Load("")
+	cfg := *loaded
+	cfg.JWTSecret = "test-secret"
+	cfg.RequireTwoFactorAdmins = false
+	config.Update(&cfg)
+
+	auth.SetPasswordVerifier(func(u, p string) bool { return p == "right" })
+	auth.SetGroupChecker(func(u, g string) bool { return g == "fastcp" })
+	auth.SetUserLookup(func(username string) (*user.User, error) {
+		return &user.User{Uid: "1000", Username: username}, nil
+	})
+	defer func() { auth.SetPasswordVerifier(nil); auth.SetGroupChecker(nil); auth.SetUserLookup(nil) }()
+
+	s := NewServer(nil, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	limiter, _ := ratelimit.New(models.RateLimitSettings{MaxFailuresPerUser: 2, WindowMinutes: 15, LockoutMinutes: 5})
+	s.SetRateLimiter(limiter)
+	tf := twofactor.NewManager(t.TempDir())
+	s.SetTwoFactor(tf)
+	secret, _, _ := tf.Begin("alice", "alice")
+	confirmCode, _ := twofactor.Code(secret, time.Now().Add(-twofactor.Period*time.Second))
+	if _, err := tf.Confirm("alice", confirmCode); err != nil {
+		t.Fatalf("Confirm failed: %v", err)
+	}
+
+	login := func(password string) (int, map[string]interface{}) {
+		return postJSON(s.login, LoginRequest{Username: "alice", Password: password})
+	}
+
+	// The password alone does not forget earlier failures
+	login("wrong")
+	status, resp := login("right")
+	challenge, _ := resp["challenge"].(string)
+	if status != http.StatusOK || challenge == "" {
+		t.Fatalf("expected a two-factor challenge, got %d %v", status, resp)
+	}
+	login("wrong")
+	if status, _ := login("right"); status != http.StatusTooManyRequests {
+		t.Fatalf("expected the account to be locked, got %d", status)
+	}
+
+	// Completing the second step does
+	limiter.Unblock(ratelimit.TypeUser, "alice")
+	login("wrong")
+	_, resp = login("right")
+	challenge, _ = resp["challenge"].(string)
+	code, _ := twofactor.Code(secret, time.Now())
+	if status, resp := postJSON(s.verifyTwoFactorLogin, TwoFactorRequest{Challenge: challenge, Code: code}); status != http.StatusOK {
+		t.Fatalf("expected the login to complete, got %d %v", status, resp)
+	}
+	login("wrong")
+	if status, _ := login("right"); status != http.StatusOK {
+		t.Fatalf("expected failures to be forgotten after a full login, got %d", status)
+	}
+}
+
+func TestLogin_SpoofedForwardedFor(t *testing.T) {
+	os.Unsetenv("FASTCP_DEV")
+	loaded, _ := config.Load("")
+	cfg := *loaded
+	cfg.JWTSecret = "test-secret"
+	config.Update(&cfg)
+	defer config.Update(loaded)
+
+	auth.SetPasswordVerifier(func(u, p string) bool { return false })
+	auth.SetGroupChecker(func(u, g string) bool { return g == "fastcp" })
+	auth.SetUserLookup(func(username string) (*user.User, error) {
+		return &user.User{Uid: "1000", Username: username}, nil
+	})
+	defer func() { auth.SetPasswordVerifier(nil); auth.SetGroupChecker(nil); auth.SetUserLookup(nil) }()
+
+	s := NewServer(nil, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	limiter, _ := ratelimit.New(models.RateLimitSettings{MaxFailuresPerIP: 2, WindowMinutes: 15, LockoutMinutes: 5})
+	s.SetRateLimiter(limiter)
+
+	login := func(peer, forwarded string) int {
+		data, _ := json.Marshal(LoginRequest{Username: "alice", Password: "wrong"})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", bytes.NewReader(data))
+		req.RemoteAddr = peer + ":40000"
+		req.Header.Set("X-Forwarded-For", forwarded)
+		req.Header.Set("X-Real-IP", forwarded)
+		req.Header.Set("True-Client-IP", forwarded)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Without trusted proxies the headers are ignored, so a new address in
+	// each of them does not escape the lockout of the peer
+	for i, forwarded := range []string{"198.51.100.1", "198.51.100.2"} {
+		if code := login("203.0.113.9", forwarded); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, code)
+		}
+	}
+	if code := login("203.0.113.9", "198.51.100.3"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the peer to be locked out despite spoofed headers, got %d", code)
+	}
+
+	// Behind a trusted proxy the forwarded client counts, and hops a
+	// client prepended to X-Forwarded-For are ignored
+	cfg.TrustedProxies = []string{"10.0.0.0/8"}
+	config.Update(&cfg)
+	for i := 0; i < 2; i++ {
+		if code := login("10.0.0.2", "192.0.2.1, 198.51.100.7"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d through the proxy: expected 401, got %d", i+1, code)
+		}
+	}
+	if code := login("10.0.0.2", "192.0.2.2, 198.51.100.7"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the forwarded client to be locked out, got %d", code)
+	}
+	if code := login("10.0.0.2", "198.51.100.8"); code != http.StatusUnauthorized {
+		t.Fatalf("expected another forwarded client to be let through, got %d", code)
+	}
+}
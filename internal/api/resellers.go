@@ -0,0 +1,199 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"os/user"
+	"slices"
+	"sort"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+// ErrAllocationExceeded is returned when a customer's limits do not fit in
+// what is left of the reseller's allocation
+var ErrAllocationExceeded = errors.New("reseller allocation exceeded")
+
+// errResellerShell is returned when a reseller grants full shell access,
+// which is not sandboxed; they grant SFTP or the jailed shell instead
+var errResellerShell = errors.New("resellers can only grant SFTP or jailed shell access")
+
+// OwnsUser reports whether username is a customer of the reseller. It is
+// used to decide which users a reseller may impersonate and manage.
+func (s *Server) OwnsUser(reseller, username string) bool {
+	if s.siteManager == nil || reseller == "" || reseller == username {
+		return false
+	}
+	return s.siteManager.GetUserLimit(username).Owner == reseller
+}
+
+// canManageUser reports whether the caller may view and change a user.
+// Admins manage everyone, resellers only their own customers.
+func (s *Server) canManageUser(claims *auth.Claims, username string) bool {
+	if claims == nil {
+		return false
+	}
+	if claims.Role == "admin" {
+		return true
+	}
+	return claims.Role == "reseller" && s.OwnsUser(claims.Username, username)
+}
+
+// isReseller reports whether a user is in a reseller group
+func (s *Server) isReseller(username string) bool {
+	for _, group := range auth.ResellerGroups {
+		if s.isUserInGroup(username, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// setReseller adds a user to or removes them from the reseller group
+func (s *Server) setReseller(username string, reseller bool) {
+	group := auth.ResellerGroups[0]
+	if reseller {
+		_ = exec.Command("groupadd", "-f", group).Run()
+		if output, err := exec.Command("usermod", "-aG", group, username).CombinedOutput(); err != nil {
+			s.logger.Warn("failed to add user to reseller group", "user", username, "error", err, "output", string(output))
+		}
+		return
+	}
+	if s.isReseller(username) {
+		if output, err := exec.Command("gpasswd", "-d", username, group).CombinedOutput(); err != nil {
+			s.logger.Warn("failed to remove user from reseller group", "user", username, "error", err, "output", string(output))
+		}
+	}
+}
+
+// customerSites returns the sites of a reseller's customers
+func (s *Server) customerSites(reseller string) []*models.Site {
+	var list []*models.Site
+	for _, c := range s.resellerCustomers(reseller) {
+		if u, err := user.Lookup(c.Username); err == nil {
+			list = append(list, s.siteManager.List(u.Uid)...)
+		}
+	}
+	return list
+}
+
+// customerDatabases returns the databases of a reseller's customers
+func (s *Server) customerDatabases(reseller string) []*models.Database {
+	var list []*models.Database
+	for _, c := range s.resellerCustomers(reseller) {
+		if u, err := user.Lookup(c.Username); err == nil {
+			list = append(list, s.dbManager.List(u.Uid)...)
+		}
+	}
+	return list
+}
+
+// ownsUID reports whether the account with the given uid is a customer of
+// the reseller
+func (s *Server) ownsUID(reseller, uid string) bool {
+	if s.siteManager == nil {
+		return false
+	}
+	for _, c := range s.resellerCustomers(reseller) {
+		if u, err := user.Lookup(c.Username); err == nil && u.Uid == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// resellerCustomers returns the limits of every customer of a reseller,
+// sorted by username
+func (s *Server) resellerCustomers(reseller string) []models.UserLimits {
+	var customers []models.UserLimits
+	for _, l := range s.siteManager.ListUserLimits() {
+		if l.Owner == reseller {
+			customers = append(customers, l)
+		}
+	}
+	sort.Slice(customers, func(i, j int) bool {
+		return customers[i].Username < customers[j].Username
+	})
+	return customers
+}
+
+// checkResellerAllocation checks a customer's new limits against the
+// reseller's allocation, counting the reseller's other customers. Customers
+// without their own PHP version list inherit the reseller's.
+func (s *Server) checkResellerAllocation(reseller string, customer *models.UserLimits) error {
+	pool := s.siteManager.GetUserLimit(reseller)
+	if len(customer.PHPVersions) == 0 {
+		customer.PHPVersions = append([]string(nil), pool.PHPVersions...)
+	}
+
+	var others []models.UserLimits
+	for _, l := range s.resellerCustomers(reseller) {
+		if l.Username != customer.Username {
+			others = append(others, l)
+		}
+	}
+	return checkAllocation(pool, others, customer)
+}
+
+// checkAllocation checks that a customer fits in a reseller's allocation.
+// The reseller's own limits are the pool: sites, RAM, disk, bandwidth and
+// databases are shared between all customers, CPU and processes cap each
+// customer. When the pool is limited (non-zero), the customer must get an
+// explicit limit too, otherwise one account could use the whole pool.
+func checkAllocation(pool *models.UserLimits, others []models.UserLimits, customer *models.UserLimits) error {
+	if pool.MaxUsers > 0 && len(others) >= pool.MaxUsers {
+		return fmt.Errorf("%w: account limit of %d reached", ErrAllocationExceeded, pool.MaxUsers)
+	}
+
+	shared := []struct {
+		name       string
+		pool, want int64
+		used       func(l models.UserLimits) int64
+	}{
+		{"site_limit", int64(pool.MaxSites), int64(customer.MaxSites), func(l models.UserLimits) int64 { return int64(l.MaxSites) }},
+		{"ram_limit_mb", pool.MaxRAMMB, customer.MaxRAMMB, func(l models.UserLimits) int64 { return l.MaxRAMMB }},
+		{"disk_limit_mb", pool.MaxDiskMB, customer.MaxDiskMB, func(l models.UserLimits) int64 { return l.MaxDiskMB }},
+		{"bandwidth_mb", pool.MaxBandwidthMB, customer.MaxBandwidthMB, func(l models.UserLimits) int64 { return l.MaxBandwidthMB }},
+		{"max_databases", int64(pool.MaxDatabases), int64(customer.MaxDatabases), func(l models.UserLimits) int64 { return int64(l.MaxDatabases) }},
+	}
+	for _, r := range shared {
+		if r.pool == 0 {
+			continue
+		}
+		var used int64
+		for _, l := range others {
+			used += r.used(l)
+		}
+		left := max(r.pool-used, 0)
+		if r.want <= 0 || r.want > left {
+			return fmt.Errorf("%w: %s must be between 1 and %d", ErrAllocationExceeded, r.name, left)
+		}
+	}
+
+	perUser := []struct {
+		name       string
+		pool, want int
+	}{
+		{"cpu_percent", pool.MaxCPUPercent, customer.MaxCPUPercent},
+		{"max_processes", pool.MaxProcesses, customer.MaxProcesses},
+	}
+	for _, r := range perUser {
+		if r.pool > 0 && (r.want <= 0 || r.want > r.pool) {
+			return fmt.Errorf("%w: %s must be between 1 and %d", ErrAllocationExceeded, r.name, r.pool)
+		}
+	}
+
+	if len(pool.PHPVersions) > 0 {
+		if len(customer.PHPVersions) == 0 {
+			return fmt.Errorf("%w: php_versions must be limited to %v", ErrAllocationExceeded, pool.PHPVersions)
+		}
+		for _, v := range customer.PHPVersions {
+			if !slices.Contains(pool.PHPVersions, v) {
+				return fmt.Errorf("%w: PHP %s is not available", ErrAllocationExceeded, v)
+			}
+		}
+	}
+	return nil
+}
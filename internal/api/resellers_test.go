@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+func TestCheckAllocation(t *testing.T) {
+	pool := &models.UserLimits{
+		Username:      "reseller",
+		MaxUsers:      2,
+		MaxSites:      10,
+		MaxDiskMB:     10240,
+		MaxCPUPercent: 100,
+		PHPVersions:   []string{"8.3", "8.4"},
+	}
+	others := []models.UserLimits{{Username: "alice", Owner: "reseller", MaxSites: 6, MaxDiskMB: 4096, MaxCPUPercent: 50}}
+	customer := func() *models.UserLimits {
+		return &models.UserLimits{Username: "bob", MaxSites: 4, MaxDiskMB: 6144, MaxCPUPercent: 100, PHPVersions: []string{"8.4"}}
+	}
+
+	if err := checkAllocation(pool, others, customer()); err != nil {
+		t.Fatalf("expected customer to fit, got %v", err)
+	}
+
+	cases := map[string]func(c *models.UserLimits){
+		"sites over what is left": func(c *models.UserLimits) { c.MaxSites = 5 },
+		"unlimited disk":          func(c *models.UserLimits) { c.MaxDiskMB = 0 },
+		"cpu over the pool":       func(c *models.UserLimits) { c.MaxCPUPercent = 200 },
+		"php version not in pool": func(c *models.UserLimits) { c.PHPVersions = []string{"7.4"} },
+	}
+	for name, mutate := range cases {
+		c := customer()
+		mutate(c)
+		if err := checkAllocation(pool, others, c); !errors.Is(err, ErrAllocationExceeded) {
+			t.Fatalf("%s: expected ErrAllocationExceeded, got %v", name, err)
+		}
+	}
+
+	// The account limit counts the other customers only
+	full := append(others, models.UserLimits{Username: "carol", Owner: "reseller"})
+	if err := checkAllocation(pool, full, customer()); !errors.Is(err, ErrAllocationExceeded) {
+		t.Fatalf("expected the account limit to be enforced, got %v", err)
+	}
+
+	// An unlimited reseller accepts any customer
+	if err := checkAllocation(&models.UserLimits{}, full, &models.UserLimits{Username: "bob"}); err != nil {
+		t.Fatalf("expected unlimited pool to accept customer, got %v", err)
+	}
+}
+
+func TestResellerImpersonation(t *testing.T) {
+	loaded, _ := config.Load("")
+	cfg := *loaded
+	cfg.JWTSecret = "test-secret"
+	config.Update(&cfg)
+
+	middleware.SetImpersonationChecker(func(claims *auth.Claims) bool {
+		return claims.Impersonator.Username == "dana" && claims.Username == "alice"
+	})
+	defer middleware.SetImpersonationChecker(nil)
+
+	reseller := &auth.Claims{UserID: "1003", Username: "dana", Role: "reseller"}
+
+	var got, real *auth.Claims
+	handler := middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = middleware.GetClaims(r)
+		real = middleware.GetRealClaims(r)
+	}))
+
+	for _, tc := range []struct {
+		target string
+		status int
+	}{
+		{"alice", http.StatusOK},
+		{"bob", http.StatusUnauthorized},
+	} {
+		got = nil
+		token, _, err := auth.GenerateImpersonationToken(&models.User{ID: "1001", Username: tc.target, Role: "user"}, reseller)
+		if err != nil {
+			t.Fatalf("failed to generate token: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/sites", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Fatalf("impersonating %s: expected %d, got %d", tc.target, tc.status, w.Code)
+		}
+		if tc.status == http.StatusOK && (got == nil || got.Username != "alice" || got.UserID != "1001" || real.Username != "dana") {
+			t.Fatalf("expected impersonated user claims, got %+v (real %+v)", got, real)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os/user"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/rbac"
+)
+
+// SetRoles attaches the custom role manager
+func (s *Server) SetRoles(manager *rbac.Manager) {
+	s.roles = manager
+}
+
+// HasPermission reports whether a user has a permission through their
+// built-in role or an assigned custom role. Used by the permission
+// middleware.
+func (s *Server) HasPermission(claims *auth.Claims, permission string) bool {
+	return rbac.Grants(s.roles.Permissions(claims.Role, claims.Username), permission)
+}
+
+// permissionsOf returns the permissions of the current user
+func (s *Server) permissionsOf(claims *auth.Claims) []string {
+	return s.roles.Permissions(claims.Role, claims.Username)
+}
+
+// listRoles returns the permission catalog with the built-in and custom roles
+func (s *Server) listRoles(w http.ResponseWriter, r *http.Request) {
+	roles := rbac.BuiltIn()
+	if s.roles != nil {
+		roles = append(roles, s.roles.List()...)
+	}
+	s.success(w, map[string]interface{}{
+		"roles":       roles,
+		"permissions": rbac.Catalog,
+	})
+}
+
+// getRole returns a custom role
+func (s *Server) getRole(w http.ResponseWriter, r *http.Request) {
+	if s.roles == nil {
+		s.error(w, http.StatusServiceUnavailable, "roles not enabled")
+		return
+	}
+
+	role, err := s.roles.Get(chi.URLParam(r, "name"))
+	if err != nil {
+		s.roleError(w, err)
+		return
+	}
+	s.success(w, role)
+}
+
+// createRole creates a custom role
+func (s *Server) createRole(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.roles == nil {
+		s.error(w, http.StatusServiceUnavailable, "roles not enabled")
+		return
+	}
+
+	var req models.Role
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	created, err := s.roles.Create(&req)
+	if err != nil {
+		s.roleError(w, err)
+		return
+	}
+
+	s.logger.Info("role created", "role", created.Name, "permissions", created.Permissions, "user", claims.Username)
+	s.json(w, http.StatusCreated, created)
+}
+
+// updateRole replaces the permissions of a custom role. Users holding it
+// get the new permissions on their next request.
+func (s *Server) updateRole(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.roles == nil {
+		s.error(w, http.StatusServiceUnavailable, "roles not enabled")
+		return
+	}
+
+	var req models.Role
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	updated, err := s.roles.Update(chi.URLParam(r, "name"), &req)
+	if err != nil {
+		s.roleError(w, err)
+		return
+	}
+
+	s.logger.Info("role updated", "role", updated.Name, "permissions", updated.Permissions, "user", claims.Username)
+	s.success(w, updated)
+}
+
+// deleteRole deletes a custom role and removes it from every user
+func (s *Server) deleteRole(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.roles == nil {
+		s.error(w, http.StatusServiceUnavailable, "roles not enabled")
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	if err := s.roles.Delete(name); err != nil {
+		s.roleError(w, err)
+		return
+	}
+
+	s.logger.Info("role deleted", "role", name, "user", claims.Username)
+	s.success(w, map[string]string{"message": "role deleted"})
+}
+
+// getUserRoles returns the custom roles assigned to a user and the
+// permissions they add up to
+func (s *Server) getUserRoles(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+
+	u, err := s.getFastCPUser(username)
+	if err != nil {
+		s.error(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	role := "user"
+	switch {
+	case u.IsAdmin:
+		role = "admin"
+	case u.IsReseller:
+		role = "reseller"
+	}
+	s.success(w, map[string]interface{}{
+		"username":    username,
+		"role":        role,
+		"roles":       u.Roles,
+		"permissions": s.roles.Permissions(role, username),
+	})
+}
+
+// setUserRoles replaces the custom roles assigned to a user
+func (s *Server) setUserRoles(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	username := chi.URLParam(r, "username")
+
+	if s.roles == nil {
+		s.error(w, http.StatusServiceUnavailable, "roles not enabled")
+		return
+	}
+	if _, err := user.Lookup(username); err != nil {
+		s.error(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	var req struct {
+		Roles []string `json:"roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := s.roles.Assign(username, req.Roles); err != nil {
+		s.roleError(w, err)
+		return
+	}
+
+	s.logger.Info("roles assigned", "username", username, "roles", req.Roles, "by", claims.Username)
+	s.success(w, map[string]interface{}{
+		"username": username,
+		"roles":    s.roles.Assigned(username),
+	})
+}
+
+// roleError maps role manager errors to HTTP responses
+func (s *Server) roleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, rbac.ErrRoleNotFound):
+		s.error(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, rbac.ErrRoleExists):
+		s.error(w, http.StatusConflict, err.Error())
+	case errors.Is(err, rbac.ErrInvalidName), errors.Is(err, rbac.ErrUnknownPermission),
+		errors.Is(err, rbac.ErrReservedPermission):
+		s.error(w, http.StatusBadRequest, err.Error())
+	default:
+		s.logger.Error("failed to save roles", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to save roles")
+	}
+}
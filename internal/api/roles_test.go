@@ -0,0 +1,95 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/rbac"
+)
+
+func TestPermissions_CustomRole(t *testing.T) {
+	loaded, _ := config.Load("")
+	cfg := *loaded
+	cfg.JWTSecret = "test-secret"
+	config.Update(&cfg)
+
+	s := NewServer(nil, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	roles := rbac.NewManager(t.TempDir())
+	s.SetRoles(roles)
+	middleware.SetPermissionChecker(s.HasPermission)
+	defer middleware.SetPermissionChecker(nil)
+
+	token, _ := auth.GenerateToken(&models.User{ID: "1001", Username: "junior", Role: "user"})
+	get := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Users can't see the bandwidth report or manage roles
+	if code := get("/api/v1/bandwidth"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 without bandwidth:read, got %d", code)
+	}
+	if code := get("/api/v1/admin/roles"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 without roles:manage, got %d", code)
+	}
+
+	// Host-level permissions stay with admins
+	if _, err := roles.Create(&models.Role{Name: "support", Permissions: []string{rbac.SecurityManage}}); !errors.Is(err, rbac.ErrReservedPermission) {
+		t.Fatalf("expected security:manage to be reserved, got %v", err)
+	}
+	if _, err := roles.Create(&models.Role{Name: "support", Permissions: []string{rbac.BandwidthRead, rbac.PHPManage}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := roles.Assign("junior", []string{"support"}); err != nil {
+		t.Fatalf("Assign failed: %v", err)
+	}
+
+	// Past the permission check; bandwidth accounting itself is not attached
+	if code := get("/api/v1/bandwidth"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the support role to reach the bandwidth report, got %d", code)
+	}
+	if code := get("/api/v1/admin/audit"); code != http.StatusForbidden {
+		t.Fatalf("expected the audit log to stay admin only, got %d", code)
+	}
+	if code := get("/api/v1/admin/roles"); code != http.StatusForbidden {
+		t.Fatalf("expected roles:manage to stay admin only, got %d", code)
+	}
+
+	// Admins keep every permission
+	adminToken, _ := auth.GenerateToken(&models.User{ID: "0", Username: "root", Role: "admin"})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/roles", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected admin to list roles, got %d", w.Code)
+	}
+}
+
+func TestPermissions_APIKeyScopes(t *testing.T) {
+	s := NewServer(nil, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	auth.SetAPIKeyValidator(func(key string) (*models.APIKey, error) {
+		return &models.APIKey{Key: key, Permissions: []string{rbac.SitesRead}}, nil
+	})
+	defer auth.SetAPIKeyValidator(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/whmcs/provision", nil)
+	req.Header.Set("X-API-Key", "fcp_readonly")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected a read-only key to be refused provisioning, got %d", w.Code)
+	}
+}
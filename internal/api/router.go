@@ -10,28 +10,58 @@ import (
 	chiMiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 
+	"github.com/rehmatworks/fastcp/internal/analytics"
+	"github.com/rehmatworks/fastcp/internal/audit"
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/bandwidth"
 	"github.com/rehmatworks/fastcp/internal/caddy"
 	"github.com/rehmatworks/fastcp/internal/database"
+	"github.com/rehmatworks/fastcp/internal/events"
+	"github.com/rehmatworks/fastcp/internal/metrics"
 	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/oidc"
+	"github.com/rehmatworks/fastcp/internal/packages"
 	"github.com/rehmatworks/fastcp/internal/php"
+	"github.com/rehmatworks/fastcp/internal/quota"
+	"github.com/rehmatworks/fastcp/internal/ratelimit"
+	"github.com/rehmatworks/fastcp/internal/rbac"
+	"github.com/rehmatworks/fastcp/internal/sessions"
 	"github.com/rehmatworks/fastcp/internal/sites"
 	"github.com/rehmatworks/fastcp/internal/ssl"
 	"github.com/rehmatworks/fastcp/internal/static"
+	"github.com/rehmatworks/fastcp/internal/teams"
+	"github.com/rehmatworks/fastcp/internal/twofactor"
 	"github.com/rehmatworks/fastcp/internal/upgrade"
 )
 
 // Server holds all API handlers and dependencies
 type Server struct {
-	router         chi.Router
-	siteManager    *sites.Manager
-	phpManager     *php.Manager
-	userPHPManager *php.UserPHPManager
-	dbManager      *database.Manager
-	sslManager     *ssl.Manager
-	caddyGen       *caddy.Generator
-	upgradeManager *upgrade.Manager
-	fileManager    *FileManager
-	logger         *slog.Logger
+	router          chi.Router
+	siteManager     *sites.Manager
+	phpManager      *php.Manager
+	userPHPManager  *php.UserPHPManager
+	dbManager       *database.Manager
+	sslManager      *ssl.Manager
+	caddyGen        *caddy.Generator
+	upgradeManager  *upgrade.Manager
+	fileManager     *FileManager
+	analytics       *analytics.Collector
+	bandwidth       *bandwidth.Manager
+	quota           *quota.Monitor
+	usage           *metrics.Sampler
+	events          *events.Manager
+	packages        *packages.Manager
+	twoFactor       *twofactor.Manager
+	webAuthn        *auth.WebAuthn
+	sessions        *sessions.Manager
+	limiter         *ratelimit.Limiter
+	audit           *audit.Log
+	oidc            *oidc.Provider
+	roles           *rbac.Manager
+	teams           *teams.Manager
+	terminalTickets terminalTickets
+	challenges      usedChallenges
+	logger          *slog.Logger
 }
 
 // NewServer creates a new API server
@@ -54,6 +84,7 @@ func NewServer(
 		caddyGen:       caddyGen,
 		upgradeManager: upgradeManager,
 		fileManager:    NewFileManager(siteManager),
+		challenges:     usedChallenges{started: time.Now()},
 		logger:         logger,
 	}
 
@@ -70,12 +101,15 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (s *Server) setupRoutes() {
 	r := chi.NewRouter()
 
+	// Every protected route requires a permission; see internal/rbac
+	can := middleware.RequirePermission
+
 	// Middleware
 	r.Use(chiMiddleware.RequestID)
-	r.Use(chiMiddleware.RealIP)
+	r.Use(middleware.RealIP)
 	r.Use(chiMiddleware.Logger)
 	r.Use(chiMiddleware.Recoverer)
-	r.Use(chiMiddleware.Timeout(60 * time.Second))
+	r.Use(exceptWebSockets(chiMiddleware.Timeout(60 * time.Second)))
 
 	// CORS
 	r.Use(cors.Handler(cors.Options{
@@ -95,17 +129,33 @@ func (s *Server) setupRoutes() {
 		// Public routes
 		r.Post("/auth/login", s.login)
 		r.Post("/auth/refresh", s.refreshToken)
-
-		// WHMCS integration routes (API key auth)
+		r.Post("/auth/2fa/verify", s.verifyTwoFactorLogin)
+		r.Post("/auth/2fa/setup", s.setupTwoFactorLogin)
+		r.Post("/auth/2fa/setup/confirm", s.confirmTwoFactorLogin)
+		r.Post("/auth/webauthn/login/begin", s.beginWebAuthnLogin)
+		r.Post("/auth/webauthn/login/finish", s.finishWebAuthnLogin)
+		r.Get("/auth/oidc", s.getOIDCInfo)
+		r.Post("/auth/oidc/begin", s.beginOIDCLogin)
+		r.Post("/auth/oidc/callback", s.finishOIDCLogin)
+
+		// Web terminals, authorized by a ticket from the protected route
+		// below since browsers cannot send tokens on WebSockets
+		r.Get("/sites/{id}/terminal", s.siteTerminal)
+
+		// WHMCS integration routes (API key auth, scoped by the key's
+		// permissions)
 		r.Route("/whmcs", func(r chi.Router) {
+			r.Use(s.limitAPIKeys)
 			r.Use(middleware.APIKeyMiddleware)
-			r.Post("/provision", s.whmcsProvision)
-			r.Get("/status/{service_id}", s.whmcsStatus)
+			r.With(can(rbac.SitesWrite)).Post("/provision", s.whmcsProvision)
+			r.With(can(rbac.SitesRead)).Get("/status/{service_id}", s.whmcsStatus)
+			r.With(can(rbac.SitesRead)).Get("/usage", s.whmcsUsage)
 		})
 
 		// Protected routes
 		r.Group(func(r chi.Router) {
 			r.Use(middleware.AuthMiddleware)
+			r.Use(s.auditImpersonation)
 
 			// Current user
 			r.Get("/me", s.getCurrentUser)
@@ -114,113 +164,198 @@ func (s *Server) setupRoutes() {
 			r.Get("/me/ssh-keys", s.getSSHKeys)
 			r.Post("/me/ssh-keys", s.addSSHKey)
 			r.Delete("/me/ssh-keys/{fingerprint}", s.deleteSSHKey)
+			r.Get("/me/2fa", s.getTwoFactorStatus)
+			r.Post("/me/2fa/enroll", s.enrollTwoFactor)
+			r.Post("/me/2fa/confirm", s.confirmTwoFactor)
+			r.Post("/me/2fa/recovery-codes", s.regenerateRecoveryCodes)
+			r.Delete("/me/2fa", s.disableTwoFactor)
+			r.Get("/me/webauthn", s.listWebAuthnCredentials)
+			r.Post("/me/webauthn/register/begin", s.beginWebAuthnRegistration)
+			r.Post("/me/webauthn/register/finish", s.finishWebAuthnRegistration)
+			r.Delete("/me/webauthn/{id}", s.deleteWebAuthnCredential)
+			r.Get("/me/sessions", s.listSessions)
+			r.Delete("/me/sessions", s.revokeAllSessions)
+			r.Delete("/me/sessions/{id}", s.revokeSession)
+			r.Get("/me/team", s.getTeam)
+			r.Post("/me/team/members", s.inviteTeamMember)
+			r.Put("/me/team/members/{id}", s.updateTeamMember)
+			r.Delete("/me/team/members/{id}", s.removeTeamMember)
+			r.Post("/me/team/invitations/{id}/accept", s.acceptTeamInvitation)
+			r.Delete("/me/team/invitations/{id}", s.removeTeamMember)
+			r.Delete("/me/impersonation", s.stopImpersonation)
+			r.Post("/auth/logout", s.logout)
 
 			// Sites
 			r.Route("/sites", func(r chi.Router) {
-				r.Get("/", s.listSites)
-				r.Post("/", s.createSite)
-				r.Get("/{id}", s.getSite)
-				r.Put("/{id}", s.updateSite)
-				r.Delete("/{id}", s.deleteSite)
-				r.Post("/{id}/suspend", s.suspendSite)
-				r.Post("/{id}/unsuspend", s.unsuspendSite)
-				r.Post("/{id}/restart-workers", s.restartSiteWorkers)
+				r.With(can(rbac.SitesRead)).Get("/", s.listSites)
+				r.With(can(rbac.SitesWrite)).Post("/", s.createSite)
+				r.With(can(rbac.SitesRead)).Get("/{id}", s.getSite)
+				r.With(can(rbac.SitesWrite)).Put("/{id}", s.updateSite)
+				r.With(can(rbac.SitesWrite)).Delete("/{id}", s.deleteSite)
+				r.With(can(rbac.SitesSuspend)).Post("/{id}/suspend", s.suspendSite)
+				r.With(can(rbac.SitesSuspend)).Post("/{id}/unsuspend", s.unsuspendSite)
+				r.With(can(rbac.SitesWrite)).Post("/{id}/restart-workers", s.restartSiteWorkers)
+				r.With(can(rbac.SitesTerminal)).Post("/{id}/terminal/ticket", s.createTerminalTicket)
+
+				// Logs
+				r.With(can(rbac.SitesRead)).Get("/{id}/logs", s.getSiteLogs)
+				r.With(can(rbac.SitesRead)).Get("/{id}/analytics", s.getSiteAnalytics)
+
+				// Custom error pages
+				r.With(can(rbac.SitesRead)).Get("/{id}/error-pages", s.listSiteErrorPages)
+				r.With(can(rbac.SitesWrite)).Put("/{id}/error-pages", s.updateSiteErrorPageSettings)
+				r.With(can(rbac.SitesRead)).Get("/{id}/error-pages/{code}", s.getSiteErrorPage)
+				r.With(can(rbac.SitesWrite)).Put("/{id}/error-pages/{code}", s.setSiteErrorPage)
+				r.With(can(rbac.SitesWrite)).Delete("/{id}/error-pages/{code}", s.deleteSiteErrorPage)
 
 				// File Manager
 				r.Route("/{site_id}/files", func(r chi.Router) {
-					r.Get("/", s.listFiles)
-					r.Get("/content", s.getFileContent)
-					r.Put("/content", s.saveFileContent)
-					r.Post("/directory", s.createDirectory)
-					r.Delete("/", s.deleteFile)
-					r.Post("/upload", s.uploadFile)
-					r.Get("/download", s.downloadFile)
+					r.With(can(rbac.FilesRead)).Get("/", s.listFiles)
+					r.With(can(rbac.FilesRead)).Get("/content", s.getFileContent)
+					r.With(can(rbac.FilesWrite)).Put("/content", s.saveFileContent)
+					r.With(can(rbac.FilesWrite)).Post("/directory", s.createDirectory)
+					r.With(can(rbac.FilesWrite)).Delete("/", s.deleteFile)
+					r.With(can(rbac.FilesWrite)).Post("/upload", s.uploadFile)
+					r.With(can(rbac.FilesRead)).Get("/download", s.downloadFile)
 				})
 			})
 
 			// PHP Instances
 			r.Route("/php", func(r chi.Router) {
-				r.Get("/", s.listPHPInstances)
-				r.Get("/available", s.getAvailablePHPVersions)
-				r.Post("/install", s.installPHPVersion)
-				r.Get("/{version}", s.getPHPInstance)
-				r.Post("/{version}/start", s.startPHPInstance)
-				r.Post("/{version}/stop", s.stopPHPInstance)
-				r.Post("/{version}/restart", s.restartPHPInstance)
-				r.Post("/{version}/restart-workers", s.restartPHPWorkers)
-				r.Post("/{version}/download", s.downloadPHPVersion)
-				r.Get("/{version}/download/status", s.getDownloadStatus)
+				r.With(can(rbac.PHPRead)).Get("/", s.listPHPInstances)
+				r.With(can(rbac.PHPRead)).Get("/available", s.getAvailablePHPVersions)
+				r.With(can(rbac.PHPInstall)).Post("/install", s.installPHPVersion)
+				r.With(can(rbac.PHPRead)).Get("/{version}", s.getPHPInstance)
+				r.With(can(rbac.PHPManage)).Post("/{version}/start", s.startPHPInstance)
+				r.With(can(rbac.PHPManage)).Post("/{version}/stop", s.stopPHPInstance)
+				r.With(can(rbac.PHPManage)).Post("/{version}/restart", s.restartPHPInstance)
+				r.With(can(rbac.PHPManage)).Post("/{version}/restart-workers", s.restartPHPWorkers)
+				r.With(can(rbac.PHPInstall)).Post("/{version}/download", s.downloadPHPVersion)
+				r.With(can(rbac.PHPRead)).Get("/{version}/download/status", s.getDownloadStatus)
 			})
 
 			// Databases
 			r.Route("/databases", func(r chi.Router) {
-				r.Get("/", s.listDatabases)
-				r.Post("/", s.createDatabase)
-				r.Get("/status", s.getDatabaseStatus)
-				r.Post("/install/mysql", s.installMySQL)
-				r.Get("/install/mysql/status", s.getMySQLInstallStatus)
-				r.Post("/install/postgresql", s.installPostgreSQL)
-				r.Get("/install/postgresql/status", s.getPostgreSQLInstallStatus)
-				r.Get("/{id}", s.getDatabase)
-				r.Delete("/{id}", s.deleteDatabase)
-				r.Post("/{id}/reset-password", s.resetDatabasePassword)
+				r.With(can(rbac.DatabasesRead)).Get("/", s.listDatabases)
+				r.With(can(rbac.DatabasesCreate)).Post("/", s.createDatabase)
+				r.With(can(rbac.DatabasesRead)).Get("/status", s.getDatabaseStatus)
+				r.With(can(rbac.DatabasesInstall)).Post("/install/mysql", s.installMySQL)
+				r.With(can(rbac.DatabasesRead)).Get("/install/mysql/status", s.getMySQLInstallStatus)
+				r.With(can(rbac.DatabasesInstall)).Post("/install/postgresql", s.installPostgreSQL)
+				r.With(can(rbac.DatabasesRead)).Get("/install/postgresql/status", s.getPostgreSQLInstallStatus)
+				r.With(can(rbac.DatabasesRead)).Get("/{id}", s.getDatabase)
+				r.With(can(rbac.DatabasesCreate)).Delete("/{id}", s.deleteDatabase)
+				r.With(can(rbac.DatabasesCreate)).Post("/{id}/reset-password", s.resetDatabasePassword)
 			})
 
 			// SSL Certificates
 			r.Route("/certificates", func(r chi.Router) {
-				r.Get("/", s.listCertificates)
-				r.Post("/", s.issueCertificate)
-				r.Get("/{id}", s.getCertificate)
-				r.Delete("/{id}", s.deleteCertificate)
-				r.Post("/{id}/renew", s.renewCertificate)
+				r.With(can(rbac.SSLRead)).Get("/", s.listCertificates)
+				r.With(can(rbac.SSLIssue)).Post("/", s.issueCertificate)
+				r.With(can(rbac.SSLRead)).Get("/{id}", s.getCertificate)
+				r.With(can(rbac.SSLIssue)).Delete("/{id}", s.deleteCertificate)
+				r.With(can(rbac.SSLIssue)).Post("/{id}/renew", s.renewCertificate)
 			})
 
 			// Site certificates
-			r.Get("/sites/{siteId}/certificates", s.getSiteCertificates)
+			r.With(can(rbac.SSLRead)).Get("/sites/{siteId}/certificates", s.getSiteCertificates)
 
 			// Dashboard stats
 			r.Get("/stats", s.getStats)
 
+			// Resource limit events (own events for non-admins)
+			r.With(can(rbac.EventsRead)).Get("/events", s.listEvents)
+
 			// Version info (available to all authenticated users)
 			r.Get("/version", s.getVersion)
 
-			// Admin-only routes
-			r.Group(func(r chi.Router) {
-				r.Use(middleware.AdminOnlyMiddleware)
-
-				// User Management
-				r.Route("/users", func(r chi.Router) {
-					r.Get("/", s.listUsers)
-					r.Post("/", s.createUser)
-					r.Post("/fix-permissions", s.fixUserPermissions)
-					r.Get("/{username}", s.getUser)
-					r.Put("/{username}", s.updateUser)
-					r.Delete("/{username}", s.deleteUser)
+			// User Management (resellers only see their own customers)
+			r.Route("/users", func(r chi.Router) {
+				r.With(can(rbac.UsersRead)).Get("/", s.listUsers)
+				r.With(can(rbac.UsersManage)).Post("/", s.createUser)
+				r.With(can(rbac.SystemManage)).Post("/fix-permissions", s.fixUserPermissions)
+				r.With(can(rbac.UsersRead)).Get("/{username}", s.getUser)
+				r.With(can(rbac.UsersManage)).Put("/{username}", s.updateUser)
+				r.With(can(rbac.UsersManage)).Delete("/{username}", s.deleteUser)
+				r.With(can(rbac.UsersRead)).Get("/{username}/suspended-page", s.getSuspendedPage)
+				r.With(can(rbac.UsersManage)).Put("/{username}/suspended-page", s.setSuspendedPage)
+				r.With(can(rbac.UsersManage)).Delete("/{username}/suspended-page", s.deleteSuspendedPage)
+			})
+
+			r.Route("/admin", func(r chi.Router) {
+				// Per-user administration does not check ownership, so it
+				// stays with admins
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.AdminOnlyMiddleware)
+					r.Get("/users/{username}", s.getUser)
+					r.Get("/users/{username}/usage", s.getUserUsageHistory)
+					r.Delete("/users/{username}/2fa", s.resetUserTwoFactor)
 				})
 
-				// API Keys
-				r.Route("/api-keys", func(r chi.Router) {
-					r.Get("/", s.listAPIKeys)
-					r.Post("/", s.createAPIKey)
-					r.Delete("/{id}", s.deleteAPIKey)
+				// Custom roles and their assignments
+				r.Route("/roles", func(r chi.Router) {
+					r.Use(can(rbac.RolesManage))
+					r.Get("/", s.listRoles)
+					r.Post("/", s.createRole)
+					r.Get("/{name}", s.getRole)
+					r.Put("/{name}", s.updateRole)
+					r.Delete("/{name}", s.deleteRole)
+				})
+				// Resellers may only impersonate their own customers
+				r.With(can(rbac.UsersManage)).Post("/impersonate/{username}", s.startImpersonation)
+
+				r.With(can(rbac.RolesManage)).Get("/users/{username}/roles", s.getUserRoles)
+				r.With(can(rbac.RolesManage)).Put("/users/{username}/roles", s.setUserRoles)
+
+				// Login lockouts, bans and the audit log
+				r.With(can(rbac.SecurityManage)).Get("/blocked", s.listBlocked)
+				r.With(can(rbac.SecurityManage)).Delete("/blocked/{type}/{value}", s.unblock)
+				r.With(can(rbac.SecurityManage)).Get("/audit", s.listAudit)
+				r.With(can(rbac.SecurityManage)).Get("/audit/recordings/{id}", s.getRecording)
+
+				// Hosting packages
+				r.Route("/packages", func(r chi.Router) {
+					r.Use(can(rbac.PackagesManage))
+					r.Get("/", s.listPackages)
+					r.Post("/", s.createPackage)
+					r.Get("/{id}", s.getPackage)
+					r.Put("/{id}", s.updatePackage)
+					r.Delete("/{id}", s.deletePackage)
 				})
+			})
 
-				// Configuration
-				r.Get("/config", s.getConfig)
-				r.Put("/config", s.updateConfig)
+			// API Keys
+			r.Route("/api-keys", func(r chi.Router) {
+				r.Use(can(rbac.APIKeysManage))
+				r.Get("/", s.listAPIKeys)
+				r.Post("/", s.createAPIKey)
+				r.Delete("/{id}", s.deleteAPIKey)
+			})
 
-				// SSH Settings
-				r.Get("/ssh-settings", s.getSSHSettings)
-				r.Put("/ssh-settings", s.updateSSHSettings)
+			// Configuration
+			r.With(can(rbac.ConfigManage)).Get("/config", s.getConfig)
+			r.With(can(rbac.ConfigManage)).Put("/config", s.updateConfig)
 
-				// System
-				r.Post("/reload", s.reloadAll)
+			// Global suspended page template
+			r.With(can(rbac.SystemManage)).Get("/suspended-page", s.getSuspendedPage)
+			r.With(can(rbac.SystemManage)).Put("/suspended-page", s.setSuspendedPage)
+			r.With(can(rbac.SystemManage)).Delete("/suspended-page", s.deleteSuspendedPage)
 
-				// Upgrade (admin only)
-				r.Route("/upgrade", func(r chi.Router) {
-					r.Post("/", s.startUpgrade)
-					r.Get("/status", s.getUpgradeStatus)
-				})
+			// Monthly bandwidth usage
+			r.With(can(rbac.BandwidthRead)).Get("/bandwidth", s.getBandwidthReport)
+
+			// SSH Settings
+			r.With(can(rbac.SystemManage)).Get("/ssh-settings", s.getSSHSettings)
+			r.With(can(rbac.SystemManage)).Put("/ssh-settings", s.updateSSHSettings)
+
+			// System
+			r.With(can(rbac.SystemManage)).Post("/reload", s.reloadAll)
+
+			// Upgrade
+			r.Route("/upgrade", func(r chi.Router) {
+				r.Use(can(rbac.SystemUpgrade))
+				r.Post("/", s.startUpgrade)
+				r.Get("/status", s.getUpgradeStatus)
 			})
 		})
 	})
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sessions"
+)
+
+// SessionInfo describes a logged in device to its user
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// SetSessions attaches the session manager
+func (s *Server) SetSessions(manager *sessions.Manager) {
+	s.sessions = manager
+}
+
+// SessionActive reports whether a token's session is still active. Tokens
+// without a session are rejected once sessions are enabled.
+func (s *Server) SessionActive(claims *auth.Claims) bool {
+	if s.sessions == nil {
+		return true
+	}
+	return claims.SessionID != "" && s.sessions.Active(claims.SessionID, claims.Username)
+}
+
+// refreshSession rotates a session's refresh token and issues a new access
+// token
+func (s *Server) refreshSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		s.error(w, http.StatusBadRequest, "refresh_token is required")
+		return
+	}
+
+	session, refreshToken, err := s.sessions.Refresh(req.RefreshToken, clientIP(r), r.UserAgent())
+	if err != nil {
+		if errors.Is(err, sessions.ErrInvalidRefreshToken) {
+			s.error(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		s.logger.Error("failed to refresh session", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to refresh token")
+		return
+	}
+
+	// A session keeps the role of its login only while the user's groups
+	// give the same role; after a promotion or demotion the user has to
+	// log in again
+	if groupRoleChanged(session) {
+		if err := s.sessions.Revoke(session.Username, session.ID); err != nil {
+			s.logger.Warn("failed to revoke session", "user", session.Username, "error", err)
+		}
+		s.logger.Info("session ended after a role change", "user", session.Username)
+		s.error(w, http.StatusUnauthorized, "your role has changed, please log in again")
+		return
+	}
+
+	user := &models.User{ID: session.UserID, Username: session.Username, Role: session.Role}
+	token, err := auth.GenerateSessionToken(user, session.ID)
+	if err != nil {
+		s.logger.Error("failed to generate refreshed token", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to refresh token")
+		return
+	}
+
+	s.success(w, map[string]interface{}{
+		"message":       "token refreshed",
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(auth.AccessTokenTTL.Seconds()),
+		"user": map[string]interface{}{
+			"id":       user.ID,
+			"username": user.Username,
+			"role":     user.Role,
+		},
+	})
+}
+
+// groupRoleChanged reports whether a user's Unix groups no longer give the
+// role they gave when the session started, or no longer let the user use
+// FastCP at all
+func groupRoleChanged(session *sessions.Session) bool {
+	user, err := auth.LookupUser(session.Username)
+	if err != nil {
+		// The development login has no Unix account
+		return !config.IsDevMode()
+	}
+	groupRole := session.GroupRole
+	if groupRole == "" {
+		// Sessions started before the group role was recorded
+		groupRole = session.Role
+	}
+	return user.Role != groupRole
+}
+
+// listSessions returns the current user's active sessions
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.sessionClaims(w, r)
+	if !ok {
+		return
+	}
+
+	list := []SessionInfo{}
+	for _, session := range s.sessions.List(claims.Username) {
+		list = append(list, SessionInfo{
+			ID:         session.ID,
+			Device:     session.Device,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == claims.SessionID,
+		})
+	}
+	s.success(w, list)
+}
+
+// revokeSession logs one of the current user's sessions out
+func (s *Server) revokeSession(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.sessionClaims(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := s.sessions.Revoke(claims.Username, id); err != nil {
+		if errors.Is(err, sessions.ErrSessionNotFound) {
+			s.error(w, http.StatusNotFound, err.Error())
+			return
+		}
+		s.logger.Error("failed to revoke session", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to revoke session")
+		return
+	}
+
+	s.logger.Info("session revoked", "username", claims.Username, "session", id)
+	s.success(w, map[string]string{"message": "session revoked"})
+}
+
+// revokeAllSessions logs the current user out everywhere, including the
+// current session
+func (s *Server) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.sessionClaims(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := s.sessions.RevokeUser(claims.Username, "")
+	if err != nil {
+		s.logger.Error("failed to revoke sessions", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to revoke sessions")
+		return
+	}
+
+	s.logger.Info("all sessions revoked", "username", claims.Username, "sessions", revoked)
+	s.success(w, map[string]interface{}{
+		"message": "logged out everywhere",
+		"revoked": revoked,
+	})
+}
+
+// logout ends the current session
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.sessionClaims(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.sessions.Revoke(claims.Username, claims.SessionID); err != nil && !errors.Is(err, sessions.ErrSessionNotFound) {
+		s.logger.Error("failed to revoke session", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to log out")
+		return
+	}
+
+	s.logger.Info("user logged out", "username", claims.Username)
+	s.success(w, map[string]string{"message": "logged out"})
+}
+
+// revokeUserSessions ends a user's sessions after a security relevant
+// change, keeping the session given in keep
+func (s *Server) revokeUserSessions(username, keep, reason string) {
+	if s.sessions == nil {
+		return
+	}
+	revoked, err := s.sessions.RevokeUser(username, keep)
+	if err != nil {
+		s.logger.Warn("failed to revoke sessions", "user", username, "error", err)
+		return
+	}
+	if revoked > 0 {
+		s.logger.Info("sessions revoked", "user", username, "sessions", revoked, "reason", reason)
+	}
+}
+
+// sessionClaims returns the caller's claims for managing their own
+// sessions, which is not possible while impersonating
+func (s *Server) sessionClaims(w http.ResponseWriter, r *http.Request) (*auth.Claims, bool) {
+	if s.sessions == nil {
+		s.error(w, http.StatusServiceUnavailable, "sessions not enabled")
+		return nil, false
+	}
+	if middleware.IsImpersonating(r) {
+		s.error(w, http.StatusForbidden, "cannot manage sessions while impersonating")
+		return nil, false
+	}
+	return middleware.GetClaims(r), true
+}
+
+// clientIP returns the client address of a request (RealIP has already
+// applied proxy headers)
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
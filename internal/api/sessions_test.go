@@ -0,0 +1,115 @@
+package api
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"testing"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sessions"
+)
+
+func TestSessions_RefreshAndRevoke(t *testing.T) {
+	os.Unsetenv("FASTCP_DEV")
+	loaded, _ := config.Load("")
+	cfg := *loaded
+	cfg.JWTSecret = "test-secret"
+	config.Update(&cfg)
+
+	auth.SetPasswordVerifier(func(u, p string) bool { return true })
+	auth.SetGroupChecker(func(u, g string) bool { return g == "fastcp" })
+	auth.SetUserLookup(func(username string) (*user.User, error) {
+		return &user.User{Uid: "1000", Username: username}, nil
+	})
+	defer func() { auth.SetPasswordVerifier(nil); auth.SetGroupChecker(nil); auth.SetUserLookup(nil) }()
+
+	s := NewServer(nil, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.SetSessions(sessions.NewManager(t.TempDir()))
+	middleware.SetSessionValidator(s.SessionActive)
+	defer middleware.SetSessionValidator(nil)
+
+	status, resp := postJSON(s.login, map[string]string{"username": "alice", "password": "pw"})
+	token, _ := resp["token"].(string)
+	refresh, _ := resp["refresh_token"].(string)
+	if status != http.StatusOK || token == "" || refresh == "" || resp["expires_in"] != auth.AccessTokenTTL.Seconds() {
+		t.Fatalf("expected session tokens, got %d %v", status, resp)
+	}
+
+	me := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		middleware.AuthMiddleware(http.HandlerFunc(s.getCurrentUser)).ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := me(token); code != http.StatusOK {
+		t.Fatalf("expected session token to be accepted, got %d", code)
+	}
+
+	// Tokens without a session are no longer accepted
+	legacy, _ := auth.GenerateToken(&models.User{ID: "1000", Username: "alice", Role: "user"})
+	if code := me(legacy); code != http.StatusUnauthorized {
+		t.Fatalf("expected sessionless token to be rejected, got %d", code)
+	}
+
+	status, resp = postJSON(s.refreshToken, map[string]string{"refresh_token": refresh})
+	rotated, _ := resp["refresh_token"].(string)
+	if status != http.StatusOK || rotated == "" || rotated == refresh {
+		t.Fatalf("expected a rotated refresh token, got %d %v", status, resp)
+	}
+	if status, _ := postJSON(s.refreshToken, map[string]string{"refresh_token": refresh}); status != http.StatusUnauthorized {
+		t.Fatalf("expected reused refresh token to be rejected, got %d", status)
+	}
+
+	// Reuse revoked the session, so its access token stops working at once
+	if code := me(token); code != http.StatusUnauthorized {
+		t.Fatalf("expected token of a revoked session to be rejected, got %d", code)
+	}
+}
+
+func TestSessions_RefreshAfterRoleChange(t *testing.T) {
+	os.Unsetenv("FASTCP_DEV")
+	loaded, _ := config.Load("")
+	cfg := *loaded
+	cfg.JWTSecret = "test-secret"
+	config.Update(&cfg)
+
+	groups := map[string]bool{"fastcp": true, auth.ResellerGroups[0]: true}
+	auth.SetPasswordVerifier(func(u, p string) bool { return true })
+	auth.SetGroupChecker(func(u, g string) bool { return groups[g] })
+	auth.SetUserLookup(func(username string) (*user.User, error) {
+		return &user.User{Uid: "1000", Username: username}, nil
+	})
+	defer func() { auth.SetPasswordVerifier(nil); auth.SetGroupChecker(nil); auth.SetUserLookup(nil) }()
+
+	s := NewServer(nil, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.SetSessions(sessions.NewManager(t.TempDir()))
+
+	status, resp := postJSON(s.login, map[string]string{"username": "alice", "password": "pw"})
+	refresh, _ := resp["refresh_token"].(string)
+	if user, _ := resp["user"].(map[string]interface{}); status != http.StatusOK || user["role"] != "reseller" {
+		t.Fatalf("expected a reseller login, got %d %v", status, resp)
+	}
+
+	status, resp = postJSON(s.refreshToken, map[string]string{"refresh_token": refresh})
+	refresh, _ = resp["refresh_token"].(string)
+	if status != http.StatusOK {
+		t.Fatalf("expected refresh with unchanged groups to succeed, got %d %v", status, resp)
+	}
+
+	// Taking the user out of the reseller group ends the session
+	delete(groups, auth.ResellerGroups[0])
+	if status, resp := postJSON(s.refreshToken, map[string]string{"refresh_token": refresh}); status != http.StatusUnauthorized {
+		t.Fatalf("expected refresh after a demotion to be rejected, got %d %v", status, resp)
+	}
+	if list := s.sessions.List("alice"); len(list) != 0 {
+		t.Errorf("expected the session to be revoked, got %d sessions", len(list))
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/go-chi/chi/v5"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/rehmatworks/fastcp/internal/middleware"
 	"github.com/rehmatworks/fastcp/internal/models"
 	"github.com/rehmatworks/fastcp/internal/sites"
+	"github.com/rehmatworks/fastcp/internal/teams"
 )
 
 // CreateSiteRequest represents a request to create a site
@@ -52,6 +54,14 @@ func (s *Server) listSites(w http.ResponseWriter, r *http.Request) {
 		sitesList = s.siteManager.List("")
 	} else {
 		sitesList = s.siteManager.List(claims.UserID)
+		if claims.Role == "reseller" {
+			sitesList = append(sitesList, s.customerSites(claims.Username)...)
+		}
+		for _, site := range s.sharedSites(claims) {
+			if !slices.ContainsFunc(sitesList, func(listed *models.Site) bool { return listed.ID == site.ID }) {
+				sitesList = append(sitesList, site)
+			}
+		}
 	}
 
 	s.success(w, map[string]interface{}{
@@ -119,10 +129,18 @@ func (s *Server) createSite(w http.ResponseWriter, r *http.Request) {
 			s.error(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		if errors.Is(err, sites.ErrPHPVersionDenied) {
+			s.error(w, http.StatusForbidden, err.Error())
+			return
+		}
 		if errors.Is(err, sites.ErrSiteLimitReached) {
 			s.error(w, http.StatusForbidden, err.Error())
 			return
 		}
+		if errors.Is(err, sites.ErrDiskQuotaExceeded) {
+			s.error(w, http.StatusInsufficientStorage, err.Error())
+			return
+		}
 		s.logger.Error("failed to create site", "error", err)
 		s.error(w, http.StatusInternalServerError, "failed to create site")
 		return
@@ -151,6 +169,8 @@ func (s *Server) createSite(w http.ResponseWriter, r *http.Request) {
 		} else {
 			s.logger.Info("user PHP instance started", "user", username, "version", created.PHPVersion)
 		}
+		// An already running instance needs the new site's error log
+		s.reloadUserPHP(username)
 	}
 
 	// Reload PHP instances to apply changes
@@ -164,22 +184,8 @@ func (s *Server) createSite(w http.ResponseWriter, r *http.Request) {
 
 // getSite returns a single site
 func (s *Server) getSite(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	claims := middleware.GetClaims(r)
-
-	site, err := s.siteManager.Get(id)
-	if err != nil {
-		if err == sites.ErrSiteNotFound {
-			s.error(w, http.StatusNotFound, "site not found")
-			return
-		}
-		s.error(w, http.StatusInternalServerError, "failed to get site")
-		return
-	}
-
-	// Check ownership (unless admin)
-	if claims.Role != "admin" && site.UserID != claims.UserID {
-		s.error(w, http.StatusForbidden, "access denied")
+	site, ok := s.loadSiteForRequest(w, r, chi.URLParam(r, "id"), accessAny)
+	if !ok {
 		return
 	}
 
@@ -191,19 +197,8 @@ func (s *Server) updateSite(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	claims := middleware.GetClaims(r)
 
-	// Check ownership
-	site, err := s.siteManager.Get(id)
-	if err != nil {
-		if err == sites.ErrSiteNotFound {
-			s.error(w, http.StatusNotFound, "site not found")
-			return
-		}
-		s.error(w, http.StatusInternalServerError, "failed to get site")
-		return
-	}
-
-	if claims.Role != "admin" && site.UserID != claims.UserID {
-		s.error(w, http.StatusForbidden, "access denied")
+	site, ok := s.loadSiteForRequest(w, r, id, teams.AccessDeploy)
+	if !ok {
 		return
 	}
 
@@ -257,6 +252,10 @@ func (s *Server) updateSite(w http.ResponseWriter, r *http.Request) {
 			s.error(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		if errors.Is(err, sites.ErrPHPVersionDenied) {
+			s.error(w, http.StatusForbidden, err.Error())
+			return
+		}
 		s.logger.Error("failed to update site", "error", err)
 		s.error(w, http.StatusInternalServerError, "failed to update site")
 		return
@@ -266,6 +265,7 @@ func (s *Server) updateSite(w http.ResponseWriter, r *http.Request) {
 	if err := s.phpManager.Reload(); err != nil {
 		s.logger.Warn("failed to reload PHP instances", "error", err)
 	}
+	s.reloadUserPHP(caddy.ExtractUsernameFromRootPath(site.RootPath))
 
 	s.logger.Info("site updated", "id", id, "user", claims.Username)
 	s.success(w, updated)
@@ -276,19 +276,9 @@ func (s *Server) deleteSite(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	claims := middleware.GetClaims(r)
 
-	// Check ownership
-	site, err := s.siteManager.Get(id)
-	if err != nil {
-		if err == sites.ErrSiteNotFound {
-			s.error(w, http.StatusNotFound, "site not found")
-			return
-		}
-		s.error(w, http.StatusInternalServerError, "failed to get site")
-		return
-	}
-
-	if claims.Role != "admin" && site.UserID != claims.UserID {
-		s.error(w, http.StatusForbidden, "access denied")
+	// Team members cannot delete a shared site
+	site, ok := s.loadSiteForRequest(w, r, id, accessOwner)
+	if !ok {
 		return
 	}
 
@@ -298,10 +288,25 @@ func (s *Server) deleteSite(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.teams != nil {
+		if err := s.teams.RemoveSite(id); err != nil {
+			s.logger.Warn("failed to remove site from teams", "id", id, "error", err)
+		}
+	}
+	if err := caddy.DeleteSiteErrorPages(id); err != nil {
+		s.logger.Warn("failed to remove custom error pages", "id", id, "error", err)
+	}
+	if s.analytics != nil {
+		if err := s.analytics.DeleteSite(id); err != nil {
+			s.logger.Warn("failed to remove site analytics", "id", id, "error", err)
+		}
+	}
+
 	// Reload PHP instances to apply changes
 	if err := s.phpManager.Reload(); err != nil {
 		s.logger.Warn("failed to reload PHP instances", "error", err)
 	}
+	s.reloadUserPHP(caddy.ExtractUsernameFromRootPath(site.RootPath))
 
 	s.logger.Info("site deleted", "id", id, "domain", site.Domain, "user", claims.Username)
 	s.success(w, map[string]string{"message": "site deleted"})
@@ -312,9 +317,8 @@ func (s *Server) suspendSite(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	claims := middleware.GetClaims(r)
 
-	// Only admin can suspend
-	if claims.Role != "admin" {
-		s.error(w, http.StatusForbidden, "admin access required")
+	// Team members cannot suspend a shared site
+	if _, ok := s.loadSiteForRequest(w, r, id, accessOwner); !ok {
 		return
 	}
 
@@ -341,9 +345,8 @@ func (s *Server) unsuspendSite(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	claims := middleware.GetClaims(r)
 
-	// Only admin can unsuspend
-	if claims.Role != "admin" {
-		s.error(w, http.StatusForbidden, "admin access required")
+	// Team members cannot unsuspend a shared site
+	if _, ok := s.loadSiteForRequest(w, r, id, accessOwner); !ok {
 		return
 	}
 
@@ -370,28 +373,52 @@ func (s *Server) restartSiteWorkers(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	claims := middleware.GetClaims(r)
 
+	site, ok := s.loadSiteForRequest(w, r, id, teams.AccessDeploy)
+	if !ok {
+		return
+	}
+
+	// Restart workers for this site's PHP version
+	if err := s.phpManager.RestartWorkers(site.PHPVersion); err != nil {
+		s.logger.Warn("failed to restart workers", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to restart workers")
+		return
+	}
+
+	s.logger.Info("workers restarted for site", "id", id, "php_version", site.PHPVersion, "user", claims.Username)
+	s.success(w, map[string]string{"message": "workers restarted"})
+}
+
+// loadSiteForRequest fetches a site by ID and checks that the caller has
+// the given access to it. On failure the error response has already been
+// written.
+func (s *Server) loadSiteForRequest(w http.ResponseWriter, r *http.Request, id, access string) (*models.Site, bool) {
+	claims := middleware.GetClaims(r)
+
 	site, err := s.siteManager.Get(id)
 	if err != nil {
 		if err == sites.ErrSiteNotFound {
 			s.error(w, http.StatusNotFound, "site not found")
-			return
+			return nil, false
 		}
 		s.error(w, http.StatusInternalServerError, "failed to get site")
-		return
+		return nil, false
 	}
 
-	if claims.Role != "admin" && site.UserID != claims.UserID {
+	if !canAccessSite(s.siteManager, s.teams, claims, site, access) {
 		s.error(w, http.StatusForbidden, "access denied")
-		return
+		return nil, false
 	}
 
-	// Restart workers for this site's PHP version
-	if err := s.phpManager.RestartWorkers(site.PHPVersion); err != nil {
-		s.logger.Warn("failed to restart workers", "error", err)
-		s.error(w, http.StatusInternalServerError, "failed to restart workers")
+	return site, true
+}
+
+// reloadUserPHP reloads a user's running PHP instances after their sites change
+func (s *Server) reloadUserPHP(username string) {
+	if username == "" || s.userPHPManager == nil {
 		return
 	}
-
-	s.logger.Info("workers restarted for site", "id", id, "php_version", site.PHPVersion, "user", claims.Username)
-	s.success(w, map[string]string{"message": "workers restarted"})
+	if err := s.userPHPManager.ReloadUserInstances(username); err != nil {
+		s.logger.Warn("failed to reload user PHP instances", "user", username, "error", err)
+	}
 }
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os/user"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/caddy"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sites"
+	"github.com/rehmatworks/fastcp/internal/teams"
+)
+
+// TeamInviteRequest invites a panel user to help manage some sites
+type TeamInviteRequest struct {
+	Username string              `json:"username"`
+	Sites    map[string][]string `json:"sites"` // Site ID -> access (files, databases, deploy, logs)
+}
+
+// SetTeams attaches the team manager
+func (s *Server) SetTeams(manager *teams.Manager) {
+	s.teams = manager
+	s.fileManager.teams = manager
+}
+
+// Access levels for canAccessSite and canAccessDatabase, next to the
+// access kinds team members are given
+const (
+	// accessAny is any access a team member was given
+	accessAny = ""
+	// accessOwner is reserved to the owner, admins and the owner's
+	// reseller; team members never have it
+	accessOwner = "owner"
+)
+
+// canAccessSite reports whether the user has the given access to a site:
+// admins and the owner always do, resellers for the sites of their
+// customers, team members for the access they were given once they
+// accepted the invitation
+func canAccessSite(sm *sites.Manager, tm *teams.Manager, claims *auth.Claims, site *models.Site, access string) bool {
+	if claims == nil {
+		return false
+	}
+	if claims.Role == "admin" || site.UserID == claims.UserID {
+		return true
+	}
+	if claims.Role == "reseller" && sm != nil {
+		if owner := caddy.ExtractUsernameFromRootPath(site.RootPath); owner != "" && owner != claims.Username && sm.GetUserLimit(owner).Owner == claims.Username {
+			return true
+		}
+	}
+	return access != accessOwner && tm != nil && tm.Can(claims.Username, site.ID, access)
+}
+
+// canAccessDatabase reports whether the user has the given access to a
+// database, like canAccessSite. Team members reach databases linked to a
+// site they were given the access to.
+func (s *Server) canAccessDatabase(claims *auth.Claims, db *models.Database, access string) bool {
+	if claims == nil {
+		return false
+	}
+	if claims.Role == "admin" || db.UserID == claims.UserID {
+		return true
+	}
+	if claims.Role == "reseller" && s.ownsUID(claims.Username, db.UserID) {
+		return true
+	}
+	return access != accessOwner && s.teams != nil && db.SiteID != "" && s.teams.Can(claims.Username, db.SiteID, access)
+}
+
+// sharedSites returns the sites other users shared with the user
+func (s *Server) sharedSites(claims *auth.Claims) []*models.Site {
+	if s.teams == nil {
+		return nil
+	}
+	var list []*models.Site
+	for _, id := range s.teams.SharedSites(claims.Username) {
+		if site, err := s.siteManager.Get(id); err == nil && site.UserID != claims.UserID {
+			list = append(list, site)
+		}
+	}
+	return list
+}
+
+// sharedDatabases returns the databases linked to sites shared with the
+// user for database access
+func (s *Server) sharedDatabases(claims *auth.Claims) []*models.Database {
+	if s.teams == nil {
+		return nil
+	}
+	var list []*models.Database
+	for _, db := range s.dbManager.List("") {
+		if db.UserID != claims.UserID && db.SiteID != "" && s.teams.Can(claims.Username, db.SiteID, teams.AccessDatabases) {
+			list = append(list, db)
+		}
+	}
+	return list
+}
+
+// getTeam returns the collaborators the user invited and the teams the
+// user was invited to
+func (s *Server) getTeam(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.teams == nil {
+		s.error(w, http.StatusServiceUnavailable, "teams not enabled")
+		return
+	}
+
+	s.success(w, map[string]interface{}{
+		"members":     s.teams.ByOwner(claims.Username),
+		"memberships": s.teams.ByMember(claims.Username),
+	})
+}
+
+// inviteTeamMember invites a panel user to some of the user's sites
+func (s *Server) inviteTeamMember(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.teams == nil {
+		s.error(w, http.StatusServiceUnavailable, "teams not enabled")
+		return
+	}
+
+	var req TeamInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Username == "" {
+		s.error(w, http.StatusBadRequest, "username is required")
+		return
+	}
+	if _, err := user.Lookup(req.Username); err != nil {
+		s.error(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if !s.ownsSites(w, claims, req.Sites) {
+		return
+	}
+
+	member, err := s.teams.Invite(claims.Username, req.Username, req.Sites)
+	if err != nil {
+		s.teamError(w, err)
+		return
+	}
+
+	s.logger.Info("team member invited", "owner", claims.Username, "member", req.Username, "sites", len(member.Sites))
+	s.json(w, http.StatusCreated, member)
+}
+
+// updateTeamMember replaces the site access of a collaborator
+func (s *Server) updateTeamMember(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.teams == nil {
+		s.error(w, http.StatusServiceUnavailable, "teams not enabled")
+		return
+	}
+
+	var req TeamInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !s.ownsSites(w, claims, req.Sites) {
+		return
+	}
+
+	member, err := s.teams.Update(claims.Username, chi.URLParam(r, "id"), req.Sites)
+	if err != nil {
+		s.teamError(w, err)
+		return
+	}
+
+	s.logger.Info("team member updated", "owner", claims.Username, "member", member.Username, "sites", len(member.Sites))
+	s.success(w, member)
+}
+
+// removeTeamMember removes a collaborator from the user's team, or lets a
+// collaborator leave or decline
+func (s *Server) removeTeamMember(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.teams == nil {
+		s.error(w, http.StatusServiceUnavailable, "teams not enabled")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := s.teams.Remove(claims.Username, id); err != nil {
+		s.teamError(w, err)
+		return
+	}
+
+	s.logger.Info("team membership removed", "id", id, "user", claims.Username)
+	s.success(w, map[string]string{"message": "team membership removed"})
+}
+
+// acceptTeamInvitation accepts an invitation to another user's team
+func (s *Server) acceptTeamInvitation(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.teams == nil {
+		s.error(w, http.StatusServiceUnavailable, "teams not enabled")
+		return
+	}
+
+	member, err := s.teams.Accept(claims.Username, chi.URLParam(r, "id"))
+	if err != nil {
+		s.teamError(w, err)
+		return
+	}
+
+	s.logger.Info("team invitation accepted", "owner", member.Owner, "member", claims.Username)
+	s.success(w, member)
+}
+
+// ownsSites checks that every site of an invitation belongs to the user.
+// On failure the error response has already been written.
+func (s *Server) ownsSites(w http.ResponseWriter, claims *auth.Claims, ids map[string][]string) bool {
+	for id := range ids {
+		site, err := s.siteManager.Get(id)
+		if err != nil {
+			if errors.Is(err, sites.ErrSiteNotFound) {
+				s.error(w, http.StatusNotFound, "site not found")
+				return false
+			}
+			s.error(w, http.StatusInternalServerError, "failed to get site")
+			return false
+		}
+		if site.UserID != claims.UserID {
+			s.error(w, http.StatusForbidden, "only the owner can share a site")
+			return false
+		}
+	}
+	return true
+}
+
+// teamError maps team manager errors to HTTP responses
+func (s *Server) teamError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, teams.ErrMemberNotFound):
+		s.error(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, teams.ErrAlreadyMember):
+		s.error(w, http.StatusConflict, err.Error())
+	case errors.Is(err, teams.ErrSelfInvite), errors.Is(err, teams.ErrInvalidAccess), errors.Is(err, teams.ErrNoSites):
+		s.error(w, http.StatusBadRequest, err.Error())
+	default:
+		s.logger.Error("failed to save team", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to save team")
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sites"
+	"github.com/rehmatworks/fastcp/internal/teams"
+)
+
+func TestCanAccessSite(t *testing.T) {
+	tm := teams.NewManager(t.TempDir())
+	site := &models.Site{ID: "site-1", UserID: "1001"}
+
+	owner := &auth.Claims{UserID: "1001", Username: "alice", Role: "user"}
+	admin := &auth.Claims{UserID: "0", Username: "root", Role: "admin"}
+	bob := &auth.Claims{UserID: "1002", Username: "bob", Role: "user"}
+
+	if !canAccessSite(nil, tm, owner, site, teams.AccessDeploy) || !canAccessSite(nil, nil, admin, site, teams.AccessFiles) {
+		t.Fatal("expected owner and admin to access the site")
+	}
+	if canAccessSite(nil, nil, bob, site, "") {
+		t.Fatal("expected no access without teams")
+	}
+
+	member, err := tm.Invite("alice", "bob", map[string][]string{"site-1": {teams.AccessFiles}})
+	if err != nil {
+		t.Fatalf("Invite failed: %v", err)
+	}
+	if canAccessSite(nil, tm, bob, site, teams.AccessFiles) {
+		t.Fatal("expected no access before the invitation is accepted")
+	}
+	tm.Accept("bob", member.ID)
+
+	if !canAccessSite(nil, tm, bob, site, teams.AccessFiles) || !canAccessSite(nil, tm, bob, site, "") {
+		t.Fatal("expected file access after accepting")
+	}
+	if canAccessSite(nil, tm, bob, site, teams.AccessDeploy) {
+		t.Fatal("expected no deploy access")
+	}
+	if canAccessSite(nil, tm, bob, &models.Site{ID: "site-2", UserID: "1001"}, teams.AccessFiles) {
+		t.Fatal("expected no access to sites that weren't shared")
+	}
+
+	// Deleting or suspending stays with the owner
+	if canAccessSite(nil, tm, bob, site, accessOwner) || !canAccessSite(nil, tm, owner, site, accessOwner) {
+		t.Fatal("expected owner access for the owner only")
+	}
+}
+
+func TestCanAccessDatabase(t *testing.T) {
+	sm := sites.NewManager(t.TempDir())
+	_ = sm.Load()
+	// The customer needs a real account for its uid
+	if err := sm.SetUserLimit(&models.UserLimits{Username: "root", Owner: "res"}); err != nil {
+		t.Fatal(err)
+	}
+	tm := teams.NewManager(t.TempDir())
+	member, _ := tm.Invite("root", "bob", map[string][]string{"site-1": {teams.AccessDatabases}})
+	tm.Accept("bob", member.ID)
+
+	s := NewServer(sm, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.SetTeams(tm)
+	db := &models.Database{ID: "db-1", UserID: "0", SiteID: "site-1"}
+
+	owner := &auth.Claims{UserID: "0", Username: "root", Role: "user"}
+	admin := &auth.Claims{UserID: "1000", Username: "admin", Role: "admin"}
+	res := &auth.Claims{UserID: "1005", Username: "res", Role: "reseller"}
+	other := &auth.Claims{UserID: "1006", Username: "other", Role: "reseller"}
+	bob := &auth.Claims{UserID: "1002", Username: "bob", Role: "user"}
+
+	tests := []struct {
+		name   string
+		claims *auth.Claims
+		access string
+		want   bool
+	}{
+		{"owner", owner, accessOwner, true},
+		{"admin", admin, accessOwner, true},
+		{"reseller of the owner", res, accessOwner, true},
+		{"other reseller", other, accessAny, false},
+		{"team member with database access", bob, teams.AccessDatabases, true},
+		{"team member deleting", bob, accessOwner, false},
+		{"team member without the access", bob, teams.AccessFiles, false},
+		{"no claims", nil, accessAny, false},
+	}
+	for _, tc := range tests {
+		if got := s.canAccessDatabase(tc.claims, db, tc.access); got != tc.want {
+			t.Fatalf("%s: canAccessDatabase = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if s.canAccessDatabase(bob, &models.Database{ID: "db-2", UserID: "0"}, teams.AccessDatabases) {
+		t.Fatal("expected no team access to databases not linked to a site")
+	}
+}
+
+func TestCanAccessSiteReseller(t *testing.T) {
+	sm := sites.NewManager(t.TempDir())
+	_ = sm.Load()
+	if err := sm.SetUserLimit(&models.UserLimits{Username: "alice", Owner: "res"}); err != nil {
+		t.Fatal(err)
+	}
+	site := &models.Site{ID: "site-1", UserID: "1001", RootPath: "/home/alice/www/a.com"}
+
+	res := &auth.Claims{UserID: "1005", Username: "res", Role: "reseller"}
+	other := &auth.Claims{UserID: "1006", Username: "other", Role: "reseller"}
+	user := &auth.Claims{UserID: "1007", Username: "res", Role: "user"}
+
+	if !canAccessSite(sm, nil, res, site, teams.AccessFiles) {
+		t.Fatal("expected the reseller to access their customer's site")
+	}
+	if canAccessSite(sm, nil, other, site, "") || canAccessSite(sm, nil, user, site, "") {
+		t.Fatal("expected no access for other resellers and non-resellers")
+	}
+	if canAccessSite(sm, nil, res, &models.Site{ID: "site-2", UserID: "1002", RootPath: "/home/bob/www/b.com"}, "") {
+		t.Fatal("expected no access to sites of other users")
+	}
+}
@@ -0,0 +1,412 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/net/websocket"
+
+	"github.com/rehmatworks/fastcp/internal/audit"
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/jail"
+	"github.com/rehmatworks/fastcp/internal/limits"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/pty"
+	"github.com/rehmatworks/fastcp/internal/sites"
+)
+
+// TerminalIdleTimeout closes a web terminal nobody typed in for this long
+var TerminalIdleTimeout = 15 * time.Minute
+
+// errNoShell is returned for users limited to SFTP
+var errNoShell = errors.New("shell access is not enabled for this user")
+
+// terminalMessage is sent by the client as a JSON text frame: typed input
+// or the size of the terminal. Output is sent back as binary frames.
+type terminalMessage struct {
+	Type string `json:"type"` // input, resize
+	Data string `json:"data,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+}
+
+// terminalTicketTTL is how long a terminal ticket can be used
+const terminalTicketTTL = 30 * time.Second
+
+// terminalTickets hands out the single-use tickets that open web
+// terminals. Browsers cannot add headers to WebSocket requests, so the
+// WebSocket URL carries a ticket rather than the access token; a ticket is
+// worthless once used or after terminalTicketTTL.
+type terminalTickets struct {
+	mu      sync.Mutex
+	tickets map[string]terminalTicket
+}
+
+type terminalTicket struct {
+	claims  *auth.Claims
+	siteID  string
+	expires time.Time
+}
+
+func (t *terminalTickets) issue(claims *auth.Claims, siteID string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ticket := hex.EncodeToString(b)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tickets == nil {
+		t.tickets = make(map[string]terminalTicket)
+	}
+	now := time.Now()
+	for other, entry := range t.tickets {
+		if now.After(entry.expires) {
+			delete(t.tickets, other)
+		}
+	}
+	t.tickets[ticket] = terminalTicket{claims: claims, siteID: siteID, expires: now.Add(terminalTicketTTL)}
+	return ticket, nil
+}
+
+// take uses up a ticket and returns the claims it was issued for, if it
+// is still valid for the site
+func (t *terminalTickets) take(ticket, siteID string) (*auth.Claims, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.tickets[ticket]
+	if !ok {
+		return nil, false
+	}
+	delete(t.tickets, ticket)
+	if entry.siteID != siteID || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.claims, true
+}
+
+// createTerminalTicket issues the ticket that opens a site's web terminal
+func (s *Server) createTerminalTicket(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	site, ok := s.terminalSite(w, claims, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	ticket, err := s.terminalTickets.issue(claims, site.ID)
+	if err != nil {
+		s.logger.Error("failed to create terminal ticket", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to create terminal ticket")
+		return
+	}
+	s.success(w, map[string]interface{}{
+		"ticket":     ticket,
+		"expires_in": int(terminalTicketTTL.Seconds()),
+	})
+}
+
+// siteTerminal opens a shell as the owner of a site over a WebSocket. The
+// request is authorized by a ticket from createTerminalTicket. The shell
+// starts in the root of the site, runs in the owner's cgroup and is
+// sandboxed like their SSH shell; users limited to SFTP get none. Only the
+// owner, their reseller and admins may open it, team members cannot.
+func (s *Server) siteTerminal(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.terminalTickets.take(r.URL.Query().Get("ticket"), chi.URLParam(r, "id"))
+	if !ok {
+		s.error(w, http.StatusUnauthorized, "invalid or expired terminal ticket")
+		return
+	}
+	// The session may have ended since the ticket was issued
+	if !s.SessionActive(claims.RealClaims()) || (claims.Impersonator != nil && !s.ImpersonationActive(claims)) {
+		s.error(w, http.StatusUnauthorized, "session expired or revoked")
+		return
+	}
+	site, ok := s.terminalSite(w, claims, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	owner, err := user.LookupId(site.UserID)
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, "site owner not found")
+		return
+	}
+	cmd, err := s.terminalCommand(owner, site.RootPath)
+	if err != nil {
+		if errors.Is(err, errNoShell) {
+			s.error(w, http.StatusForbidden, err.Error())
+			return
+		}
+		s.logger.Error("failed to prepare terminal", "site", site.ID, "error", err)
+		s.error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	session := &terminalSession{
+		server:  s,
+		cmd:     cmd,
+		owner:   owner.Username,
+		site:    site,
+		auditor: claims.RealClaims(),
+		ip:      clientIP(r),
+	}
+	// The ticket authenticates the request, not a cookie, so other origins
+	// cannot open a terminal on behalf of the user
+	ws := websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   session.run,
+	}
+	ws.ServeHTTP(w, r)
+}
+
+// terminalSite returns a site whose terminal the user may open. On
+// failure the error response has already been written.
+func (s *Server) terminalSite(w http.ResponseWriter, claims *auth.Claims, id string) (*models.Site, bool) {
+	site, err := s.siteManager.Get(id)
+	if err != nil {
+		if err == sites.ErrSiteNotFound {
+			s.error(w, http.StatusNotFound, "site not found")
+			return nil, false
+		}
+		s.error(w, http.StatusInternalServerError, "failed to get site")
+		return nil, false
+	}
+	if !canAccessSite(s.siteManager, s.teams, claims, site, accessOwner) {
+		s.error(w, http.StatusForbidden, "access denied")
+		return nil, false
+	}
+	if site.Status == "suspended" {
+		s.error(w, http.StatusForbidden, "site is suspended")
+		return nil, false
+	}
+	return site, true
+}
+
+// exceptWebSockets applies a middleware to every request but WebSocket
+// upgrades, which outlive request timeouts
+func exceptWebSockets(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
+
+// terminalCommand builds the shell of a user: a login shell for users
+// with full shell access, the bubblewrap sandbox for jailed shells
+func (s *Server) terminalCommand(u *user.User, dir string) (*exec.Cmd, error) {
+	isAdmin := u.Username == "root" || s.isUserInGroup(u.Username, "sudo") || s.isUserInGroup(u.Username, "wheel")
+
+	var cmd *exec.Cmd
+	switch {
+	case isAdmin:
+		cmd = exec.Command("/bin/bash", "-l")
+	case jail.IsUserJailed(u.Username):
+		return nil, errNoShell
+	case jail.IsUserShellJailed(u.Username):
+		exists := func(path string) bool {
+			_, err := os.Stat(path)
+			return err == nil
+		}
+		cmd = exec.Command(jail.BwrapPath, jail.ShellArgs(u, "", dir, exists)...)
+	default:
+		cmd = exec.Command("/bin/bash", "-l")
+	}
+	cmd.Dir = dir
+	cmd.Env = []string{
+		"HOME=" + u.HomeDir,
+		"USER=" + u.Username,
+		"LOGNAME=" + u.Username,
+		"SHELL=/bin/bash",
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"TERM=xterm-256color",
+		"LANG=C.UTF-8",
+	}
+
+	uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+	if int(uid) == os.Geteuid() {
+		return cmd, nil
+	}
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("the web terminal requires FastCP to run as root")
+	}
+	var groups []uint32
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+				groups = append(groups, uint32(g))
+			}
+		}
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups},
+	}
+	return cmd, nil
+}
+
+// terminalSession connects a shell to a WebSocket and records it in the
+// audit log, with its output in a recording the entries refer to
+type terminalSession struct {
+	server  *Server
+	cmd     *exec.Cmd
+	owner   string
+	site    *models.Site
+	auditor *auth.Claims // The real user, also when impersonating
+	ip      string
+}
+
+func (t *terminalSession) run(ws *websocket.Conn) {
+	defer ws.Close()
+	// The server's request timeouts do not apply to the hijacked connection
+	ws.SetDeadline(time.Time{})
+	ws.PayloadType = websocket.BinaryFrame
+
+	// The output is recorded, not the input: programs don't echo
+	// passwords, but a typed password looks like any other input
+	var rec *audit.Recording
+	if t.server.audit != nil {
+		var err error
+		rec, err = t.server.audit.StartRecording(80, 24, fmt.Sprintf("%s in %s", t.owner, t.site.RootPath))
+		if err != nil {
+			t.server.logger.Error("failed to start terminal recording", "site", t.site.ID, "error", err)
+			websocket.Message.Send(ws, "failed to start terminal\r\n")
+			return
+		}
+		defer rec.Close()
+	}
+
+	limitsManager := limits.NewManager(t.server.logger)
+	master, err := pty.Start(t.cmd, func(cmd *exec.Cmd) error {
+		return limitsManager.StartInCgroup(cmd, t.owner)
+	})
+	if err != nil {
+		t.server.logger.Error("failed to start terminal", "site", t.site.ID, "error", err)
+		websocket.Message.Send(ws, "failed to start terminal\r\n")
+		return
+	}
+	started := time.Now()
+	details := fmt.Sprintf("as %s in %s", t.owner, t.site.RootPath)
+	if rec != nil {
+		details += ", recording " + rec.ID
+	}
+	t.record(audit.ActionTerminalStart, details)
+
+	// Output goes to the client until the shell exits; closing the
+	// connection then ends the input loop below
+	exited := make(chan struct{})
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := master.Read(buf)
+			if n > 0 {
+				if rec != nil {
+					rec.Write(buf[:n])
+				}
+				if _, werr := ws.Write(buf[:n]); werr != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		close(exited)
+		ws.Close()
+	}()
+
+	reason := "closed"
+	for {
+		ws.SetReadDeadline(time.Now().Add(TerminalIdleTimeout))
+		var msg terminalMessage
+		err := websocket.JSON.Receive(ws, &msg)
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+			continue
+		}
+		if err != nil {
+			var netErr interface{ Timeout() bool }
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				reason = "idle timeout"
+				websocket.Message.Send(ws, "\r\n[session closed after being idle]\r\n")
+			}
+			break
+		}
+
+		if msg.Type == "resize" && msg.Cols > 0 && msg.Rows > 0 {
+			pty.Resize(master, msg.Cols, msg.Rows)
+			if rec != nil {
+				rec.Resize(int(msg.Cols), int(msg.Rows))
+			}
+		}
+		if msg.Type == "input" {
+			if _, err := master.Write([]byte(msg.Data)); err != nil {
+				break
+			}
+		}
+	}
+
+	select {
+	case <-exited:
+		if reason == "closed" {
+			reason = "shell exited"
+		}
+	default:
+	}
+
+	// Hang up on the whole session of the shell
+	master.Close()
+	syscall.Kill(-t.cmd.Process.Pid, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		t.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		syscall.Kill(-t.cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+	}
+
+	details = fmt.Sprintf("%s after %s", reason, time.Since(started).Round(time.Second))
+	if rec != nil && rec.Truncated() {
+		details += ", recording truncated"
+	}
+	t.record(audit.ActionTerminalEnd, details)
+}
+
+func (t *terminalSession) record(action, details string) {
+	t.server.recordAudit(models.AuditLog{
+		UserID:     t.auditor.UserID,
+		Username:   t.auditor.Username,
+		Action:     action,
+		Resource:   "site",
+		ResourceID: t.site.ID,
+		Details:    details,
+		IP:         t.ip,
+	})
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os/user"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/rehmatworks/fastcp/internal/audit"
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sites"
+)
+
+func TestTerminalTickets(t *testing.T) {
+	var tickets terminalTickets
+	claims := &auth.Claims{UserID: "1001", Username: "alice", Role: "user"}
+
+	ticket, err := tickets.issue(claims, "site-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A ticket for another site is used up without opening anything
+	if _, ok := tickets.take(ticket, "site-2"); ok {
+		t.Fatal("expected a ticket to be refused for another site")
+	}
+	if _, ok := tickets.take(ticket, "site-1"); ok {
+		t.Fatal("expected a ticket to be used up by a failed attempt")
+	}
+
+	ticket, _ = tickets.issue(claims, "site-1")
+	if got, ok := tickets.take(ticket, "site-1"); !ok || got != claims {
+		t.Fatal("expected the ticket to carry the claims it was issued for")
+	}
+
+	ticket, _ = tickets.issue(claims, "site-1")
+	entry := tickets.tickets[ticket]
+	entry.expires = time.Now().Add(-time.Second)
+	tickets.tickets[ticket] = entry
+	if _, ok := tickets.take(ticket, "site-1"); ok {
+		t.Fatal("expected an expired ticket to be refused")
+	}
+}
+
+func TestSiteTerminal(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("no current user")
+	}
+
+	loaded, _ := config.Load("")
+	cfg := *loaded
+	cfg.JWTSecret = "test-secret"
+	config.Update(&cfg)
+
+	siteManager := sites.NewManager(t.TempDir())
+	_ = siteManager.Load()
+	site, err := siteManager.Create(&models.Site{
+		UserID:     current.Uid,
+		Name:       "example",
+		Domain:     "example.com",
+		PHPVersion: cfg.PHPVersions[0].Version,
+		RootPath:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create site: %v", err)
+	}
+
+	s := NewServer(siteManager, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	log := audit.NewLog(t.TempDir())
+	s.SetAudit(log)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	owner, _ := auth.GenerateToken(&models.User{ID: current.Uid, Username: current.Username, Role: "user"})
+	other, _ := auth.GenerateToken(&models.User{ID: "99999", Username: "mallory", Role: "user"})
+	path := "/api/v1/sites/" + site.ID + "/terminal"
+
+	ticket := func(token string) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+path+"/ticket", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Ticket string `json:"ticket"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Ticket
+	}
+	dial := func(ticket string) (*websocket.Conn, error) {
+		return websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path+"?ticket="+ticket, "", srv.URL)
+	}
+
+	// Other users cannot get a ticket for the site, and the access token
+	// does not open a terminal by itself
+	if code, _ := ticket(other); code != http.StatusForbidden {
+		t.Fatalf("expected another user to be refused a ticket, got %d", code)
+	}
+	if _, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path+"?token="+owner, "", srv.URL); err == nil {
+		t.Fatal("expected a token in the URL to be refused")
+	}
+
+	code, issued := ticket(owner)
+	if code != http.StatusOK || issued == "" {
+		t.Fatalf("expected a ticket for the owner, got %d", code)
+	}
+	ws, err := dial(issued)
+	if err != nil {
+		t.Fatalf("failed to open terminal: %v", err)
+	}
+	// Tickets are single use
+	if _, err := dial(issued); err == nil {
+		t.Fatal("expected a used ticket to be refused")
+	}
+	defer ws.Close()
+
+	send := func(msg terminalMessage) {
+		if err := websocket.JSON.Send(ws, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	readUntil := func(want string) {
+		ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+		for !strings.Contains(out.String(), want) {
+			var data []byte
+			if err := websocket.Message.Receive(ws, &data); err != nil {
+				t.Fatalf("expected %q in output, got %q: %v", want, out.String(), err)
+			}
+			out.Write(data)
+		}
+	}
+
+	send(terminalMessage{Type: "resize", Cols: 100, Rows: 40})
+	send(terminalMessage{Type: "input", Data: "stty size; pwd; echo done-$((6*7))\r"})
+	readUntil("done-42")
+	for _, want := range []string{"40 100", site.RootPath} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in output %q", want, out.String())
+		}
+	}
+
+	// Input typed at a prompt without echo, such as a password, stays out
+	// of the audit log and the recording
+	send(terminalMessage{Type: "input", Data: "read -s -p Pass''word: secret; echo; echo got-${#secret}\r"})
+	readUntil("Password:")
+	send(terminalMessage{Type: "input", Data: "hunter2\r"})
+	readUntil("got-7")
+
+	// The connection closes when the shell exits
+	send(terminalMessage{Type: "input", Data: "exit\r"})
+	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(ws, &data); err != nil {
+			break
+		}
+	}
+
+	var entries []models.AuditLog
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		entries, _ = log.List(audit.Filter{})
+		if slices.ContainsFunc(entries, func(e models.AuditLog) bool { return e.Action == audit.ActionTerminalEnd }) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	var recorded []string
+	recording := ""
+	for _, e := range entries {
+		if e.UserID != current.Uid || e.Username != current.Username || e.ResourceID != site.ID {
+			t.Errorf("unexpected audit entry %+v", e)
+		}
+		recorded = append(recorded, e.Action+" "+e.Details)
+		if _, id, ok := strings.Cut(e.Details, ", recording "); ok && e.Action == audit.ActionTerminalStart {
+			recording = id
+		}
+	}
+	joined := strings.Join(recorded, "\n")
+	for _, want := range []string{
+		audit.ActionTerminalStart + " as " + current.Username,
+		audit.ActionTerminalEnd + " shell exited",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected %q in audit log:\n%s", want, joined)
+		}
+	}
+	if strings.Contains(joined, "hunter2") {
+		t.Errorf("expected typed input not to be recorded:\n%s", joined)
+	}
+
+	// The output is in the recording the start entry refers to, which
+	// only admins can read
+	getRecording := func(token string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/admin/audit/recordings/"+recording, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if recording == "" {
+		t.Fatalf("expected the start entry to refer to a recording:\n%s", joined)
+	}
+	if code, _ := getRecording(owner); code != http.StatusForbidden {
+		t.Errorf("expected the site owner to be refused the recording, got %d", code)
+	}
+	admin, _ := auth.GenerateToken(&models.User{ID: "0", Username: "root", Role: "admin"})
+	code, cast := getRecording(admin)
+	if code != http.StatusOK || !strings.Contains(cast, `"version":2`) {
+		t.Fatalf("expected the recording, got %d %q", code, cast)
+	}
+	for _, want := range []string{"done-42", "got-7", `"r","100x40"`} {
+		if !strings.Contains(cast, want) {
+			t.Errorf("expected %q in the recording:\n%s", want, cast)
+		}
+	}
+	if strings.Contains(cast, "hunter2") {
+		t.Errorf("expected the password not to be recorded:\n%s", cast)
+	}
+}
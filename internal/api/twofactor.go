@@ -0,0 +1,352 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/twofactor"
+)
+
+// TwoFactorRequest carries a login challenge and/or an authentication code
+type TwoFactorRequest struct {
+	Challenge string `json:"challenge,omitempty"`
+	Code      string `json:"code,omitempty"` // TOTP or recovery code
+}
+
+// usedChallenges remembers the login challenges that completed a login
+// until they expire, so a challenge logs in only once. The record is kept
+// in memory, so challenges issued before the server started are refused.
+type usedChallenges struct {
+	mu      sync.Mutex
+	started time.Time
+	used    map[string]time.Time
+}
+
+// valid reports whether a challenge may still complete a login
+func (u *usedChallenges) valid(claims *auth.Claims) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if claims.ID == "" || claims.IssuedAt == nil || claims.IssuedAt.Time.Before(u.started.Truncate(time.Second)) {
+		return false
+	}
+	_, used := u.used[claims.ID]
+	return !used
+}
+
+// use marks a challenge as used. It returns false if it already was.
+func (u *usedChallenges) use(claims *auth.Claims) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.used == nil {
+		u.used = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for id, expires := range u.used {
+		if now.After(expires) {
+			delete(u.used, id)
+		}
+	}
+	if _, used := u.used[claims.ID]; used {
+		return false
+	}
+	u.used[claims.ID] = claims.ExpiresAt.Time
+	return true
+}
+
+// SetTwoFactor attaches the two-factor authentication manager
+func (s *Server) SetTwoFactor(manager *twofactor.Manager) {
+	s.twoFactor = manager
+}
+
+// loginChallenge answers a correct password with a challenge for the
+// second login step
+func (s *Server) loginChallenge(w http.ResponseWriter, user *models.User, purpose string) {
+	challenge, err := auth.GenerateChallengeToken(user, purpose)
+	if err != nil {
+		s.logger.Error("failed to generate login challenge", "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+
+	resp := map[string]interface{}{
+		"challenge":  challenge,
+		"expires_in": int(auth.ChallengeTTL.Seconds()),
+	}
+	if purpose == auth.PurposeTwoFactorSetup {
+		resp["two_factor_setup_required"] = true
+	} else {
+		resp["two_factor_required"] = true
+	}
+	s.logger.Info("login awaiting two-factor step", "username", user.Username, "step", purpose)
+	s.success(w, resp)
+}
+
+// decodeChallenge reads a two-factor request and validates its challenge
+func (s *Server) decodeChallenge(w http.ResponseWriter, r *http.Request, purpose string) (*TwoFactorRequest, *auth.Claims, bool) {
+	if s.twoFactor == nil {
+		s.error(w, http.StatusServiceUnavailable, "two-factor authentication not enabled")
+		return nil, nil, false
+	}
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return nil, nil, false
+	}
+
+	claims, err := auth.ValidateChallengeToken(req.Challenge, purpose)
+	if err != nil || !s.challenges.valid(claims) {
+		s.error(w, http.StatusUnauthorized, "invalid or expired challenge")
+		return nil, nil, false
+	}
+	return &req, claims, true
+}
+
+// finishChallenge logs in the user of a challenge, unless the challenge
+// was used meanwhile
+func (s *Server) finishChallenge(w http.ResponseWriter, r *http.Request, claims *auth.Claims, recoveryCodes []string) {
+	if !s.challenges.use(claims) {
+		s.error(w, http.StatusUnauthorized, "invalid or expired challenge")
+		return
+	}
+	s.completeLogin(w, r, &models.User{
+		ID:       claims.UserID,
+		Username: claims.Username,
+		Email:    claims.Username + "@localhost",
+		Role:     claims.Role,
+	}, recoveryCodes)
+}
+
+// verifyTwoFactorLogin completes a login with an authentication code
+func (s *Server) verifyTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	req, claims, ok := s.decodeChallenge(w, r, auth.PurposeTwoFactor)
+	if !ok {
+		return
+	}
+
+	if err := s.twoFactor.Verify(claims.Username, req.Code); err != nil {
+		s.logger.Warn("failed two-factor login", "username", claims.Username, "error", err)
+		s.twoFactorError(w, err)
+		return
+	}
+
+	s.finishChallenge(w, r, claims, nil)
+}
+
+// setupTwoFactorLogin starts the enrollment required before an admin can
+// log in
+func (s *Server) setupTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	_, claims, ok := s.decodeChallenge(w, r, auth.PurposeTwoFactorSetup)
+	if !ok {
+		return
+	}
+	s.beginTwoFactor(w, claims.Username)
+}
+
+// confirmTwoFactorLogin finishes the required enrollment and logs in
+func (s *Server) confirmTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	req, claims, ok := s.decodeChallenge(w, r, auth.PurposeTwoFactorSetup)
+	if !ok {
+		return
+	}
+
+	codes, err := s.twoFactor.Confirm(claims.Username, req.Code)
+	if err != nil {
+		s.twoFactorError(w, err)
+		return
+	}
+
+	s.logger.Info("two-factor authentication enabled", "username", claims.Username)
+	s.finishChallenge(w, r, claims, codes)
+}
+
+// getTwoFactorStatus returns the current user's two-factor state
+func (s *Server) getTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.twoFactor == nil {
+		s.error(w, http.StatusServiceUnavailable, "two-factor authentication not enabled")
+		return
+	}
+
+	s.success(w, map[string]interface{}{
+		"status":   s.twoFactor.Status(claims.Username),
+		"required": s.twoFactorRequired(claims),
+	})
+}
+
+// enrollTwoFactor starts two-factor enrollment for the current user
+func (s *Server) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.twoFactorClaims(w, r)
+	if !ok {
+		return
+	}
+	s.beginTwoFactor(w, claims.Username)
+}
+
+// confirmTwoFactor enables two-factor authentication for the current user
+func (s *Server) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.twoFactorClaims(w, r)
+	if !ok {
+		return
+	}
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	codes, err := s.twoFactor.Confirm(claims.Username, req.Code)
+	if err != nil {
+		s.twoFactorError(w, err)
+		return
+	}
+
+	s.logger.Info("two-factor authentication enabled", "username", claims.Username)
+	s.success(w, map[string]interface{}{
+		"message":        "two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// regenerateRecoveryCodes replaces the current user's recovery codes
+func (s *Server) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.twoFactorClaims(w, r)
+	if !ok {
+		return
+	}
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	codes, err := s.twoFactor.RegenerateRecoveryCodes(claims.Username, req.Code)
+	if err != nil {
+		s.twoFactorError(w, err)
+		return
+	}
+
+	s.logger.Info("two-factor recovery codes regenerated", "username", claims.Username)
+	s.success(w, map[string]interface{}{"recovery_codes": codes})
+}
+
+// disableTwoFactor turns two-factor authentication off after verifying a code
+func (s *Server) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.twoFactorClaims(w, r)
+	if !ok {
+		return
+	}
+
+	if s.twoFactorRequired(claims) {
+		s.error(w, http.StatusForbidden, "two-factor authentication is required for admins")
+		return
+	}
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := s.twoFactor.Verify(claims.Username, req.Code); err != nil {
+		s.twoFactorError(w, err)
+		return
+	}
+	if err := s.twoFactor.Disable(claims.Username); err != nil {
+		s.twoFactorError(w, err)
+		return
+	}
+
+	s.logger.Info("two-factor authentication disabled", "username", claims.Username)
+	s.success(w, map[string]string{"message": "two-factor authentication disabled"})
+}
+
+// resetUserTwoFactor removes a user's two-factor state so a user who lost
+// their authenticator and recovery codes can enroll again (admin only)
+func (s *Server) resetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	claims := middleware.GetClaims(r)
+
+	if s.twoFactor == nil {
+		s.error(w, http.StatusServiceUnavailable, "two-factor authentication not enabled")
+		return
+	}
+
+	if err := s.twoFactor.Disable(username); err != nil {
+		s.twoFactorError(w, err)
+		return
+	}
+
+	s.logger.Info("two-factor authentication reset", "username", username, "by", claims.Username)
+	s.success(w, map[string]string{"message": "two-factor authentication reset"})
+}
+
+// beginTwoFactor creates a new secret and returns its provisioning URI
+func (s *Server) beginTwoFactor(w http.ResponseWriter, username string) {
+	account := username
+	if hostname, err := os.Hostname(); err == nil {
+		account = username + "@" + hostname
+	}
+
+	secret, uri, err := s.twoFactor.Begin(username, account)
+	if err != nil {
+		s.twoFactorError(w, err)
+		return
+	}
+
+	s.success(w, map[string]string{
+		"secret":           secret,
+		"provisioning_uri": uri,
+	})
+}
+
+// twoFactorClaims returns the caller's claims for changing their own
+// two-factor settings, which is not possible while impersonating
+func (s *Server) twoFactorClaims(w http.ResponseWriter, r *http.Request) (*auth.Claims, bool) {
+	if s.twoFactor == nil {
+		s.error(w, http.StatusServiceUnavailable, "two-factor authentication not enabled")
+		return nil, false
+	}
+	if middleware.IsImpersonating(r) {
+		s.error(w, http.StatusForbidden, "cannot change two-factor settings while impersonating")
+		return nil, false
+	}
+	return middleware.GetClaims(r), true
+}
+
+// twoFactorRequired reports whether the admin policy requires 2FA for a user
+func (s *Server) twoFactorRequired(claims *auth.Claims) bool {
+	return claims.Role == "admin" && config.Get().RequireTwoFactorAdmins
+}
+
+// twoFactorError maps two-factor errors to HTTP responses
+func (s *Server) twoFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, twofactor.ErrInvalidCode):
+		s.error(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, twofactor.ErrLockedOut):
+		s.error(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, twofactor.ErrAlreadyEnabled):
+		s.error(w, http.StatusConflict, err.Error())
+	case errors.Is(err, twofactor.ErrNotEnabled), errors.Is(err, twofactor.ErrNoEnrollment):
+		s.error(w, http.StatusBadRequest, err.Error())
+	default:
+		s.logger.Error("two-factor operation failed", "error", err)
+		s.error(w, http.StatusInternalServerError, "two-factor operation failed")
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"testing"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/twofactor"
+)
+
+func postJSON(handler http.HandlerFunc, body interface{}) (int, map[string]interface{}) {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	var resp map[string]interface{}
+	json.NewDecoder(w.Result().Body).Decode(&resp)
+	return w.Code, resp
+}
+
+func TestLogin_TwoFactor(t *testing.T) {
+	os.Unsetenv("FASTCP_DEV")
+	loaded, _ := config.Load("")
+	cfg := *loaded
+	cfg.JWTSecret = "test-secret"
+	cfg.RequireTwoFactorAdmins = false
+	config.Update(&cfg)
+
+	auth.SetPasswordVerifier(func(u, p string) bool { return true })
+	auth.SetGroupChecker(func(u, g string) bool { return g == "fastcp" })
+	auth.SetUserLookup(func(username string) (*user.User, error) {
+		return &user.User{Uid: "1000", Username: username}, nil
+	})
+	defer func() { auth.SetPasswordVerifier(nil); auth.SetGroupChecker(nil); auth.SetUserLookup(nil) }()
+
+	s := NewServer(nil, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	tf := twofactor.NewManager(t.TempDir())
+	s.SetTwoFactor(tf)
+
+	secret, _, err := tf.Begin("alice", "alice")
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	confirmCode, _ := twofactor.Code(secret, time.Now().Add(-twofactor.Period*time.Second))
+	if _, err := tf.Confirm("alice", confirmCode); err != nil {
+		t.Fatalf("Confirm failed: %v", err)
+	}
+
+	status, resp := postJSON(s.login, map[string]string{"username": "alice", "password": "pw"})
+	challenge, _ := resp["challenge"].(string)
+	if status != http.StatusOK || resp["two_factor_required"] != true || challenge == "" || resp["token"] != nil {
+		t.Fatalf("expected a two-factor challenge, got %d %v", status, resp)
+	}
+
+	// The challenge is not a session token
+	if _, err := auth.ValidateToken(challenge); err == nil {
+		t.Fatal("expected challenge to be rejected as a session token")
+	}
+
+	if status, _ := postJSON(s.verifyTwoFactorLogin, TwoFactorRequest{Challenge: challenge, Code: "000000"}); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong code, got %d", status)
+	}
+
+	code, _ := twofactor.Code(secret, time.Now())
+	status, resp = postJSON(s.verifyTwoFactorLogin, TwoFactorRequest{Challenge: challenge, Code: code})
+	token, _ := resp["token"].(string)
+	if status != http.StatusOK || token == "" {
+		t.Fatalf("expected a token after verification, got %d %v", status, resp)
+	}
+	if claims, err := auth.ValidateToken(token); err != nil || claims.Username != "alice" {
+		t.Fatalf("expected a valid session token, got %v %v", claims, err)
+	}
+
+	// A challenge logs in only once
+	if status, resp := postJSON(s.verifyTwoFactorLogin, TwoFactorRequest{Challenge: challenge, Code: code}); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a reused challenge, got %d %v", status, resp)
+	}
+
+	// Challenges issued before the server started are refused
+	_, resp = postJSON(s.login, map[string]string{"username": "alice", "password": "pw"})
+	challenge, _ = resp["challenge"].(string)
+	s.challenges.started = time.Now().Add(2 * time.Second)
+	if status, resp := postJSON(s.verifyTwoFactorLogin, TwoFactorRequest{Challenge: challenge, Code: code}); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a challenge from before a restart, got %d %v", status, resp)
+	}
+}
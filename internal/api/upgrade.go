@@ -32,11 +32,6 @@ func (s *Server) getVersion(w http.ResponseWriter, r *http.Request) {
 func (s *Server) startUpgrade(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
-	if claims.Role != "admin" {
-		s.error(w, http.StatusForbidden, "admin access required")
-		return
-	}
-
 	if s.upgradeManager == nil {
 		s.error(w, http.StatusInternalServerError, "upgrade manager not initialized")
 		return
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"os/user"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rehmatworks/fastcp/internal/analytics"
+	"github.com/rehmatworks/fastcp/internal/metrics"
+)
+
+// SetUsageSampler attaches the per-user resource usage sampler
+func (s *Server) SetUsageSampler(sampler *metrics.Sampler) {
+	s.usage = sampler
+}
+
+// getUserUsageHistory returns a user's resource usage over time (admin only)
+func (s *Server) getUserUsageHistory(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+
+	if s.usage == nil {
+		s.error(w, http.StatusServiceUnavailable, "usage history not enabled")
+		return
+	}
+
+	if _, err := user.Lookup(username); err != nil {
+		s.error(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	rangeParam := r.URL.Query().Get("range")
+	if rangeParam == "" {
+		rangeParam = "24h"
+	}
+	d, err := analytics.ParseRange(rangeParam, maxAnalyticsRange)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	history, err := s.usage.Query(username, d)
+	if err != nil {
+		s.logger.Error("failed to query usage history", "user", username, "error", err)
+		s.error(w, http.StatusInternalServerError, "failed to load usage history")
+		return
+	}
+
+	s.success(w, history)
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -8,15 +10,20 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/events"
 	"github.com/rehmatworks/fastcp/internal/jail"
 	"github.com/rehmatworks/fastcp/internal/limits"
 	"github.com/rehmatworks/fastcp/internal/middleware"
 	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/packages"
+	"github.com/rehmatworks/fastcp/internal/quota"
 )
 
 // FastCPUser represents a FastCP user with limits and usage
@@ -28,21 +35,53 @@ type FastCPUser struct {
 	IsAdmin  bool   `json:"is_admin"`
 	Enabled  bool   `json:"enabled"`
 
+	// Reseller settings
+	IsReseller bool   `json:"is_reseller"`
+	Owner      string `json:"owner,omitempty"` // Reseller the account belongs to
+	MaxUsers   int    `json:"max_users"`       // Resellers only: 0 = unlimited customers
+
+	// Custom roles granting permissions beyond the built-in role
+	Roles []string `json:"roles,omitempty"`
+
+	// Admins and resellers cannot act as this user
+	ImpersonationDisabled bool `json:"impersonation_disabled"`
+
 	// Jail/SSH settings
 	IsJailed    bool `json:"is_jailed"`    // SFTP-only, chrooted
 	ShellAccess bool `json:"shell_access"` // Can use SSH shell (not jailed)
+	JailedShell bool `json:"jailed_shell"` // SSH shell in a sandbox
+	Sandbox     bool `json:"sandbox"`      // PHP runs in a sandbox, set by the package
 
 	// Limits
-	SiteLimit    int   `json:"site_limit"`    // 0 = unlimited
-	RAMLimitMB   int64 `json:"ram_limit_mb"`  // 0 = unlimited
-	CPUPercent   int   `json:"cpu_percent"`   // 0 = unlimited (100 = 1 core)
-	MaxProcesses int   `json:"max_processes"` // 0 = unlimited
+	SiteLimit    int   `json:"site_limit"`     // 0 = unlimited
+	RAMLimitMB   int64 `json:"ram_limit_mb"`   // 0 = unlimited
+	CPUPercent   int   `json:"cpu_percent"`    // 0 = unlimited (100 = 1 core)
+	MaxProcesses int   `json:"max_processes"`  // 0 = unlimited
+	BandwidthMB  int64 `json:"bandwidth_mb"`   // 0 = unlimited, monthly transfer
+	DiskLimitMB  int64 `json:"disk_limit_mb"`  // 0 = unlimited
+	MemoryHighMB int64 `json:"memory_high_mb"` // 0 = 90% of ram_limit_mb
+	SwapLimitMB  int64 `json:"swap_limit_mb"`  // 0 = unlimited, -1 = no swap
+	IOReadMBps   int64 `json:"io_read_mbps"`   // 0 = unlimited
+	IOWriteMBps  int64 `json:"io_write_mbps"`  // 0 = unlimited
+	IOReadIOPS   int   `json:"io_read_iops"`   // 0 = unlimited
+	IOWriteIOPS  int   `json:"io_write_iops"`  // 0 = unlimited
+
+	NotifyEmail  string `json:"notify_email,omitempty"` // Receives resource limit alerts
+	Package      string `json:"package,omitempty"`      // Assigned hosting package ID
+	MaxDatabases int    `json:"max_databases"`          // 0 = unlimited
 
 	// Usage
-	SiteCount    int   `json:"site_count"`
-	DiskUsedMB   int64 `json:"disk_used_mb"`
-	RAMUsedMB    int64 `json:"ram_used_mb"`
-	ProcessCount int   `json:"process_count"`
+	SiteCount       int   `json:"site_count"`
+	DiskUsedMB      int64 `json:"disk_used_mb"`
+	RAMUsedMB       int64 `json:"ram_used_mb"`
+	ProcessCount    int   `json:"process_count"`
+	BandwidthUsedMB int64 `json:"bandwidth_used_mb"` // transfer this month
+	SwapUsedMB      int64 `json:"swap_used_mb"`
+	IOReadBytes     int64 `json:"io_read_bytes"`  // cumulative
+	IOWriteBytes    int64 `json:"io_write_bytes"` // cumulative
+
+	// Disk quota state, only returned for a single user
+	DiskQuota *quota.Status `json:"disk_quota,omitempty"`
 }
 
 // CreateUserRequest represents a request to create a user
@@ -50,13 +89,32 @@ type CreateUserRequest struct {
 	Username    string `json:"username"`
 	Password    string `json:"password"`
 	IsAdmin     bool   `json:"is_admin"`     // Add to sudo group
+	IsReseller  bool   `json:"is_reseller"`  // Add to fastcp-reseller group (admins only)
 	ShellAccess bool   `json:"shell_access"` // Allow SSH shell (false = SFTP only, jailed)
 
 	// Resource limits
-	SiteLimit    int   `json:"site_limit"`    // 0 = unlimited
-	RAMLimitMB   int64 `json:"ram_limit_mb"`  // 0 = unlimited
-	CPUPercent   int   `json:"cpu_percent"`   // 0 = unlimited
-	MaxProcesses int   `json:"max_processes"` // 0 = unlimited
+	SiteLimit    int   `json:"site_limit"`     // 0 = unlimited
+	RAMLimitMB   int64 `json:"ram_limit_mb"`   // 0 = unlimited
+	CPUPercent   int   `json:"cpu_percent"`    // 0 = unlimited
+	MaxProcesses int   `json:"max_processes"`  // 0 = unlimited
+	BandwidthMB  int64 `json:"bandwidth_mb"`   // 0 = unlimited, monthly transfer
+	DiskLimitMB  int64 `json:"disk_limit_mb"`  // 0 = unlimited
+	MemoryHighMB int64 `json:"memory_high_mb"` // 0 = 90% of ram_limit_mb
+	SwapLimitMB  int64 `json:"swap_limit_mb"`  // 0 = unlimited, -1 = no swap
+	IOReadMBps   int64 `json:"io_read_mbps"`   // 0 = unlimited
+	IOWriteMBps  int64 `json:"io_write_mbps"`  // 0 = unlimited
+	IOReadIOPS   int   `json:"io_read_iops"`   // 0 = unlimited
+	IOWriteIOPS  int   `json:"io_write_iops"`  // 0 = unlimited
+
+	NotifyEmail string `json:"notify_email,omitempty"` // Receives resource limit alerts
+
+	// Package (ID or name) replaces the limits above and shell_access
+	Package string `json:"package,omitempty"`
+
+	// Reseller settings (admins only). Accounts created by a reseller
+	// always belong to that reseller.
+	MaxUsers int    `json:"max_users"`       // 0 = unlimited customer accounts
+	Owner    string `json:"owner,omitempty"` // Reseller the account belongs to
 }
 
 // UpdateUserRequest represents a request to update a user
@@ -64,20 +122,52 @@ type UpdateUserRequest struct {
 	Password    string `json:"password,omitempty"`
 	Enabled     bool   `json:"enabled"`
 	ShellAccess bool   `json:"shell_access"` // Allow SSH shell (false = SFTP only, jailed)
+	JailedShell bool   `json:"jailed_shell"` // Without shell_access: sandboxed shell instead of SFTP only
 
 	// Resource limits
 	SiteLimit    int   `json:"site_limit"`
 	RAMLimitMB   int64 `json:"ram_limit_mb"`
 	CPUPercent   int   `json:"cpu_percent"`
 	MaxProcesses int   `json:"max_processes"`
+	BandwidthMB  int64 `json:"bandwidth_mb"`
+	DiskLimitMB  int64 `json:"disk_limit_mb"`
+	MemoryHighMB int64 `json:"memory_high_mb"`
+	SwapLimitMB  int64 `json:"swap_limit_mb"`
+	IOReadMBps   int64 `json:"io_read_mbps"`
+	IOWriteMBps  int64 `json:"io_write_mbps"`
+	IOReadIOPS   int   `json:"io_read_iops"`
+	IOWriteIOPS  int   `json:"io_write_iops"`
+
+	NotifyEmail string `json:"notify_email,omitempty"`
+	Package     string `json:"package,omitempty"`
+
+	// Reseller settings (admins only), nil leaves them unchanged
+	IsReseller *bool   `json:"is_reseller,omitempty"`
+	Owner      *string `json:"owner,omitempty"` // "" makes the account admin managed
+	MaxUsers   int     `json:"max_users"`
+
+	// Impersonation setting (admins only), nil leaves it unchanged
+	ImpersonationDisabled *bool `json:"impersonation_disabled,omitempty"`
 }
 
-// listUsers returns all FastCP users
+// listUsers returns all FastCP users, or a reseller's own customers
 func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := s.getFastCPUsers()
-	if err != nil {
-		s.error(w, http.StatusInternalServerError, "failed to list users")
-		return
+	claims := middleware.GetClaims(r)
+
+	var users []FastCPUser
+	if claims.Role == "reseller" {
+		users = []FastCPUser{}
+		for _, l := range s.resellerCustomers(claims.Username) {
+			if u, err := s.getFastCPUser(l.Username); err == nil {
+				users = append(users, *u)
+			}
+		}
+	} else {
+		var err error
+		if users, err = s.getFastCPUsers(); err != nil {
+			s.error(w, http.StatusInternalServerError, "failed to list users")
+			return
+		}
 	}
 
 	s.success(w, map[string]interface{}{
@@ -90,12 +180,23 @@ func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
 func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
 	username := chi.URLParam(r, "username")
 
+	if !s.canManageUser(middleware.GetClaims(r), username) {
+		s.error(w, http.StatusNotFound, "user not found")
+		return
+	}
+
 	u, err := s.getFastCPUser(username)
 	if err != nil {
 		s.error(w, http.StatusNotFound, "user not found")
 		return
 	}
 
+	if s.quota != nil {
+		if u.DiskQuota, err = s.quota.Status(username); err != nil {
+			s.logger.Warn("failed to get disk quota status", "user", username, "error", err)
+		}
+	}
+
 	s.success(w, u)
 }
 
@@ -122,6 +223,67 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 		s.error(w, http.StatusBadRequest, "password must be at least 8 characters")
 		return
 	}
+	if req.NotifyEmail != "" && !events.ValidAddress(req.NotifyEmail) {
+		s.error(w, http.StatusBadRequest, "invalid notify_email")
+		return
+	}
+
+	// A package replaces the raw limits and shell access
+	var pkg *models.Package
+	if req.Package != "" {
+		var err error
+		if pkg, err = s.resolvePackage(req.Package); err != nil {
+			s.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.ShellAccess = pkg.ShellAccess
+	}
+
+	// Resource limits
+	userLimits := &models.UserLimits{
+		Username:       req.Username,
+		MaxSites:       req.SiteLimit,
+		MaxRAMMB:       req.RAMLimitMB,
+		MaxCPUPercent:  req.CPUPercent,
+		MaxProcesses:   req.MaxProcesses,
+		MaxBandwidthMB: req.BandwidthMB,
+		MaxDiskMB:      req.DiskLimitMB,
+		MemoryHighMB:   req.MemoryHighMB,
+		MaxSwapMB:      req.SwapLimitMB,
+		MaxIOReadMBps:  req.IOReadMBps,
+		MaxIOWriteMBps: req.IOWriteMBps,
+		MaxIOReadIOPS:  req.IOReadIOPS,
+		MaxIOWriteIOPS: req.IOWriteIOPS,
+		NotifyEmail:    req.NotifyEmail,
+		MaxUsers:       req.MaxUsers,
+	}
+	if pkg != nil {
+		userLimits = packages.ApplyTo(pkg, userLimits)
+	}
+
+	// Resellers create customers within their own allocation
+	if claims.Role == "reseller" {
+		if req.IsAdmin || req.IsReseller {
+			s.error(w, http.StatusForbidden, "resellers cannot create admin or reseller accounts")
+			return
+		}
+		userLimits.Owner = claims.Username
+		userLimits.MaxUsers = 0
+		if err := s.checkResellerAllocation(claims.Username, userLimits); err != nil {
+			s.error(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if req.ShellAccess {
+			s.error(w, http.StatusForbidden, errResellerShell.Error())
+			return
+		}
+	} else if req.Owner != "" {
+		if !s.isReseller(req.Owner) {
+			s.error(w, http.StatusBadRequest, "owner must be a reseller")
+			return
+		}
+		userLimits.Owner = req.Owner
+	}
 
 	// Check if user already exists
 	if _, err := user.Lookup(req.Username); err == nil {
@@ -129,25 +291,54 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := s.createAccount(req.Username, req.Password, req.IsAdmin, req.ShellAccess); err != nil {
+		s.error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if req.IsReseller {
+		s.setReseller(req.Username, true)
+	}
+
+	// Set resource limits
+	if err := s.siteManager.SetUserLimit(userLimits); err != nil {
+		s.logger.Warn("failed to save user limits", "error", err)
+	}
+
+	// Apply system-level limits (cgroups, quotas)
+	limitsManager := limits.NewManager(s.logger)
+	if err := limitsManager.ApplyLimits(userLimits); err != nil {
+		s.logger.Warn("failed to apply system limits", "error", err)
+	}
+	s.applyDiskQuota(userLimits)
+
+	s.logger.Info("user created", "username", req.Username, "by", claims.Username)
+
+	// Return the created user
+	createdUser, _ := s.getFastCPUser(req.Username)
+	s.json(w, http.StatusCreated, createdUser)
+}
+
+// createAccount creates the Unix account of a panel user with its home,
+// web and PHP directories and group memberships
+func (s *Server) createAccount(username, password string, isAdmin, shellAccess bool) error {
 	// Create user with useradd
-	cmd := exec.Command("useradd", "-m", "-s", "/bin/bash", req.Username)
+	cmd := exec.Command("useradd", "-m", "-s", "/bin/bash", username)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		s.logger.Error("failed to create user", "error", err, "output", string(output))
-		s.error(w, http.StatusInternalServerError, "failed to create user")
-		return
+		return fmt.Errorf("failed to create user")
 	}
 
 	// Set password (requires root privileges or configured sudo fallback)
-	if out, err := runChpasswd(req.Username, req.Password); err != nil {
+	if out, err := runChpasswd(username, password); err != nil {
 		s.logger.Error("failed to set password", "error", err, "output", string(out))
 		// Cleanup: delete the user to avoid partial state
-		_ = exec.Command("userdel", "-r", req.Username).Run()
-		s.error(w, http.StatusInternalServerError, fmt.Sprintf("failed to set password: %v", err))
-		return
+		_ = exec.Command("userdel", "-r", username).Run()
+		return fmt.Errorf("failed to set password: %v", err)
 	}
 
 	// Secure home directory - prevent other users from accessing
-	homeDir := fmt.Sprintf("/home/%s", req.Username)
+	homeDir := fmt.Sprintf("/home/%s", username)
 	_ = exec.Command("chmod", "750", homeDir).Run()
 
 	// Create directories for per-user PHP instances
@@ -164,30 +355,30 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 
 	// Add to fastcp group (for FastCP panel access)
 	_ = exec.Command("groupadd", "-f", "fastcp").Run()
-	cmd = exec.Command("usermod", "-aG", "fastcp", req.Username)
+	cmd = exec.Command("usermod", "-aG", "fastcp", username)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		s.logger.Warn("failed to add user to fastcp group", "error", err, "output", string(output))
 	}
 
 	// Add to ssh group if it exists (some systems restrict SSH to specific groups)
-	_ = exec.Command("usermod", "-aG", "ssh", req.Username).Run()
+	_ = exec.Command("usermod", "-aG", "ssh", username).Run()
 	// Also try sshusers group (used by some configurations)
-	_ = exec.Command("usermod", "-aG", "sshusers", req.Username).Run()
+	_ = exec.Command("usermod", "-aG", "sshusers", username).Run()
 
 	// Add to sudo group if admin
-	if req.IsAdmin {
-		cmd = exec.Command("usermod", "-aG", "sudo", req.Username)
+	if isAdmin {
+		cmd = exec.Command("usermod", "-aG", "sudo", username)
 		if output, err := cmd.CombinedOutput(); err != nil {
 			s.logger.Warn("failed to add user to sudo group", "error", err, "output", string(output))
 		}
 		// Admins are not jailed
-		jail.RemoveUserFromJail(req.Username)
-	} else if !req.ShellAccess {
+		jail.RemoveUserFromJail(username)
+	} else if !shellAccess {
 		// Non-admin users without shell access are jailed (SFTP only)
-		if err := jail.SetupUserJail(req.Username); err != nil {
+		if err := jail.SetupUserJail(username); err != nil {
 			s.logger.Warn("failed to setup user jail", "error", err)
 		}
-		s.logger.Info("user jailed (SFTP-only)", "username", req.Username)
+		s.logger.Info("user jailed (SFTP-only)", "username", username)
 	}
 
 	// Create user's web directory with proper permissions
@@ -200,7 +391,7 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Set ownership of all user directories to the user
-	u, _ := user.Lookup(req.Username)
+	u, _ := user.Lookup(username)
 	if u != nil {
 		uid, _ := strconv.Atoi(u.Uid)
 		gid, _ := strconv.Atoi(u.Gid)
@@ -209,31 +400,31 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 			_ = os.Chown(dir, uid, gid)
 		}
 	}
+	return nil
+}
 
-	// Set resource limits
-	userLimits := &models.UserLimits{
-		Username:      req.Username,
-		MaxSites:      req.SiteLimit,
-		MaxRAMMB:      req.RAMLimitMB,
-		MaxCPUPercent: req.CPUPercent,
-		MaxProcesses:  req.MaxProcesses,
+// ProvisionDirectoryUser creates the Unix account of a directory user on
+// their first login. The account is jailed without shell access, like a
+// new customer, and gets a random local password the user never sees.
+func (s *Server) ProvisionDirectoryUser(username string) error {
+	if _, err := user.Lookup(username); err == nil {
+		return nil
 	}
 
-	if err := s.siteManager.SetUserLimit(userLimits); err != nil {
-		s.logger.Warn("failed to save user limits", "error", err)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return err
 	}
-
-	// Apply system-level limits (cgroups, quotas)
-	limitsManager := limits.NewManager(s.logger)
-	if err := limitsManager.ApplyLimits(userLimits); err != nil {
-		s.logger.Warn("failed to apply system limits", "error", err)
+	if err := s.createAccount(username, base64.RawURLEncoding.EncodeToString(secret), false, false); err != nil {
+		return err
 	}
 
-	s.logger.Info("user created", "username", req.Username, "by", claims.Username)
-
-	// Return the created user
-	createdUser, _ := s.getFastCPUser(req.Username)
-	s.json(w, http.StatusCreated, createdUser)
+	userLimits := &models.UserLimits{Username: username}
+	if err := s.siteManager.SetUserLimit(userLimits); err != nil {
+		s.logger.Warn("failed to save user limits", "error", err)
+	}
+	s.logger.Info("user provisioned from directory", "username", username)
+	return nil
 }
 
 // updateUser updates a user's settings
@@ -242,7 +433,7 @@ func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 
 	// Verify user exists
-	if _, err := user.Lookup(username); err != nil {
+	if _, err := user.Lookup(username); err != nil || !s.canManageUser(claims, username) {
 		s.error(w, http.StatusNotFound, "user not found")
 		return
 	}
@@ -252,6 +443,77 @@ func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 		s.error(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	if req.NotifyEmail != "" && !events.ValidAddress(req.NotifyEmail) {
+		s.error(w, http.StatusBadRequest, "invalid notify_email")
+		return
+	}
+
+	// A package replaces the raw limits and shell access
+	var pkg *models.Package
+	if req.Package != "" {
+		var err error
+		if pkg, err = s.resolvePackage(req.Package); err != nil {
+			s.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.ShellAccess = pkg.ShellAccess
+	}
+
+	current := s.siteManager.GetUserLimit(username)
+	userLimits := &models.UserLimits{
+		Username:       username,
+		MaxSites:       req.SiteLimit,
+		MaxRAMMB:       req.RAMLimitMB,
+		MaxCPUPercent:  req.CPUPercent,
+		MaxProcesses:   req.MaxProcesses,
+		MaxBandwidthMB: req.BandwidthMB,
+		MaxDiskMB:      req.DiskLimitMB,
+		MemoryHighMB:   req.MemoryHighMB,
+		MaxSwapMB:      req.SwapLimitMB,
+		MaxIOReadMBps:  req.IOReadMBps,
+		MaxIOWriteMBps: req.IOWriteMBps,
+		MaxIOReadIOPS:  req.IOReadIOPS,
+		MaxIOWriteIOPS: req.IOWriteIOPS,
+		NotifyEmail:    req.NotifyEmail,
+		Owner:          current.Owner,
+		MaxUsers:       req.MaxUsers,
+
+		ImpersonationDisabled: current.ImpersonationDisabled,
+	}
+	if pkg != nil {
+		userLimits = packages.ApplyTo(pkg, userLimits)
+	}
+
+	// Resellers change their customers within their own allocation, only
+	// admins change roles and ownership
+	if claims.Role == "reseller" {
+		userLimits.MaxUsers = 0
+		if err := s.checkResellerAllocation(claims.Username, userLimits); err != nil {
+			s.error(w, http.StatusForbidden, err.Error())
+			return
+		}
+		// Keeping full shell access an admin granted is not a grant
+		if req.ShellAccess && (jail.IsUserJailed(username) || jail.IsUserShellJailed(username)) {
+			s.error(w, http.StatusForbidden, errResellerShell.Error())
+			return
+		}
+	} else {
+		if req.Owner != nil {
+			if *req.Owner != "" && (*req.Owner == username || !s.isReseller(*req.Owner)) {
+				s.error(w, http.StatusBadRequest, "owner must be a reseller")
+				return
+			}
+			userLimits.Owner = *req.Owner
+		}
+		if req.ImpersonationDisabled != nil {
+			userLimits.ImpersonationDisabled = *req.ImpersonationDisabled
+		}
+		if req.IsReseller != nil && *req.IsReseller != s.isReseller(username) {
+			s.setReseller(username, *req.IsReseller)
+			// Sessions carry the role, so the user logs in again with the new one
+			s.revokeUserSessions(username, "", "role changed")
+		}
+	}
 
 	// Update password if provided
 	if req.Password != "" {
@@ -269,17 +531,10 @@ func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 			s.error(w, http.StatusInternalServerError, fmt.Sprintf("failed to update password: %s", strings.TrimSpace(string(out))))
 			return
 		}
+		s.revokeUserSessions(username, "", "password changed")
 	}
 
 	// Update resource limits
-	userLimits := &models.UserLimits{
-		Username:      username,
-		MaxSites:      req.SiteLimit,
-		MaxRAMMB:      req.RAMLimitMB,
-		MaxCPUPercent: req.CPUPercent,
-		MaxProcesses:  req.MaxProcesses,
-	}
-
 	if err := s.siteManager.SetUserLimit(userLimits); err != nil {
 		s.logger.Warn("failed to save user limits", "error", err)
 	}
@@ -289,24 +544,11 @@ func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 	if err := limitsManager.ApplyLimits(userLimits); err != nil {
 		s.logger.Warn("failed to apply system limits", "error", err)
 	}
+	s.applyDiskQuota(userLimits)
+	s.applySandbox(username, current.Sandbox, userLimits.Sandbox)
 
 	// Handle shell access / jail changes
-	isCurrentlyJailed := jail.IsUserJailed(username)
-	isAdmin := s.isUserInGroup(username, "sudo") || s.isUserInGroup(username, "wheel")
-
-	if !isAdmin {
-		if req.ShellAccess && isCurrentlyJailed {
-			// Grant shell access - remove from jail
-			jail.RemoveUserFromJail(username)
-			s.logger.Info("user removed from jail (shell access granted)", "username", username)
-		} else if !req.ShellAccess && !isCurrentlyJailed {
-			// Revoke shell access - add to jail
-			if err := jail.SetupUserJail(username); err != nil {
-				s.logger.Warn("failed to setup user jail", "error", err)
-			}
-			s.logger.Info("user jailed (SFTP-only)", "username", username)
-		}
-	}
+	s.setShellAccess(username, req.ShellAccess, req.JailedShell)
 
 	// Enable/disable user and their sites
 	u, err := user.Lookup(username)
@@ -335,6 +577,7 @@ func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 	if !req.Enabled && currentEnabled {
 		// Disabling user - lock account and suspend all their sites
 		_ = exec.Command("usermod", "-L", username).Run()
+		s.revokeUserSessions(username, "", "user disabled")
 
 		// Suspend all user's sites
 		userSites := s.siteManager.List(u.Uid)
@@ -394,7 +637,7 @@ func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
 
 	// Get user info before deletion
 	u, err := user.Lookup(username)
-	if err != nil {
+	if err != nil || !s.canManageUser(claims, username) {
 		s.error(w, http.StatusNotFound, "user not found")
 		return
 	}
@@ -436,6 +679,14 @@ func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
 	// Remove user limits from config
 	_ = s.siteManager.SetUserLimit(&models.UserLimits{Username: username, MaxSites: 0})
 
+	// Customers of a deleted reseller become admin managed
+	for _, customer := range s.resellerCustomers(username) {
+		customer.Owner = ""
+		if err := s.siteManager.SetUserLimit(&customer); err != nil {
+			s.logger.Warn("failed to release reseller customer", "user", customer.Username, "error", err)
+		}
+	}
+
 	// Delete user (with home directory)
 	cmd := exec.Command("userdel", "-rf", username)
 	if output, err := cmd.CombinedOutput(); err != nil {
@@ -448,6 +699,23 @@ func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	s.revokeUserSessions(username, "", "user deleted")
+
+	// Drop custom role assignments so a new account with the same name
+	// doesn't inherit them
+	if s.roles != nil {
+		if err := s.roles.Assign(username, nil); err != nil {
+			s.logger.Warn("failed to remove role assignments", "user", username, "error", err)
+		}
+	}
+
+	// End the user's team and their memberships in other teams
+	if s.teams != nil {
+		if err := s.teams.RemoveUser(username); err != nil {
+			s.logger.Warn("failed to remove team memberships", "user", username, "error", err)
+		}
+	}
+
 	// Clean up user's web directory
 	// Note: User's home directory (/home/username) is deleted by userdel -r above
 	// which includes /home/username/www where all their sites were stored
@@ -537,6 +805,7 @@ func (s *Server) getFastCPUser(username string) (*FastCPUser, error) {
 
 	// Check jail status
 	isJailed := jail.IsUserJailed(username)
+	jailedShell := !isAdmin && jail.IsUserShellJailed(username)
 
 	fastcpUser := &FastCPUser{
 		Username: username,
@@ -546,18 +815,39 @@ func (s *Server) getFastCPUser(username string) (*FastCPUser, error) {
 		IsAdmin:  isAdmin,
 		Enabled:  enabled,
 
+		// Reseller settings
+		IsReseller: !isAdmin && s.isReseller(username),
+		Owner:      userLimits.Owner,
+		MaxUsers:   userLimits.MaxUsers,
+
+		ImpersonationDisabled: userLimits.ImpersonationDisabled,
+
 		// Jail status
 		IsJailed:    isJailed,
-		ShellAccess: !isJailed || isAdmin,
+		ShellAccess: isAdmin || (!isJailed && !jailedShell),
+		JailedShell: jailedShell,
+		Sandbox:     userLimits.Sandbox,
 
 		// Limits
 		SiteLimit:    userLimits.MaxSites,
 		RAMLimitMB:   userLimits.MaxRAMMB,
 		CPUPercent:   userLimits.MaxCPUPercent,
 		MaxProcesses: userLimits.MaxProcesses,
+		BandwidthMB:  userLimits.MaxBandwidthMB,
+		DiskLimitMB:  userLimits.MaxDiskMB,
+		MemoryHighMB: userLimits.MemoryHighMB,
+		SwapLimitMB:  userLimits.MaxSwapMB,
+		IOReadMBps:   userLimits.MaxIOReadMBps,
+		IOWriteMBps:  userLimits.MaxIOWriteMBps,
+		IOReadIOPS:   userLimits.MaxIOReadIOPS,
+		IOWriteIOPS:  userLimits.MaxIOWriteIOPS,
+		NotifyEmail:  userLimits.NotifyEmail,
+		Package:      userLimits.Package,
+		MaxDatabases: userLimits.MaxDatabases,
 
 		// Current usage
-		SiteCount: siteCount,
+		SiteCount:       siteCount,
+		BandwidthUsedMB: s.bandwidthUsedMB(username),
 	}
 
 	// Add usage stats if available
@@ -565,11 +855,79 @@ func (s *Server) getFastCPUser(username string) (*FastCPUser, error) {
 		fastcpUser.DiskUsedMB = usage.DiskUsedMB
 		fastcpUser.RAMUsedMB = usage.RAMUsedMB
 		fastcpUser.ProcessCount = usage.ProcessCount
+		fastcpUser.SwapUsedMB = usage.SwapUsedMB
+		fastcpUser.IOReadBytes = usage.IOReadBytes
+		fastcpUser.IOWriteBytes = usage.IOWriteBytes
+	}
+	if s.roles != nil {
+		fastcpUser.Roles = s.roles.Assigned(username)
 	}
 
 	return fastcpUser, nil
 }
 
+// setShellAccess moves a user between the three SSH access levels: a full
+// shell, a shell in a sandbox (jailedShell) and SFTP only in the chroot
+// jail. Admins are never jailed.
+func (s *Server) setShellAccess(username string, shellAccess, jailedShell bool) {
+	isCurrentlyJailed := jail.IsUserJailed(username)
+	isShellJailed := jail.IsUserShellJailed(username)
+	isAdmin := s.isUserInGroup(username, "sudo") || s.isUserInGroup(username, "wheel")
+
+	if isAdmin {
+		return
+	}
+	switch {
+	case shellAccess:
+		// Grant shell access - remove from both jails
+		if isCurrentlyJailed {
+			jail.RemoveUserFromJail(username)
+			s.logger.Info("user removed from jail (shell access granted)", "username", username)
+		}
+		if isShellJailed {
+			if err := jail.RemoveUserFromShellJail(username); err != nil {
+				s.logger.Warn("failed to remove jailed shell", "error", err)
+			}
+			s.logger.Info("user removed from shell jail (shell access granted)", "username", username)
+		}
+	case jailedShell:
+		// Set up on every change so the PHP versions follow the user's limits
+		if err := jail.SetupShellJail(username, s.shellPHPBinaries(username)); err != nil {
+			s.logger.Warn("failed to setup jailed shell", "error", err)
+			return
+		}
+		if !isShellJailed {
+			s.logger.Info("user given a jailed shell", "username", username)
+		}
+	default:
+		// Revoke shell access - add to jail
+		if isShellJailed {
+			if err := jail.RemoveUserFromShellJail(username); err != nil {
+				s.logger.Warn("failed to remove jailed shell", "error", err)
+			}
+		}
+		if !isCurrentlyJailed {
+			if err := jail.SetupUserJail(username); err != nil {
+				s.logger.Warn("failed to setup user jail", "error", err)
+			}
+			s.logger.Info("user jailed (SFTP-only)", "username", username)
+		}
+	}
+}
+
+// shellPHPBinaries returns the enabled PHP versions a user may use, with
+// their binaries, for the php commands of the jailed shell
+func (s *Server) shellPHPBinaries(username string) map[string]string {
+	allowed := s.siteManager.GetUserLimit(username).PHPVersions
+	binaries := make(map[string]string)
+	for _, pv := range config.Get().PHPVersions {
+		if pv.Enabled && pv.BinaryPath != "" && (len(allowed) == 0 || slices.Contains(allowed, pv.Version)) {
+			binaries[pv.Version] = pv.BinaryPath
+		}
+	}
+	return binaries
+}
+
 // isUserInGroup checks if user is in a group
 func (s *Server) isUserInGroup(username, groupName string) bool {
 	cmd := exec.Command("groups", username)
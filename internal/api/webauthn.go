@@ -0,0 +1,220 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/middleware"
+)
+
+// WebAuthnLoginRequest starts a security key or passkey login
+type WebAuthnLoginRequest struct {
+	Username string `json:"username,omitempty"` // Empty for passkeys chosen on the device
+}
+
+// WebAuthnRegisterRequest completes a credential registration
+type WebAuthnRegisterRequest struct {
+	Name       string                   `json:"name"`
+	Credential auth.AttestationResponse `json:"credential"`
+}
+
+// SetWebAuthn attaches the WebAuthn credential manager
+func (s *Server) SetWebAuthn(manager *auth.WebAuthn) {
+	s.webAuthn = manager
+}
+
+// relyingParty returns the configured relying party, or one for the host
+// the panel is being accessed on
+func (s *Server) relyingParty(r *http.Request) auth.RelyingParty {
+	settings := config.Get().WebAuthn
+	rp := auth.RelyingParty{ID: settings.RPID, Name: settings.RPName, Origins: settings.Origins}
+	if rp.Name == "" {
+		rp.Name = "FastCP"
+	}
+	if rp.ID != "" {
+		return rp
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	rp.ID = host
+	rp.Origins = []string{scheme + "://" + r.Host}
+	return rp
+}
+
+// beginWebAuthnLogin issues the options for a WebAuthn login
+func (s *Server) beginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if s.webAuthn == nil {
+		s.error(w, http.StatusServiceUnavailable, "WebAuthn not enabled")
+		return
+	}
+
+	var req WebAuthnLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	opts, err := s.webAuthn.BeginLogin(req.Username, s.relyingParty(r))
+	if err != nil {
+		s.webAuthnError(w, err)
+		return
+	}
+	s.success(w, opts)
+}
+
+// finishWebAuthnLogin verifies a WebAuthn login. A verified user (PIN or
+// biometrics) is logged in directly, otherwise the two-factor step applies
+// as for a password login.
+func (s *Server) finishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if s.webAuthn == nil {
+		s.error(w, http.StatusServiceUnavailable, "WebAuthn not enabled")
+		return
+	}
+
+	var resp auth.AssertionResponse
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if s.loginBlocked(w, r, "") {
+		return
+	}
+
+	username, verified, err := s.webAuthn.FinishLogin(s.relyingParty(r), &resp)
+	if err != nil {
+		s.logger.Warn("failed WebAuthn login attempt", "error", err)
+		s.loginFailed(r, "")
+		s.error(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	user, err := auth.LookupUser(username)
+	if err != nil {
+		s.logger.Warn("failed WebAuthn login attempt", "username", username, "error", err)
+		s.error(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	if verified {
+		s.completeLogin(w, r, user, nil)
+		return
+	}
+	s.continueLogin(w, r, user)
+}
+
+// listWebAuthnCredentials returns the current user's credentials
+func (s *Server) listWebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+
+	if s.webAuthn == nil {
+		s.error(w, http.StatusServiceUnavailable, "WebAuthn not enabled")
+		return
+	}
+
+	s.success(w, s.webAuthn.Credentials(claims.Username))
+}
+
+// beginWebAuthnRegistration issues the options for a new credential
+func (s *Server) beginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.webAuthnClaims(w, r)
+	if !ok {
+		return
+	}
+
+	opts, err := s.webAuthn.BeginRegistration(claims.Username, s.relyingParty(r))
+	if err != nil {
+		s.webAuthnError(w, err)
+		return
+	}
+	s.success(w, opts)
+}
+
+// finishWebAuthnRegistration verifies and stores a new credential
+func (s *Server) finishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.webAuthnClaims(w, r)
+	if !ok {
+		return
+	}
+
+	var req WebAuthnRegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) > 64 {
+		s.error(w, http.StatusBadRequest, "name must be at most 64 characters")
+		return
+	}
+
+	cred, err := s.webAuthn.FinishRegistration(claims.Username, req.Name, s.relyingParty(r), &req.Credential)
+	if err != nil {
+		s.webAuthnError(w, err)
+		return
+	}
+
+	s.logger.Info("WebAuthn credential registered", "username", claims.Username, "name", cred.Name)
+	s.json(w, http.StatusCreated, cred)
+}
+
+// deleteWebAuthnCredential removes one of the current user's credentials
+func (s *Server) deleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.webAuthnClaims(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := s.webAuthn.DeleteCredential(claims.Username, id); err != nil {
+		s.webAuthnError(w, err)
+		return
+	}
+
+	s.logger.Info("WebAuthn credential removed", "username", claims.Username, "id", id)
+	s.success(w, map[string]string{"message": "credential removed"})
+}
+
+// webAuthnClaims returns the caller's claims for changing their own
+// credentials, which is not possible while impersonating
+func (s *Server) webAuthnClaims(w http.ResponseWriter, r *http.Request) (*auth.Claims, bool) {
+	if s.webAuthn == nil {
+		s.error(w, http.StatusServiceUnavailable, "WebAuthn not enabled")
+		return nil, false
+	}
+	if middleware.IsImpersonating(r) {
+		s.error(w, http.StatusForbidden, "cannot change credentials while impersonating")
+		return nil, false
+	}
+	return middleware.GetClaims(r), true
+}
+
+// webAuthnError maps WebAuthn errors to HTTP responses
+func (s *Server) webAuthnError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrCredentialNotFound):
+		s.error(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, auth.ErrCredentialExists):
+		s.error(w, http.StatusConflict, err.Error())
+	case errors.Is(err, auth.ErrWebAuthnChallenge), errors.Is(err, auth.ErrWebAuthnVerification),
+		errors.Is(err, auth.ErrUnsupportedAlgorithm):
+		s.error(w, http.StatusBadRequest, err.Error())
+	default:
+		s.logger.Error("WebAuthn operation failed", "error", err)
+		s.error(w, http.StatusInternalServerError, "WebAuthn operation failed")
+	}
+}
@@ -3,10 +3,12 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"slices"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 
+	"github.com/rehmatworks/fastcp/internal/caddy"
 	"github.com/rehmatworks/fastcp/internal/models"
 )
 
@@ -48,9 +50,29 @@ func (s *Server) whmcsCreate(w http.ResponseWriter, req *models.WHMCSProvisionRe
 		return
 	}
 
+	// Resolve the WHMCS product to a FastCP package by name or ID. Unknown
+	// packages fall back to the disk and bandwidth limits sent by WHMCS.
+	var pkg *models.Package
+	if req.Package != "" && req.Username != "" {
+		if p, err := s.resolvePackage(req.Package); err == nil {
+			pkg = p
+		} else {
+			s.logger.Info("WHMCS package not found, using request limits", "package", req.Package, "error", err)
+		}
+	}
+
 	phpVersion := req.PHPVersion
 	if phpVersion == "" {
 		phpVersion = "8.4" // Default to latest
+		if pkg != nil && len(pkg.PHPVersions) > 0 && !slices.Contains(pkg.PHPVersions, phpVersion) {
+			phpVersion = pkg.PHPVersions[0]
+		}
+	}
+
+	// Apply the package limits before creating the site so the site limit
+	// and allowed PHP versions are in force
+	if pkg != nil {
+		s.applyPackage(pkg, req.Username)
 	}
 
 	site := &models.Site{
@@ -73,6 +95,18 @@ func (s *Server) whmcsCreate(w http.ResponseWriter, req *models.WHMCSProvisionRe
 		return
 	}
 
+	// Apply the disk and bandwidth limits sent by WHMCS (MB, 0 = unlimited)
+	if req.Username != "" && pkg == nil {
+		userLimits := *s.siteManager.GetUserLimit(req.Username)
+		userLimits.Username = req.Username
+		userLimits.MaxBandwidthMB = max(req.BWLimit, 0)
+		userLimits.MaxDiskMB = max(req.DiskLimit, 0)
+		if err := s.siteManager.SetUserLimit(&userLimits); err != nil {
+			s.logger.Warn("failed to save limits after WHMCS create", "error", err, "username", req.Username)
+		}
+		s.applyDiskQuota(&userLimits)
+	}
+
 	// Reload PHP instances
 	if err := s.phpManager.Reload(); err != nil {
 		s.logger.Warn("failed to reload PHP instances after WHMCS create", "error", err)
@@ -205,15 +239,19 @@ func (s *Server) whmcsStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	username := caddy.ExtractUsernameFromRootPath(site.RootPath)
+
 	s.json(w, http.StatusOK, models.WHMCSResponse{
 		Result: "success",
 		Data: map[string]interface{}{
-			"service_id":  serviceID,
-			"site_id":     site.ID,
-			"domain":      site.Domain,
-			"status":      site.Status,
-			"php_version": site.PHPVersion,
-			"created_at":  site.CreatedAt,
+			"service_id":         serviceID,
+			"site_id":            site.ID,
+			"domain":             site.Domain,
+			"status":             site.Status,
+			"php_version":        site.PHPVersion,
+			"created_at":         site.CreatedAt,
+			"bandwidth_used_mb":  s.bandwidthUsedMB(username),
+			"bandwidth_limit_mb": s.siteManager.GetUserLimit(username).MaxBandwidthMB,
 		},
 	})
 }
@@ -0,0 +1,159 @@
+// Package audit records security relevant actions such as login lockouts
+// in monthly append-only files.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+// Actions
+const (
+	ActionLockout = "auth.lockout" // an IP or username was locked out after failed attempts
+	ActionBan     = "auth.ban"     // an IP was banned after repeated lockouts
+	ActionUnblock = "auth.unblock" // an admin lifted a lockout or ban
+
+	ActionImpersonateStart  = "impersonate.start"  // an admin or reseller started acting as a user
+	ActionImpersonateStop   = "impersonate.stop"   // an impersonation was ended
+	ActionImpersonateAction = "impersonate.action" // a change made while impersonating
+
+	ActionSFTPUpload   = "sftp.upload"   // a file was written over SFTP
+	ActionSFTPDownload = "sftp.download" // a file was read over SFTP
+
+	ActionTerminalStart = "terminal.start" // a web terminal was opened
+	ActionTerminalEnd   = "terminal.end"   // a web terminal was closed
+)
+
+// RetentionMonths is how many monthly audit files are kept on disk
+var RetentionMonths = 12
+
+// Filter selects entries for List
+type Filter struct {
+	Action string
+	Since  time.Time
+	Limit  int
+}
+
+// Log stores audit entries under dataDir/audit
+type Log struct {
+	mu  sync.Mutex
+	dir string
+	now func() time.Time
+}
+
+// NewLog creates an audit log storing entries under dataDir/audit
+func NewLog(dataDir string) *Log {
+	return &Log{
+		dir: filepath.Join(dataDir, "audit"),
+		now: time.Now,
+	}
+}
+
+// Record appends an entry, filling in its ID and time
+func (l *Log) Record(entry models.AuditLog) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.ID = uuid.New().String()
+	entry.CreatedAt = l.now().UTC()
+
+	if err := os.MkdirAll(l.dir, 0700); err != nil {
+		return err
+	}
+
+	path := l.monthPath(entry.CreatedAt)
+	_, statErr := os.Stat(path)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(entry); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if os.IsNotExist(statErr) {
+		l.pruneUnlocked(entry.CreatedAt)
+	}
+	return nil
+}
+
+// List returns the entries matching f, newest first
+func (l *Log) List(f Filter) ([]models.AuditLog, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now().UTC()
+	if f.Since.IsZero() {
+		f.Since = now.AddDate(0, 0, -30)
+	}
+	f.Since = f.Since.UTC()
+
+	entries := []models.AuditLog{}
+	month := time.Date(f.Since.Year(), f.Since.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for !month.After(now) {
+		file, err := os.Open(l.monthPath(month))
+		month = month.AddDate(0, 1, 0)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var e models.AuditLog
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				continue
+			}
+			if e.CreatedAt.Before(f.Since) || (f.Action != "" && e.Action != f.Action) {
+				continue
+			}
+			entries = append(entries, e)
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[:f.Limit]
+	}
+	return entries, nil
+}
+
+// pruneUnlocked removes monthly files older than RetentionMonths (caller
+// must hold lock)
+func (l *Log) pruneUnlocked(now time.Time) {
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	oldest := filepath.Base(l.monthPath(month.AddDate(0, -(RetentionMonths - 1), 0)))
+	matches, _ := filepath.Glob(filepath.Join(l.dir, "*.jsonl"))
+	for _, path := range matches {
+		// File names sort chronologically
+		if filepath.Base(path) < oldest {
+			os.Remove(path)
+		}
+	}
+}
+
+func (l *Log) monthPath(t time.Time) string {
+	return filepath.Join(l.dir, t.UTC().Format("2006-01")+".jsonl")
+}
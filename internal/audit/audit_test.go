@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+func TestRecordAndList(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLog(dir)
+	now := time.Date(2026, 3, 31, 23, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	if err := l.Record(models.AuditLog{Action: ActionLockout, Resource: "ip", ResourceID: "203.0.113.9"}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	now = now.Add(2 * time.Hour) // next month
+	if err := l.Record(models.AuditLog{Action: ActionUnblock, Resource: "ip", ResourceID: "203.0.113.9", UserID: "0", Username: "root"}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	entries, err := l.List(Filter{})
+	if err != nil || len(entries) != 2 || entries[0].Action != ActionUnblock || entries[0].ID == "" {
+		t.Fatalf("expected both entries newest first, got %+v %v", entries, err)
+	}
+	if entries, _ := l.List(Filter{Action: ActionLockout}); len(entries) != 1 || entries[0].ResourceID != "203.0.113.9" {
+		t.Fatalf("unexpected filtered entries: %+v", entries)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "audit", "2026-04.jsonl"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected a private monthly file, got %v", err)
+	}
+}
+
+func TestRecording(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLog(dir)
+
+	// Recordings past the retention period are removed
+	old := filepath.Join(dir, "audit", "recordings", "00000000-0000-0000-0000-000000000000.cast")
+	os.MkdirAll(filepath.Dir(old), 0700)
+	os.WriteFile(old, nil, 0600)
+	stale := time.Now().AddDate(0, 0, -RecordingRetentionDays-1)
+	os.Chtimes(old, stale, stale)
+
+	rec, err := l.StartRecording(80, 24, "alice in /home/alice")
+	if err != nil {
+		t.Fatalf("StartRecording failed: %v", err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expected the old recording to be removed, got %v", err)
+	}
+	// "é" split across two reads stays one character
+	rec.Write([]byte("caf\xc3"))
+	rec.Write([]byte("\xa9\r\n"))
+	rec.Resize(100, 40)
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := l.OpenRecording(rec.ID)
+	if err != nil {
+		t.Fatalf("OpenRecording failed: %v", err)
+	}
+	defer f.Close()
+	if fi, _ := f.Stat(); fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the recording to be private, got %v", fi.Mode())
+	}
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 4 {
+		t.Fatalf("expected a header and 3 events, got %q", lines)
+	}
+	var header struct {
+		Version int    `json:"version"`
+		Width   int    `json:"width"`
+		Title   string `json:"title"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil || header.Version != 2 || header.Width != 80 {
+		t.Errorf("unexpected header %s", lines[0])
+	}
+	for i, want := range [][2]string{{"o", "caf"}, {"o", "é\r\n"}, {"r", "100x40"}} {
+		var event []interface{}
+		if err := json.Unmarshal([]byte(lines[i+1]), &event); err != nil || len(event) != 3 || event[1] != want[0] || event[2] != want[1] {
+			t.Errorf("event %d = %s, want %q", i, lines[i+1], want)
+		}
+	}
+
+	for _, id := range []string{"../audit/2026-01", "00000000-0000-0000-0000-000000000000"} {
+		if _, err := l.OpenRecording(id); !errors.Is(err, ErrRecordingNotFound) {
+			t.Errorf("OpenRecording(%q) = %v, want ErrRecordingNotFound", id, err)
+		}
+	}
+}
+
+func TestRecordingSizeLimit(t *testing.T) {
+	l := NewLog(t.TempDir())
+	MaxRecordingSize = 200
+	defer func() { MaxRecordingSize = 64 << 20 }()
+
+	rec, err := l.StartRecording(80, 24, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Write([]byte("first"))
+	rec.Write(bytes.Repeat([]byte("x"), 200))
+	rec.Close()
+	if !rec.Truncated() {
+		t.Error("expected the recording to be truncated")
+	}
+	f, _ := l.OpenRecording(rec.ID)
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	if len(data) > 200 || !strings.Contains(string(data), "first") {
+		t.Errorf("unexpected recording %q", data)
+	}
+}
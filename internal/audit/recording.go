@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// RecordingRetentionDays is how long terminal recordings are kept on disk
+var RecordingRetentionDays = 90
+
+// MaxRecordingSize caps a single recording; output past it is not recorded
+var MaxRecordingSize int64 = 64 << 20
+
+// ErrRecordingNotFound is returned for unknown recording IDs
+var ErrRecordingNotFound = errors.New("recording not found")
+
+var recordingID = regexp.MustCompile(`^[0-9a-f-]{36}$`)
+
+// Recording stores the output of a terminal session in asciicast v2 format
+// under dataDir/audit/recordings, readable by root only. Only output is
+// stored: programs do not echo passwords, while typed input cannot be told
+// apart from one.
+type Recording struct {
+	ID string
+
+	mu        sync.Mutex
+	f         *os.File
+	started   time.Time
+	size      int64
+	pending   []byte // Start of a UTF-8 sequence split across writes
+	truncated bool
+}
+
+// StartRecording creates a recording of a terminal of the given size,
+// removing recordings older than RecordingRetentionDays
+func (l *Log) StartRecording(cols, rows int, title string) (*Recording, error) {
+	dir := filepath.Join(l.dir, "recordings")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	l.pruneRecordings(dir)
+
+	id := uuid.New().String()
+	f, err := os.OpenFile(filepath.Join(dir, id+".cast"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recording{ID: id, f: f, started: l.now()}
+	header, _ := json.Marshal(map[string]interface{}{
+		"version":   2,
+		"width":     cols,
+		"height":    rows,
+		"timestamp": r.started.Unix(),
+		"title":     title,
+	})
+	if err := r.writeLine(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// OpenRecording opens a recording for reading
+func (l *Log) OpenRecording(id string) (*os.File, error) {
+	if !recordingID.MatchString(id) {
+		return nil, ErrRecordingNotFound
+	}
+	f, err := os.Open(filepath.Join(l.dir, "recordings", id+".cast"))
+	if os.IsNotExist(err) {
+		return nil, ErrRecordingNotFound
+	}
+	return f, err
+}
+
+// Write records terminal output
+func (r *Recording) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := append(r.pending, p...)
+	// Hold back an incomplete UTF-8 sequence until the rest arrives
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i > len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		if err := r.event("o", string(data[:cut])); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Resize records a change of the terminal size
+func (r *Recording) Resize(cols, rows int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Truncated reports whether output was dropped for exceeding MaxRecordingSize
+func (r *Recording) Truncated() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.truncated
+}
+
+// Close flushes held back output and closes the file
+func (r *Recording) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.pending) > 0 {
+		r.event("o", string(r.pending))
+		r.pending = nil
+	}
+	return r.f.Close()
+}
+
+// event appends an event (caller must hold lock)
+func (r *Recording) event(kind, data string) error {
+	line, err := json.Marshal([]interface{}{time.Since(r.started).Seconds(), kind, data})
+	if err != nil {
+		return err
+	}
+	if r.size+int64(len(line))+1 > MaxRecordingSize {
+		r.truncated = true
+		return nil
+	}
+	return r.writeLine(line)
+}
+
+func (r *Recording) writeLine(line []byte) error {
+	n, err := r.f.Write(append(line, '\n'))
+	r.size += int64(n)
+	return err
+}
+
+// pruneRecordings removes recordings older than RecordingRetentionDays
+func (l *Log) pruneRecordings(dir string) {
+	cutoff := l.now().AddDate(0, 0, -RecordingRetentionDays)
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.cast"))
+	for _, path := range matches {
+		if fi, err := os.Stat(path); err == nil && fi.ModTime().Before(cutoff) {
+			os.Remove(path)
+		}
+	}
+}
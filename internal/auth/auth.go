@@ -4,9 +4,11 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"os"
 	"os/exec"
 	"os/user"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -39,11 +41,15 @@ func SetAPIKeyValidator(validator APIKeyValidator) {
 
 // AllowedGroups defines which Unix groups can access FastCP
 // Users in these groups can log in to the control panel
-var AllowedGroups = []string{"root", "sudo", "wheel", "admin", "fastcp"}
+var AllowedGroups = []string{"root", "sudo", "wheel", "admin", "fastcp", "fastcp-reseller"}
 
 // AdminGroups defines which groups get admin role
 var AdminGroups = []string{"root", "sudo", "wheel"}
 
+// ResellerGroups defines which groups get the reseller role. Resellers
+// manage their own customer accounts within their allocation.
+var ResellerGroups = []string{"fastcp-reseller"}
+
 // -------------------- Test hooks / overridable system calls --------------------
 // These package-level variables allow tests to replace system interactions (user lookup,
 // group membership checks and password verification) without touching the OS.
@@ -88,9 +94,56 @@ type Claims struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	// Purpose is set on short-lived challenge tokens, which are not
+	// accepted as session tokens
+	Purpose string `json:"purpose,omitempty"`
+	// SessionID ties an access token to a server-side session that can be
+	// revoked
+	SessionID string `json:"sid,omitempty"`
+	// Impersonator is set on impersonation tokens to the admin or reseller
+	// acting as the user. SessionID is then the impersonator's session.
+	Impersonator *Impersonator `json:"imp,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Impersonator identifies who is acting as another user
+type Impersonator struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// RealClaims returns the claims of the impersonator on an impersonation
+// token, or the claims themselves otherwise
+func (c *Claims) RealClaims() *Claims {
+	if c.Impersonator == nil {
+		return c
+	}
+	return &Claims{
+		UserID:    c.Impersonator.UserID,
+		Username:  c.Impersonator.Username,
+		Role:      c.Impersonator.Role,
+		SessionID: c.SessionID,
+	}
+}
+
+// AccessTokenTTL is the lifetime of session access tokens. Clients renew
+// them with the session's refresh token.
+const AccessTokenTTL = 15 * time.Minute
+
+// ImpersonationTTL is the lifetime of impersonation tokens. They are not
+// renewed; the admin starts a new impersonation instead.
+const ImpersonationTTL = 30 * time.Minute
+
+// Challenge token purposes
+const (
+	PurposeTwoFactor      = "2fa"       // Password checked, authentication code pending
+	PurposeTwoFactorSetup = "2fa-setup" // Password checked, two-factor enrollment required
+)
+
+// ChallengeTTL is how long a login challenge can be completed
+const ChallengeTTL = 5 * time.Minute
+
 // Authenticate authenticates a user with username and password
 // Uses Unix/PAM authentication on Linux systems
 func Authenticate(username, password string) (*models.User, error) {
@@ -114,8 +167,15 @@ func Authenticate(username, password string) (*models.User, error) {
 		}
 	}
 
-	// Use Unix authentication (Linux only)
+	// Use Unix authentication (Linux only), after the directory if one is
+	// configured
 	if runtime.GOOS == "linux" {
+		if directoryAuthenticator != nil {
+			user, err := authenticateDirectory(username, password)
+			if !errors.Is(err, ErrDirectoryUserNotFound) && !errors.Is(err, ErrDirectoryUnavailable) {
+				return user, err
+			}
+		}
 		return authenticateUnix(username, password)
 	}
 
@@ -125,6 +185,29 @@ func Authenticate(username, password string) (*models.User, error) {
 
 // authenticateUnix authenticates against Unix/PAM
 func authenticateUnix(username, password string) (*models.User, error) {
+	user, err := lookupUnixUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	// Authenticate password using PAM/direct shadow verification
+	if !passwordVerifier(username, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// LookupUser returns the panel user for a system account without checking
+// a password, for logins that prove identity otherwise (WebAuthn). The
+// same group rules as password logins apply.
+func LookupUser(username string) (*models.User, error) {
+	return lookupUnixUser(username)
+}
+
+// lookupUnixUser checks that a system user may use FastCP and determines
+// their role
+func lookupUnixUser(username string) (*models.User, error) {
 	// Verify user exists in the system
 	u, err := userLookupFunc(username)
 	if err != nil {
@@ -136,11 +219,6 @@ func authenticateUnix(username, password string) (*models.User, error) {
 		return nil, ErrUserNotAllowed
 	}
 
-	// Authenticate password using PAM/direct shadow verification
-	if !passwordVerifier(username, password) {
-		return nil, ErrInvalidCredentials
-	}
-
 	// Determine role based on groups
 	role := "user"
 	for _, adminGroup := range AdminGroups {
@@ -149,6 +227,14 @@ func authenticateUnix(username, password string) (*models.User, error) {
 			break
 		}
 	}
+	if role == "user" {
+		for _, resellerGroup := range ResellerGroups {
+			if groupChecker(username, resellerGroup) {
+				role = "reseller"
+				break
+			}
+		}
+	}
 
 	return &models.User{
 		ID:        u.Uid,
@@ -160,6 +246,52 @@ func authenticateUnix(username, password string) (*models.User, error) {
 	}, nil
 }
 
+// loginDefs holds UID_MIN, the first uid of regular accounts
+var loginDefs = "/etc/login.defs"
+
+// ProtectedAccount reports whether an account is root, a system account
+// (uid below UID_MIN) or a member of an admin group. Identities from a
+// directory or an identity provider must not reach such accounts by name,
+// only through an explicit mapping, or anyone able to name themselves
+// "root" there would become a panel admin.
+func ProtectedAccount(username string) bool {
+	if username == "root" {
+		return true
+	}
+	u, err := userLookupFunc(username)
+	if err != nil {
+		// Accounts that don't exist yet are created as regular users
+		return false
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil || uid < uidMin() {
+		return true
+	}
+	for _, adminGroup := range AdminGroups {
+		if groupChecker(username, adminGroup) {
+			return true
+		}
+	}
+	return false
+}
+
+// uidMin returns UID_MIN from login.defs, 1000 if it is not set
+func uidMin() int {
+	data, err := os.ReadFile(loginDefs)
+	if err != nil {
+		return 1000
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "UID_MIN" {
+			if n, err := strconv.Atoi(fields[1]); err == nil {
+				return n
+			}
+		}
+	}
+	return 1000
+}
+
 // isUserInAllowedGroup checks if user belongs to any allowed group
 func isUserInAllowedGroup(username string) bool {
 	for _, group := range AllowedGroups {
@@ -220,8 +352,114 @@ func GenerateToken(user *models.User) (string, error) {
 	return token.SignedString([]byte(cfg.JWTSecret))
 }
 
+// GenerateSessionToken generates a short-lived access token for a session
+func GenerateSessionToken(user *models.User, sessionID string) (string, error) {
+	cfg := config.Get()
+
+	claims := Claims{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "fastcp",
+			Subject:   user.ID,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(cfg.JWTSecret))
+}
+
+// GenerateImpersonationToken generates a short-lived token acting as user
+// on behalf of the impersonator. It stays tied to the impersonator's
+// session and carries a unique ID so it can be ended early.
+func GenerateImpersonationToken(user *models.User, impersonator *Claims) (string, *Claims, error) {
+	cfg := config.Get()
+
+	now := time.Now()
+	claims := &Claims{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		SessionID: impersonator.SessionID,
+		Impersonator: &Impersonator{
+			UserID:   impersonator.UserID,
+			Username: impersonator.Username,
+			Role:     impersonator.Role,
+		},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ImpersonationTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "fastcp",
+			Subject:   user.ID,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString([]byte(cfg.JWTSecret))
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+// GenerateChallengeToken generates a short-lived token proving the password
+// step of a login, to be exchanged for a session token. Its unique ID lets
+// the server accept it only once.
+func GenerateChallengeToken(user *models.User, purpose string) (string, error) {
+	cfg := config.Get()
+
+	claims := Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Purpose:  purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "fastcp",
+			Subject:   user.ID,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(cfg.JWTSecret))
+}
+
+// ValidateChallengeToken validates a challenge token of the given purpose
+func ValidateChallengeToken(tokenString, purpose string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
 // ValidateToken validates a JWT token and returns the claims
 func ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// parseToken verifies a token's signature and expiry
+func parseToken(tokenString string) (*Claims, error) {
 	cfg := config.Get()
 
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
@@ -3,6 +3,7 @@ package auth
 import (
 	"os"
 	"os/user"
+	"path/filepath"
 	"testing"
 
 	"github.com/rehmatworks/fastcp/internal/config"
@@ -88,3 +89,57 @@ func TestDevAdminAuthenticateHook(t *testing.T) {
 	}
 	_ = u
 }
+
+func TestAuthenticateUnix_ResellerRole(t *testing.T) {
+	SetPasswordVerifier(func(u, p string) bool { return true })
+	SetGroupChecker(func(u, g string) bool { return g == "fastcp-reseller" })
+	SetUserLookup(func(username string) (*user.User, error) {
+		return &user.User{Uid: "1003", Username: username}, nil
+	})
+	defer func() { SetPasswordVerifier(nil); SetGroupChecker(nil); SetUserLookup(nil) }()
+
+	u, err := authenticateUnix("dana", "pw")
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if u.Role != "reseller" {
+		t.Fatalf("expected reseller role, got %s", u.Role)
+	}
+}
+
+func TestProtectedAccount(t *testing.T) {
+	uids := map[string]string{"root": "0", "www-data": "33", "deploy": "1200", "alice": "1001"}
+	SetUserLookup(func(username string) (*user.User, error) {
+		uid, ok := uids[username]
+		if !ok {
+			return nil, user.UnknownUserError(username)
+		}
+		return &user.User{Uid: uid, Username: username}, nil
+	})
+	SetGroupChecker(func(u, g string) bool { return u == "deploy" && g == "sudo" })
+	defs := filepath.Join(t.TempDir(), "login.defs")
+	if err := os.WriteFile(defs, []byte("# comment\nUID_MIN\t\t\t 1000\nUID_MAX 60000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loginDefs = defs
+	defer func() { SetUserLookup(nil); SetGroupChecker(nil); loginDefs = "/etc/login.defs" }()
+
+	for username, want := range map[string]bool{
+		"root":     true,  // Even if lookups fail
+		"www-data": true,  // System account
+		"deploy":   true,  // Admin by group
+		"alice":    false, // Customer
+		"newuser":  false, // Created as a regular user on provisioning
+	} {
+		if got := ProtectedAccount(username); got != want {
+			t.Errorf("ProtectedAccount(%q) = %v, want %v", username, got, want)
+		}
+	}
+
+	if err := os.WriteFile(defs, []byte("UID_MIN 1500\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !ProtectedAccount("alice") {
+		t.Error("expected uids below a raised UID_MIN to be protected")
+	}
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+var (
+	// ErrDirectoryUserNotFound means the directory does not know the user,
+	// so the local accounts are tried
+	ErrDirectoryUserNotFound = errors.New("user not found in directory")
+	// ErrDirectoryUnavailable means the directory could not be reached,
+	// so the local accounts are tried
+	ErrDirectoryUnavailable = errors.New("directory unavailable")
+)
+
+// DirectoryUser is a user whose password a directory service verified
+type DirectoryUser struct {
+	Username  string
+	Email     string
+	Role      string // Empty when the Unix groups decide
+	Provision bool   // Create the Unix account if it does not exist
+	Trusted   bool   // May log in as root, a system account or a local admin
+}
+
+// DirectoryAuthenticator verifies a password against a directory service
+// such as LDAP
+type DirectoryAuthenticator func(username, password string) (*DirectoryUser, error)
+
+var (
+	directoryAuthenticator DirectoryAuthenticator
+	userProvisioner        func(username string) error
+	accountLocked          = isAccountLocked
+)
+
+// SetDirectoryAuthenticator sets the directory tried before local
+// accounts. Passing nil disables it.
+func SetDirectoryAuthenticator(f DirectoryAuthenticator) {
+	directoryAuthenticator = f
+}
+
+// SetUserProvisioner sets the function that creates the Unix account of a
+// directory user on first login
+func SetUserProvisioner(f func(username string) error) {
+	userProvisioner = f
+}
+
+// authenticateDirectory verifies a password with the directory and maps the
+// user to their Unix account. The directory is authoritative for its users:
+// only users it does not know, or an outage, fall back to local accounts.
+// Protected accounts (see ProtectedAccount) keep their local password
+// unless the directory user is trusted with them, so a directory entry
+// named root or after a local admin does not log in as that admin.
+func authenticateDirectory(username, password string) (*models.User, error) {
+	du, err := directoryAuthenticator(username, password)
+	if err != nil {
+		if ProtectedAccount(username) {
+			return nil, fmt.Errorf("%w: %v", ErrDirectoryUserNotFound, err)
+		}
+		return nil, err
+	}
+	if !du.Trusted && ProtectedAccount(du.Username) {
+		return nil, fmt.Errorf("%w: %s is a protected local account", ErrDirectoryUserNotFound, du.Username)
+	}
+
+	user, err := lookupUnixUser(du.Username)
+	if errors.Is(err, ErrInvalidCredentials) && du.Provision && userProvisioner != nil {
+		if perr := userProvisioner(du.Username); perr != nil {
+			return nil, perr
+		}
+		user, err = lookupUnixUser(du.Username)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The directory does not know about accounts disabled in the panel
+	if accountLocked(du.Username) {
+		return nil, ErrUserNotAllowed
+	}
+
+	if du.Role != "" {
+		user.Role = du.Role
+	}
+	if du.Email != "" {
+		user.Email = du.Email
+	}
+	return user, nil
+}
+
+// isAccountLocked reports whether the password of a Unix account is locked,
+// which is how the panel disables users
+func isAccountLocked(username string) bool {
+	out, err := exec.Command("passwd", "-S", username).Output()
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(out))
+	return len(fields) >= 2 && fields[1] == "L"
+}
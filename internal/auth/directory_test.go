@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"errors"
+	"os/user"
+	"testing"
+)
+
+func TestAuthenticateDirectory_ProvisionsAndMapsRole(t *testing.T) {
+	existing := map[string]bool{}
+	SetGroupChecker(func(u, g string) bool { return g == "fastcp" })
+	SetUserLookup(func(username string) (*user.User, error) {
+		if !existing[username] {
+			return nil, user.UnknownUserError(username)
+		}
+		return &user.User{Uid: "1005", Username: username}, nil
+	})
+	SetDirectoryAuthenticator(func(username, password string) (*DirectoryUser, error) {
+		if password != "secret" {
+			return nil, ErrInvalidCredentials
+		}
+		return &DirectoryUser{Username: username, Email: "carol@example.com", Role: "reseller", Provision: true}, nil
+	})
+	provisioned := ""
+	SetUserProvisioner(func(username string) error {
+		provisioned = username
+		existing[username] = true
+		return nil
+	})
+	accountLocked = func(string) bool { return false }
+	defer func() {
+		SetGroupChecker(nil)
+		SetUserLookup(nil)
+		SetDirectoryAuthenticator(nil)
+		SetUserProvisioner(nil)
+		accountLocked = isAccountLocked
+	}()
+
+	u, err := authenticateDirectory("carol", "secret")
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if provisioned != "carol" {
+		t.Fatalf("expected carol to be provisioned, got %q", provisioned)
+	}
+	if u.Role != "reseller" || u.Email != "carol@example.com" {
+		t.Fatalf("directory role and email not applied: %+v", u)
+	}
+
+	if _, err := authenticateDirectory("carol", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	accountLocked = func(string) bool { return true }
+	if _, err := authenticateDirectory("carol", "secret"); !errors.Is(err, ErrUserNotAllowed) {
+		t.Fatalf("expected locked account to be refused, got %v", err)
+	}
+}
+
+func TestAuthenticateDirectory_NoProvisioning(t *testing.T) {
+	SetUserLookup(func(username string) (*user.User, error) {
+		return nil, user.UnknownUserError(username)
+	})
+	SetDirectoryAuthenticator(func(username, password string) (*DirectoryUser, error) {
+		return &DirectoryUser{Username: username}, nil
+	})
+	SetUserProvisioner(func(string) error {
+		t.Fatal("provisioner called without auto-provisioning")
+		return nil
+	})
+	defer func() { SetUserLookup(nil); SetDirectoryAuthenticator(nil); SetUserProvisioner(nil) }()
+
+	if _, err := authenticateDirectory("dave", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func TestAuthenticateDirectory_ProtectedAccounts(t *testing.T) {
+	SetGroupChecker(func(u, g string) bool { return u == g || u == "deploy" && g == "sudo" || u == "alice" && g == "fastcp" })
+	SetUserLookup(func(username string) (*user.User, error) {
+		uids := map[string]string{"root": "0", "deploy": "1000", "alice": "1001"}
+		return &user.User{Uid: uids[username], Username: username}, nil
+	})
+	trusted := map[string]bool{}
+	SetDirectoryAuthenticator(func(username, password string) (*DirectoryUser, error) {
+		if password != "directory-pw" {
+			return nil, ErrInvalidCredentials
+		}
+		return &DirectoryUser{Username: username, Trusted: trusted[username]}, nil
+	})
+	SetPasswordVerifier(func(username, password string) bool { return password == "local-pw" })
+	accountLocked = func(string) bool { return false }
+	defer func() {
+		SetGroupChecker(nil)
+		SetUserLookup(nil)
+		SetDirectoryAuthenticator(nil)
+		SetPasswordVerifier(nil)
+		accountLocked = isAccountLocked
+	}()
+
+	// A directory entry named after root or a local admin is not that
+	// account; the local password is needed
+	for _, username := range []string{"root", "deploy"} {
+		if _, err := authenticateDirectory(username, "directory-pw"); !errors.Is(err, ErrDirectoryUserNotFound) {
+			t.Errorf("expected the directory to be refused %s, got %v", username, err)
+		}
+		if _, err := Authenticate(username, "directory-pw"); err == nil {
+			t.Errorf("expected the directory password not to log in as %s", username)
+		}
+		if u, err := Authenticate(username, "local-pw"); err != nil || u.Role != "admin" {
+			t.Errorf("expected the local password to log in as %s, got %v", username, err)
+		}
+	}
+
+	if u, err := authenticateDirectory("alice", "directory-pw"); err != nil || u.Role != "user" {
+		t.Errorf("expected a regular account to log in through the directory, got %v", err)
+	}
+
+	trusted["deploy"] = true
+	if u, err := authenticateDirectory("deploy", "directory-pw"); err != nil || u.Role != "admin" {
+		t.Errorf("expected a trusted account to log in through the directory, got %v", err)
+	}
+}
@@ -0,0 +1,638 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrWebAuthnChallenge    = errors.New("invalid or expired WebAuthn challenge")
+	ErrWebAuthnVerification = errors.New("WebAuthn verification failed")
+	ErrCredentialNotFound   = errors.New("credential not found")
+	ErrCredentialExists     = errors.New("credential already registered")
+	ErrUnsupportedAlgorithm = errors.New("unsupported credential algorithm")
+)
+
+// COSE algorithm identifiers supported for credentials
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgRS256 = -257
+)
+
+// WebAuthnTimeout is how long a registration or login ceremony can take
+const WebAuthnTimeout = 2 * time.Minute
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+var b64url = base64.RawURLEncoding
+
+// RelyingParty identifies the panel to authenticators. Credentials are
+// bound to ID (the panel's domain) and only accepted from Origins.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// WebAuthnCredential is a registered security key or passkey
+type WebAuthnCredential struct {
+	ID         string     `json:"id"` // base64url credential ID
+	Name       string     `json:"name"`
+	PublicKey  string     `json:"public_key"` // base64 PKIX DER
+	Algorithm  int        `json:"algorithm"`  // COSE algorithm
+	SignCount  uint32     `json:"sign_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// CredentialDescriptor references a credential in ceremony options
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// CreationOptions are passed to navigator.credentials.create(). Binary
+// values are base64url encoded.
+type CreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+}
+
+// RequestOptions are passed to navigator.credentials.get()
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int                    `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse is the browser's answer to a registration
+type AttestationResponse struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+// AssertionResponse is the browser's answer to a login
+type AssertionResponse struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+// webAuthnUser is the stored WebAuthn state of a user
+type webAuthnUser struct {
+	Handle      string                `json:"handle"` // base64url user.id given to authenticators
+	Credentials []*WebAuthnCredential `json:"credentials"`
+}
+
+// pendingCeremony is an issued challenge awaiting its response
+type pendingCeremony struct {
+	username     string // empty for usernameless logins
+	registration bool
+	expires      time.Time
+}
+
+// WebAuthn stores credentials per user and verifies registrations and
+// logins. Attestation statements are not checked: like the "none"
+// conveyance the panel requests, any authenticator is accepted.
+type WebAuthn struct {
+	mu         sync.Mutex
+	path       string
+	users      map[string]*webAuthnUser
+	challenges map[string]pendingCeremony
+	now        func() time.Time
+}
+
+// NewWebAuthn creates a WebAuthn manager storing credentials in dataDir
+func NewWebAuthn(dataDir string) *WebAuthn {
+	return &WebAuthn{
+		path:       filepath.Join(dataDir, "webauthn.json"),
+		users:      make(map[string]*webAuthnUser),
+		challenges: make(map[string]pendingCeremony),
+		now:        time.Now,
+	}
+}
+
+// Load reads the stored credentials
+func (w *WebAuthn) Load() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	users := make(map[string]*webAuthnUser)
+	if err := json.Unmarshal(data, &users); err != nil {
+		return err
+	}
+	w.users = users
+	return nil
+}
+
+// saveUnlocked writes the credentials (caller must hold lock)
+func (w *WebAuthn) saveUnlocked() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(w.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(w.path, data, 0600)
+}
+
+// Credentials returns a user's registered credentials
+func (w *WebAuthn) Credentials(username string) []WebAuthnCredential {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	list := []WebAuthnCredential{}
+	if u, ok := w.users[username]; ok {
+		for _, c := range u.Credentials {
+			list = append(list, *c)
+		}
+	}
+	return list
+}
+
+// DeleteCredential removes one of a user's credentials
+func (w *WebAuthn) DeleteCredential(username, id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	u, ok := w.users[username]
+	if !ok {
+		return ErrCredentialNotFound
+	}
+	i := slices.IndexFunc(u.Credentials, func(c *WebAuthnCredential) bool { return c.ID == id })
+	if i < 0 {
+		return ErrCredentialNotFound
+	}
+	previous := u.Credentials
+	u.Credentials = slices.Delete(slices.Clone(u.Credentials), i, i+1)
+	if err := w.saveUnlocked(); err != nil {
+		u.Credentials = previous
+		return err
+	}
+	return nil
+}
+
+// BeginRegistration issues the options for registering a new credential
+func (w *WebAuthn) BeginRegistration(username string, rp RelyingParty) (*CreationOptions, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	u, ok := w.users[username]
+	if !ok {
+		handle := make([]byte, 32)
+		if _, err := rand.Read(handle); err != nil {
+			return nil, err
+		}
+		u = &webAuthnUser{Handle: b64url.EncodeToString(handle)}
+		w.users[username] = u
+	}
+
+	challenge, err := w.newChallengeUnlocked(pendingCeremony{username: username, registration: true})
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &CreationOptions{
+		Challenge:          challenge,
+		Timeout:            int(WebAuthnTimeout.Milliseconds()),
+		Attestation:        "none",
+		ExcludeCredentials: descriptors(u.Credentials),
+	}
+	opts.RP.ID = rp.ID
+	opts.RP.Name = rp.Name
+	opts.User.ID = u.Handle
+	opts.User.Name = username
+	opts.User.DisplayName = username
+	for _, alg := range []int{COSEAlgES256, COSEAlgEdDSA, COSEAlgRS256} {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{"public-key", alg})
+	}
+	opts.AuthenticatorSelection.ResidentKey = "preferred"
+	opts.AuthenticatorSelection.UserVerification = "preferred"
+	return opts, nil
+}
+
+// FinishRegistration verifies a registration response and stores the new
+// credential
+func (w *WebAuthn) FinishRegistration(username, name string, rp RelyingParty, resp *AttestationResponse) (*WebAuthnCredential, error) {
+	clientData, err := b64url.DecodeString(resp.ClientDataJSON)
+	if err != nil {
+		return nil, ErrWebAuthnVerification
+	}
+	challenge, err := verifyClientData(clientData, "webauthn.create", rp)
+	if err != nil {
+		return nil, err
+	}
+
+	rawObject, err := b64url.DecodeString(resp.AttestationObject)
+	if err != nil {
+		return nil, ErrWebAuthnVerification
+	}
+	decoded, _, err := cborDecode(rawObject)
+	if err != nil {
+		return nil, ErrWebAuthnVerification
+	}
+	object, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrWebAuthnVerification
+	}
+	authData, ok := object["authData"].([]byte)
+	if !ok {
+		return nil, ErrWebAuthnVerification
+	}
+
+	parsed, err := parseAuthenticatorData(authData, rp)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.flags&flagAttestedData == 0 || parsed.credentialID == nil {
+		return nil, ErrWebAuthnVerification
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.consumeChallengeUnlocked(challenge, username, true); err != nil {
+		return nil, err
+	}
+
+	id := b64url.EncodeToString(parsed.credentialID)
+	for _, u := range w.users {
+		for _, c := range u.Credentials {
+			if c.ID == id {
+				return nil, ErrCredentialExists
+			}
+		}
+	}
+
+	if name == "" {
+		name = "Security key"
+	}
+	cred := &WebAuthnCredential{
+		ID:        id,
+		Name:      name,
+		PublicKey: base64.StdEncoding.EncodeToString(parsed.publicKey),
+		Algorithm: parsed.algorithm,
+		SignCount: parsed.signCount,
+		CreatedAt: w.now(),
+	}
+	u := w.users[username]
+	u.Credentials = append(u.Credentials, cred)
+	if err := w.saveUnlocked(); err != nil {
+		u.Credentials = u.Credentials[:len(u.Credentials)-1]
+		return nil, err
+	}
+	result := *cred
+	return &result, nil
+}
+
+// BeginLogin issues the options for a login. Without a username any
+// discoverable credential (passkey) on the authenticator can be used.
+func (w *WebAuthn) BeginLogin(username string, rp RelyingParty) (*RequestOptions, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	opts := &RequestOptions{
+		RPID:             rp.ID,
+		Timeout:          int(WebAuthnTimeout.Milliseconds()),
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "preferred",
+	}
+	if username != "" {
+		// Unknown users get an empty list so the response does not reveal
+		// which accounts have credentials
+		if u, ok := w.users[username]; ok {
+			opts.AllowCredentials = descriptors(u.Credentials)
+		}
+	}
+
+	challenge, err := w.newChallengeUnlocked(pendingCeremony{username: username})
+	if err != nil {
+		return nil, err
+	}
+	opts.Challenge = challenge
+	return opts, nil
+}
+
+// FinishLogin verifies a login response and returns the username and
+// whether the authenticator verified the user (PIN or biometrics)
+func (w *WebAuthn) FinishLogin(rp RelyingParty, resp *AssertionResponse) (string, bool, error) {
+	clientData, err := b64url.DecodeString(resp.ClientDataJSON)
+	if err != nil {
+		return "", false, ErrWebAuthnVerification
+	}
+	challenge, err := verifyClientData(clientData, "webauthn.get", rp)
+	if err != nil {
+		return "", false, err
+	}
+	authData, err := b64url.DecodeString(resp.AuthenticatorData)
+	if err != nil {
+		return "", false, ErrWebAuthnVerification
+	}
+	signature, err := b64url.DecodeString(resp.Signature)
+	if err != nil {
+		return "", false, ErrWebAuthnVerification
+	}
+	parsed, err := parseAuthenticatorData(authData, rp)
+	if err != nil {
+		return "", false, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	username, cred := w.findCredentialUnlocked(resp.ID)
+	if cred == nil {
+		return "", false, ErrCredentialNotFound
+	}
+	if resp.UserHandle != "" && resp.UserHandle != w.users[username].Handle {
+		return "", false, ErrWebAuthnVerification
+	}
+	if err := w.consumeChallengeUnlocked(challenge, username, false); err != nil {
+		return "", false, err
+	}
+
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	if err := verifySignature(cred, signed, signature); err != nil {
+		return "", false, err
+	}
+
+	// A counter that does not increase points to a cloned authenticator.
+	// Authenticators without a counter always report zero.
+	if (parsed.signCount != 0 || cred.SignCount != 0) && parsed.signCount <= cred.SignCount {
+		return "", false, ErrWebAuthnVerification
+	}
+
+	now := w.now()
+	previous := *cred
+	cred.SignCount = parsed.signCount
+	cred.LastUsedAt = &now
+	if err := w.saveUnlocked(); err != nil {
+		*cred = previous
+		return "", false, err
+	}
+	return username, parsed.flags&flagUserVerified != 0, nil
+}
+
+// findCredentialUnlocked looks a credential up by ID (caller must hold lock)
+func (w *WebAuthn) findCredentialUnlocked(id string) (string, *WebAuthnCredential) {
+	for username, u := range w.users {
+		for _, c := range u.Credentials {
+			if c.ID == id {
+				return username, c
+			}
+		}
+	}
+	return "", nil
+}
+
+// newChallengeUnlocked issues a random challenge and drops expired ones
+// (caller must hold lock)
+func (w *WebAuthn) newChallengeUnlocked(p pendingCeremony) (string, error) {
+	now := w.now()
+	for c, pending := range w.challenges {
+		if now.After(pending.expires) {
+			delete(w.challenges, c)
+		}
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	challenge := b64url.EncodeToString(buf)
+	p.expires = now.Add(WebAuthnTimeout)
+	w.challenges[challenge] = p
+	return challenge, nil
+}
+
+// consumeChallengeUnlocked checks that a challenge was issued for this
+// ceremony and user, and removes it (caller must hold lock)
+func (w *WebAuthn) consumeChallengeUnlocked(challenge, username string, registration bool) error {
+	p, ok := w.challenges[challenge]
+	if !ok {
+		return ErrWebAuthnChallenge
+	}
+	delete(w.challenges, challenge)
+	if w.now().After(p.expires) || p.registration != registration {
+		return ErrWebAuthnChallenge
+	}
+	if p.username != "" && p.username != username {
+		return ErrWebAuthnChallenge
+	}
+	return nil
+}
+
+// verifyClientData checks the ceremony type and origin in clientDataJSON
+// and returns its challenge
+func verifyClientData(raw []byte, ceremony string, rp RelyingParty) (string, error) {
+	var clientData struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return "", ErrWebAuthnVerification
+	}
+	if clientData.Type != ceremony || !slices.Contains(rp.Origins, clientData.Origin) {
+		return "", ErrWebAuthnVerification
+	}
+	return clientData.Challenge, nil
+}
+
+// authenticatorData is the parsed authenticator data of a response
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte // PKIX DER
+	algorithm    int
+}
+
+// parseAuthenticatorData parses authenticator data and checks the RP ID
+// hash and user presence
+func parseAuthenticatorData(data []byte, rp RelyingParty) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrWebAuthnVerification
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data[:32], rpIDHash[:]) {
+		return nil, ErrWebAuthnVerification
+	}
+
+	parsed := &authenticatorData{
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if parsed.flags&flagUserPresent == 0 {
+		return nil, ErrWebAuthnVerification
+	}
+	if parsed.flags&flagAttestedData == 0 {
+		return parsed, nil
+	}
+
+	// Attested credential data: AAGUID, credential ID length and ID, then
+	// the COSE public key
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, ErrWebAuthnVerification
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLen == 0 || len(rest) < idLen {
+		return nil, ErrWebAuthnVerification
+	}
+	parsed.credentialID = append([]byte(nil), rest[:idLen]...)
+
+	coseKey, _, err := cborDecode(rest[idLen:])
+	if err != nil {
+		return nil, ErrWebAuthnVerification
+	}
+	key, ok := coseKey.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrWebAuthnVerification
+	}
+	if parsed.publicKey, parsed.algorithm, err = parseCOSEKey(key); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// parseCOSEKey converts a COSE public key to PKIX DER
+func parseCOSEKey(key map[interface{}]interface{}) ([]byte, int, error) {
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+
+	var pub interface{}
+	switch {
+	case kty == 2 && alg == COSEAlgES256:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrWebAuthnVerification
+		}
+		ecKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !ecKey.Curve.IsOnCurve(ecKey.X, ecKey.Y) {
+			return nil, 0, ErrWebAuthnVerification
+		}
+		pub = ecKey
+	case kty == 1 && alg == COSEAlgEdDSA:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrWebAuthnVerification
+		}
+		pub = ed25519.PublicKey(x)
+	case kty == 3 && alg == COSEAlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrWebAuthnVerification
+		}
+		pub = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	default:
+		return nil, 0, ErrUnsupportedAlgorithm
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, 0, ErrWebAuthnVerification
+	}
+	return der, int(alg), nil
+}
+
+// verifySignature checks an assertion signature with a stored credential
+func verifySignature(cred *WebAuthnCredential, signed, signature []byte) error {
+	der, err := base64.StdEncoding.DecodeString(cred.PublicKey)
+	if err != nil {
+		return ErrWebAuthnVerification
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return ErrWebAuthnVerification
+	}
+
+	digest := sha256.Sum256(signed)
+	ok := false
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		ok = cred.Algorithm == COSEAlgES256 && ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		ok = cred.Algorithm == COSEAlgEdDSA && ed25519.Verify(key, signed, signature)
+	case *rsa.PublicKey:
+		ok = cred.Algorithm == COSEAlgRS256 && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !ok {
+		return ErrWebAuthnVerification
+	}
+	return nil
+}
+
+// descriptors lists credentials for ceremony options
+func descriptors(creds []*WebAuthnCredential) []CredentialDescriptor {
+	list := []CredentialDescriptor{}
+	for _, c := range creds {
+		list = append(list, CredentialDescriptor{Type: "public-key", ID: c.ID})
+	}
+	return list
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// cborEncode is a tiny encoder for the values a test authenticator sends
+func cborEncode(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			b := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(b[1:], uint16(n))
+			return b
+		}
+	}
+	switch x := v.(type) {
+	case int:
+		if x < 0 {
+			return head(1, uint64(-1-x))
+		}
+		return head(0, uint64(x))
+	case []byte:
+		return append(head(2, uint64(len(x))), x...)
+	case string:
+		return append(head(3, uint64(len(x))), x...)
+	case [][2]interface{}: // ordered map
+		out := head(5, uint64(len(x)))
+		for _, kv := range x {
+			out = append(out, cborEncode(kv[0])...)
+			out = append(out, cborEncode(kv[1])...)
+		}
+		return out
+	}
+	panic("unsupported type")
+}
+
+type testAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	signCount uint32
+}
+
+func (a *testAuthenticator) authData(rpID string, flags byte, attested bool) []byte {
+	hash := sha256.Sum256([]byte(rpID))
+	data := append(hash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		pub := a.key.PublicKey
+		data = append(data, cborEncode([][2]interface{}{
+			{1, 2}, {3, COSEAlgES256}, {-1, 1},
+			{-2, pub.X.FillBytes(make([]byte, 32))},
+			{-3, pub.Y.FillBytes(make([]byte, 32))},
+		})...)
+	}
+	return data
+}
+
+func clientData(ceremony, challenge, origin string) string {
+	raw, _ := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": origin})
+	return b64url.EncodeToString(raw)
+}
+
+func TestWebAuthnRegisterAndLogin(t *testing.T) {
+	rp := RelyingParty{ID: "panel.example.com", Name: "FastCP", Origins: []string{"https://panel.example.com:8080"}}
+	dir := t.TempDir()
+	w := NewWebAuthn(dir)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a := &testAuthenticator{key: key, id: []byte("credential-1")}
+
+	creation, err := w.BeginRegistration("alice", rp)
+	if err != nil {
+		t.Fatalf("BeginRegistration failed: %v", err)
+	}
+	attestation := cborEncode([][2]interface{}{
+		{"fmt", "none"},
+		{"attStmt", [][2]interface{}{}},
+		{"authData", a.authData(rp.ID, flagUserPresent|flagAttestedData, true)},
+	})
+	cred, err := w.FinishRegistration("alice", "YubiKey", rp, &AttestationResponse{
+		ID:                b64url.EncodeToString(a.id),
+		ClientDataJSON:    clientData("webauthn.create", creation.Challenge, rp.Origins[0]),
+		AttestationObject: b64url.EncodeToString(attestation),
+	})
+	if err != nil {
+		t.Fatalf("FinishRegistration failed: %v", err)
+	}
+	if cred.Name != "YubiKey" || len(w.Credentials("alice")) != 1 {
+		t.Fatalf("unexpected credential: %+v", cred)
+	}
+
+	login := func(origin string) (string, bool, error) {
+		opts, err := w.BeginLogin("", rp)
+		if err != nil {
+			t.Fatalf("BeginLogin failed: %v", err)
+		}
+		a.signCount++
+		authData := a.authData(rp.ID, flagUserPresent|flagUserVerified, false)
+		cd := clientData("webauthn.get", opts.Challenge, origin)
+		rawCD, _ := b64url.DecodeString(cd)
+		cdHash := sha256.Sum256(rawCD)
+		digest := sha256.Sum256(append(append([]byte(nil), authData...), cdHash[:]...))
+		sig, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		return w.FinishLogin(rp, &AssertionResponse{
+			ID:                b64url.EncodeToString(a.id),
+			ClientDataJSON:    cd,
+			AuthenticatorData: b64url.EncodeToString(authData),
+			Signature:         b64url.EncodeToString(sig),
+		})
+	}
+
+	username, verified, err := login(rp.Origins[0])
+	if err != nil || username != "alice" || !verified {
+		t.Fatalf("expected verified login for alice, got %q %v %v", username, verified, err)
+	}
+
+	// A phishing origin is rejected
+	if _, _, err := login("https://panel.example.com.evil.test"); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Fatalf("expected foreign origin to fail, got %v", err)
+	}
+
+	// A counter that goes backwards points to a cloned key
+	a.signCount = 0
+	if _, _, err := login(rp.Origins[0]); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Fatalf("expected replayed counter to fail, got %v", err)
+	}
+
+	// Credentials survive a reload
+	reloaded := NewWebAuthn(dir)
+	if err := reloaded.Load(); err != nil || len(reloaded.Credentials("alice")) != 1 {
+		t.Fatalf("expected credential after reload, got %v", err)
+	}
+
+	if err := w.DeleteCredential("alice", cred.ID); err != nil || len(w.Credentials("alice")) != 0 {
+		t.Fatalf("DeleteCredential failed: %v", err)
+	}
+}
+
+func TestCBORDecodeRejectsMalformed(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		{0x5f},                   // indefinite-length byte string
+		{0x44, 0x01, 0x02},       // byte string shorter than its length
+		{0xa1, 0x41, 0x00, 0x01}, // map with a byte string key
+		{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, // huge array
+	} {
+		if _, _, err := cborDecode(data); err == nil {
+			t.Fatalf("expected %x to be rejected", data)
+		}
+	}
+}
@@ -0,0 +1,555 @@
+// Package bandwidth accounts monthly transfer per user from the proxy
+// access logs, and enforces the monthly bandwidth limit of
+// models.UserLimits.
+//
+// Transfer is the response body size logged by the proxy, so it slightly
+// undercounts the bytes on the wire (headers and TLS overhead are excluded).
+// The logs are written by root into directories users cannot change.
+package bandwidth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/analytics"
+	"github.com/rehmatworks/fastcp/internal/caddy"
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/limits"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sitelogs"
+)
+
+// Actions taken when a user exceeds their monthly bandwidth
+const (
+	ActionNone     = "none"      // only report the overage
+	ActionSuspend  = "suspend"   // suspend all of the user's sites
+	ActionLimitCPU = "limit_cpu" // lower the CPU limit of the user's PHP instance
+)
+
+const bytesPerMB = 1024 * 1024
+
+var (
+	ErrInvalidMonth  = errors.New("invalid month (use YYYY-MM)")
+	ErrInvalidAction = errors.New("invalid bandwidth action (use none, suspend or limit_cpu)")
+)
+
+// ValidAction reports whether a is a known enforcement action
+func ValidAction(a string) bool {
+	return a == ActionNone || a == ActionSuspend || a == ActionLimitCPU
+}
+
+// Month returns the accounting month (UTC) of t, e.g. "2026-10"
+func Month(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// ParseMonth validates a YYYY-MM month. An empty string yields the current month.
+func ParseMonth(s string) (string, error) {
+	if s == "" {
+		return Month(time.Now()), nil
+	}
+	t, err := time.Parse("2006-01", s)
+	if err != nil {
+		return "", ErrInvalidMonth
+	}
+	return Month(t), nil
+}
+
+// Usage is the transfer of one user during one month
+type Usage struct {
+	Username string           `json:"username"`
+	Month    string           `json:"month"`
+	Requests int64            `json:"requests"`
+	Bytes    int64            `json:"bytes"`
+	Sites    map[string]int64 `json:"sites"` // bytes per site ID
+}
+
+// Enforcement records an action applied to a user over their limit
+type Enforcement struct {
+	Username   string    `json:"username"`
+	Month      string    `json:"month"`
+	Action     string    `json:"action"`
+	Sites      []string  `json:"sites,omitempty"`       // sites suspended by the enforcement
+	CPUPercent int       `json:"cpu_percent,omitempty"` // CPU limit applied by limit_cpu
+	At         time.Time `json:"at"`
+}
+
+// Report summarises a user's monthly usage against their limit
+type Report struct {
+	Username  string           `json:"username"`
+	Month     string           `json:"month"`
+	Requests  int64            `json:"requests"`
+	UsedBytes int64            `json:"used_bytes"`
+	UsedMB    int64            `json:"used_mb"`
+	LimitMB   int64            `json:"limit_mb"` // 0 = unlimited
+	OverageMB int64            `json:"overage_mb"`
+	Sites     map[string]int64 `json:"sites"`
+	Enforced  string           `json:"enforced,omitempty"` // action currently applied
+}
+
+// SiteStore is the part of the site manager used for accounting and enforcement
+type SiteStore interface {
+	GetAll() []models.Site
+	GetUserLimit(username string) *models.UserLimits
+	Suspend(id string) error
+	Unsuspend(id string) error
+}
+
+// Manager tracks monthly bandwidth and enforces limits
+type Manager struct {
+	dir    string
+	store  SiteStore
+	logger *slog.Logger
+	reload func() error
+	setCPU func(username string, percent int) error
+	now    func() time.Time
+
+	mu       sync.Mutex
+	state    *state                  // loaded on first use
+	enforced map[string]*Enforcement // username -> enforcement
+}
+
+// state is what accounting read from the access logs: the position in
+// each log and the usage of the open months, the current and the previous
+// one. Both are saved together so a position never gets ahead of or
+// behind the usage it accounts for. Closed months are archived to a file
+// of their own.
+type state struct {
+	Positions map[string]analytics.Position `json:"positions"` // site ID -> log position
+	Months    map[string]map[string]*Usage  `json:"months"`    // month -> username -> usage
+}
+
+// NewManager creates a bandwidth manager storing data under dataDir/bandwidth
+func NewManager(dataDir string, store SiteStore, logger *slog.Logger) *Manager {
+	return &Manager{
+		dir:      filepath.Join(dataDir, "bandwidth"),
+		store:    store,
+		logger:   logger,
+		setCPU:   limits.NewManager(logger).SetCPULimit,
+		now:      time.Now,
+		enforced: make(map[string]*Enforcement),
+	}
+}
+
+// SetReloadFunc sets the function called after sites were suspended or
+// unsuspended, so the proxy configuration picks up the change
+func (m *Manager) SetReloadFunc(fn func() error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reload = fn
+}
+
+// Load reads the active enforcements from disk
+func (m *Manager) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := os.ReadFile(m.enforcedPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, &m.enforced)
+}
+
+// Start accounts traffic and enforces limits periodically until ctx is done
+func (m *Manager) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := m.Collect(); err != nil {
+				m.logger.Warn("bandwidth accounting failed", "error", err)
+			}
+			if err := m.Enforce(); err != nil {
+				m.logger.Warn("bandwidth enforcement failed", "error", err)
+			}
+		}
+	}()
+}
+
+// Collect accounts the traffic appended to the access logs of all sites
+// since the last run
+func (m *Manager) Collect() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, err := m.stateUnlocked()
+	if err != nil {
+		return err
+	}
+	first := m.firstOpenMonth()
+
+	changed := false
+	var errs []error
+	sites := make(map[string]bool)
+	for _, site := range m.store.GetAll() {
+		sites[site.ID] = true
+		username := caddy.ExtractUsernameFromRootPath(site.RootPath)
+		if username == "" {
+			continue
+		}
+		last := st.Positions[site.ID]
+		pos, err := analytics.ReadLog(site.ID, last, func(e *sitelogs.Entry) {
+			// Traffic of a closed month is not added to its archive
+			if month := Month(e.Timestamp()); month >= first {
+				st.add(username, site.ID, month, e.Size)
+			}
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", site.ID, err))
+		}
+		if pos != last {
+			st.Positions[site.ID] = pos
+			changed = true
+		}
+	}
+	for id := range st.Positions {
+		if !sites[id] {
+			delete(st.Positions, id)
+			changed = true
+		}
+	}
+
+	for month, usage := range st.Months {
+		if month >= first {
+			continue
+		}
+		if err := writeFile(m.monthPath(month), usage); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(st.Months, month)
+		changed = true
+	}
+
+	if changed {
+		if err := writeFile(m.statePath(), st); err != nil {
+			// The usage in memory is ahead of the positions on disk, read
+			// both again so the traffic is accounted once on the next run
+			m.state = nil
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// add adds a request of a site to its owner's usage during a month
+func (st *state) add(username, siteID, month string, bytes int64) {
+	usage := st.Months[month]
+	if usage == nil {
+		usage = make(map[string]*Usage)
+		st.Months[month] = usage
+	}
+	u := usage[username]
+	if u == nil {
+		u = &Usage{Username: username, Month: month, Sites: make(map[string]int64)}
+		usage[username] = u
+	}
+	u.Requests++
+	u.Bytes += bytes
+	u.Sites[siteID] += bytes
+}
+
+// UserReport returns the usage of one user during a month
+func (m *Manager) UserReport(username, month string) (*Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage, err := m.usageUnlocked(month)
+	if err != nil {
+		return nil, err
+	}
+	return m.reportUnlocked(username, month, usage[username]), nil
+}
+
+// Reports returns the usage of every user with traffic during a month,
+// sorted by username
+func (m *Manager) Reports(month string) ([]*Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage, err := m.usageUnlocked(month)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]*Report, 0, len(usage))
+	for username, u := range usage {
+		reports = append(reports, m.reportUnlocked(username, month, u))
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Username < reports[j].Username })
+	return reports, nil
+}
+
+// reportUnlocked builds a report (caller must hold lock)
+func (m *Manager) reportUnlocked(username, month string, u *Usage) *Report {
+	r := &Report{
+		Username: username,
+		Month:    month,
+		LimitMB:  m.store.GetUserLimit(username).MaxBandwidthMB,
+		Sites:    map[string]int64{},
+	}
+	if u != nil {
+		r.Requests = u.Requests
+		r.UsedBytes = u.Bytes
+		r.UsedMB = u.Bytes / bytesPerMB
+		for id, b := range u.Sites {
+			r.Sites[id] = b
+		}
+	}
+	if r.LimitMB > 0 && r.UsedMB > r.LimitMB {
+		r.OverageMB = r.UsedMB - r.LimitMB
+	}
+	if e := m.enforced[username]; e != nil && e.Month == month {
+		r.Enforced = e.Action
+	}
+	return r
+}
+
+// Enforce applies the configured action to users over their limit and lifts
+// enforcements that no longer apply: the limit was raised or removed, the
+// action was set to none, or a new month started.
+func (m *Manager) Enforce() error {
+	policy := config.Get().Bandwidth
+	month := Month(m.now())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage, err := m.usageUnlocked(month)
+	if err != nil {
+		return err
+	}
+
+	changed, reload := false, false
+	var errs []error
+
+	for username, e := range m.enforced {
+		limit := m.store.GetUserLimit(username).MaxBandwidthMB
+		if policy.Action != ActionNone && e.Month == month && overLimit(usage[username], limit) {
+			// Limits may have been re-applied since, keep the CPU limit in place
+			if e.Action == ActionLimitCPU {
+				if err := m.setCPU(username, e.CPUPercent); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", username, err))
+				}
+			}
+			continue
+		}
+		r, err := m.liftUnlocked(e)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", username, err))
+		}
+		reload = reload || r
+		delete(m.enforced, username)
+		changed = true
+	}
+
+	if ValidAction(policy.Action) && policy.Action != ActionNone {
+		for username, u := range usage {
+			if m.enforced[username] != nil {
+				continue
+			}
+			limit := m.store.GetUserLimit(username).MaxBandwidthMB
+			if !overLimit(u, limit) {
+				continue
+			}
+			e, r, err := m.applyUnlocked(username, month, policy)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", username, err))
+			}
+			reload = reload || r
+			m.enforced[username] = e
+			changed = true
+			m.logger.Warn("bandwidth limit exceeded", "user", username, "used_mb", u.Bytes/bytesPerMB, "limit_mb", limit, "action", e.Action)
+		}
+	}
+
+	if changed {
+		if err := m.saveEnforcedUnlocked(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if reload && m.reload != nil {
+		if err := m.reload(); err != nil {
+			errs = append(errs, fmt.Errorf("reload: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// applyUnlocked enforces the policy on a user (caller must hold lock).
+// It reports whether sites were suspended.
+func (m *Manager) applyUnlocked(username, month string, policy models.BandwidthPolicy) (*Enforcement, bool, error) {
+	e := &Enforcement{Username: username, Month: month, Action: policy.Action, At: m.now()}
+
+	switch policy.Action {
+	case ActionSuspend:
+		for _, site := range m.store.GetAll() {
+			if site.Status != "active" || caddy.ExtractUsernameFromRootPath(site.RootPath) != username {
+				continue
+			}
+			if err := m.store.Suspend(site.ID); err != nil {
+				return e, len(e.Sites) > 0, err
+			}
+			e.Sites = append(e.Sites, site.ID)
+		}
+		return e, len(e.Sites) > 0, nil
+
+	case ActionLimitCPU:
+		// Never raise a CPU limit that is already lower
+		e.CPUPercent = policy.LimitCPUPercent
+		if current := m.store.GetUserLimit(username).MaxCPUPercent; current > 0 && current < e.CPUPercent {
+			e.CPUPercent = current
+		}
+		return e, false, m.setCPU(username, e.CPUPercent)
+	}
+	return e, false, nil
+}
+
+// liftUnlocked reverts an enforcement (caller must hold lock).
+// It reports whether sites were unsuspended.
+func (m *Manager) liftUnlocked(e *Enforcement) (bool, error) {
+	m.logger.Info("lifting bandwidth enforcement", "user", e.Username, "month", e.Month, "action", e.Action)
+
+	switch e.Action {
+	case ActionSuspend:
+		// Only unsuspend sites that are still suspended; sites suspended by
+		// the enforcement and reactivated by an admin are left alone
+		suspended := make(map[string]bool)
+		for _, site := range m.store.GetAll() {
+			if site.Status == "suspended" {
+				suspended[site.ID] = true
+			}
+		}
+		changed := false
+		for _, id := range e.Sites {
+			if !suspended[id] {
+				continue
+			}
+			if err := m.store.Unsuspend(id); err != nil {
+				return changed, err
+			}
+			changed = true
+		}
+		return changed, nil
+
+	case ActionLimitCPU:
+		return false, m.setCPU(e.Username, m.store.GetUserLimit(e.Username).MaxCPUPercent)
+	}
+	return false, nil
+}
+
+// overLimit reports whether usage reached a limit in MB (0 = unlimited)
+func overLimit(u *Usage, limitMB int64) bool {
+	return u != nil && limitMB > 0 && u.Bytes >= limitMB*bytesPerMB
+}
+
+// firstOpenMonth returns the oldest month still accounted in the state,
+// the previous one, so lines logged late at the turn of a month count
+func (m *Manager) firstOpenMonth() string {
+	now := m.now().UTC()
+	return Month(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0))
+}
+
+// stateUnlocked returns the accounting state, loading it from disk on
+// first use (caller must hold lock)
+func (m *Manager) stateUnlocked() (*state, error) {
+	if m.state != nil {
+		return m.state, nil
+	}
+
+	st := &state{}
+	data, err := os.ReadFile(m.statePath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, st); err != nil {
+			return nil, err
+		}
+	}
+	if st.Positions == nil {
+		st.Positions = make(map[string]analytics.Position)
+	}
+	if st.Months == nil {
+		st.Months = make(map[string]map[string]*Usage)
+	}
+	m.state = st
+	return st, nil
+}
+
+// usageUnlocked returns the usage of a month from the state while it is
+// open, or from its archive (caller must hold lock)
+func (m *Manager) usageUnlocked(month string) (map[string]*Usage, error) {
+	st, err := m.stateUnlocked()
+	if err != nil {
+		return nil, err
+	}
+	if usage, ok := st.Months[month]; ok {
+		return usage, nil
+	}
+
+	usage := make(map[string]*Usage)
+	data, err := os.ReadFile(m.monthPath(month))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &usage); err != nil {
+			return nil, err
+		}
+	}
+	return usage, nil
+}
+
+// saveEnforcedUnlocked writes the active enforcements (caller must hold lock)
+func (m *Manager) saveEnforcedUnlocked() error {
+	return writeFile(m.enforcedPath(), m.enforced)
+}
+
+// writeFile replaces a file with v as JSON atomically
+var writeFile = func(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (m *Manager) statePath() string {
+	return filepath.Join(m.dir, "state.json")
+}
+
+func (m *Manager) monthPath(month string) string {
+	return filepath.Join(m.dir, month+".json")
+}
+
+func (m *Manager) enforcedPath() string {
+	return filepath.Join(m.dir, "enforced.json")
+}
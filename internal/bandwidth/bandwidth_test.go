@@ -0,0 +1,263 @@
+package bandwidth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sitelogs"
+)
+
+type fakeStore struct {
+	sites  []models.Site
+	limits map[string]*models.UserLimits
+}
+
+func (f *fakeStore) GetAll() []models.Site { return f.sites }
+
+func (f *fakeStore) GetUserLimit(username string) *models.UserLimits {
+	if l, ok := f.limits[username]; ok {
+		return l
+	}
+	return &models.UserLimits{Username: username}
+}
+
+func (f *fakeStore) setStatus(id, status string) error {
+	for i := range f.sites {
+		if f.sites[i].ID == id {
+			f.sites[i].Status = status
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) Suspend(id string) error   { return f.setStatus(id, "suspended") }
+func (f *fakeStore) Unsuspend(id string) error { return f.setStatus(id, "active") }
+
+func setup(t *testing.T, action string) (*Manager, *fakeStore, map[string]int) {
+	t.Helper()
+	os.Setenv("FASTCP_DEV", "1")
+	t.Cleanup(func() { os.Unsetenv("FASTCP_DEV") })
+
+	cfg, _ := config.Load("")
+	cfg.DataDir = t.TempDir()
+	cfg.LogDir = t.TempDir()
+	cfg.Bandwidth = models.BandwidthPolicy{Action: action, LimitCPUPercent: 10}
+	config.Update(cfg)
+
+	store := &fakeStore{
+		sites: []models.Site{
+			{ID: "a", RootPath: "/home/alice/www/a.com", Status: "active"},
+			{ID: "b", RootPath: "/home/alice/www/b.com", Status: "active"},
+			{ID: "c", RootPath: "/home/bob/www/c.com", Status: "active"},
+		},
+		limits: map[string]*models.UserLimits{
+			"alice": {Username: "alice", MaxBandwidthMB: 10, MaxCPUPercent: 50},
+		},
+	}
+	cpu := make(map[string]int)
+	m := NewManager(cfg.DataDir, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.setCPU = func(username string, percent int) error {
+		cpu[username] = percent
+		return nil
+	}
+	return m, store, cpu
+}
+
+// logRequest appends a request to the access log of a site
+func logRequest(t *testing.T, siteID string, ts time.Time, size int64) {
+	t.Helper()
+	if err := os.MkdirAll(sitelogs.Dir(siteID), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(sitelogs.AccessLogPath(siteID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fmt.Fprintf(f, `{"level":"info","ts":%d,"msg":"handled request","request":{"uri":"/"},"size":%d,"status":200}`+"\n", ts.Unix(), size)
+}
+
+func TestCollectAndReports(t *testing.T) {
+	m, store, _ := setup(t, ActionNone)
+	hour := time.Date(2026, 10, 5, 12, 0, 0, 0, time.UTC)
+	now := hour.AddDate(0, 1, 0)
+	m.now = func() time.Time { return now }
+
+	logRequest(t, "a", hour, 3*bytesPerMB)
+	logRequest(t, "a", hour, 3*bytesPerMB)
+	logRequest(t, "b", hour, 6*bytesPerMB)
+	logRequest(t, "c", hour, bytesPerMB)
+	logRequest(t, "a", now, bytesPerMB)
+
+	if err := m.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	// A second run must not count the same lines again
+	if err := m.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	// Usage survives a restart
+	m2 := NewManager(config.Get().DataDir, store, m.logger)
+	m2.now = m.now
+	if err := m2.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	reports, err := m2.Reports("2026-10")
+	if err != nil {
+		t.Fatalf("Reports failed: %v", err)
+	}
+	if len(reports) != 2 || reports[0].Username != "alice" || reports[1].Username != "bob" {
+		t.Fatalf("unexpected reports: %+v", reports)
+	}
+	alice := reports[0]
+	if alice.UsedMB != 12 || alice.Requests != 3 || alice.LimitMB != 10 || alice.OverageMB != 2 {
+		t.Fatalf("unexpected alice report: %+v", alice)
+	}
+	if alice.Sites["a"] != 6*bytesPerMB || alice.Sites["b"] != 6*bytesPerMB {
+		t.Fatalf("unexpected per-site usage: %v", alice.Sites)
+	}
+
+	r, _ := m2.UserReport("alice", "2026-11")
+	if r.UsedMB != 1 {
+		t.Fatalf("expected 1 MB in November, got %d", r.UsedMB)
+	}
+
+	// Once October is closed it moves to its archive and late lines for it
+	// are no longer added
+	now = now.AddDate(0, 1, 0)
+	logRequest(t, "a", hour, 5*bytesPerMB)
+	if err := m2.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if _, err := os.Stat(m2.monthPath("2026-10")); err != nil {
+		t.Fatalf("expected October to be archived: %v", err)
+	}
+	if _, open := m2.state.Months["2026-10"]; open {
+		t.Fatal("expected October to leave the state")
+	}
+	if r, _ := m2.UserReport("alice", "2026-10"); r.UsedMB != 12 {
+		t.Fatalf("expected 12 MB in archived October, got %d", r.UsedMB)
+	}
+}
+
+func TestCollectSaveFailure(t *testing.T) {
+	m, store, _ := setup(t, ActionNone)
+	now := time.Date(2026, 10, 5, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	logRequest(t, "a", now, bytesPerMB)
+	if err := m.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	// Neither the usage nor the position of a failed run is kept, so its
+	// traffic is counted once by the next run
+	logRequest(t, "a", now, 2*bytesPerMB)
+	write := writeFile
+	writeFile = func(string, any) error { return errors.New("disk full") }
+	err := m.Collect()
+	writeFile = write
+	if err == nil {
+		t.Fatal("expected the failed save to be reported")
+	}
+	logRequest(t, "a", now, 4*bytesPerMB)
+	if err := m.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	for _, mgr := range []*Manager{m, NewManager(config.Get().DataDir, store, m.logger)} {
+		mgr.now = m.now
+		if r, _ := mgr.UserReport("alice", "2026-10"); r.UsedMB != 7 || r.Requests != 3 {
+			t.Fatalf("expected 7 MB in 3 requests, got %d MB in %d", r.UsedMB, r.Requests)
+		}
+	}
+}
+
+func TestEnforceSuspend(t *testing.T) {
+	m, store, _ := setup(t, ActionSuspend)
+	now := time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	reloads := 0
+	m.SetReloadFunc(func() error { reloads++; return nil })
+
+	logRequest(t, "a", now, 11*bytesPerMB)
+	logRequest(t, "c", now, 100*bytesPerMB) // bob has no limit
+	if err := m.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	if err := m.Enforce(); err != nil {
+		t.Fatalf("Enforce failed: %v", err)
+	}
+	if store.sites[0].Status != "suspended" || store.sites[1].Status != "suspended" || store.sites[2].Status != "active" {
+		t.Fatalf("unexpected site states: %+v", store.sites)
+	}
+	if reloads != 1 {
+		t.Fatalf("expected one reload, got %d", reloads)
+	}
+
+	// Running again changes nothing
+	if err := m.Enforce(); err != nil || reloads != 1 {
+		t.Fatalf("expected no further changes, reloads=%d err=%v", reloads, err)
+	}
+
+	// A new month lifts the suspension
+	now = now.AddDate(0, 1, 0)
+	if err := m.Enforce(); err != nil {
+		t.Fatalf("Enforce failed: %v", err)
+	}
+	if store.sites[0].Status != "active" || store.sites[1].Status != "active" {
+		t.Fatalf("expected sites to be unsuspended: %+v", store.sites)
+	}
+	if reloads != 2 {
+		t.Fatalf("expected a reload after lifting, got %d", reloads)
+	}
+}
+
+func TestEnforceLimitCPU(t *testing.T) {
+	m, store, cpu := setup(t, ActionLimitCPU)
+	now := time.Now()
+
+	logRequest(t, "a", now, 11*bytesPerMB)
+	if err := m.Collect(); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if err := m.Enforce(); err != nil {
+		t.Fatalf("Enforce failed: %v", err)
+	}
+	if cpu["alice"] != 10 || store.sites[0].Status != "active" {
+		t.Fatalf("expected alice to be limited to 10%%, got %v", cpu)
+	}
+	r, _ := m.UserReport("alice", Month(now))
+	if r.Enforced != ActionLimitCPU {
+		t.Fatalf("expected report to show the CPU limit, got %q", r.Enforced)
+	}
+
+	// Raising the limit restores the user's own CPU limit
+	store.limits["alice"].MaxBandwidthMB = 100
+	if err := m.Enforce(); err != nil {
+		t.Fatalf("Enforce failed: %v", err)
+	}
+	if cpu["alice"] != 50 {
+		t.Fatalf("expected CPU limit to be restored to 50%%, got %d", cpu["alice"])
+	}
+}
+
+func TestParseMonth(t *testing.T) {
+	if m, err := ParseMonth("2026-03"); err != nil || m != "2026-03" {
+		t.Fatalf("ParseMonth(2026-03) = %q, %v", m, err)
+	}
+	for _, bad := range []string{"2026", "2026-13", "03-2026", "../x"} {
+		if _, err := ParseMonth(bad); err != ErrInvalidMonth {
+			t.Fatalf("ParseMonth(%q) expected ErrInvalidMonth, got %v", bad, err)
+		}
+	}
+}
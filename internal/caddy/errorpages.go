@@ -0,0 +1,210 @@
+package caddy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rehmatworks/fastcp/internal/config"
+)
+
+// MaxErrorPageSize is the largest custom page accepted (512 KB)
+const MaxErrorPageSize = 512 * 1024
+
+// SuspendedPageFile is the file name of a suspended page template
+const SuspendedPageFile = "suspended.html"
+
+// ErrorPageCodes lists the status codes that can have a custom page per site
+var ErrorPageCodes = []int{404, 500, 502, 503}
+
+var (
+	ErrInvalidErrorCode = errors.New("unsupported error page status code")
+	ErrPageTooLarge     = errors.New("page exceeds maximum size of 512 KB")
+	ErrPageNotFound     = errors.New("page not found")
+)
+
+// IsErrorPageCode reports whether a custom page can be set for the status code
+func IsErrorPageCode(code int) bool {
+	for _, c := range ErrorPageCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorPagesDir returns the root directory for custom pages.
+// Pages live in the data dir (root-owned) rather than the site root so the
+// proxy never serves files a site owner could symlink elsewhere.
+func ErrorPagesDir() string {
+	return filepath.Join(config.Get().DataDir, "error_pages")
+}
+
+// SiteErrorPagesDir returns the directory holding a site's custom error pages
+func SiteErrorPagesDir(siteID string) string {
+	return filepath.Join(ErrorPagesDir(), "sites", filepath.Base(siteID))
+}
+
+// SuspendedPageDir returns the directory holding the suspended page template
+// for a user, or the global template when username is empty
+func SuspendedPageDir(username string) string {
+	if username == "" {
+		return ErrorPagesDir()
+	}
+	return filepath.Join(ErrorPagesDir(), "users", filepath.Base(username))
+}
+
+// SiteErrorPages returns the status codes that have a custom page for a site
+func SiteErrorPages(siteID string) []int {
+	var codes []int
+	dir := SiteErrorPagesDir(siteID)
+	for _, code := range ErrorPageCodes {
+		if fileExists(filepath.Join(dir, fmt.Sprintf("%d.html", code))) {
+			codes = append(codes, code)
+		}
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+// ReadSiteErrorPage returns the custom page for a site and status code
+func ReadSiteErrorPage(siteID string, code int) ([]byte, error) {
+	if !IsErrorPageCode(code) {
+		return nil, ErrInvalidErrorCode
+	}
+	return readPage(filepath.Join(SiteErrorPagesDir(siteID), fmt.Sprintf("%d.html", code)))
+}
+
+// WriteSiteErrorPage stores a custom page for a site and status code
+func WriteSiteErrorPage(siteID string, code int, content []byte) error {
+	if !IsErrorPageCode(code) {
+		return ErrInvalidErrorCode
+	}
+	return writePage(filepath.Join(SiteErrorPagesDir(siteID), fmt.Sprintf("%d.html", code)), content)
+}
+
+// DeleteSiteErrorPage removes a custom page for a site and status code
+func DeleteSiteErrorPage(siteID string, code int) error {
+	if !IsErrorPageCode(code) {
+		return ErrInvalidErrorCode
+	}
+	return deletePage(filepath.Join(SiteErrorPagesDir(siteID), fmt.Sprintf("%d.html", code)))
+}
+
+// DeleteSiteErrorPages removes all custom pages of a site
+func DeleteSiteErrorPages(siteID string) error {
+	return os.RemoveAll(SiteErrorPagesDir(siteID))
+}
+
+// ReadSuspendedPage returns the suspended template of a user (or the global one)
+func ReadSuspendedPage(username string) ([]byte, error) {
+	return readPage(filepath.Join(SuspendedPageDir(username), SuspendedPageFile))
+}
+
+// WriteSuspendedPage stores the suspended template of a user (or the global one)
+func WriteSuspendedPage(username string, content []byte) error {
+	return writePage(filepath.Join(SuspendedPageDir(username), SuspendedPageFile), content)
+}
+
+// DeleteSuspendedPage removes the suspended template of a user (or the global one)
+func DeleteSuspendedPage(username string) error {
+	return deletePage(filepath.Join(SuspendedPageDir(username), SuspendedPageFile))
+}
+
+// ResolveSuspendedPageDir returns the directory of the suspended template that
+// applies to a user: the user's own template, then the global one.
+// Returns an empty string when neither exists.
+func ResolveSuspendedPageDir(username string) string {
+	if username != "" {
+		dir := SuspendedPageDir(username)
+		if fileExists(filepath.Join(dir, SuspendedPageFile)) {
+			return dir
+		}
+	}
+	dir := SuspendedPageDir("")
+	if fileExists(filepath.Join(dir, SuspendedPageFile)) {
+		return dir
+	}
+	return ""
+}
+
+func readPage(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrPageNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func writePage(path string, content []byte) error {
+	if len(content) > MaxErrorPageSize {
+		return ErrPageTooLarge
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create pages directory: %w", err)
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+func deletePage(path string) error {
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrPageNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// joinCodes formats status codes for a Caddyfile status matcher
+func joinCodes(codes []int) string {
+	parts := make([]string, len(codes))
+	for i, c := range codes {
+		parts[i] = fmt.Sprintf("%d", c)
+	}
+	return strings.Join(parts, " ")
+}
+
+// defaultSuspendedPage is served for suspended sites without a custom template.
+// It is intentionally unbranded since visitors see it on client domains.
+const defaultSuspendedPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Site Unavailable</title>
+    <style>
+        * { box-sizing: border-box; margin: 0; padding: 0; }
+        body {
+            font-family: system-ui, -apple-system, sans-serif;
+            min-height: 100vh;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            background: #f8fafc;
+            color: #0f172a;
+            padding: 1.5rem;
+        }
+        .container { text-align: center; max-width: 480px; }
+        h1 { font-size: 1.5rem; font-weight: 600; margin-bottom: 0.75rem; }
+        p { color: #475569; line-height: 1.7; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>This site is temporarily unavailable</h1>
+        <p>The website you are trying to reach has been suspended. If you are the owner, please contact your hosting provider.</p>
+    </div>
+</body>
+</html>`
@@ -10,6 +10,7 @@ import (
 
 	"github.com/rehmatworks/fastcp/internal/config"
 	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sitelogs"
 )
 
 // Generator generates Caddyfile configurations
@@ -90,13 +91,11 @@ func (g *Generator) GenerateMainProxy(sites []models.Site, phpVersions []models.
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Service Unavailable - FastCP</title>
-    <link rel="preconnect" href="https://fonts.googleapis.com">
-    <link href="https://fonts.googleapis.com/css2?family=Inter:wght@400;500;600;700&display=swap" rel="stylesheet">
+    <title>Service Unavailable</title>
     <style>
         * { box-sizing: border-box; margin: 0; padding: 0; }
         body {
-            font-family: 'Inter', system-ui, sans-serif;
+            font-family: system-ui, -apple-system, sans-serif;
             min-height: 100vh;
             display: flex;
             align-items: center;
@@ -149,33 +148,32 @@ func (g *Generator) GenerateMainProxy(sites []models.Site, phpVersions []models.
             margin-bottom: 0.5rem; color: #cbd5e1; font-size: 0.85rem;
         }
         .tip-icon { color: #f59e0b; }
-        .footer { margin-top: 1.5rem; color: #475569; font-size: 0.8rem; }
-        .footer a { color: #10b981; text-decoration: none; }
     </style>
 </head>
 <body>
     <div class="container">
         <div class="logo"><span>!</span></div>
         <div class="error-code">502</div>
-        <h1>PHP Not Responding</h1>
+        <h1>Site Not Responding</h1>
         
         <div class="card">
-            <p>The PHP server for this site is not responding. This usually means FrankenPHP is not running or is overloaded.</p>
+            <p>The server behind this site is not responding. It may be restarting or overloaded.</p>
             <span class="status">Service Unavailable</span>
             <div class="tips">
-                <div class="tip"><span class="tip-icon">→</span> Check if PHP instance is running in FastCP</div>
-                <div class="tip"><span class="tip-icon">→</span> Try restarting the PHP instance</div>
-                <div class="tip"><span class="tip-icon">→</span> Check server logs for errors</div>
+                <div class="tip"><span class="tip-icon">→</span> Try again in a few moments</div>
+                <div class="tip"><span class="tip-icon">→</span> If you are the owner, contact your hosting provider</div>
             </div>
         </div>
-        
-        <p class="footer">Managed by <a href="https://fastcp.org" target="_blank">FastCP</a></p>
     </div>
 </body>
 </html>`
 
 	// Generate site blocks for each active site
 	for _, site := range sites {
+		if site.Status == "suspended" {
+			g.writeSuspendedSite(&buf, site, isDevMode)
+			continue
+		}
 		if site.Status != "active" {
 			continue
 		}
@@ -231,15 +229,59 @@ func (g *Generator) GenerateMainProxy(sites []models.Site, phpVersions []models.
 		buf.WriteString(primaryAddr)
 		buf.WriteString(" {\n")
 
-		// Reverse proxy to PHP instance via Unix socket with error handling
+		// Per-site access log
+		buf.WriteString(LogDirective("\t", "", nil, sitelogs.AccessLogPath(site.ID), cfg.SiteLogs, ""))
+
+		// Custom error pages uploaded for this site (served from the data dir)
+		customCodes := SiteErrorPages(site.ID)
+		pagesDir := SiteErrorPagesDir(site.ID)
+
+		// Gateway errors without a custom page fall back to the default page
+		var gatewayCodes []int
+		for _, code := range []int{502, 503, 504} {
+			if !containsCode(customCodes, code) {
+				gatewayCodes = append(gatewayCodes, code)
+			}
+		}
+
+		// Reverse proxy to PHP instance via Unix socket with error handling.
+		// Error responses of the application keep their own body unless the
+		// site opted in to replacing them with its custom pages.
 		buf.WriteString(fmt.Sprintf("\treverse_proxy unix/%s {\n", socketPath))
-		buf.WriteString("\t\t@error status 502 503 504\n")
-		buf.WriteString("\t\thandle_response @error {\n")
-		buf.WriteString("\t\t\theader Content-Type text/html\n")
-		buf.WriteString(fmt.Sprintf("\t\t\trespond %s {resp.status_code}\n", "`"+gatewayErrorPage+"`"))
-		buf.WriteString("\t\t}\n")
+		if len(customCodes) > 0 && site.InterceptErrors {
+			buf.WriteString(fmt.Sprintf("\t\t@custom_error status %s\n", joinCodes(customCodes)))
+			buf.WriteString("\t\thandle_response @custom_error {\n")
+			buf.WriteString(fmt.Sprintf("\t\t\troot * %s\n", pagesDir))
+			buf.WriteString("\t\t\trewrite * /{rp.status_code}.html\n")
+			buf.WriteString("\t\t\tfile_server {\n")
+			buf.WriteString("\t\t\t\tstatus {rp.status_code}\n")
+			buf.WriteString("\t\t\t}\n")
+			buf.WriteString("\t\t}\n")
+		}
+		if len(gatewayCodes) > 0 {
+			buf.WriteString(fmt.Sprintf("\t\t@error status %s\n", joinCodes(gatewayCodes)))
+			buf.WriteString("\t\thandle_response @error {\n")
+			buf.WriteString("\t\t\theader Content-Type text/html\n")
+			buf.WriteString(fmt.Sprintf("\t\t\trespond %s {resp.status_code}\n", "`"+gatewayErrorPage+"`"))
+			buf.WriteString("\t\t}\n")
+		}
 		buf.WriteString("\t}\n")
 
+		// Errors raised by the proxy itself (e.g. PHP socket down) always
+		// get the custom pages
+		if len(customCodes) > 0 {
+			buf.WriteString("\thandle_errors {\n")
+			buf.WriteString(fmt.Sprintf("\t\t@custom_error expression `{err.status_code} in [%s]`\n", strings.ReplaceAll(joinCodes(customCodes), " ", ", ")))
+			buf.WriteString("\t\thandle @custom_error {\n")
+			buf.WriteString(fmt.Sprintf("\t\t\troot * %s\n", pagesDir))
+			buf.WriteString("\t\t\trewrite * /{err.status_code}.html\n")
+			buf.WriteString("\t\t\tfile_server {\n")
+			buf.WriteString("\t\t\t\tstatus {err.status_code}\n")
+			buf.WriteString("\t\t\t}\n")
+			buf.WriteString("\t\t}\n")
+			buf.WriteString("\t}\n")
+		}
+
 		buf.WriteString("}\n\n")
 	}
 
@@ -255,7 +297,7 @@ func (g *Generator) GenerateMainProxy(sites []models.Site, phpVersions []models.
     <style>
         * { box-sizing: border-box; margin: 0; padding: 0; }
         body {
-            font-family: 'Inter', system-ui, sans-serif;
+            font-family: system-ui, -apple-system, sans-serif;
             min-height: 100vh;
             display: flex;
             align-items: center;
@@ -404,6 +446,84 @@ func (g *Generator) GenerateMainProxy(sites []models.Site, phpVersions []models.
 	return buf.String(), nil
 }
 
+// writeSuspendedSite writes a site block that serves the suspended page for
+// the site's domain and aliases. The user's template takes precedence over
+// the global one; without either a neutral built-in page is used.
+func (g *Generator) writeSuspendedSite(buf *bytes.Buffer, site models.Site, isDevMode bool) {
+	addrs := make([]string, 0, len(site.Aliases)+1)
+	for _, d := range append([]string{site.Domain}, site.Aliases...) {
+		if isDevMode {
+			d = "http://" + d
+		}
+		addrs = append(addrs, d)
+	}
+
+	buf.WriteString(fmt.Sprintf("# Site: %s (suspended)\n", site.Name))
+	buf.WriteString(strings.Join(addrs, ", "))
+	buf.WriteString(" {\n")
+	buf.WriteString(LogDirective("\t", "", nil, sitelogs.AccessLogPath(site.ID), config.Get().SiteLogs, ""))
+	buf.WriteString("\theader Cache-Control no-store\n")
+
+	if dir := ResolveSuspendedPageDir(ExtractUsernameFromRootPath(site.RootPath)); dir != "" {
+		buf.WriteString(fmt.Sprintf("\troot * %s\n", dir))
+		buf.WriteString(fmt.Sprintf("\trewrite * /%s\n", SuspendedPageFile))
+		buf.WriteString("\tfile_server {\n")
+		buf.WriteString("\t\tstatus 503\n")
+		buf.WriteString("\t}\n")
+	} else {
+		buf.WriteString("\theader Content-Type text/html\n")
+		buf.WriteString(fmt.Sprintf("\trespond %s 503\n", "`"+defaultSuspendedPage+"`"))
+	}
+
+	buf.WriteString("}\n\n")
+}
+
+// LogDirective renders a Caddyfile log directive that writes JSON entries to
+// path with the given rotation. The logger name, hostnames (catch-all site
+// blocks only) and minimum level are emitted when set. Rolled files stay
+// uncompressed so readers following the log can finish a rolled file.
+func LogDirective(indent, name string, hostnames []string, path string, rot models.LogRotation, level string) string {
+	var b strings.Builder
+
+	b.WriteString(indent + "log")
+	if name != "" {
+		b.WriteString(" " + name)
+	}
+	b.WriteString(" {\n")
+	if len(hostnames) > 0 {
+		b.WriteString(fmt.Sprintf("%s\thostnames %s\n", indent, strings.Join(hostnames, " ")))
+	}
+	b.WriteString(fmt.Sprintf("%s\toutput file %s {\n", indent, path))
+	b.WriteString(indent + "\t\troll_uncompressed\n")
+	if rot.RollSizeMB > 0 {
+		b.WriteString(fmt.Sprintf("%s\t\troll_size %dmb\n", indent, rot.RollSizeMB))
+	}
+	if rot.RollKeep > 0 {
+		b.WriteString(fmt.Sprintf("%s\t\troll_keep %d\n", indent, rot.RollKeep))
+	}
+	if rot.RollKeepDays > 0 {
+		b.WriteString(fmt.Sprintf("%s\t\troll_keep_for %dh\n", indent, rot.RollKeepDays*24))
+	}
+	b.WriteString(indent + "\t}\n")
+	b.WriteString(indent + "\tformat json\n")
+	if level != "" {
+		b.WriteString(fmt.Sprintf("%s\tlevel %s\n", indent, level))
+	}
+	b.WriteString(indent + "}\n")
+
+	return b.String()
+}
+
+// containsCode reports whether code is in codes
+func containsCode(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
 // GetPHPSocketPath returns the Unix socket path for a PHP version
 func GetPHPSocketPath(version string) string {
 	return filepath.Join(config.RuntimeDir(), fmt.Sprintf("php-%s.sock", version))
@@ -0,0 +1,119 @@
+package caddy
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sitelogs"
+)
+
+func setupTestConfig(t *testing.T) {
+	t.Helper()
+	os.Setenv("FASTCP_DEV", "1")
+	t.Cleanup(func() { os.Unsetenv("FASTCP_DEV") })
+
+	cfg, _ := config.Load("")
+	cfg.DataDir = t.TempDir()
+	cfg.LogDir = t.TempDir()
+	config.Update(cfg)
+}
+
+var testPHPVersions = []models.PHPVersionConfig{{Version: "8.4", Port: 9084, Enabled: true}}
+
+func TestGenerateMainProxyCustomErrorPages(t *testing.T) {
+	setupTestConfig(t)
+
+	site := models.Site{ID: "site-1", Name: "example", Domain: "example.com", RootPath: "/home/alice/www/example.com", PHPVersion: "8.4", Status: "active"}
+
+	if err := WriteSiteErrorPage(site.ID, 404, []byte("<h1>missing</h1>")); err != nil {
+		t.Fatalf("WriteSiteErrorPage failed: %v", err)
+	}
+	if err := WriteSiteErrorPage(site.ID, 502, []byte("<h1>down</h1>")); err != nil {
+		t.Fatalf("WriteSiteErrorPage failed: %v", err)
+	}
+	if err := WriteSiteErrorPage(site.ID, 418, []byte("teapot")); err != ErrInvalidErrorCode {
+		t.Fatalf("expected ErrInvalidErrorCode, got %v", err)
+	}
+
+	out, err := NewGenerator(t.TempDir(), t.TempDir()).GenerateMainProxy([]models.Site{site}, testPHPVersions, 80, 443)
+	if err != nil {
+		t.Fatalf("GenerateMainProxy failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"root * " + SiteErrorPagesDir(site.ID),
+		"@error status 503 504",
+		"{err.status_code} in [404, 502]",
+		"output file " + sitelogs.AccessLogPath(site.ID) + " {\n\t\t\troll_uncompressed\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected proxy config to contain %q, got:\n%s", want, out)
+		}
+	}
+
+	// Error responses of the application keep their own body by default
+	if strings.Contains(out, "@custom_error status") || strings.Contains(out, "{rp.status_code}.html") {
+		t.Fatalf("expected application responses to be left alone, got:\n%s", out)
+	}
+
+	// The built-in gateway page is shown on client domains and is unbranded
+	if !strings.Contains(out, "<title>Service Unavailable</title>") || strings.Contains(out, "Managed by") {
+		t.Fatalf("expected the unbranded gateway page, got:\n%s", out)
+	}
+
+	// Sites that opt in also get the pages for application responses
+	site.InterceptErrors = true
+	out, err = NewGenerator(t.TempDir(), t.TempDir()).GenerateMainProxy([]models.Site{site}, testPHPVersions, 80, 443)
+	if err != nil {
+		t.Fatalf("GenerateMainProxy failed: %v", err)
+	}
+	for _, want := range []string{
+		"@custom_error status 404 502\n\t\thandle_response @custom_error {",
+		"rewrite * /{rp.status_code}.html",
+		"{err.status_code} in [404, 502]",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected proxy config to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestGenerateMainProxySuspendedPage(t *testing.T) {
+	setupTestConfig(t)
+
+	site := models.Site{ID: "site-2", Name: "shop", Domain: "shop.test", Aliases: []string{"www.shop.test"}, RootPath: "/home/bob/www/shop.test", PHPVersion: "8.4", Status: "suspended"}
+	gen := NewGenerator(t.TempDir(), t.TempDir())
+
+	// Without templates the neutral built-in page is used
+	out, err := gen.GenerateMainProxy([]models.Site{site}, testPHPVersions, 80, 443)
+	if err != nil {
+		t.Fatalf("GenerateMainProxy failed: %v", err)
+	}
+	if !strings.Contains(out, "http://shop.test, http://www.shop.test {") || !strings.Contains(out, "temporarily unavailable") {
+		t.Fatalf("expected default suspended block, got:\n%s", out)
+	}
+	if strings.Contains(out, "reverse_proxy unix//home/bob") {
+		t.Fatalf("suspended site must not be proxied to PHP")
+	}
+
+	// Global template applies when the user has none
+	if err := WriteSuspendedPage("", []byte("global")); err != nil {
+		t.Fatalf("WriteSuspendedPage failed: %v", err)
+	}
+	out, _ = gen.GenerateMainProxy([]models.Site{site}, testPHPVersions, 80, 443)
+	if !strings.Contains(out, "root * "+SuspendedPageDir("")+"\n") {
+		t.Fatalf("expected global suspended template, got:\n%s", out)
+	}
+
+	// The user's template takes precedence
+	if err := WriteSuspendedPage("bob", []byte("bob")); err != nil {
+		t.Fatalf("WriteSuspendedPage failed: %v", err)
+	}
+	out, _ = gen.GenerateMainProxy([]models.Site{site}, testPHPVersions, 80, 443)
+	if !strings.Contains(out, "root * "+SuspendedPageDir("bob")+"\n") {
+		t.Fatalf("expected user suspended template, got:\n%s", out)
+	}
+}
@@ -141,7 +141,40 @@ func DefaultConfig() *models.Config {
 		// Disabled by default; requires explicit opt-in for safety
 		AllowAdminPasswordLogin: false, // Disabled by default; if enabled and sudo is configured accordingly, the
 		// server will attempt to run `sudo chpasswd` when not running as root.
-		AllowSudoPasswordChange: false}
+		AllowSudoPasswordChange: false,
+
+		SiteLogs: models.LogRotation{
+			RollSizeMB:   50,
+			RollKeep:     5,
+			RollKeepDays: 14,
+		},
+
+		Bandwidth: models.BandwidthPolicy{
+			Action:          "suspend",
+			LimitCPUPercent: 10,
+		},
+
+		UsageSampleSeconds: 30,
+
+		Notifications: models.NotificationSettings{
+			CooldownMinutes: 60,
+		},
+
+		RateLimit: models.RateLimitSettings{
+			MaxFailuresPerIP:     20,
+			MaxFailuresPerUser:   5,
+			WindowMinutes:        15,
+			LockoutMinutes:       5,
+			MaxLockoutMinutes:    60,
+			BanAfterLockouts:     3,
+			BanMinutes:           1440,
+			APIRequestsPerMinute: 120,
+		},
+
+		SFTP: models.SFTPSettings{
+			ListenAddr: ":2222",
+		},
+	}
 }
 
 // Load loads configuration from file or creates default
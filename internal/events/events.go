@@ -0,0 +1,291 @@
+// Package events detects when users hit their cgroup resource limits
+// (OOM kills, memory and process limits, CPU throttling), records the
+// events on disk and sends notifications for them.
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/rehmatworks/fastcp/internal/limits"
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+// Event types
+const (
+	TypeOOMKill      = "oom_kill"      // processes killed by the OOM killer
+	TypeMemoryMax    = "memory_max"    // memory.max was reached
+	TypeMemoryHigh   = "memory_high"   // memory.high was exceeded and memory was reclaimed
+	TypeProcessLimit = "process_limit" // forks refused by pids.max
+	TypeCPUThrottled = "cpu_throttled" // cpu.max throttled the user for a large part of the interval
+)
+
+// RetentionMonths is how many monthly event files are kept on disk
+var RetentionMonths = 3
+
+// CPUThrottleThreshold is the share of an interval a user must be throttled
+// for before a cpu_throttled event is recorded
+var CPUThrottleThreshold = 0.25
+
+var ErrInvalidType = errors.New("invalid event type")
+
+// Event is a resource limit hit by a user
+type Event struct {
+	ID       string    `json:"id"`
+	Username string    `json:"username"`
+	Type     string    `json:"type"`
+	Count    int64     `json:"count"`           // occurrences since the previous sample
+	Limit    string    `json:"limit,omitempty"` // the limit that was hit
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+// Filter selects events for List
+type Filter struct {
+	Username string
+	Type     string
+	Since    time.Time
+	Limit    int
+}
+
+// ValidType reports whether t is a known event type
+func ValidType(t string) bool {
+	switch t {
+	case TypeOOMKill, TypeMemoryMax, TypeMemoryHigh, TypeProcessLimit, TypeCPUThrottled:
+		return true
+	}
+	return false
+}
+
+// counters are the cumulative cgroup counters of the previous sample
+type counters struct {
+	oomKills      int64
+	memoryMax     int64
+	memoryHigh    int64
+	processLimit  int64
+	throttledUsec int64
+	at            time.Time
+}
+
+// Manager turns cgroup counter increases into events
+type Manager struct {
+	dir      string
+	getLimit func(username string) *models.UserLimits
+	notify   func(e Event, userEmail string) // delivers in the background
+	logger   *slog.Logger
+
+	mu   sync.Mutex
+	last map[string]counters
+}
+
+// NewManager creates an event manager storing events under dataDir/events
+func NewManager(dataDir string, getLimit func(username string) *models.UserLimits, logger *slog.Logger) *Manager {
+	notifier := NewNotifier(logger)
+	return &Manager{
+		dir:      filepath.Join(dataDir, "events"),
+		getLimit: getLimit,
+		notify: func(e Event, userEmail string) {
+			go notifier.Notify(e, userEmail)
+		},
+		logger: logger,
+		last:   make(map[string]counters),
+	}
+}
+
+// Observe compares a usage snapshot with the previous one of the user and
+// records an event for every limit that was hit in between. It matches
+// metrics.SampleFunc.
+func (m *Manager) Observe(username string, usage *limits.ResourceUsage, at time.Time) {
+	m.mu.Lock()
+	prev, seen := m.last[username]
+	cur := counters{
+		oomKills:      usage.OOMKills,
+		memoryMax:     usage.MemoryMaxEvents,
+		memoryHigh:    usage.MemoryHighEvents,
+		processLimit:  usage.ProcessLimitEvents,
+		throttledUsec: usage.CPUThrottledMicros,
+		at:            at,
+	}
+	m.last[username] = cur
+	m.mu.Unlock()
+
+	// The first sample only sets the baseline
+	if !seen {
+		return
+	}
+
+	limit := m.getLimit(username)
+	detected := detect(username, prev, cur, limit)
+	if len(detected) == 0 {
+		return
+	}
+
+	if err := m.store(detected); err != nil {
+		m.logger.Error("failed to store resource events", "user", username, "error", err)
+	}
+	for _, e := range detected {
+		m.logger.Warn("resource limit hit", "user", username, "type", e.Type, "count", e.Count)
+		m.notify(e, limit.NotifyEmail)
+	}
+}
+
+// detect returns the events between two counter snapshots. Counters that
+// went backwards (the cgroup was recreated) are ignored.
+func detect(username string, prev, cur counters, limit *models.UserLimits) []Event {
+	var out []Event
+	add := func(typ string, delta int64, limitDesc, msg string) {
+		out = append(out, Event{
+			ID:       uuid.New().String(),
+			Username: username,
+			Type:     typ,
+			Count:    delta,
+			Limit:    limitDesc,
+			Message:  msg,
+			Time:     cur.at.UTC(),
+		})
+	}
+
+	ram := "unlimited"
+	if limit.MaxRAMMB > 0 {
+		ram = fmt.Sprintf("%d MB", limit.MaxRAMMB)
+	}
+
+	if d := cur.oomKills - prev.oomKills; d > 0 {
+		add(TypeOOMKill, d, ram, fmt.Sprintf("%d process(es) killed by the OOM killer after reaching the memory limit (%s)", d, ram))
+	}
+	if d := cur.memoryMax - prev.memoryMax; d > 0 {
+		add(TypeMemoryMax, d, ram, fmt.Sprintf("Memory limit (%s) reached %d time(s)", ram, d))
+	}
+	if d := cur.memoryHigh - prev.memoryHigh; d > 0 {
+		high := limit.MemoryHighMB
+		if high == 0 {
+			high = limit.MaxRAMMB * 9 / 10
+		}
+		add(TypeMemoryHigh, d, fmt.Sprintf("%d MB", high), fmt.Sprintf("Memory usage exceeded the soft limit (%d MB) %d time(s) and was throttled", high, d))
+	}
+	if d := cur.processLimit - prev.processLimit; d > 0 {
+		procs := fmt.Sprintf("%d", limit.MaxProcesses)
+		add(TypeProcessLimit, d, procs, fmt.Sprintf("%d new process(es) refused by the process limit (%s)", d, procs))
+	}
+
+	elapsed := cur.at.Sub(prev.at).Microseconds()
+	if d := cur.throttledUsec - prev.throttledUsec; d > 0 && elapsed > 0 && float64(d)/float64(elapsed) >= CPUThrottleThreshold {
+		cpu := fmt.Sprintf("%d%%", limit.MaxCPUPercent)
+		add(TypeCPUThrottled, d/1000, cpu, fmt.Sprintf("CPU throttled for %s of the last %s by the CPU limit (%s)",
+			(time.Duration(d)*time.Microsecond).Round(time.Millisecond), cur.at.Sub(prev.at).Round(time.Second), cpu))
+	}
+	return out
+}
+
+// store appends events to the file of the current month and removes files
+// past the retention period when a new month starts
+func (m *Manager) store(list []Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+
+	path := m.monthPath(list[0].Time)
+	_, statErr := os.Stat(path)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range list {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if os.IsNotExist(statErr) {
+		m.pruneUnlocked(list[0].Time)
+	}
+	return nil
+}
+
+// pruneUnlocked removes monthly files older than RetentionMonths (caller must hold lock)
+func (m *Manager) pruneUnlocked(now time.Time) {
+	now = now.UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	oldest := filepath.Base(m.monthPath(month.AddDate(0, -(RetentionMonths - 1), 0)))
+	matches, _ := filepath.Glob(filepath.Join(m.dir, "*.jsonl"))
+	for _, path := range matches {
+		// File names sort chronologically
+		if filepath.Base(path) < oldest {
+			os.Remove(path)
+		}
+	}
+}
+
+// List returns the events matching f, newest first
+func (m *Manager) List(f Filter) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	if f.Since.IsZero() {
+		f.Since = now.AddDate(0, 0, -7)
+	}
+	f.Since = f.Since.UTC()
+
+	events := []Event{}
+	month := time.Date(f.Since.Year(), f.Since.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for !month.After(now) {
+		file, err := os.Open(m.monthPath(month))
+		month = month.AddDate(0, 1, 0)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var e Event
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				continue
+			}
+			if e.Time.Before(f.Since) ||
+				(f.Username != "" && e.Username != f.Username) ||
+				(f.Type != "" && e.Type != f.Type) {
+				continue
+			}
+			events = append(events, e)
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.After(events[j].Time)
+	})
+	if f.Limit > 0 && len(events) > f.Limit {
+		events = events[:f.Limit]
+	}
+	return events, nil
+}
+
+func (m *Manager) monthPath(t time.Time) string {
+	return filepath.Join(m.dir, t.UTC().Format("2006-01")+".jsonl")
+}
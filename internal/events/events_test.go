@@ -0,0 +1,167 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/limits"
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func setupConfig(t *testing.T) *models.Config {
+	t.Helper()
+	os.Setenv("FASTCP_DEV", "1")
+	loaded, _ := config.Load("")
+	cfg := *loaded
+	cfg.DataDir = t.TempDir()
+	config.Update(&cfg)
+	return &cfg
+}
+
+func getLimit(username string) *models.UserLimits {
+	return &models.UserLimits{Username: username, MaxRAMMB: 512, MaxCPUPercent: 50, MaxProcesses: 64}
+}
+
+func TestObserveDetectsLimitHits(t *testing.T) {
+	setupConfig(t)
+	m := NewManager(t.TempDir(), getLimit, testLogger())
+	var notified []Event
+	m.notify = func(e Event, userEmail string) { notified = append(notified, e) }
+	at := time.Now().Add(-time.Minute)
+
+	// The first snapshot is only the baseline
+	m.Observe("alice", &limits.ResourceUsage{OOMKills: 3, MemoryHighEvents: 10}, at)
+	if list, _ := m.List(Filter{}); len(list) != 0 {
+		t.Fatalf("expected no events after baseline, got %+v", list)
+	}
+
+	m.Observe("alice", &limits.ResourceUsage{
+		OOMKills:           5,
+		MemoryHighEvents:   10,
+		ProcessLimitEvents: 1,
+		CPUThrottledMicros: 20 * 1000000, // 20s of a 30s interval
+	}, at.Add(30*time.Second))
+
+	list, err := m.List(Filter{Username: "alice"})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	types := map[string]Event{}
+	for _, e := range list {
+		types[e.Type] = e
+	}
+	if len(list) != 3 || types[TypeOOMKill].Count != 2 || types[TypeProcessLimit].Count != 1 {
+		t.Fatalf("unexpected events: %+v", list)
+	}
+	if e, ok := types[TypeCPUThrottled]; !ok || e.Limit != "50%" {
+		t.Fatalf("expected a cpu_throttled event, got %+v", list)
+	}
+	if len(notified) != 3 {
+		t.Fatalf("expected 3 notifications, got %d", len(notified))
+	}
+
+	// A recreated cgroup resets its counters without producing events
+	m.Observe("alice", &limits.ResourceUsage{}, at.Add(time.Minute))
+	if list, _ := m.List(Filter{Type: TypeOOMKill}); len(list) != 1 {
+		t.Fatalf("expected one oom_kill event, got %+v", list)
+	}
+	if list, _ := m.List(Filter{Username: "bob"}); len(list) != 0 {
+		t.Fatalf("expected no events for bob, got %+v", list)
+	}
+}
+
+func TestPruneOldMonths(t *testing.T) {
+	m := NewManager(t.TempDir(), getLimit, testLogger())
+	os.MkdirAll(m.dir, 0755)
+	for _, name := range []string{"2026-01.jsonl", "2026-02.jsonl", "2026-03.jsonl"} {
+		os.WriteFile(filepath.Join(m.dir, name), nil, 0644)
+	}
+
+	// Three months of retention in April keeps February to April
+	m.pruneUnlocked(time.Date(2026, 4, 30, 0, 0, 0, 0, time.UTC))
+
+	matches, _ := filepath.Glob(filepath.Join(m.dir, "*.jsonl"))
+	if len(matches) != 2 || filepath.Base(matches[0]) != "2026-02.jsonl" {
+		t.Fatalf("unexpected files after prune: %v", matches)
+	}
+}
+
+func TestNotifyCooldown(t *testing.T) {
+	cfg := setupConfig(t)
+
+	var mu sync.Mutex
+	var hooks []webhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhookPayload
+		json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		hooks = append(hooks, p)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	var mails []string
+	oldSend := sendMail
+	sendMail = func(msg []byte) error {
+		mails = append(mails, string(msg))
+		return nil
+	}
+	t.Cleanup(func() { sendMail = oldSend })
+
+	updated := *cfg
+	updated.Notifications = models.NotificationSettings{
+		WebhookURL:      srv.URL,
+		Email:           "admin@example.com",
+		NotifyUsers:     true,
+		CooldownMinutes: 60,
+	}
+	config.Update(&updated)
+
+	n := NewNotifier(testLogger())
+	now := time.Now()
+	e := Event{Username: "alice", Type: TypeOOMKill, Count: 1, Message: "killed", Time: now}
+	n.Notify(e, "alice@example.com")
+
+	// Within the cooldown nothing is sent again
+	e.Time = now.Add(10 * time.Minute)
+	n.Notify(e, "alice@example.com")
+
+	if len(hooks) != 1 || hooks[0].Username != "alice" || hooks[0].Hostname == "" {
+		t.Fatalf("unexpected webhooks: %+v", hooks)
+	}
+	if len(mails) != 2 || !strings.HasPrefix(mails[1], "To: alice@example.com\r\n") {
+		t.Fatalf("unexpected mails: %q", mails)
+	}
+
+	// Another event type has its own cooldown
+	e.Type = TypeProcessLimit
+	n.Notify(e, "")
+	if len(hooks) != 2 || len(mails) != 3 {
+		t.Fatalf("expected a second notification, got %d webhooks and %d mails", len(hooks), len(mails))
+	}
+}
+
+func TestValidAddress(t *testing.T) {
+	if !ValidAddress("admin@example.com") {
+		t.Fatal("expected plain address to be valid")
+	}
+	for _, bad := range []string{"", "admin", "Admin <admin@example.com>", "a@example.com\r\nBcc: x@example.com"} {
+		if ValidAddress(bad) {
+			t.Fatalf("expected %q to be invalid", bad)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/config"
+)
+
+// SendmailPath is the sendmail compatible binary used for email alerts
+var SendmailPath = "/usr/sbin/sendmail"
+
+// sendMail pipes a complete message to sendmail (replaced in tests)
+var sendMail = func(msg []byte) error {
+	cmd := exec.Command(SendmailPath, "-t", "-i")
+	cmd.Stdin = bytes.NewReader(msg)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sendmail failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ValidAddress reports whether addr is a single plain email address
+func ValidAddress(addr string) bool {
+	a, err := mail.ParseAddress(addr)
+	return err == nil && a.Address == addr
+}
+
+// webhookPayload is the JSON body POSTed to the webhook
+type webhookPayload struct {
+	Event
+	Hostname string `json:"hostname"`
+}
+
+// Notifier delivers events by webhook and email, at most once per user and
+// event type within the configured cooldown
+type Notifier struct {
+	client *http.Client
+	logger *slog.Logger
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+// NewNotifier creates a notifier
+func NewNotifier(logger *slog.Logger) *Notifier {
+	return &Notifier{
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+		sent:   make(map[string]time.Time),
+	}
+}
+
+// Notify sends e to the configured webhook and admin email, and to
+// userEmail when user notifications are enabled
+func (n *Notifier) Notify(e Event, userEmail string) {
+	settings := config.Get().Notifications
+
+	var recipients []string
+	if settings.Email != "" {
+		recipients = append(recipients, settings.Email)
+	}
+	if settings.NotifyUsers && userEmail != "" && userEmail != settings.Email {
+		recipients = append(recipients, userEmail)
+	}
+	if settings.WebhookURL == "" && len(recipients) == 0 {
+		return
+	}
+
+	key := e.Username + "/" + e.Type
+	cooldown := time.Duration(settings.CooldownMinutes) * time.Minute
+	n.mu.Lock()
+	if last, ok := n.sent[key]; ok && e.Time.Sub(last) < cooldown {
+		n.mu.Unlock()
+		return
+	}
+	n.sent[key] = e.Time
+	n.mu.Unlock()
+
+	hostname, _ := os.Hostname()
+
+	if settings.WebhookURL != "" {
+		if err := n.postWebhook(settings.WebhookURL, webhookPayload{Event: e, Hostname: hostname}); err != nil {
+			n.logger.Warn("failed to send event webhook", "user", e.Username, "type", e.Type, "error", err)
+		}
+	}
+
+	for _, to := range recipients {
+		if !ValidAddress(to) {
+			n.logger.Warn("skipping invalid notification address", "address", to)
+			continue
+		}
+		if err := sendMail(formatMail(to, hostname, e)); err != nil {
+			n.logger.Warn("failed to send event email", "user", e.Username, "type", e.Type, "error", err)
+		}
+	}
+}
+
+func (n *Notifier) postWebhook(url string, payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// formatMail builds the plain text alert email for sendmail -t
+func formatMail(to, hostname string, e Event) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: [FastCP] %s: %s on %s\r\n", e.Username, strings.ReplaceAll(e.Type, "_", " "), hostname)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "%s\r\n\r\n", e.Message)
+	fmt.Fprintf(&b, "User:   %s\r\n", e.Username)
+	fmt.Fprintf(&b, "Server: %s\r\n", hostname)
+	fmt.Fprintf(&b, "Time:   %s\r\n", e.Time.Format(time.RFC1123))
+	return []byte(b.String())
+}
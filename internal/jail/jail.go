@@ -6,16 +6,17 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+
+	"github.com/rehmatworks/fastcp/internal/sshd"
 )
 
 const (
-	// SSHConfigPath is the path to sshd_config
-	SSHConfigPath = "/etc/ssh/sshd_config"
 	// JailGroup is the group for jailed users
 	JailGroup = "fastcp-jail"
 	// JailMarker marks our config section
-	JailMarker = "# FastCP Jail Configuration"
+	JailMarker = sshd.JailStart
 )
 
 // SSHJailConfig represents the SSH jail configuration
@@ -32,6 +33,13 @@ Match Group fastcp-jail
     AllowAgentForwarding no
     PasswordAuthentication yes
 
+Match Group fastcp-shell
+    ForceCommand /usr/local/bin/fastcp jail-shell
+    AllowTcpForwarding no
+    X11Forwarding no
+    PermitTunnel no
+    AllowAgentForwarding no
+
 # End FastCP Jail Configuration
 `
 
@@ -48,39 +56,14 @@ func SetupJailGroup() error {
 	return nil
 }
 
-// SetupSSHConfig adds the jail configuration to sshd_config
+// SetupSSHConfig installs the jail Match block at the end of sshd_config.
+// The change is validated before sshd is reloaded.
 func SetupSSHConfig() error {
 	if runtime.GOOS != "linux" {
 		return nil
 	}
 
-	// Read current config
-	data, err := os.ReadFile(SSHConfigPath)
-	if err != nil {
-		return fmt.Errorf("failed to read sshd_config: %w", err)
-	}
-
-	config := string(data)
-
-	// Check if our config already exists
-	if strings.Contains(config, JailMarker) {
-		// Already configured
-		return nil
-	}
-
-	// Append our config
-	config = config + "\n" + SSHJailConfig
-
-	// Write back
-	if err := os.WriteFile(SSHConfigPath, []byte(config), 0644); err != nil {
-		return fmt.Errorf("failed to write sshd_config: %w", err)
-	}
-
-	// Reload SSH
-	_ = exec.Command("systemctl", "reload", "sshd").Run()
-	_ = exec.Command("systemctl", "reload", "ssh").Run()
-
-	return nil
+	return sshd.Update(func(m *sshd.Managed) { m.Jail = SSHJailConfig })
 }
 
 // SetupUserJail sets up the jail environment for a user
@@ -170,12 +153,26 @@ func RemoveUserFromJail(username string) error {
 
 // IsUserJailed checks if a user is in the jail group
 func IsUserJailed(username string) bool {
-	cmd := exec.Command("groups", username)
-	output, err := cmd.Output()
+	return inGroup(username, JailGroup)
+}
+
+// inGroup checks if a user is a member of a group
+func inGroup(username, group string) bool {
+	output, err := exec.Command("groups", username).Output()
 	if err != nil {
 		return false
 	}
-	return strings.Contains(string(output), JailGroup)
+	return hasGroup(string(output), group)
+}
+
+// hasGroup reports whether the output of groups, such as "alice : alice
+// fastcp-shell", names the group. Names are compared whole, so
+// fastcp-shell does not match fastcp-shell-old.
+func hasGroup(output, group string) bool {
+	if _, names, ok := strings.Cut(output, ":"); ok {
+		output = names
+	}
+	return slices.Contains(strings.Fields(output), group)
 }
 
 // GetJailStatus returns jail status for a user
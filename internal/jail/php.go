@@ -0,0 +1,115 @@
+package jail
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PHPSandboxDir holds the passwd and group files of each user's PHP
+// sandbox, one directory per user
+var PHPSandboxDir = "/usr/local/lib/fastcp/php-sandbox"
+
+// phpEtcFiles are the parts of /etc PHP needs for DNS, TLS, time zones and
+// the dynamic linker; the rest of /etc is not visible in the sandbox
+var phpEtcFiles = []string{
+	"/etc/alternatives",
+	"/etc/ca-certificates",
+	"/etc/gai.conf",
+	"/etc/host.conf",
+	"/etc/hosts",
+	"/etc/ld.so.cache",
+	"/etc/ld.so.conf",
+	"/etc/ld.so.conf.d",
+	"/etc/localtime",
+	"/etc/mime.types",
+	"/etc/nsswitch.conf",
+	"/etc/resolv.conf",
+	"/etc/services",
+	"/etc/ssl",
+}
+
+// PreparePHPSandbox writes the account files of a user's PHP sandbox. The
+// files belong to root so the user cannot change them.
+func PreparePHPSandbox(u *user.User) error {
+	etc := filepath.Join(PHPSandboxDir, u.Username, "etc")
+	if err := os.MkdirAll(etc, 0755); err != nil {
+		return err
+	}
+	return writeAccountFiles(u, etc)
+}
+
+// PHPSandboxArgs returns the bubblewrap arguments that run argv, a PHP
+// process of a user, in its own mount namespace. The process sees the
+// user's home directory and binds (such as site log directories)
+// read-write, the system directories and argv[0] read-only, a private
+// /tmp and nothing of other users. seccompFD is an inherited descriptor
+// holding the filter from SeccompFilter, or -1 for none.
+//
+// The network is shared, PHP needs it for MySQL and outgoing requests.
+// The process is not killed with FastCP, which adopts it again on start.
+func PHPSandboxArgs(u *user.User, binds []string, seccompFD int, argv []string, exists func(string) bool) []string {
+	env := filepath.Join(PHPSandboxDir, u.Username, "etc")
+	args := []string{"--unshare-all", "--share-net"}
+	for _, dir := range shellSystemDirs {
+		if exists(dir) {
+			args = append(args, "--ro-bind", dir, dir)
+		}
+	}
+	for _, path := range phpEtcFiles {
+		if exists(path) {
+			args = append(args, "--ro-bind", path, path)
+		}
+	}
+	args = append(args,
+		"--ro-bind", filepath.Join(env, "passwd"), "/etc/passwd",
+		"--ro-bind", filepath.Join(env, "group"), "/etc/group",
+	)
+	if binary := argv[0]; !underSystemDir(binary) {
+		args = append(args, "--ro-bind", binary, binary)
+	}
+	// The MySQL socket
+	if exists("/run/mysqld") {
+		args = append(args, "--ro-bind", "/run/mysqld", "/run/mysqld")
+	}
+	args = append(args,
+		"--proc", "/proc",
+		"--dev", "/dev",
+		"--tmpfs", "/tmp",
+		"--bind", u.HomeDir, u.HomeDir,
+	)
+	for _, path := range binds {
+		args = append(args, "--bind", path, path)
+	}
+	args = append(args,
+		"--chdir", u.HomeDir,
+		"--clearenv",
+		"--setenv", "HOME", u.HomeDir,
+		"--setenv", "USER", u.Username,
+		"--setenv", "LOGNAME", u.Username,
+		"--setenv", "PATH", "/usr/local/bin:/usr/bin:/bin",
+		"--setenv", "TMPDIR", "/tmp",
+	)
+	if seccompFD >= 0 {
+		args = append(args, "--seccomp", strconv.Itoa(seccompFD))
+	}
+	return append(append(args, "--"), argv...)
+}
+
+// IsSandboxed reports whether a process is a bubblewrap sandbox, such as
+// a sandboxed PHP instance started before FastCP restarted
+func IsSandboxed(pid int) bool {
+	comm, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+	return err == nil && strings.TrimSpace(string(comm)) == filepath.Base(BwrapPath)
+}
+
+func underSystemDir(path string) bool {
+	for _, dir := range shellSystemDirs {
+		if strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package jail
+
+import (
+	"os/user"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPHPSandboxArgs(t *testing.T) {
+	u := &user.User{Username: "alice", Uid: "1001", Gid: "1001", HomeDir: "/home/alice"}
+	present := map[string]bool{"/usr": true, "/lib": true, "/etc/ssl": true, "/etc/resolv.conf": true, "/run/mysqld": true}
+	exists := func(p string) bool { return present[p] }
+
+	argv := []string{"/opt/php/frankenphp-8.3", "run", "--config", "/home/alice/run/Caddyfile.php-8.3"}
+	args := PHPSandboxArgs(u, []string{"/var/log/fastcp/sites/s1"}, 3, argv, exists)
+	joined := strings.Join(args, " ")
+
+	for _, want := range []string{
+		"--unshare-all --share-net",
+		"--ro-bind /usr /usr",
+		"--ro-bind /etc/resolv.conf /etc/resolv.conf",
+		"--ro-bind " + PHPSandboxDir + "/alice/etc/passwd /etc/passwd",
+		"--ro-bind /opt/php/frankenphp-8.3 /opt/php/frankenphp-8.3",
+		"--ro-bind /run/mysqld /run/mysqld",
+		"--tmpfs /tmp",
+		"--bind /home/alice /home/alice",
+		"--bind /var/log/fastcp/sites/s1 /var/log/fastcp/sites/s1",
+		"--seccomp 3",
+		"-- /opt/php/frankenphp-8.3 run --config /home/alice/run/Caddyfile.php-8.3",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected %q in %s", want, joined)
+		}
+	}
+	// Other users, /root, /var and the rest of /etc stay hidden
+	for _, hidden := range []string{"/home ", "/root", "/etc ", "/var/lib", "--die-with-parent"} {
+		if strings.Contains(joined+" ", hidden) {
+			t.Errorf("unexpected %q in %s", hidden, joined)
+		}
+	}
+
+	// Binaries in system directories are already visible; no filter, no --seccomp
+	args = PHPSandboxArgs(u, nil, -1, []string{"/usr/local/bin/frankenphp", "run"}, exists)
+	if slices.Contains(args, "--seccomp") || strings.Count(strings.Join(args, " "), "/usr/local/bin/frankenphp") != 1 {
+		t.Errorf("unexpected args %v", args)
+	}
+}
@@ -0,0 +1,134 @@
+//go:build linux && (amd64 || arm64)
+
+package jail
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// deniedSyscalls fail with EPERM in the PHP sandbox: they load kernel
+// code, change the system, reach into other processes or build new
+// namespaces to escape the sandbox from. PHP and the tools it runs never
+// need them.
+var deniedSyscalls = []uint32{
+	unix.SYS_ACCT,
+	unix.SYS_ADD_KEY,
+	unix.SYS_ADJTIMEX,
+	unix.SYS_BPF,
+	unix.SYS_CLOCK_ADJTIME,
+	unix.SYS_CLOCK_SETTIME,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_FSCONFIG,
+	unix.SYS_FSMOUNT,
+	unix.SYS_FSOPEN,
+	unix.SYS_FSPICK,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEYCTL,
+	unix.SYS_LOOKUP_DCOOKIE,
+	unix.SYS_MOUNT,
+	unix.SYS_MOUNT_SETATTR,
+	unix.SYS_MOVE_MOUNT,
+	unix.SYS_NAME_TO_HANDLE_AT,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_OPEN_TREE,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_PTRACE,
+	unix.SYS_QUOTACTL,
+	unix.SYS_REBOOT,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_SETNS,
+	unix.SYS_SETTIMEOFDAY,
+	unix.SYS_SWAPOFF,
+	unix.SYS_SWAPON,
+	unix.SYS_SYSLOG,
+	unix.SYS_UMOUNT2,
+	unix.SYS_UNSHARE,
+	unix.SYS_USERFAULTFD,
+	unix.SYS_VHANGUP,
+}
+
+// newNamespaceFlags make clone create namespaces
+const newNamespaceFlags = unix.CLONE_NEWNS | unix.CLONE_NEWCGROUP | unix.CLONE_NEWUTS |
+	unix.CLONE_NEWIPC | unix.CLONE_NEWUSER | unix.CLONE_NEWPID | unix.CLONE_NEWNET
+
+// SeccompFilter returns the seccomp filter of the PHP sandbox as a
+// compiled BPF program, the format bubblewrap's --seccomp reads. Denied
+// system calls fail with EPERM, clone3 fails with ENOSYS so the C library
+// falls back to clone, whose flags can be checked. Other architectures,
+// such as 32-bit calls on a 64-bit kernel, are killed.
+func SeccompFilter() ([]byte, error) {
+	denied := append(append([]uint32(nil), deniedSyscalls...), archDeniedSyscalls...)
+
+	// Checks jump forward to the return instructions at the end
+	var prog []bpf.Instruction
+	prog = append(prog,
+		bpf.LoadAbsolute{Off: 4, Size: 4}, // arch
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: auditArch},
+		bpf.LoadAbsolute{Off: 0, Size: 4}, // syscall number
+	)
+	archCheck := 1
+	var toEPERM []int
+	if syscallLimit > 0 {
+		toEPERM = append(toEPERM, len(prog))
+		prog = append(prog, bpf.JumpIf{Cond: bpf.JumpGreaterOrEqual, Val: syscallLimit})
+	}
+	for _, nr := range denied {
+		toEPERM = append(toEPERM, len(prog))
+		prog = append(prog, bpf.JumpIf{Cond: bpf.JumpEqual, Val: nr})
+	}
+	clone3 := len(prog)
+	prog = append(prog,
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.SYS_CLONE3},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.SYS_CLONE, SkipTrue: 1},
+		bpf.RetConstant{Val: unix.SECCOMP_RET_ALLOW},
+		bpf.LoadAbsolute{Off: 16, Size: 4}, // low half of the flags argument
+	)
+	toEPERM = append(toEPERM, len(prog))
+	prog = append(prog,
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: newNamespaceFlags},
+		bpf.RetConstant{Val: unix.SECCOMP_RET_ALLOW},
+	)
+	eperm := len(prog)
+	prog = append(prog,
+		bpf.RetConstant{Val: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		bpf.RetConstant{Val: unix.SECCOMP_RET_ERRNO | uint32(unix.ENOSYS)},
+		bpf.RetConstant{Val: unix.SECCOMP_RET_KILL_PROCESS},
+	)
+	enosys, kill := eperm+1, eperm+2
+
+	jump := func(i, target int, onTrue bool) {
+		ins := prog[i].(bpf.JumpIf)
+		if onTrue {
+			ins.SkipTrue = uint8(target - i - 1)
+		} else {
+			ins.SkipFalse = uint8(target - i - 1)
+		}
+		prog[i] = ins
+	}
+	jump(archCheck, kill, false)
+	for _, i := range toEPERM {
+		jump(i, eperm, true)
+	}
+	jump(clone3, enosys, true)
+
+	raw, err := bpf.Assemble(prog)
+	if err != nil {
+		return nil, err
+	}
+	// struct sock_filter in the byte order of the kernel
+	var buf bytes.Buffer
+	for _, ins := range raw {
+		binary.Write(&buf, binary.NativeEndian, ins)
+	}
+	return buf.Bytes(), nil
+}
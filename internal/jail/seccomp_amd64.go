@@ -0,0 +1,17 @@
+//go:build linux
+
+package jail
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_X86_64
+
+// syscallLimit is where x32 system calls start; they bypass checks on the
+// 64-bit numbers and are denied
+const syscallLimit = 0x40000000
+
+// archDeniedSyscalls are denied on this architecture only
+var archDeniedSyscalls = []uint32{
+	unix.SYS_IOPERM,
+	unix.SYS_IOPL,
+}
@@ -0,0 +1,13 @@
+//go:build linux
+
+package jail
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_AARCH64
+
+// syscallLimit is unused, there is no second ABI on arm64
+const syscallLimit = 0
+
+// archDeniedSyscalls are denied on this architecture only
+var archDeniedSyscalls []uint32
@@ -0,0 +1,10 @@
+//go:build !linux || !(amd64 || arm64)
+
+package jail
+
+import "errors"
+
+// SeccompFilter is only supported on Linux on amd64 and arm64
+func SeccompFilter() ([]byte, error) {
+	return nil, errors.New("seccomp filters are not supported on this system")
+}
@@ -0,0 +1,131 @@
+//go:build linux && (amd64 || arm64)
+
+package jail
+
+import (
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+func TestSeccompFilter(t *testing.T) {
+	data, err := SeccompFilter()
+	if err != nil {
+		t.Fatalf("SeccompFilter failed: %v", err)
+	}
+
+	// Run the program on struct seccomp_data. The VM loads big-endian
+	// words, the kernel native ones, so decode and encode accordingly.
+	var raw []bpf.RawInstruction
+	for i := 0; i+8 <= len(data); i += 8 {
+		raw = append(raw, bpf.RawInstruction{
+			Op: binary.NativeEndian.Uint16(data[i:]),
+			Jt: data[i+2],
+			Jf: data[i+3],
+			K:  binary.NativeEndian.Uint32(data[i+4:]),
+		})
+	}
+	prog, ok := bpf.Disassemble(raw)
+	if !ok {
+		t.Fatal("filter does not disassemble")
+	}
+	vm, err := bpf.NewVM(prog)
+	if err != nil {
+		t.Fatalf("invalid filter: %v", err)
+	}
+	run := func(arch, nr uint32, flags uint32) uint32 {
+		in := make([]byte, 64)
+		binary.BigEndian.PutUint32(in[0:], nr)
+		binary.BigEndian.PutUint32(in[4:], arch)
+		binary.BigEndian.PutUint32(in[16:], flags)
+		out, err := vm.Run(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return uint32(out)
+	}
+
+	allow := uint32(unix.SECCOMP_RET_ALLOW)
+	eperm := uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))
+	for _, tc := range []struct {
+		name  string
+		arch  uint32
+		nr    uint32
+		flags uint32
+		want  uint32
+	}{
+		{"read", auditArch, unix.SYS_READ, 0, allow},
+		{"mount", auditArch, unix.SYS_MOUNT, 0, eperm},
+		{"ptrace", auditArch, unix.SYS_PTRACE, 0, eperm},
+		{"unshare", auditArch, unix.SYS_UNSHARE, 0, eperm},
+		{"clone thread", auditArch, unix.SYS_CLONE, unix.CLONE_VM | unix.CLONE_THREAD, allow},
+		{"clone namespace", auditArch, unix.SYS_CLONE, unix.CLONE_NEWUSER, eperm},
+		{"clone3", auditArch, unix.SYS_CLONE3, 0, unix.SECCOMP_RET_ERRNO | uint32(unix.ENOSYS)},
+		{"other arch", 0x40000003, unix.SYS_READ, 0, unix.SECCOMP_RET_KILL_PROCESS},
+	} {
+		if got := run(tc.arch, tc.nr, tc.flags); got != tc.want {
+			t.Errorf("%s: expected %#x, got %#x", tc.name, tc.want, got)
+		}
+	}
+}
+
+// TestSeccompFilterKernel loads the filter in a child process and checks
+// the kernel applies it
+func TestSeccompFilterKernel(t *testing.T) {
+	if os.Getenv("FASTCP_SECCOMP_CHILD") == "1" {
+		seccompChild()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSeccompFilterKernel$")
+	cmd.Env = append(os.Environ(), "FASTCP_SECCOMP_CHILD=1")
+	out, _ := cmd.CombinedOutput()
+	result := string(out)
+	if strings.Contains(result, "skip:") {
+		t.Skipf("cannot load seccomp filters here: %s", result)
+	}
+	for _, want := range []string{"unshare: operation not permitted", "clone namespace: fork/exec /bin/true: operation not permitted", "exec: ok"} {
+		if !strings.Contains(result, want) {
+			t.Errorf("expected %q, got:\n%s", want, result)
+		}
+	}
+}
+
+func seccompChild() {
+	data, err := SeccompFilter()
+	if err != nil {
+		os.Stdout.WriteString("skip: " + err.Error() + "\n")
+		os.Exit(0)
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		os.Stdout.WriteString("skip: " + err.Error() + "\n")
+		os.Exit(0)
+	}
+	prog := unix.SockFprog{Len: uint16(len(data) / 8), Filter: (*unix.SockFilter)(unsafe.Pointer(&data[0]))}
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		os.Stdout.WriteString("skip: " + errno.Error() + "\n")
+		os.Exit(0)
+	}
+
+	report := func(name string, err error) {
+		if err == nil {
+			os.Stdout.WriteString(name + ": ok\n")
+		} else {
+			os.Stdout.WriteString(name + ": " + err.Error() + "\n")
+		}
+	}
+	report("unshare", unix.Unshare(unix.CLONE_NEWUTS))
+	cmd := exec.Command("/bin/true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUTS}
+	report("clone namespace", cmd.Run())
+	// Plain processes still start, clone3 falls back to clone
+	report("exec", exec.Command("/bin/true").Run())
+	os.Exit(0)
+}
@@ -0,0 +1,260 @@
+package jail
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	// ShellJailGroup is the group for users with a jailed shell
+	ShellJailGroup = "fastcp-shell"
+	// ShellCommand is forced by sshd for ShellJailGroup (see SSHJailConfig)
+	// and starts the sandbox
+	ShellCommand = "/usr/local/bin/fastcp jail-shell"
+	// ShellToolsPath is where the tools of the user appear in the sandbox
+	ShellToolsPath = "/opt/fastcp/bin"
+)
+
+var (
+	// ShellDir holds the sandbox environment of each user, one directory
+	// per user with bin/ for the tools and etc/ for passwd and group
+	ShellDir = "/usr/local/lib/fastcp/shell"
+	// BwrapPath is bubblewrap, which builds the sandbox
+	BwrapPath = "/usr/bin/bwrap"
+	// SFTPServerPath serves sftp sessions inside the sandbox
+	SFTPServerPath = "/usr/lib/openssh/sftp-server"
+)
+
+// shellSystemDirs are bound read-only, for the shell, git, the mysql client
+// and the libraries they need. Missing ones are skipped.
+var shellSystemDirs = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64"}
+
+// shellEtcFiles are the parts of /etc the tools need; the rest of /etc,
+// other homes, /root and /var are not visible in the sandbox
+var shellEtcFiles = []string{
+	"/etc/alternatives",
+	"/etc/bash.bashrc",
+	"/etc/ca-certificates",
+	"/etc/gitconfig",
+	"/etc/hosts",
+	"/etc/inputrc",
+	"/etc/ld.so.cache",
+	"/etc/ld.so.conf",
+	"/etc/ld.so.conf.d",
+	"/etc/localtime",
+	"/etc/mysql",
+	"/etc/nsswitch.conf",
+	"/etc/profile",
+	"/etc/resolv.conf",
+	"/etc/ssl",
+	"/etc/terminfo",
+}
+
+// SetupShellJail gives a user an interactive shell in a sandbox instead of
+// SFTP only. phpBinaries maps the PHP versions the user may use to their
+// FrankenPHP binaries; the first version in sort order becomes `php`.
+func SetupShellJail(username string, phpBinaries map[string]string) error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	if username == "" || username == "root" {
+		return fmt.Errorf("cannot jail root or empty username")
+	}
+
+	u, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+	if err := writeShellEnv(u, phpBinaries); err != nil {
+		return err
+	}
+
+	// The sandbox does not chroot, so the home belongs to the user again
+	if out, err := exec.Command("chown", fmt.Sprintf("%s:%s", username, username), u.HomeDir).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to chown %s: %s", u.HomeDir, strings.TrimSpace(string(out)))
+	}
+	if out, err := exec.Command("chmod", "750", u.HomeDir).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to chmod %s: %s", u.HomeDir, strings.TrimSpace(string(out)))
+	}
+
+	// A user is in one jail at a time
+	_ = exec.Command("gpasswd", "-d", username, JailGroup).Run()
+	_ = exec.Command("groupadd", "-f", ShellJailGroup).Run()
+	if out, err := exec.Command("usermod", "-aG", ShellJailGroup, username).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add %s to %s: %s", username, ShellJailGroup, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// RemoveUserFromShellJail takes the jailed shell away again
+func RemoveUserFromShellJail(username string) error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	_ = exec.Command("gpasswd", "-d", username, ShellJailGroup).Run()
+	return os.RemoveAll(filepath.Join(ShellDir, username))
+}
+
+// IsUserShellJailed checks if a user is in the jailed shell group
+func IsUserShellJailed(username string) bool {
+	return inGroup(username, ShellJailGroup)
+}
+
+// writeShellEnv writes the tools and account files of a user's sandbox.
+// The files belong to root so the user cannot change them.
+func writeShellEnv(u *user.User, phpBinaries map[string]string) error {
+	dir := filepath.Join(ShellDir, u.Username)
+	bin := filepath.Join(dir, "bin")
+	etc := filepath.Join(dir, "etc")
+
+	// Start over so versions no longer allowed disappear
+	if err := os.RemoveAll(bin); err != nil {
+		return err
+	}
+	for _, d := range []string{bin, etc} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+
+	versions := make([]string, 0, len(phpBinaries))
+	for version := range phpBinaries {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	for i, version := range versions {
+		script := fmt.Sprintf("#!/bin/sh\nexec %s php-cli \"$@\"\n", phpBinaries[version])
+		names := []string{"php" + version}
+		if i == 0 {
+			names = append(names, "php")
+		}
+		for _, name := range names {
+			if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writeAccountFiles(u, etc)
+}
+
+// writeAccountFiles writes the passwd and group files of a sandbox, in
+// which only the user and root are known
+func writeAccountFiles(u *user.User, etc string) error {
+	group := u.Username
+	if g, err := user.LookupGroupId(u.Gid); err == nil {
+		group = g.Name
+	}
+	passwd := fmt.Sprintf("root:x:0:0:root:/root:/usr/sbin/nologin\n%s:x:%s:%s::%s:/bin/bash\n", u.Username, u.Uid, u.Gid, u.HomeDir)
+	groups := fmt.Sprintf("root:x:0:\n%s:x:%s:\n", group, u.Gid)
+	if err := os.WriteFile(filepath.Join(etc, "passwd"), []byte(passwd), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(etc, "group"), []byte(groups), 0644)
+}
+
+// ShellArgs returns the bubblewrap arguments running command, or a login
+// shell when command is empty, in the sandbox of a user, starting in dir
+// (the www directory or home when empty). The user sees
+// their home directory read-write, the system directories read-only and
+// nothing of other users; processes, IPC and the hostname are private.
+func ShellArgs(u *user.User, command, dir string, exists func(string) bool) []string {
+	env := filepath.Join(ShellDir, u.Username)
+	if dir == "" {
+		dir = shellWorkDir(u.HomeDir, exists)
+	}
+	args := []string{
+		"--die-with-parent",
+		"--unshare-all",
+		"--share-net",
+		"--hostname", "fastcp",
+	}
+	for _, dir := range shellSystemDirs {
+		if exists(dir) {
+			args = append(args, "--ro-bind", dir, dir)
+		}
+	}
+	for _, path := range shellEtcFiles {
+		if exists(path) {
+			args = append(args, "--ro-bind", path, path)
+		}
+	}
+	args = append(args,
+		"--ro-bind", filepath.Join(env, "etc", "passwd"), "/etc/passwd",
+		"--ro-bind", filepath.Join(env, "etc", "group"), "/etc/group",
+		"--ro-bind", filepath.Join(env, "bin"), ShellToolsPath,
+	)
+	// The MySQL socket, for the mysql client
+	if exists("/run/mysqld") {
+		args = append(args, "--ro-bind", "/run/mysqld", "/run/mysqld")
+	}
+	args = append(args,
+		"--proc", "/proc",
+		"--dev", "/dev",
+		"--tmpfs", "/tmp",
+		"--bind", u.HomeDir, u.HomeDir,
+		"--chdir", dir,
+		"--clearenv",
+		"--setenv", "HOME", u.HomeDir,
+		"--setenv", "USER", u.Username,
+		"--setenv", "LOGNAME", u.Username,
+		"--setenv", "SHELL", "/bin/bash",
+		"--setenv", "PATH", ShellToolsPath+":/usr/local/bin:/usr/bin:/bin",
+		"--setenv", "TERM", termOrDefault(),
+		"--",
+	)
+	if command == "" {
+		return append(args, "/bin/bash", "-l")
+	}
+	return append(args, "/bin/bash", "-c", command)
+}
+
+func shellWorkDir(home string, exists func(string) bool) string {
+	if www := filepath.Join(home, "www"); exists(www) {
+		return www
+	}
+	return home
+}
+
+func termOrDefault() string {
+	if term := os.Getenv("TERM"); term != "" {
+		return term
+	}
+	return "xterm"
+}
+
+// ExecShell replaces the process with the sandbox of the current user. It
+// runs as the user, from the ForceCommand sshd applies to ShellJailGroup;
+// command is SSH_ORIGINAL_COMMAND, which also carries sftp sessions.
+func ExecShell(command string) error {
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	if u.Uid == "0" {
+		return fmt.Errorf("jail-shell is not for root")
+	}
+	if _, err := os.Stat(filepath.Join(ShellDir, u.Username)); err != nil {
+		return fmt.Errorf("no jailed shell is set up for %s", u.Username)
+	}
+
+	// internal-sftp only exists inside sshd
+	if rest, ok := strings.CutPrefix(command, "internal-sftp"); ok {
+		command = SFTPServerPath + rest
+	}
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	argv := append([]string{BwrapPath}, ShellArgs(u, command, "", exists)...)
+	return syscall.Exec(BwrapPath, argv, os.Environ())
+}
@@ -0,0 +1,111 @@
+package jail
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestShellArgs(t *testing.T) {
+	u := &user.User{Username: "alice", Uid: "1001", Gid: "1001", HomeDir: "/home/alice"}
+	present := map[string]bool{"/usr": true, "/lib": true, "/etc/ssl": true, "/run/mysqld": true, "/home/alice/www": true}
+	exists := func(p string) bool { return present[p] }
+
+	args := ShellArgs(u, "", "", exists)
+	joined := strings.Join(args, " ")
+
+	for _, want := range []string{
+		"--ro-bind /usr /usr",
+		"--ro-bind /lib /lib",
+		"--ro-bind /etc/ssl /etc/ssl",
+		"--ro-bind /run/mysqld /run/mysqld",
+		"--ro-bind " + filepath.Join(ShellDir, "alice", "etc", "passwd") + " /etc/passwd",
+		"--ro-bind " + filepath.Join(ShellDir, "alice", "bin") + " " + ShellToolsPath,
+		"--bind /home/alice /home/alice",
+		"--chdir /home/alice/www",
+		"--unshare-all --share-net",
+		"--setenv PATH " + ShellToolsPath + ":",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected %q in %s", want, joined)
+		}
+	}
+	// Missing system directories are skipped, /etc is never bound whole
+	if strings.Contains(joined, "/lib64") || slices.Contains(args, "/etc") || strings.Contains(joined, "/root") {
+		t.Errorf("unexpected binds in %s", joined)
+	}
+	if tail := args[len(args)-3:]; !slices.Equal(tail, []string{"--", "/bin/bash", "-l"}) {
+		t.Errorf("expected a login shell, got %v", tail)
+	}
+
+	// Commands, e.g. scp or git over ssh, run through bash
+	args = ShellArgs(u, "git-upload-pack 'repo.git'", "", exists)
+	if tail := args[len(args)-3:]; !slices.Equal(tail, []string{"/bin/bash", "-c", "git-upload-pack 'repo.git'"}) {
+		t.Errorf("expected command to run through bash, got %v", tail)
+	}
+
+	// The web terminal starts in the directory of a site
+	args = ShellArgs(u, "", "/home/alice/www/example.com", exists)
+	if !strings.Contains(strings.Join(args, " "), "--chdir /home/alice/www/example.com ") {
+		t.Errorf("expected the site directory as working directory, got %v", args)
+	}
+}
+
+func TestWriteShellEnv(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skip("no current user")
+	}
+	ShellDir = t.TempDir()
+	defer func() { ShellDir = "/usr/local/lib/fastcp/shell" }()
+
+	err = writeShellEnv(u, map[string]string{
+		"8.3": "/usr/local/bin/frankenphp-8.3",
+		"8.2": "/usr/local/bin/frankenphp-8.2",
+	})
+	if err != nil {
+		t.Fatalf("writeShellEnv failed: %v", err)
+	}
+
+	bin := filepath.Join(ShellDir, u.Username, "bin")
+	for name, binary := range map[string]string{"php": "frankenphp-8.2", "php8.2": "frankenphp-8.2", "php8.3": "frankenphp-8.3"} {
+		data, err := os.ReadFile(filepath.Join(bin, name))
+		if err != nil || !strings.Contains(string(data), binary+" php-cli") {
+			t.Errorf("unexpected %s shim: %q, %v", name, data, err)
+		}
+	}
+
+	passwd, _ := os.ReadFile(filepath.Join(ShellDir, u.Username, "etc", "passwd"))
+	if lines := strings.Split(strings.TrimSpace(string(passwd)), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], u.Username+":x:"+u.Uid+":") {
+		t.Errorf("unexpected passwd:\n%s", passwd)
+	}
+
+	// Versions no longer allowed disappear
+	if err := writeShellEnv(u, map[string]string{"8.3": "/usr/local/bin/frankenphp-8.3"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(bin, "php8.2")); !os.IsNotExist(err) {
+		t.Error("expected php8.2 removed")
+	}
+}
+
+func TestHasGroup(t *testing.T) {
+	for _, c := range []struct {
+		output, group string
+		want          bool
+	}{
+		{"alice : alice fastcp-shell\n", ShellJailGroup, true},
+		{"alice : alice fastcp-shell-old fastcp-jailers\n", ShellJailGroup, false},
+		{"alice : alice fastcp-jailers\n", JailGroup, false},
+		{"alice fastcp-jail\n", JailGroup, true},
+		// A user named like the group is not a member
+		{"fastcp-shell : users\n", ShellJailGroup, false},
+	} {
+		if got := hasGroup(c.output, c.group); got != c.want {
+			t.Errorf("hasGroup(%q, %q) = %v, want %v", c.output, c.group, got, c.want)
+		}
+	}
+}
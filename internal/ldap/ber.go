@@ -0,0 +1,216 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// BER tags used by LDAP (RFC 4511). The class and constructed bits are
+// part of the tag byte; all LDAP tags fit in the low-tag-number form.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	tagBindRequest       = 0x60
+	tagBindResponse      = 0x61
+	tagUnbindRequest     = 0x42
+	tagSearchRequest     = 0x63
+	tagSearchEntry       = 0x64
+	tagSearchDone        = 0x65
+	tagSearchReference   = 0x73
+	tagExtendedRequest   = 0x77
+	tagExtendedResponse  = 0x78
+	tagSimpleAuth        = 0x80
+	tagExtendedName      = 0x80
+	tagFilterAnd         = 0xa0
+	tagFilterOr          = 0xa1
+	tagFilterNot         = 0xa2
+	tagFilterEquality    = 0xa3
+	tagFilterSubstrings  = 0xa4
+	tagFilterGreater     = 0xa5
+	tagFilterLess        = 0xa6
+	tagFilterPresent     = 0x87
+	tagFilterApprox      = 0xa8
+	tagSubstringInitial  = 0x80
+	tagSubstringAny      = 0x81
+	tagSubstringFinal    = 0x82
+	constructed          = 0x20
+	maxMessageSize       = 16 << 20
+	maxPacketDepth       = 32
+	lengthLongFormPrefix = 0x80
+)
+
+var errMalformed = errors.New("malformed BER data")
+
+// packet is a decoded BER element
+type packet struct {
+	tag      byte
+	data     []byte    // contents of primitive elements
+	children []*packet // elements of constructed elements
+}
+
+// encode returns a BER element with the given tag and contents
+func encode(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	n := len(content)
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	default:
+		var buf []byte
+		for v := n; v > 0; v >>= 8 {
+			buf = append([]byte{byte(v)}, buf...)
+		}
+		out = append(out, lengthLongFormPrefix|byte(len(buf)))
+		out = append(out, buf...)
+	}
+	return append(out, content...)
+}
+
+// sequence returns a constructed element of the encoded children
+func sequence(tag byte, children ...[]byte) []byte {
+	var content []byte
+	for _, c := range children {
+		content = append(content, c...)
+	}
+	return encode(tag, content)
+}
+
+func octetString(s string) []byte {
+	return encode(tagOctetString, []byte(s))
+}
+
+func integer(tag byte, n int) []byte {
+	// Minimal two's complement big-endian encoding
+	var buf []byte
+	v := int64(n)
+	for {
+		buf = append([]byte{byte(v)}, buf...)
+		v >>= 8
+		if (v == 0 && buf[0]&0x80 == 0) || (v == -1 && buf[0]&0x80 != 0) {
+			break
+		}
+	}
+	return encode(tag, buf)
+}
+
+func boolean(b bool) []byte {
+	if b {
+		return encode(tagBoolean, []byte{0xff})
+	}
+	return encode(tagBoolean, []byte{0})
+}
+
+// readPacket reads one top-level element from r
+func readPacket(r *bufio.Reader) (*packet, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := int(first)
+	header := []byte{tag, first}
+	if first&lengthLongFormPrefix != 0 {
+		n := int(first &^ lengthLongFormPrefix)
+		if n == 0 || n > 4 {
+			return nil, errMalformed
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			header = append(header, b)
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxMessageSize {
+		return nil, errors.New("LDAP message too large")
+	}
+
+	buf := make([]byte, len(header)+length)
+	copy(buf, header)
+	if _, err := io.ReadFull(r, buf[len(header):]); err != nil {
+		return nil, err
+	}
+	p, rest, err := parse(buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errMalformed
+	}
+	return p, nil
+}
+
+// parse decodes one element from data and returns the remaining bytes
+func parse(data []byte, depth int) (*packet, []byte, error) {
+	if depth > maxPacketDepth || len(data) < 2 {
+		return nil, nil, errMalformed
+	}
+	tag := data[0]
+	if tag&0x1f == 0x1f {
+		return nil, nil, errMalformed // high tag numbers are not used by LDAP
+	}
+	length := int(data[1])
+	pos := 2
+	if data[1]&lengthLongFormPrefix != 0 {
+		n := int(data[1] &^ lengthLongFormPrefix)
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return nil, nil, errMalformed
+		}
+		length = 0
+		for _, b := range data[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		pos += n
+	}
+	if length < 0 || length > len(data)-pos {
+		return nil, nil, errMalformed
+	}
+
+	content := data[pos : pos+length]
+	p := &packet{tag: tag}
+	if tag&constructed != 0 {
+		for len(content) > 0 {
+			child, rest, err := parse(content, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			p.children = append(p.children, child)
+			content = rest
+		}
+	} else {
+		p.data = content
+	}
+	return p, data[pos+length:], nil
+}
+
+// int returns the value of an INTEGER or ENUMERATED element
+func (p *packet) int() (int, error) {
+	if len(p.data) == 0 || len(p.data) > 4 {
+		return 0, errMalformed
+	}
+	v := int64(int8(p.data[0]))
+	for _, b := range p.data[1:] {
+		v = v<<8 | int64(b)
+	}
+	return int(v), nil
+}
+
+// child returns the i-th child, or nil
+func (p *packet) child(i int) *packet {
+	if i < len(p.children) {
+		return p.children[i]
+	}
+	return nil
+}
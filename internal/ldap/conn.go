@@ -0,0 +1,276 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Search scopes
+const (
+	ScopeBase     = 0
+	ScopeOne      = 1
+	ScopeSubtree  = 2
+	resultSuccess = 0
+	// resultInvalidCredentials is returned for a wrong password or unknown DN
+	resultInvalidCredentials = 49
+	startTLSOID              = "1.3.6.1.4.1.1466.20037"
+)
+
+// ResultError is an LDAP operation that did not succeed
+type ResultError struct {
+	Code    int
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("LDAP result %d: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("LDAP result %d", e.Code)
+}
+
+// Entry is a search result
+type Entry struct {
+	DN         string
+	Attributes map[string][]string // keyed by lowercase attribute name
+}
+
+// Get returns the first value of an attribute
+func (e *Entry) Get(name string) string {
+	if values := e.Attributes[strings.ToLower(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Conn is a connection to an LDAP server
+type Conn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	msgID   int
+	timeout time.Duration
+}
+
+// Dial connects to an ldap:// or ldaps:// URL
+func Dial(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid LDAP URL %q", rawURL)
+	}
+
+	host := u.Host
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, withServerName(tlsConfig, u.Hostname()))
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn, r: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+// StartTLS upgrades a plain connection to TLS
+func (c *Conn) StartTLS(tlsConfig *tls.Config, serverName string) error {
+	op := sequence(tagExtendedRequest, encode(tagExtendedName, []byte(startTLSOID)))
+	resp, err := c.roundTrip(op, tagExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := result(resp); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Client(c.conn, withServerName(tlsConfig, serverName))
+	tlsConn.SetDeadline(time.Now().Add(c.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tlsConn
+	c.r = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind authenticates with a DN and password (simple bind). An empty
+// password would be an unauthenticated bind that servers accept for any
+// DN, so it is refused unless the DN is empty too.
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" && dn != "" {
+		return &ResultError{Code: resultInvalidCredentials, Message: "empty password"}
+	}
+	op := sequence(tagBindRequest,
+		integer(tagInteger, 3),
+		octetString(dn),
+		encode(tagSimpleAuth, []byte(password)),
+	)
+	resp, err := c.roundTrip(op, tagBindResponse)
+	if err != nil {
+		return err
+	}
+	return result(resp)
+}
+
+// Search returns the entries under base matching filter
+func (c *Conn) Search(base string, scope int, filter string, attributes []string, sizeLimit int) ([]Entry, error) {
+	encodedFilter, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var attrs [][]byte
+	for _, a := range attributes {
+		attrs = append(attrs, octetString(a))
+	}
+	op := sequence(tagSearchRequest,
+		octetString(base),
+		integer(tagEnumerated, scope),
+		integer(tagEnumerated, 0), // never dereference aliases
+		integer(tagInteger, sizeLimit),
+		integer(tagInteger, int(c.timeout.Seconds())),
+		boolean(false),
+		encodedFilter,
+		sequence(tagSequence, attrs...),
+	)
+
+	id, err := c.send(op)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for {
+		resp, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.tag {
+		case tagSearchEntry:
+			entry, err := parseEntry(resp)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case tagSearchReference:
+			// Referrals to other servers are not followed
+		case tagSearchDone:
+			return entries, result(resp)
+		default:
+			return nil, errMalformed
+		}
+	}
+}
+
+// Close unbinds and closes the connection
+func (c *Conn) Close() error {
+	c.send(encode(tagUnbindRequest, nil))
+	return c.conn.Close()
+}
+
+// roundTrip sends a request and returns the response operation
+func (c *Conn) roundTrip(op []byte, responseTag byte) (*packet, error) {
+	id, err := c.send(op)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if resp.tag != responseTag {
+		return nil, errMalformed
+	}
+	return resp, nil
+}
+
+// send writes an LDAPMessage and returns its message ID
+func (c *Conn) send(op []byte) (int, error) {
+	c.msgID++
+	msg := sequence(tagSequence, integer(tagInteger, c.msgID), op)
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(msg)
+	return c.msgID, err
+}
+
+// receive reads the next LDAPMessage for a request and returns its
+// operation
+func (c *Conn) receive(id int) (*packet, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	msg, err := readPacket(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if msg.tag != tagSequence || len(msg.children) < 2 {
+		return nil, errMalformed
+	}
+	got, err := msg.children[0].int()
+	if err != nil {
+		return nil, err
+	}
+	if got != id {
+		return nil, errors.New("unexpected LDAP message ID")
+	}
+	return msg.children[1], nil
+}
+
+// result checks the LDAPResult of a response
+func result(resp *packet) error {
+	if len(resp.children) < 3 {
+		return errMalformed
+	}
+	code, err := resp.children[0].int()
+	if err != nil {
+		return err
+	}
+	if code != resultSuccess {
+		return &ResultError{Code: code, Message: string(resp.children[2].data)}
+	}
+	return nil
+}
+
+// parseEntry decodes a SearchResultEntry
+func parseEntry(p *packet) (Entry, error) {
+	if len(p.children) < 2 {
+		return Entry{}, errMalformed
+	}
+	entry := Entry{DN: string(p.children[0].data), Attributes: make(map[string][]string)}
+	for _, attr := range p.children[1].children {
+		if len(attr.children) < 2 {
+			return Entry{}, errMalformed
+		}
+		name := strings.ToLower(string(attr.children[0].data))
+		for _, v := range attr.children[1].children {
+			entry.Attributes[name] = append(entry.Attributes[name], string(v.data))
+		}
+	}
+	return entry, nil
+}
+
+// withServerName returns a TLS config that verifies the given host name
+func withServerName(cfg *tls.Config, serverName string) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg = cfg.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = serverName
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	return cfg
+}
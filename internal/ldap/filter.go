@@ -0,0 +1,166 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidFilter = errors.New("invalid LDAP filter")
+
+// EscapeFilter escapes a value for use in a search filter (RFC 4515), so a
+// username can't change the filter
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter encodes a string filter such as (&(objectClass=person)(uid=j*))
+func compileFilter(filter string) ([]byte, error) {
+	filter = strings.TrimSpace(filter)
+	if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+	encoded, rest, err := parseFilter(filter, 0)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidFilter, rest)
+	}
+	return encoded, nil
+}
+
+// parseFilter parses one parenthesized filter and returns the rest
+func parseFilter(s string, depth int) ([]byte, string, error) {
+	if depth > maxPacketDepth || len(s) < 3 || s[0] != '(' {
+		return nil, "", ErrInvalidFilter
+	}
+	s = s[1:]
+
+	switch s[0] {
+	case '&', '|':
+		tag := byte(tagFilterAnd)
+		if s[0] == '|' {
+			tag = tagFilterOr
+		}
+		s = s[1:]
+		var children [][]byte
+		for len(s) > 0 && s[0] == '(' {
+			child, rest, err := parseFilter(s, depth+1)
+			if err != nil {
+				return nil, "", err
+			}
+			children = append(children, child)
+			s = rest
+		}
+		if len(children) == 0 || len(s) == 0 || s[0] != ')' {
+			return nil, "", ErrInvalidFilter
+		}
+		return sequence(tag, children...), s[1:], nil
+	case '!':
+		child, rest, err := parseFilter(s[1:], depth+1)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", ErrInvalidFilter
+		}
+		return sequence(tagFilterNot, child), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", ErrInvalidFilter
+	}
+	item, rest := s[:end], s[end+1:]
+	encoded, err := parseItem(item)
+	if err != nil {
+		return nil, "", err
+	}
+	return encoded, rest, nil
+}
+
+// parseItem encodes a simple comparison such as uid=jdoe or cn=j*n
+func parseItem(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFilter, item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	tag := byte(tagFilterEquality)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = tagFilterGreater, attr[:len(attr)-1]
+	case '<':
+		tag, attr = tagFilterLess, attr[:len(attr)-1]
+	case '~':
+		tag, attr = tagFilterApprox, attr[:len(attr)-1]
+	}
+	if attr == "" || strings.ContainsAny(attr, "()*\\ ") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFilter, item)
+	}
+
+	if tag == tagFilterEquality && value == "*" {
+		return encode(tagFilterPresent, []byte(attr)), nil
+	}
+	if tag == tagFilterEquality && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		var subs [][]byte
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			v, err := unescapeValue(part)
+			if err != nil {
+				return nil, err
+			}
+			subTag := byte(tagSubstringAny)
+			switch i {
+			case 0:
+				subTag = tagSubstringInitial
+			case len(parts) - 1:
+				subTag = tagSubstringFinal
+			}
+			subs = append(subs, encode(subTag, v))
+		}
+		return sequence(tagFilterSubstrings, octetString(attr), sequence(tagSequence, subs...)), nil
+	}
+
+	v, err := unescapeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return sequence(tag, octetString(attr), encode(tagOctetString, v)), nil
+}
+
+// unescapeValue decodes \XX escapes in a filter value
+func unescapeValue(s string) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out = append(out, s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return nil, fmt.Errorf("%w: bad escape in %q", ErrInvalidFilter, s)
+		}
+		b, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad escape in %q", ErrInvalidFilter, s)
+		}
+		out = append(out, b[0])
+		i += 2
+	}
+	return out, nil
+}
@@ -0,0 +1,254 @@
+// Package ldap verifies panel passwords against an LDAP directory. It
+// speaks just enough of the protocol for logins: simple bind, search and
+// StartTLS, over ldap:// or ldaps://.
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found in directory")
+	ErrInvalidCredentials = errors.New("invalid directory credentials")
+	ErrUnavailable        = errors.New("directory unavailable")
+	ErrGroupNotAllowed    = errors.New("user is not in an allowed group")
+)
+
+const (
+	DefaultUserFilter  = "(uid={username})"
+	DefaultGroupFilter = "(|(member={dn})(memberUid={username}))"
+	DefaultTimeout     = 10 * time.Second
+)
+
+// validUsername matches names that can be Unix accounts, so directory
+// entries with other names can't log in or be provisioned
+var validUsername = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// Result is a user the directory verified
+type Result struct {
+	DN     string
+	Email  string
+	Groups []string // Group CNs and DNs
+}
+
+// Validate checks that settings are complete enough to log in
+func Validate(settings models.LDAPSettings) error {
+	if !settings.Enabled {
+		return nil
+	}
+	u, err := url.Parse(settings.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return errors.New("ldap url must be an ldap:// or ldaps:// URL")
+	}
+	if settings.BaseDN == "" {
+		return errors.New("ldap base_dn is required")
+	}
+	if settings.TimeoutSeconds < 0 {
+		return errors.New("ldap timeout_seconds must not be negative")
+	}
+	for _, f := range []string{settings.UserFilter, settings.GroupFilter} {
+		if f == "" {
+			continue
+		}
+		if _, err := compileFilter(strings.NewReplacer("{username}", "x", "{dn}", "x").Replace(f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Authenticate finds a user in the directory and verifies the password by
+// binding as them
+func Authenticate(settings models.LDAPSettings, username, password string) (*Result, error) {
+	if !validUsername.MatchString(username) {
+		return nil, ErrUserNotFound
+	}
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := connect(settings)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(settings.BindDN, settings.BindPassword); err != nil {
+		return nil, fmt.Errorf("%w: service bind failed: %v", ErrUnavailable, err)
+	}
+
+	filter := settings.UserFilter
+	if filter == "" {
+		filter = DefaultUserFilter
+	}
+	filter = strings.ReplaceAll(filter, "{username}", EscapeFilter(username))
+	entries, err := conn.Search(settings.BaseDN, ScopeSubtree, filter, []string{"mail", "memberOf"}, 2)
+	if err != nil {
+		return nil, fmt.Errorf("%w: user search failed: %v", ErrUnavailable, err)
+	}
+	switch len(entries) {
+	case 0:
+		return nil, ErrUserNotFound
+	case 1:
+	default:
+		return nil, fmt.Errorf("%w: filter matches several entries", ErrInvalidCredentials)
+	}
+	entry := entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		var resErr *ResultError
+		if errors.As(err, &resErr) && resErr.Code == resultInvalidCredentials {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	res := &Result{DN: entry.DN, Email: entry.Get("mail")}
+	for _, dn := range entry.Attributes["memberof"] {
+		res.Groups = append(res.Groups, dn, groupCN(dn))
+	}
+
+	if settings.GroupBaseDN != "" {
+		// Group searches use the service account again
+		if err := conn.Bind(settings.BindDN, settings.BindPassword); err != nil {
+			return nil, fmt.Errorf("%w: service bind failed: %v", ErrUnavailable, err)
+		}
+		groupFilter := settings.GroupFilter
+		if groupFilter == "" {
+			groupFilter = DefaultGroupFilter
+		}
+		groupFilter = strings.NewReplacer(
+			"{username}", EscapeFilter(username),
+			"{dn}", EscapeFilter(entry.DN),
+		).Replace(groupFilter)
+		groups, err := conn.Search(settings.GroupBaseDN, ScopeSubtree, groupFilter, []string{"cn"}, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%w: group search failed: %v", ErrUnavailable, err)
+		}
+		for _, g := range groups {
+			res.Groups = append(res.Groups, g.DN, g.Get("cn"))
+		}
+	}
+	return res, nil
+}
+
+// Role returns the role the user's groups map to, or "" when no role
+// groups are configured and the Unix groups decide. It fails when the user
+// is not in an allowed group.
+func Role(settings models.LDAPSettings, res *Result) (string, error) {
+	inAny := func(list []string) bool {
+		for _, want := range list {
+			for _, g := range res.Groups {
+				if g != "" && strings.EqualFold(g, want) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	admin, reseller := inAny(settings.AdminGroups), inAny(settings.ResellerGroups)
+	if len(settings.AllowedGroups) > 0 && !admin && !reseller && !inAny(settings.AllowedGroups) {
+		return "", ErrGroupNotAllowed
+	}
+	if len(settings.AdminGroups) == 0 && len(settings.ResellerGroups) == 0 {
+		return "", nil
+	}
+	switch {
+	case admin:
+		return "admin", nil
+	case reseller:
+		return "reseller", nil
+	}
+	return "user", nil
+}
+
+// Authenticator returns an auth.DirectoryAuthenticator that uses the
+// current settings. While LDAP is disabled every user falls through to the
+// local accounts.
+func Authenticator(settings func() models.LDAPSettings) auth.DirectoryAuthenticator {
+	return func(username, password string) (*auth.DirectoryUser, error) {
+		s := settings()
+		if !s.Enabled {
+			return nil, auth.ErrDirectoryUserNotFound
+		}
+
+		res, err := Authenticate(s, username, password)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrUserNotFound):
+				return nil, auth.ErrDirectoryUserNotFound
+			case errors.Is(err, ErrUnavailable):
+				return nil, fmt.Errorf("%w: %v", auth.ErrDirectoryUnavailable, err)
+			}
+			return nil, auth.ErrInvalidCredentials
+		}
+
+		role, err := Role(s, res)
+		if err != nil {
+			return nil, auth.ErrUserNotAllowed
+		}
+		return &auth.DirectoryUser{
+			Username:  username,
+			Email:     res.Email,
+			Role:      role,
+			Provision: s.AutoProvision,
+			Trusted:   slices.Contains(s.TrustedAccounts, username),
+		}, nil
+	}
+}
+
+// connect dials the directory and starts TLS as configured
+func connect(settings models.LDAPSettings) (*Conn, error) {
+	timeout := DefaultTimeout
+	if settings.TimeoutSeconds > 0 {
+		timeout = time.Duration(settings.TimeoutSeconds) * time.Second
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: settings.InsecureSkipVerify}
+	if settings.CACertFile != "" {
+		pem, err := os.ReadFile(settings.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", settings.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	conn, err := Dial(settings.URL, tlsConfig, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if settings.StartTLS && strings.HasPrefix(settings.URL, "ldap://") {
+		u, _ := url.Parse(settings.URL)
+		if err := conn.StartTLS(tlsConfig, u.Hostname()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// groupCN returns the CN of a group DN such as cn=admins,ou=groups,dc=example
+func groupCN(dn string) string {
+	first, _, _ := strings.Cut(dn, ",")
+	if name, value, ok := strings.Cut(first, "="); ok && strings.EqualFold(strings.TrimSpace(name), "cn") {
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
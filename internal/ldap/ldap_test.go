@@ -0,0 +1,352 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+type mockEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// mockServer is a minimal LDAP server answering binds and searches from a
+// fixed list of entries
+type mockServer struct {
+	ln      net.Listener
+	entries []mockEntry
+}
+
+func newMockServer(t *testing.T, entries []mockEntry) *mockServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockServer{ln: ln, entries: entries}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return m
+}
+
+func (m *mockServer) url() string {
+	return "ldap://" + m.ln.Addr().String()
+}
+
+func (m *mockServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readPacket(r)
+		if err != nil || len(msg.children) < 2 {
+			return
+		}
+		id, _ := msg.children[0].int()
+		op := msg.children[1]
+		reply := func(ops ...[]byte) {
+			for _, o := range ops {
+				conn.Write(sequence(tagSequence, integer(tagInteger, id), o))
+			}
+		}
+		done := func(tag byte, code int) []byte {
+			return sequence(tag, integer(tagEnumerated, code), octetString(""), octetString(""))
+		}
+
+		switch op.tag {
+		case tagBindRequest:
+			dn, password := string(op.child(1).data), string(op.child(2).data)
+			code := resultInvalidCredentials
+			if dn == "" && password == "" {
+				code = resultSuccess
+			}
+			for _, e := range m.entries {
+				if e.dn == dn && e.password == password {
+					code = resultSuccess
+				}
+			}
+			reply(done(tagBindResponse, code))
+		case tagSearchRequest:
+			base := string(op.child(0).data)
+			var out [][]byte
+			for _, e := range m.entries {
+				if !strings.HasSuffix(e.dn, base) || !matches(op.child(6), e) {
+					continue
+				}
+				var attrs [][]byte
+				for name, values := range e.attrs {
+					var vals [][]byte
+					for _, v := range values {
+						vals = append(vals, octetString(v))
+					}
+					attrs = append(attrs, sequence(tagSequence, octetString(name), sequence(tagSet, vals...)))
+				}
+				out = append(out, sequence(tagSearchEntry, octetString(e.dn), sequence(tagSequence, attrs...)))
+			}
+			reply(append(out, done(tagSearchDone, resultSuccess))...)
+		default:
+			return
+		}
+	}
+}
+
+// matches evaluates the filters the tests use against an entry
+func matches(f *packet, e mockEntry) bool {
+	switch f.tag {
+	case tagFilterAnd, tagFilterOr:
+		for _, c := range f.children {
+			if matches(c, e) == (f.tag == tagFilterOr) {
+				return f.tag == tagFilterOr
+			}
+		}
+		return f.tag == tagFilterAnd
+	case tagFilterEquality:
+		attr, value := strings.ToLower(string(f.child(0).data)), string(f.child(1).data)
+		if attr == "objectclass" {
+			return true
+		}
+		for _, v := range e.attrs[attr] {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+	case tagFilterPresent:
+		return len(e.attrs[strings.ToLower(string(f.data))]) > 0
+	}
+	return false
+}
+
+func testDirectory() []mockEntry {
+	return []mockEntry{
+		{dn: "cn=svc,dc=example,dc=com", password: "svcpass"},
+		{
+			dn:       "uid=alice,ou=people,dc=example,dc=com",
+			password: "alicepass",
+			attrs: map[string][]string{
+				"uid":      {"alice"},
+				"mail":     {"alice@example.com"},
+				"memberof": {"cn=staff,ou=groups,dc=example,dc=com"},
+			},
+		},
+		{
+			dn:       "uid=bob,ou=people,dc=example,dc=com",
+			password: "bobpass",
+			attrs:    map[string][]string{"uid": {"bob"}},
+		},
+		{
+			dn:    "cn=panel-admins,ou=groups,dc=example,dc=com",
+			attrs: map[string][]string{"cn": {"panel-admins"}, "memberuid": {"alice"}},
+		},
+	}
+}
+
+func testSettings(url string) models.LDAPSettings {
+	return models.LDAPSettings{
+		Enabled:      true,
+		URL:          url,
+		BindDN:       "cn=svc,dc=example,dc=com",
+		BindPassword: "svcpass",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		GroupBaseDN:  "ou=groups,dc=example,dc=com",
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	m := newMockServer(t, testDirectory())
+	settings := testSettings(m.url())
+
+	res, err := Authenticate(settings, "alice", "alicepass")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if res.DN != "uid=alice,ou=people,dc=example,dc=com" || res.Email != "alice@example.com" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	want := map[string]bool{"staff": false, "panel-admins": false}
+	for _, g := range res.Groups {
+		if _, ok := want[g]; ok {
+			want[g] = true
+		}
+	}
+	for g, found := range want {
+		if !found {
+			t.Errorf("group %s missing from %v", g, res.Groups)
+		}
+	}
+
+	if _, err := Authenticate(settings, "alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := Authenticate(settings, "alice", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected empty password to be refused, got %v", err)
+	}
+	if _, err := Authenticate(settings, "carol", "x"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := Authenticate(settings, "*", "x"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected wildcard username to be refused, got %v", err)
+	}
+
+	settings.BindPassword = "wrong"
+	if _, err := Authenticate(settings, "alice", "alicepass"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected failed service bind to be ErrUnavailable, got %v", err)
+	}
+}
+
+func TestRole(t *testing.T) {
+	res := &Result{Groups: []string{"cn=staff,ou=groups,dc=example,dc=com", "staff"}}
+	settings := models.LDAPSettings{}
+
+	if role, err := Role(settings, res); err != nil || role != "" {
+		t.Fatalf("expected Unix groups to decide, got %q, %v", role, err)
+	}
+
+	settings.AdminGroups = []string{"Panel-Admins"}
+	settings.ResellerGroups = []string{"STAFF"}
+	if role, err := Role(settings, res); err != nil || role != "reseller" {
+		t.Fatalf("expected reseller, got %q, %v", role, err)
+	}
+
+	settings.ResellerGroups = nil
+	if role, err := Role(settings, res); err != nil || role != "user" {
+		t.Fatalf("expected user, got %q, %v", role, err)
+	}
+
+	settings.AllowedGroups = []string{"cn=hosting,ou=groups,dc=example,dc=com"}
+	if _, err := Role(settings, res); !errors.Is(err, ErrGroupNotAllowed) {
+		t.Fatalf("expected ErrGroupNotAllowed, got %v", err)
+	}
+	res.Groups = append(res.Groups, "cn=panel-admins,ou=groups,dc=example,dc=com", "panel-admins")
+	if role, err := Role(settings, res); err != nil || role != "admin" {
+		t.Fatalf("expected admin groups to be allowed, got %q, %v", role, err)
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	m := newMockServer(t, testDirectory())
+	settings := testSettings(m.url())
+	settings.AdminGroups = []string{"panel-admins"}
+	settings.AutoProvision = true
+	verify := Authenticator(func() models.LDAPSettings { return settings })
+
+	du, err := verify("alice", "alicepass")
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if du.Role != "admin" || !du.Provision || du.Email != "alice@example.com" {
+		t.Fatalf("unexpected directory user: %+v", du)
+	}
+	settings.TrustedAccounts = []string{"alice"}
+	if du, err := verify("alice", "alicepass"); err != nil || !du.Trusted {
+		t.Fatalf("expected alice to be trusted, got %+v %v", du, err)
+	}
+	if _, err := verify("alice", "wrong"); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("expected auth.ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := verify("nobody", "x"); !errors.Is(err, auth.ErrDirectoryUserNotFound) {
+		t.Fatalf("expected auth.ErrDirectoryUserNotFound, got %v", err)
+	}
+
+	settings.AllowedGroups = []string{"staff"}
+	if _, err := verify("bob", "bobpass"); !errors.Is(err, auth.ErrUserNotAllowed) {
+		t.Fatalf("expected auth.ErrUserNotAllowed, got %v", err)
+	}
+
+	settings.Enabled = false
+	if _, err := verify("alice", "alicepass"); !errors.Is(err, auth.ErrDirectoryUserNotFound) {
+		t.Fatalf("expected disabled directory to fall through, got %v", err)
+	}
+
+	// An unreachable server falls back to local accounts
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+	settings.Enabled = true
+	settings.URL = "ldap://" + addr
+	if _, err := verify("alice", "alicepass"); !errors.Is(err, auth.ErrDirectoryUnavailable) {
+		t.Fatalf("expected auth.ErrDirectoryUnavailable, got %v", err)
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	got := EscapeFilter("*)(uid=*")
+	if got != `\2a\29\28uid=\2a` {
+		t.Fatalf("EscapeFilter = %q", got)
+	}
+
+	// The escaped value must stay a single equality match
+	encoded, err := compileFilter("(uid=" + got + ")")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, rest, err := parse(encoded, 0)
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("parse: %v", err)
+	}
+	if p.tag != tagFilterEquality || string(p.child(1).data) != "*)(uid=*" {
+		t.Fatalf("escaped filter changed meaning: tag %#x", p.tag)
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	valid := []string{
+		"(uid=jdoe)",
+		"uid=jdoe",
+		"(&(objectClass=person)(|(uid=jdoe)(mail=j*@example.com)))",
+		"(!(nsAccountLock=TRUE))",
+		"(memberOf=*)",
+		"(uidNumber>=1000)",
+	}
+	for _, f := range valid {
+		if _, err := compileFilter(f); err != nil {
+			t.Errorf("compileFilter(%q): %v", f, err)
+		}
+	}
+
+	invalid := []string{"", "(uid=jdoe", "(&)", "(=x)", "(uid=\\zz)", "(uid=a)(uid=b)"}
+	for _, f := range invalid {
+		if _, err := compileFilter(f); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("compileFilter(%q) = %v, want ErrInvalidFilter", f, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(models.LDAPSettings{}); err != nil {
+		t.Fatalf("disabled settings should be valid: %v", err)
+	}
+	settings := testSettings("ldaps://ldap.example.com")
+	if err := Validate(settings); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	bad := settings
+	bad.URL = "https://ldap.example.com"
+	if Validate(bad) == nil {
+		t.Error("expected non-LDAP URL to be rejected")
+	}
+	bad = settings
+	bad.BaseDN = ""
+	if Validate(bad) == nil {
+		t.Error("expected missing base_dn to be rejected")
+	}
+	bad = settings
+	bad.UserFilter = "(uid={username}"
+	if Validate(bad) == nil {
+		t.Error("expected invalid user_filter to be rejected")
+	}
+}
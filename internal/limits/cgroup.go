@@ -0,0 +1,161 @@
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// CgroupRoot is the mount point of the cgroup v2 hierarchy
+var CgroupRoot = "/sys/fs/cgroup"
+
+// SysBlockDevPath maps "MAJ:MIN" device numbers to block devices
+var SysBlockDevPath = "/sys/dev/block"
+
+var ErrCgroupUnavailable = errors.New("cgroup v2 not available")
+
+// CgroupDir returns the cgroup directory of a user
+func CgroupDir(username string) string {
+	return filepath.Join(CgroupRoot, fmt.Sprintf("fastcp-%s", username))
+}
+
+// EnsureCgroup creates the user's cgroup with the cpu, memory, pids and io
+// controllers enabled, and returns its directory
+func (m *Manager) EnsureCgroup(username string) (string, error) {
+	if runtime.GOOS != "linux" || !m.isCgroupV2() {
+		return "", ErrCgroupUnavailable
+	}
+
+	cgroupDir := filepath.Join(m.cgroupPath, fmt.Sprintf("fastcp-%s", username))
+	if err := os.MkdirAll(cgroupDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	// Enable controllers. io is enabled separately since a write naming an
+	// unavailable controller enables none of them.
+	subtree := filepath.Join(m.cgroupPath, "cgroup.subtree_control")
+	if err := os.WriteFile(subtree, []byte("+cpu +memory +pids"), 0644); err != nil {
+		m.logger.Warn("failed to enable cgroup controllers", "error", err)
+	}
+	if err := os.WriteFile(subtree, []byte("+io"), 0644); err != nil {
+		m.logger.Debug("failed to enable io cgroup controller", "error", err)
+	}
+
+	return cgroupDir, nil
+}
+
+// CgroupUsers returns the users that have a FastCP cgroup
+func (m *Manager) CgroupUsers() []string {
+	matches, _ := filepath.Glob(filepath.Join(m.cgroupPath, "fastcp-*"))
+	users := make([]string, 0, len(matches))
+	for _, dir := range matches {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			users = append(users, strings.TrimPrefix(filepath.Base(dir), "fastcp-"))
+		}
+	}
+	return users
+}
+
+// Session hook: SSH/SFTP sessions and cron jobs of FastCP users are moved
+// into the user's cgroup by pam_exec when the PAM session opens
+var SessionHookPath = "/usr/local/lib/fastcp/cgroup-session.sh"
+
+const sessionMarker = "# FastCP cgroup session"
+
+// PAMServices are the PAM service files the session hook is added to
+var PAMServices = []string{"/etc/pam.d/sshd", "/etc/pam.d/cron", "/etc/pam.d/crond"}
+
+var sessionHookScript = `#!/bin/sh
+# Installed by FastCP: moves SSH/SFTP sessions and cron jobs of FastCP
+# users into the user's cgroup so resource limits apply to them.
+[ "$PAM_TYPE" = "open_session" ] || exit 0
+case "$PAM_USER" in
+	""|*[!a-zA-Z0-9._-]*) exit 0 ;;
+esac
+cg="` + CgroupRoot + `/fastcp-$PAM_USER"
+[ -d "$cg" ] || exit 0
+# The parent is the sshd or cron process of the session; its children follow it
+echo "$PPID" > "$cg/cgroup.procs" 2>/dev/null
+exit 0
+`
+
+// SetupSessionHook installs the PAM session hook for sshd and cron
+func SetupSessionHook() error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(SessionHookPath), 0755); err != nil {
+		return fmt.Errorf("failed to create hook directory: %w", err)
+	}
+	if err := os.WriteFile(SessionHookPath, []byte(sessionHookScript), 0755); err != nil {
+		return fmt.Errorf("failed to write session hook: %w", err)
+	}
+
+	for _, path := range PAMServices {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		// Already installed, by FastCP or by hand
+		if strings.Contains(string(data), sessionMarker) || strings.Contains(string(data), SessionHookPath) {
+			continue
+		}
+
+		// Appended last so it runs after pam_systemd placed the session
+		config := strings.TrimRight(string(data), "\n") + "\n\n" + sessionMarker + "\n" +
+			"session optional pam_exec.so quiet " + SessionHookPath + "\n"
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// blockDevice returns the "MAJ:MIN" of the whole disk holding path, as
+// io.max expects. For a partition the parent disk is returned.
+func blockDevice(path string) (string, error) {
+	// The home directory may not exist yet; walk up to an existing parent
+	var st syscall.Stat_t
+	for {
+		err := syscall.Stat(path, &st)
+		if err == nil {
+			break
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		path = parent
+	}
+
+	dev := fmt.Sprintf("%d:%d", unix.Major(uint64(st.Dev)), unix.Minor(uint64(st.Dev)))
+	sysDev := filepath.Join(SysBlockDevPath, dev)
+	if _, err := os.Stat(sysDev); err != nil {
+		// Not a block device (tmpfs, overlay, ...)
+		return "", fmt.Errorf("%s is not a block device", dev)
+	}
+
+	// /sys/dev/block/8:1 -> ../../devices/.../block/sda/sda1
+	if _, err := os.Stat(filepath.Join(sysDev, "partition")); err == nil {
+		target, err := filepath.EvalSymlinks(sysDev)
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(target), "dev"))
+		if err != nil {
+			return "", err
+		}
+		dev = strings.TrimSpace(string(data))
+	}
+	return dev, nil
+}
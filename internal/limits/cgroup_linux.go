@@ -0,0 +1,146 @@
+//go:build linux
+
+package limits
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ProcPath is the mount point of procfs
+var ProcPath = "/proc"
+
+// StartInCgroup starts cmd inside the user's cgroup. The child is cloned
+// directly into the cgroup (CLONE_INTO_CGROUP, Linux 5.7+) so it never runs
+// outside its limits; on older kernels it is moved right after starting.
+// Without cgroup v2 the command is started normally.
+func (m *Manager) StartInCgroup(cmd *exec.Cmd, username string) error {
+	cgroupDir, err := m.EnsureCgroup(username)
+	if err != nil {
+		if err != ErrCgroupUnavailable {
+			m.logger.Warn("failed to prepare cgroup", "user", username, "error", err)
+		}
+		return cmd.Start()
+	}
+
+	if dir, err := os.Open(cgroupDir); err == nil {
+		defer dir.Close()
+		if cloneIntoCgroupSupported(int(dir.Fd())) {
+			if cmd.SysProcAttr == nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{}
+			}
+			cmd.SysProcAttr.UseCgroupFD = true
+			cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+			return cmd.Start()
+		}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := m.AddProcessToCgroup(username, cmd.Process.Pid); err != nil {
+		m.logger.Warn("failed to move process into cgroup", "user", username, "pid", cmd.Process.Pid, "error", err)
+	}
+	return nil
+}
+
+var (
+	cloneProbeMu sync.Mutex
+	// cloneUnsupported is set once the kernel turned CLONE_INTO_CGROUP down
+	cloneUnsupported bool
+
+	// cloneProbe clones a throwaway process into a cgroup
+	cloneProbe = func(cgroupFD int) error {
+		probe := exec.Command("/bin/true")
+		probe.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: cgroupFD}
+		return probe.Run()
+	}
+)
+
+// cloneIntoCgroupSupported reports whether a process can be cloned straight
+// into the cgroup. A failed Start cannot be retried on the same exec.Cmd,
+// so a throwaway process is cloned into it first. Only a kernel without
+// CLONE_INTO_CGROUP is remembered; other failures, like a cgroup removed
+// meanwhile, are probed again next time.
+func cloneIntoCgroupSupported(cgroupFD int) bool {
+	cloneProbeMu.Lock()
+	defer cloneProbeMu.Unlock()
+
+	if cloneUnsupported {
+		return false
+	}
+	err := cloneProbe(cgroupFD)
+	if errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EINVAL) {
+		cloneUnsupported = true
+	}
+	return err == nil
+}
+
+// AttachProcessGroup moves every process of a process group into the
+// user's cgroup, e.g. an instance recovered after a FastCP restart together
+// with the children it spawned
+func (m *Manager) AttachProcessGroup(username string, pgid int) error {
+	if _, err := m.EnsureCgroup(username); err != nil {
+		return err
+	}
+
+	pids, err := groupMembers(pgid)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, pid := range pids {
+		if err := m.AddProcessToCgroup(username, pid); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// groupMembers returns the running processes of a process group
+func groupMembers(pgid int) ([]int, error) {
+	entries, err := os.ReadDir(ProcPath)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if pid == pgid || processGroup(pid) == pgid {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// processGroup returns the process group of a process, or -1
+func processGroup(pid int) int {
+	data, err := os.ReadFile(filepath.Join(ProcPath, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return -1
+	}
+	// The command name may contain spaces; fields follow the last ')'
+	s := string(data)
+	i := strings.LastIndexByte(s, ')')
+	if i < 0 {
+		return -1
+	}
+	fields := strings.Fields(s[i+1:])
+	if len(fields) < 3 {
+		return -1
+	}
+	pgrp, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return -1
+	}
+	return pgrp
+}
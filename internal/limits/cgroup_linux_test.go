@@ -0,0 +1,155 @@
+//go:build linux
+
+package limits
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// stubCloneProbe replaces the CLONE_INTO_CGROUP probe and counts its calls
+func stubCloneProbe(t *testing.T, err error) *int {
+	t.Helper()
+	calls := 0
+	old := cloneProbe
+	cloneProbe = func(int) error {
+		calls++
+		return err
+	}
+	t.Cleanup(func() {
+		cloneProbe = old
+		cloneUnsupported = false
+	})
+	return &calls
+}
+
+func TestCloneIntoCgroupSupported(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		supported bool
+		probes    int // Probes after two calls
+	}{
+		{name: "supported", err: nil, supported: true, probes: 2},
+		{name: "old kernel", err: &os.PathError{Op: "fork/exec", Path: "/bin/true", Err: syscall.ENOSYS}, probes: 1},
+		{name: "rejected flag", err: syscall.EINVAL, probes: 1},
+		{name: "not a cgroup", err: syscall.EBADF, probes: 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := stubCloneProbe(t, tc.err)
+			for i := 0; i < 2; i++ {
+				if got := cloneIntoCgroupSupported(0); got != tc.supported {
+					t.Fatalf("call %d: supported = %v, want %v", i+1, got, tc.supported)
+				}
+			}
+			if *calls != tc.probes {
+				t.Fatalf("probed %d times, want %d", *calls, tc.probes)
+			}
+		})
+	}
+}
+
+func TestStartInCgroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		cgroup  bool // cgroup v2 available
+		probe   error
+		path    string
+		wantErr bool
+		moved   bool // The pid is written to the user's cgroup.procs
+	}{
+		{name: "moved after start on old kernels", cgroup: true, probe: syscall.ENOSYS, path: "/bin/true", moved: true},
+		{name: "moved after a failed probe", cgroup: true, probe: syscall.EBADF, path: "/bin/true", moved: true},
+		{name: "without cgroup v2", cgroup: false, path: "/bin/true"},
+		{name: "start fails", cgroup: true, probe: syscall.ENOSYS, path: "/nonexistent/fastcp-test", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stubCloneProbe(t, tc.probe)
+			m := newTestManager(t)
+			if !tc.cgroup {
+				os.Remove(filepath.Join(m.cgroupPath, "cgroup.controllers"))
+			}
+
+			cmd := exec.Command(tc.path)
+			err := m.StartInCgroup(cmd, "alice")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected Start to fail")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("StartInCgroup failed: %v", err)
+				}
+				cmd.Wait()
+			}
+
+			data, err := os.ReadFile(filepath.Join(m.cgroupPath, "fastcp-alice", "cgroup.procs"))
+			if tc.moved {
+				if err != nil || string(data) != strconv.Itoa(cmd.Process.Pid) {
+					t.Fatalf("expected pid %d in cgroup.procs, got %q %v", cmd.Process.Pid, data, err)
+				}
+			} else if err == nil {
+				t.Fatalf("expected no process in cgroup.procs, got %q", data)
+			}
+		})
+	}
+}
+
+func TestAttachProcessGroup(t *testing.T) {
+	m := newTestManager(t)
+
+	// Fake procfs: an instance recovered after a restart (100), its worker
+	// (101) and an unrelated process (102)
+	proc := t.TempDir()
+	stats := map[string]string{
+		"100": "100 (php-fpm: master) S 1 100 100 0 -1",
+		"101": "101 (php-fpm: pool (www)) S 100 100 100 0 -1",
+		"102": "102 (sleep) S 1 102 102 0 -1",
+	}
+	for pid, stat := range stats {
+		os.MkdirAll(filepath.Join(proc, pid), 0755)
+		os.WriteFile(filepath.Join(proc, pid, "stat"), []byte(stat), 0644)
+	}
+	os.MkdirAll(filepath.Join(proc, "self"), 0755)
+	old := ProcPath
+	ProcPath = proc
+	t.Cleanup(func() { ProcPath = old })
+
+	pids, err := groupMembers(100)
+	if err != nil {
+		t.Fatalf("groupMembers failed: %v", err)
+	}
+	slices.Sort(pids)
+	if !slices.Equal(pids, []int{100, 101}) {
+		t.Fatalf("expected the instance and its worker, got %v", pids)
+	}
+
+	// Every member is moved; cgroup.procs keeps the last write
+	if err := m.AttachProcessGroup("alice", 100); err != nil {
+		t.Fatalf("AttachProcessGroup failed: %v", err)
+	}
+	if got := readCgroupFile(t, m, "alice", "cgroup.procs"); got != "101" {
+		t.Fatalf("expected the worker to be moved last, got %q", got)
+	}
+
+	// A group that no longer runs moves nothing
+	if pids, err := groupMembers(300); err != nil || len(pids) != 0 {
+		t.Fatalf("expected no members, got %v %v", pids, err)
+	}
+}
+
+func TestProcessGroup(t *testing.T) {
+	if got := processGroup(os.Getpid()); got != syscall.Getpgrp() {
+		t.Fatalf("processGroup = %d, want %d", got, syscall.Getpgrp())
+	}
+	if got := processGroup(-5); got != -1 {
+		t.Fatalf("expected -1 for a missing process, got %d", got)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package limits
+
+import "os/exec"
+
+// StartInCgroup starts cmd; cgroups are only supported on Linux
+func (m *Manager) StartInCgroup(cmd *exec.Cmd, username string) error {
+	return cmd.Start()
+}
+
+// AttachProcessGroup is a no-op; cgroups are only supported on Linux
+func (m *Manager) AttachProcessGroup(username string, pgid int) error {
+	return nil
+}
@@ -23,7 +23,7 @@ type Manager struct {
 func NewManager(logger *slog.Logger) *Manager {
 	return &Manager{
 		logger:     logger,
-		cgroupPath: "/sys/fs/cgroup",
+		cgroupPath: CgroupRoot,
 	}
 }
 
@@ -44,6 +44,8 @@ func (m *Manager) ApplyLimits(limits *models.UserLimits) error {
 		"ram_mb", limits.MaxRAMMB,
 		"cpu_percent", limits.MaxCPUPercent,
 		"processes", limits.MaxProcesses,
+		"io_read_mbps", limits.MaxIOReadMBps,
+		"io_write_mbps", limits.MaxIOWriteMBps,
 	)
 
 	return nil
@@ -57,61 +59,98 @@ func (m *Manager) applyCgroupLimits(limits *models.UserLimits) error {
 		return nil
 	}
 
-	cgroupName := fmt.Sprintf("fastcp-%s", limits.Username)
-	cgroupDir := filepath.Join(m.cgroupPath, cgroupName)
-
-	// Create cgroup directory if it doesn't exist
-	if err := os.MkdirAll(cgroupDir, 0755); err != nil {
-		return fmt.Errorf("failed to create cgroup: %w", err)
+	cgroupDir, err := m.EnsureCgroup(limits.Username)
+	if err != nil {
+		return err
 	}
 
-	// Enable controllers
-	controllers := "+cpu +memory +pids"
-	if err := os.WriteFile(filepath.Join(m.cgroupPath, "cgroup.subtree_control"), []byte(controllers), 0644); err != nil {
-		m.logger.Warn("failed to enable cgroup controllers", "error", err)
+	// Limits of 0 are written as "max" so lowering a limit back to
+	// unlimited takes effect
+	const mb = 1024 * 1024
+
+	// Apply memory limit, with a soft limit below it so the user's processes
+	// are throttled and reclaimed before the OOM killer steps in
+	memHigh := limits.MemoryHighMB
+	if memHigh == 0 && limits.MaxRAMMB > 0 {
+		memHigh = limits.MaxRAMMB * 9 / 10
 	}
+	m.writeCgroupFile(cgroupDir, "memory.max", limitValue(limits.MaxRAMMB*mb))
+	m.writeCgroupFile(cgroupDir, "memory.high", limitValue(memHigh*mb))
 
-	// Apply memory limit
-	if limits.MaxRAMMB > 0 {
-		memBytes := limits.MaxRAMMB * 1024 * 1024
-		memFile := filepath.Join(cgroupDir, "memory.max")
-		if err := os.WriteFile(memFile, []byte(strconv.FormatInt(memBytes, 10)), 0644); err != nil {
-			m.logger.Warn("failed to set memory limit", "error", err)
-		}
+	// Apply swap limit (-1 disables swap)
+	swapMax := limitValue(limits.MaxSwapMB * mb)
+	if limits.MaxSwapMB < 0 {
+		swapMax = "0"
 	}
+	m.writeCgroupFile(cgroupDir, "memory.swap.max", swapMax)
 
 	// Apply CPU limit
-	if limits.MaxCPUPercent > 0 {
-		// cpu.max format: "$MAX $PERIOD" (microseconds)
-		// 100000 = 100ms period, so for 50% = "50000 100000"
-		period := 100000
-		quota := (limits.MaxCPUPercent * period) / 100
-		cpuMax := fmt.Sprintf("%d %d", quota, period)
-		cpuFile := filepath.Join(cgroupDir, "cpu.max")
-		if err := os.WriteFile(cpuFile, []byte(cpuMax), 0644); err != nil {
-			m.logger.Warn("failed to set CPU limit", "error", err)
-		}
-	}
+	// cpu.max format: "$MAX $PERIOD" (microseconds)
+	// 100000 = 100ms period, so for 50% = "50000 100000"
+	period := 100000
+	quota := (limits.MaxCPUPercent * period) / 100
+	m.writeCgroupFile(cgroupDir, "cpu.max", fmt.Sprintf("%s %d", limitValue(int64(quota)), period))
 
 	// Apply process limit
-	if limits.MaxProcesses > 0 {
-		pidsFile := filepath.Join(cgroupDir, "pids.max")
-		if err := os.WriteFile(pidsFile, []byte(strconv.Itoa(limits.MaxProcesses)), 0644); err != nil {
-			m.logger.Warn("failed to set process limit", "error", err)
-		}
+	m.writeCgroupFile(cgroupDir, "pids.max", limitValue(int64(limits.MaxProcesses)))
+
+	// Apply disk I/O limits on the device holding the home directories
+	if dev, err := blockDevice(filepath.Join("/home", limits.Username)); err != nil {
+		m.logger.Debug("no block device for I/O limits", "user", limits.Username, "error", err)
+	} else {
+		ioMax := fmt.Sprintf("%s rbps=%s wbps=%s riops=%s wiops=%s", dev,
+			limitValue(limits.MaxIOReadMBps*mb),
+			limitValue(limits.MaxIOWriteMBps*mb),
+			limitValue(int64(limits.MaxIOReadIOPS)),
+			limitValue(int64(limits.MaxIOWriteIOPS)),
+		)
+		m.writeCgroupFile(cgroupDir, "io.max", ioMax)
 	}
 
 	return nil
 }
 
+// writeCgroupFile writes a cgroup interface file, logging failures
+func (m *Manager) writeCgroupFile(cgroupDir, name, value string) {
+	if err := os.WriteFile(filepath.Join(cgroupDir, name), []byte(value), 0644); err != nil {
+		m.logger.Warn("failed to set cgroup limit", "file", name, "value", value, "error", err)
+	}
+}
+
+// limitValue formats a cgroup limit, where 0 or less means unlimited
+func limitValue(v int64) string {
+	if v <= 0 {
+		return "max"
+	}
+	return strconv.FormatInt(v, 10)
+}
+
 // GetUsage returns current resource usage for a user
 func (m *Manager) GetUsage(username string) (*ResourceUsage, error) {
+	usage := m.CgroupUsage(username)
+	if runtime.GOOS != "linux" {
+		return usage, nil
+	}
+
+	// Disk usage
+	diskUsage, err := m.getDiskUsage(username)
+	if err == nil {
+		usage.DiskUsedMB = diskUsage
+	}
+
+	return usage, nil
+}
+
+// CgroupUsage returns the resource usage read from the user's cgroup.
+// Unlike GetUsage it does not measure disk usage, so it is cheap enough
+// to call every few seconds.
+func (m *Manager) CgroupUsage(username string) *ResourceUsage {
 	usage := &ResourceUsage{
 		Username: username,
 	}
 
 	if runtime.GOOS != "linux" {
-		return usage, nil
+		return usage
 	}
 
 	// Get cgroup usage
@@ -124,16 +163,52 @@ func (m *Manager) GetUsage(username string) (*ResourceUsage, error) {
 		}
 	}
 
-	// CPU usage (this is cumulative, would need to calculate rate)
+	// CPU usage and throttling (cumulative, would need to calculate rate)
 	if data, err := os.ReadFile(filepath.Join(cgroupDir, "cpu.stat")); err == nil {
-		lines := strings.Split(string(data), "\n")
-		for _, line := range lines {
-			if strings.HasPrefix(line, "usage_usec") {
-				parts := strings.Fields(line)
-				if len(parts) >= 2 {
-					if usec, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
-						usage.CPUUsageMicros = usec
-					}
+		stat := parseFlatKeyed(string(data))
+		usage.CPUUsageMicros = stat["usage_usec"]
+		usage.CPUThrottledPeriods = stat["nr_throttled"]
+		usage.CPUThrottledMicros = stat["throttled_usec"]
+	}
+
+	// Swap usage
+	if data, err := os.ReadFile(filepath.Join(cgroupDir, "memory.swap.current")); err == nil {
+		if bytes, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			usage.SwapUsedMB = bytes / (1024 * 1024)
+		}
+	}
+
+	// Times the soft memory limit was exceeded and reclaim kicked in
+	if data, err := os.ReadFile(filepath.Join(cgroupDir, "memory.events")); err == nil {
+		events := parseFlatKeyed(string(data))
+		usage.MemoryHighEvents = events["high"]
+		usage.MemoryMaxEvents = events["max"]
+		usage.OOMKills = events["oom_kill"]
+	}
+
+	// Forks refused because the process limit was reached
+	if data, err := os.ReadFile(filepath.Join(cgroupDir, "pids.events")); err == nil {
+		usage.ProcessLimitEvents = parseFlatKeyed(string(data))["max"]
+	}
+
+	// Disk I/O, summed over all devices
+	if data, err := os.ReadFile(filepath.Join(cgroupDir, "io.stat")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			for _, field := range strings.Fields(line) {
+				key, value, ok := strings.Cut(field, "=")
+				if !ok {
+					continue
+				}
+				n, _ := strconv.ParseInt(value, 10, 64)
+				switch key {
+				case "rbytes":
+					usage.IOReadBytes += n
+				case "wbytes":
+					usage.IOWriteBytes += n
+				case "rios":
+					usage.IOReadOps += n
+				case "wios":
+					usage.IOWriteOps += n
 				}
 			}
 		}
@@ -146,13 +221,7 @@ func (m *Manager) GetUsage(username string) (*ResourceUsage, error) {
 		}
 	}
 
-	// Disk usage
-	diskUsage, err := m.getDiskUsage(username)
-	if err == nil {
-		usage.DiskUsedMB = diskUsage
-	}
-
-	return usage, nil
+	return usage
 }
 
 // ResourceUsage represents current resource usage
@@ -162,6 +231,34 @@ type ResourceUsage struct {
 	CPUUsageMicros int64  `json:"cpu_usage_micros"`
 	DiskUsedMB     int64  `json:"disk_used_mb"`
 	ProcessCount   int    `json:"process_count"`
+
+	SwapUsedMB       int64 `json:"swap_used_mb"`
+	MemoryHighEvents int64 `json:"memory_high_events"` // times memory.high was exceeded
+	MemoryMaxEvents  int64 `json:"memory_max_events"`  // times memory.max was about to be exceeded
+	OOMKills         int64 `json:"oom_kills"`          // processes killed by the OOM killer
+	IOReadBytes      int64 `json:"io_read_bytes"`      // cumulative
+	IOWriteBytes     int64 `json:"io_write_bytes"`     // cumulative
+	IOReadOps        int64 `json:"io_read_ops"`        // cumulative
+	IOWriteOps       int64 `json:"io_write_ops"`       // cumulative
+
+	ProcessLimitEvents  int64 `json:"process_limit_events"`  // forks refused by pids.max
+	CPUThrottledPeriods int64 `json:"cpu_throttled_periods"` // periods throttled by cpu.max
+	CPUThrottledMicros  int64 `json:"cpu_throttled_micros"`  // cumulative
+}
+
+// parseFlatKeyed parses a cgroup "key value" file such as memory.events
+func parseFlatKeyed(data string) map[string]int64 {
+	values := make(map[string]int64)
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			values[fields[0]] = n
+		}
+	}
+	return values
 }
 
 // AddProcessToCgroup adds a process to user's cgroup
@@ -176,6 +273,26 @@ func (m *Manager) AddProcessToCgroup(username string, pid int) error {
 	return os.WriteFile(procsFile, []byte(strconv.Itoa(pid)), 0644)
 }
 
+// SetCPULimit overrides the CPU limit of a user's cgroup.
+// A percent of 0 removes the limit.
+func (m *Manager) SetCPULimit(username string, percent int) error {
+	if runtime.GOOS != "linux" || !m.isCgroupV2() {
+		return nil
+	}
+
+	cgroupDir := filepath.Join(m.cgroupPath, fmt.Sprintf("fastcp-%s", username))
+	if err := os.MkdirAll(cgroupDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	period := 100000
+	cpuMax := fmt.Sprintf("max %d", period)
+	if percent > 0 {
+		cpuMax = fmt.Sprintf("%d %d", (percent*period)/100, period)
+	}
+	return os.WriteFile(filepath.Join(cgroupDir, "cpu.max"), []byte(cpuMax), 0644)
+}
+
 // RemoveLimits removes cgroup for a user
 func (m *Manager) RemoveLimits(username string) error {
 	if runtime.GOOS != "linux" {
@@ -0,0 +1,196 @@
+package limits
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("cgroup limits are only supported on Linux")
+	}
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu memory pids io"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &Manager{
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		cgroupPath: root,
+	}
+}
+
+func readCgroupFile(t *testing.T, m *Manager, username, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(m.cgroupPath, "fastcp-"+username, name))
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestApplyCgroupLimits(t *testing.T) {
+	m := newTestManager(t)
+
+	limits := &models.UserLimits{
+		Username:      "alice",
+		MaxRAMMB:      1000,
+		MaxCPUPercent: 50,
+		MaxSwapMB:     -1,
+	}
+	if err := m.ApplyLimits(limits); err != nil {
+		t.Fatalf("ApplyLimits failed: %v", err)
+	}
+
+	want := map[string]string{
+		"memory.max":      fmt.Sprint(1000 * 1024 * 1024),
+		"memory.high":     fmt.Sprint(900 * 1024 * 1024),
+		"memory.swap.max": "0",
+		"cpu.max":         "50000 100000",
+		"pids.max":        "max",
+	}
+	for name, value := range want {
+		if got := readCgroupFile(t, m, "alice", name); got != value {
+			t.Fatalf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	// Removing limits resets them to max
+	if err := m.ApplyLimits(&models.UserLimits{Username: "alice"}); err != nil {
+		t.Fatalf("ApplyLimits failed: %v", err)
+	}
+	for _, name := range []string{"memory.max", "memory.high", "memory.swap.max"} {
+		if got := readCgroupFile(t, m, "alice", name); got != "max" {
+			t.Fatalf("%s = %q, want max", name, got)
+		}
+	}
+	if got := readCgroupFile(t, m, "alice", "cpu.max"); got != "max 100000" {
+		t.Fatalf("cpu.max = %q, want %q", got, "max 100000")
+	}
+}
+
+func TestBlockDevicePartition(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("block devices are only resolved on Linux")
+	}
+	dir := t.TempDir()
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		t.Fatal(err)
+	}
+	dev := fmt.Sprintf("%d:%d", unix.Major(uint64(st.Dev)), unix.Minor(uint64(st.Dev)))
+
+	// Fake sysfs: the partition links into the parent disk's directory
+	sys := t.TempDir()
+	disk := filepath.Join(sys, "devices", "sda")
+	part := filepath.Join(disk, "sda1")
+	if err := os.MkdirAll(part, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(disk, "dev"), []byte("8:0\n"), 0644)
+	os.WriteFile(filepath.Join(part, "partition"), []byte("1\n"), 0644)
+	if err := os.MkdirAll(filepath.Join(sys, "block"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(part, filepath.Join(sys, "block", dev)); err != nil {
+		t.Fatal(err)
+	}
+
+	old := SysBlockDevPath
+	SysBlockDevPath = filepath.Join(sys, "block")
+	t.Cleanup(func() { SysBlockDevPath = old })
+
+	got, err := blockDevice(filepath.Join(dir, "missing", "home"))
+	if err != nil || got != "8:0" {
+		t.Fatalf("blockDevice = %q, %v; want 8:0", got, err)
+	}
+}
+
+func TestParseFlatKeyed(t *testing.T) {
+	values := parseFlatKeyed("low 0\nhigh 12\nmax 3\noom 1\noom_kill 1\n")
+	if values["high"] != 12 || values["oom_kill"] != 1 || len(values) != 5 {
+		t.Fatalf("unexpected values: %v", values)
+	}
+	if !strings.Contains(limitValue(0), "max") || limitValue(5) != "5" {
+		t.Fatalf("unexpected limitValue results")
+	}
+}
+
+func TestSetupSessionHook(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the session hook is only installed on Linux")
+	}
+	dir := t.TempDir()
+	oldHook, oldServices := SessionHookPath, PAMServices
+	SessionHookPath = filepath.Join(dir, "lib", "cgroup-session.sh")
+	t.Cleanup(func() { SessionHookPath, PAMServices = oldHook, oldServices })
+
+	hookLine := "session optional pam_exec.so quiet " + SessionHookPath + "\n"
+	tests := []struct {
+		name     string
+		existing string // Empty for a service that is not installed
+		want     string
+	}{
+		{
+			name:     "appended",
+			existing: "@include common-session\n",
+			want:     "@include common-session\n\n" + sessionMarker + "\n" + hookLine,
+		},
+		{
+			name:     "no trailing newline",
+			existing: "session required pam_unix.so",
+			want:     "session required pam_unix.so\n\n" + sessionMarker + "\n" + hookLine,
+		},
+		{
+			name:     "added by hand",
+			existing: "session required pam_unix.so\n" + hookLine,
+			want:     "session required pam_unix.so\n" + hookLine,
+		},
+		{
+			name: "missing service",
+		},
+	}
+
+	PAMServices = nil
+	for _, tc := range tests {
+		PAMServices = append(PAMServices, filepath.Join(dir, strings.ReplaceAll(tc.name, " ", "-")))
+		if tc.existing != "" {
+			os.WriteFile(PAMServices[len(PAMServices)-1], []byte(tc.existing), 0644)
+		}
+	}
+
+	// Running setup again changes nothing
+	for run := 1; run <= 2; run++ {
+		if err := SetupSessionHook(); err != nil {
+			t.Fatalf("run %d: SetupSessionHook failed: %v", run, err)
+		}
+		for i, tc := range tests {
+			data, err := os.ReadFile(PAMServices[i])
+			if tc.existing == "" {
+				if !os.IsNotExist(err) {
+					t.Fatalf("run %d, %s: expected no file to be created", run, tc.name)
+				}
+				continue
+			}
+			if string(data) != tc.want {
+				t.Fatalf("run %d, %s: got\n%s\nwant\n%s", run, tc.name, data, tc.want)
+			}
+		}
+	}
+
+	info, err := os.Stat(SessionHookPath)
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("expected an executable hook script, got %v", err)
+	}
+}
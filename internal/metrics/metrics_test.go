@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/limits"
+)
+
+func TestRingWraparound(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raw.ring")
+	r, err := openRing(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Unix(1700000000, 0).UTC()
+	for i := 0; i < 5; i++ {
+		if err := r.append(Sample{Time: base.Add(time.Duration(i) * time.Minute), RAMMB: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.close()
+
+	// Reopening keeps the position; only the last three records survive
+	r, err = openRing(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+	samples, err := r.read(base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 3 || samples[0].RAMMB != 2 || samples[2].RAMMB != 4 {
+		t.Fatalf("unexpected samples: %+v", samples)
+	}
+
+	// A different capacity resets the file
+	r2, err := openRing(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.close()
+	if samples, _ := r2.read(base, base.Add(time.Hour)); len(samples) != 0 {
+		t.Fatalf("expected reset ring, got %d samples", len(samples))
+	}
+}
+
+func TestSamplerRecordAndDownsample(t *testing.T) {
+	s := NewSampler(t.TempDir(), time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.SetDiskUsageFunc(func(string) int64 { return 42 })
+
+	// Start a few minutes ago so the samples fall inside the query range
+	start := time.Now().UTC().Add(-20 * time.Minute).Truncate(5 * time.Minute)
+	var cpu int64
+	for i := 0; i <= 10; i++ {
+		u := &limits.ResourceUsage{
+			RAMUsedMB:      int64(100 + i),
+			ProcessCount:   3,
+			CPUUsageMicros: cpu,
+		}
+		if i >= 7 {
+			u.OOMKills = 1 // cumulative counter: one kill at minute 7
+		}
+		if err := s.Record("alice", u, start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		cpu += 30 * 1000000 // half a core for each minute
+	}
+
+	h, err := s.Query("alice", time.Hour)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	// The first record only sets the baseline
+	if h.Resolution != "1m0s" || len(h.Samples) != 10 {
+		t.Fatalf("unexpected history: resolution %s, %d samples", h.Resolution, len(h.Samples))
+	}
+	if got := h.Samples[0].CPUPercent; got != 50 {
+		t.Fatalf("CPUPercent = %v, want 50", got)
+	}
+	if h.Samples[0].DiskMB != 42 || h.Summary.OOMKills != 1 || h.Summary.PeakRAMMB != 110 {
+		t.Fatalf("unexpected samples/summary: %+v %+v", h.Samples[0], h.Summary)
+	}
+
+	// Minutes 1-4 and 5-9 complete two 5 minute buckets
+	h, err = s.Query("alice", 48*time.Hour)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if h.Resolution != "5m" || len(h.Samples) != 2 {
+		t.Fatalf("unexpected 5m history: resolution %s, %+v", h.Resolution, h.Samples)
+	}
+	if got := h.Samples[1]; got.RAMMB != 107 || got.CPUPercent != 50 || got.OOMKills != 1 {
+		t.Fatalf("unexpected 5m bucket: %+v", got)
+	}
+
+	// Users without history return an empty series
+	h, err = s.Query("bob", 30*24*time.Hour)
+	if err != nil || h.Resolution != "1h" || len(h.Samples) != 0 {
+		t.Fatalf("unexpected empty history: %+v, %v", h, err)
+	}
+}
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Ring file layout: a 32 byte header followed by capacity fixed size
+// records. next counts every record ever appended; record i lives in slot
+// i % capacity, so the file never grows and the oldest records are
+// overwritten in place.
+//
+//	header: magic[4] capacity:uint32 next:uint64 reserved[16]
+//	record: time:int64 cpu:uint32 ram:uint32 swap:uint32 procs:uint32 disk:uint32 oom:uint32
+const (
+	headerSize = 32
+	recordSize = 32
+)
+
+var ringMagic = [4]byte{'F', 'C', 'P', 'U'}
+
+var errCorruptRing = errors.New("corrupt ring file")
+
+// ring is an open ring buffer file
+type ring struct {
+	f        *os.File
+	capacity uint64
+	next     uint64
+}
+
+// openRing opens or creates a ring file. A file with a different capacity
+// (e.g. after changing the sample interval) is reset.
+func openRing(path string, capacity int) (*ring, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r := &ring{f: f, capacity: uint64(capacity)}
+
+	var header [headerSize]byte
+	if _, err := f.ReadAt(header[:], 0); err == nil &&
+		[4]byte(header[:4]) == ringMagic &&
+		uint64(binary.LittleEndian.Uint32(header[4:8])) == r.capacity {
+		r.next = binary.LittleEndian.Uint64(header[8:16])
+		return r, nil
+	} else if err != nil && err != io.EOF {
+		f.Close()
+		return nil, err
+	}
+
+	// New or incompatible file: start over
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	copy(header[:4], ringMagic[:])
+	binary.LittleEndian.PutUint32(header[4:8], uint32(capacity))
+	if _, err := f.WriteAt(header[:], 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *ring) close() error {
+	return r.f.Close()
+}
+
+// append writes a record into the next slot and advances the header
+func (r *ring) append(s Sample) error {
+	var rec [recordSize]byte
+	encodeSample(rec[:], s)
+	off := headerSize + int64(r.next%r.capacity)*recordSize
+	if _, err := r.f.WriteAt(rec[:], off); err != nil {
+		return err
+	}
+	r.next++
+
+	var next [8]byte
+	binary.LittleEndian.PutUint64(next[:], r.next)
+	_, err := r.f.WriteAt(next[:], 8)
+	return err
+}
+
+// read returns the records with from <= time < to, oldest first
+func (r *ring) read(from, to time.Time) ([]Sample, error) {
+	n := min(r.next, r.capacity)
+	if n == 0 {
+		return []Sample{}, nil
+	}
+
+	buf := make([]byte, r.capacity*recordSize)
+	read, err := r.f.ReadAt(buf, headerSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	samples := make([]Sample, 0, n)
+	for i := r.next - n; i < r.next; i++ {
+		off := (i % r.capacity) * recordSize
+		if off+recordSize > uint64(read) {
+			return nil, errCorruptRing
+		}
+		s := decodeSample(buf[off : off+recordSize])
+		if s.Time.Before(from) || !s.Time.Before(to) {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+func encodeSample(b []byte, s Sample) {
+	le := binary.LittleEndian
+	le.PutUint64(b[0:8], uint64(s.Time.Unix()))
+	le.PutUint32(b[8:12], clampUint32(math.Round(s.CPUPercent*100)))
+	le.PutUint32(b[12:16], clampUint32(float64(s.RAMMB)))
+	le.PutUint32(b[16:20], clampUint32(float64(s.SwapMB)))
+	le.PutUint32(b[20:24], clampUint32(float64(s.Processes)))
+	le.PutUint32(b[24:28], clampUint32(float64(s.DiskMB)))
+	le.PutUint32(b[28:32], clampUint32(float64(s.OOMKills)))
+}
+
+func decodeSample(b []byte) Sample {
+	le := binary.LittleEndian
+	return Sample{
+		Time:       time.Unix(int64(le.Uint64(b[0:8])), 0).UTC(),
+		CPUPercent: float64(le.Uint32(b[8:12])) / 100,
+		RAMMB:      int64(le.Uint32(b[12:16])),
+		SwapMB:     int64(le.Uint32(b[16:20])),
+		Processes:  int(le.Uint32(b[20:24])),
+		DiskMB:     int64(le.Uint32(b[24:28])),
+		OOMKills:   int64(le.Uint32(b[28:32])),
+	}
+}
+
+func clampUint32(v float64) uint32 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(v)
+}
@@ -0,0 +1,321 @@
+// Package metrics samples per-user resource usage from the user cgroups and
+// keeps its history in fixed size ring buffer files.
+//
+// Each user has three rings under DataDir/usage/<user>/: raw samples for
+// 24 hours, 5 minute averages for 7 days and hourly averages for 90 days.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/limits"
+)
+
+// Sample is the resource usage of a user at one point in time, or the
+// average over a downsampled interval
+type Sample struct {
+	Time       time.Time `json:"time"`
+	CPUPercent float64   `json:"cpu_percent"` // 100 = one core
+	RAMMB      int64     `json:"ram_mb"`
+	SwapMB     int64     `json:"swap_mb"`
+	Processes  int       `json:"processes"`
+	DiskMB     int64     `json:"disk_mb"`
+	OOMKills   int64     `json:"oom_kills"` // OOM kills during the interval
+}
+
+// Summary aggregates the samples of a history
+type Summary struct {
+	AvgCPUPercent  float64 `json:"avg_cpu_percent"`
+	PeakCPUPercent float64 `json:"peak_cpu_percent"`
+	AvgRAMMB       int64   `json:"avg_ram_mb"`
+	PeakRAMMB      int64   `json:"peak_ram_mb"`
+	PeakProcesses  int     `json:"peak_processes"`
+	OOMKills       int64   `json:"oom_kills"`
+}
+
+// History is the usage of a user over a time range
+type History struct {
+	Username   string    `json:"username"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Resolution string    `json:"resolution"`
+	Samples    []Sample  `json:"samples"`
+	Summary    Summary   `json:"summary"`
+}
+
+// tier is one resolution of the history
+type tier struct {
+	name      string
+	step      time.Duration
+	retention time.Duration
+}
+
+// userState holds what is needed to turn cumulative counters into rates
+// and the partially filled downsampled buckets
+type userState struct {
+	lastCPU  int64
+	lastOOM  int64
+	lastTime time.Time
+	pending  []*bucket // one per downsampled tier
+}
+
+// bucket accumulates raw samples for a downsampled tier
+type bucket struct {
+	start time.Time
+	n     int
+	sum   Sample
+}
+
+// SampleFunc is called with every usage snapshot taken by SampleAll
+type SampleFunc func(username string, usage *limits.ResourceUsage, at time.Time)
+
+// Sampler records per-user resource usage periodically
+type Sampler struct {
+	dir       string
+	tiers     []tier
+	limits    *limits.Manager
+	diskUsage func(username string) int64
+	onSample  []SampleFunc
+	logger    *slog.Logger
+
+	mu    sync.Mutex
+	users map[string]*userState
+}
+
+// NewSampler creates a sampler taking a raw sample every interval
+func NewSampler(dataDir string, interval time.Duration, logger *slog.Logger) *Sampler {
+	return &Sampler{
+		dir: filepath.Join(dataDir, "usage"),
+		tiers: []tier{
+			{name: "raw", step: interval, retention: 24 * time.Hour},
+			{name: "5m", step: 5 * time.Minute, retention: 7 * 24 * time.Hour},
+			{name: "1h", step: time.Hour, retention: 90 * 24 * time.Hour},
+		},
+		limits: limits.NewManager(logger),
+		logger: logger,
+		users:  make(map[string]*userState),
+	}
+}
+
+// SetDiskUsageFunc sets the function reporting a user's disk usage in MB.
+// Disk usage is expensive to measure, so a cached value is expected.
+func (s *Sampler) SetDiskUsageFunc(fn func(username string) int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.diskUsage = fn
+}
+
+// OnSample registers a callback for every usage snapshot, e.g. to detect
+// limit events from the cumulative cgroup counters
+func (s *Sampler) OnSample(fn SampleFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onSample = append(s.onSample, fn)
+}
+
+// Start samples every user cgroup until ctx is done
+func (s *Sampler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.tiers[0].step)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			s.SampleAll()
+		}
+	}()
+}
+
+// SampleAll records a sample for every user that has a cgroup
+func (s *Sampler) SampleAll() {
+	s.mu.Lock()
+	callbacks := s.onSample
+	s.mu.Unlock()
+
+	now := time.Now()
+	for _, username := range s.limits.CgroupUsers() {
+		usage := s.limits.CgroupUsage(username)
+		if err := s.Record(username, usage, now); err != nil {
+			s.logger.Warn("failed to record resource usage", "user", username, "error", err)
+		}
+		for _, fn := range callbacks {
+			fn(username, usage, now)
+		}
+	}
+}
+
+// Record converts a usage snapshot into a sample and stores it in every tier
+func (s *Sampler) Record(username string, u *limits.ResourceUsage, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.users[username]
+	if st == nil {
+		st = &userState{pending: make([]*bucket, len(s.tiers)-1)}
+		s.users[username] = st
+	}
+
+	sample := Sample{
+		Time:      at.UTC(),
+		RAMMB:     u.RAMUsedMB,
+		SwapMB:    u.SwapUsedMB,
+		Processes: u.ProcessCount,
+	}
+	if s.diskUsage != nil {
+		sample.DiskMB = s.diskUsage(username)
+	}
+
+	// CPU and OOM kills are cumulative; the first sample only sets the baseline
+	first := st.lastTime.IsZero()
+	if !first {
+		if elapsed := at.Sub(st.lastTime).Microseconds(); elapsed > 0 && u.CPUUsageMicros >= st.lastCPU {
+			sample.CPUPercent = float64(u.CPUUsageMicros-st.lastCPU) / float64(elapsed) * 100
+		}
+		if u.OOMKills > st.lastOOM {
+			sample.OOMKills = u.OOMKills - st.lastOOM
+		}
+	}
+	st.lastCPU, st.lastOOM, st.lastTime = u.CPUUsageMicros, u.OOMKills, at
+	if first {
+		return nil
+	}
+
+	if err := s.appendUnlocked(username, s.tiers[0], sample); err != nil {
+		return err
+	}
+
+	// Downsampled tiers get the average of a bucket once it is complete
+	for i, t := range s.tiers[1:] {
+		start := sample.Time.Truncate(t.step)
+		b := st.pending[i]
+		if b != nil && !b.start.Equal(start) {
+			if err := s.appendUnlocked(username, t, b.average()); err != nil {
+				return err
+			}
+			b = nil
+		}
+		if b == nil {
+			b = &bucket{start: start}
+			st.pending[i] = b
+		}
+		b.add(sample)
+	}
+	return nil
+}
+
+// Query returns the usage history of a user for the last d, using the
+// finest tier that covers the range
+func (s *Sampler) Query(username string, d time.Duration) (*History, error) {
+	to := time.Now().UTC()
+	from := to.Add(-d)
+
+	t := s.tiers[len(s.tiers)-1]
+	for _, candidate := range s.tiers {
+		if d <= candidate.retention {
+			t = candidate
+			break
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := &History{Username: username, From: from, To: to, Resolution: t.name, Samples: []Sample{}}
+	if t.name == "raw" {
+		h.Resolution = t.step.String()
+	}
+
+	path := s.ringPath(username, t)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return h, nil
+	}
+	r, err := openRing(path, s.capacity(t))
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	if h.Samples, err = r.read(from, to); err != nil {
+		return nil, err
+	}
+	h.Summary = summarize(h.Samples)
+	return h, nil
+}
+
+// appendUnlocked appends a sample to a tier's ring (caller must hold lock)
+func (s *Sampler) appendUnlocked(username string, t tier, sample Sample) error {
+	dir := filepath.Join(s.dir, filepath.Base(username))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	r, err := openRing(s.ringPath(username, t), s.capacity(t))
+	if err != nil {
+		return err
+	}
+	if err := r.append(sample); err != nil {
+		r.close()
+		return fmt.Errorf("failed to write %s sample: %w", t.name, err)
+	}
+	return r.close()
+}
+
+func (s *Sampler) ringPath(username string, t tier) string {
+	return filepath.Join(s.dir, filepath.Base(username), t.name+".ring")
+}
+
+func (s *Sampler) capacity(t tier) int {
+	return max(int(t.retention/t.step), 1)
+}
+
+func (b *bucket) add(s Sample) {
+	b.n++
+	b.sum.CPUPercent += s.CPUPercent
+	b.sum.RAMMB += s.RAMMB
+	b.sum.SwapMB += s.SwapMB
+	b.sum.Processes += s.Processes
+	b.sum.DiskMB += s.DiskMB
+	b.sum.OOMKills += s.OOMKills
+}
+
+// average returns the mean of the bucket; OOM kills are summed
+func (b *bucket) average() Sample {
+	n := int64(b.n)
+	return Sample{
+		Time:       b.start,
+		CPUPercent: b.sum.CPUPercent / float64(b.n),
+		RAMMB:      b.sum.RAMMB / n,
+		SwapMB:     b.sum.SwapMB / n,
+		Processes:  b.sum.Processes / b.n,
+		DiskMB:     b.sum.DiskMB / n,
+		OOMKills:   b.sum.OOMKills,
+	}
+}
+
+func summarize(samples []Sample) Summary {
+	var sum Summary
+	if len(samples) == 0 {
+		return sum
+	}
+	var cpu float64
+	var ram int64
+	for _, s := range samples {
+		cpu += s.CPUPercent
+		ram += s.RAMMB
+		sum.PeakCPUPercent = max(sum.PeakCPUPercent, s.CPUPercent)
+		sum.PeakRAMMB = max(sum.PeakRAMMB, s.RAMMB)
+		sum.PeakProcesses = max(sum.PeakProcesses, s.Processes)
+		sum.OOMKills += s.OOMKills
+	}
+	sum.AvgCPUPercent = cpu / float64(len(samples))
+	sum.AvgRAMMB = ram / int64(len(samples))
+	return sum
+}
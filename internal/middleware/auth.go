@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/rbac"
 )
 
 type contextKey string
@@ -14,8 +16,41 @@ const (
 	UserContextKey          contextKey = "user"
 	ClaimsContextKey        contextKey = "claims"
 	ImpersonatingContextKey contextKey = "impersonating"
+	APIKeyContextKey        contextKey = "api_key"
 )
 
+// impersonationChecker reports whether an impersonation token may still be
+// used. Set by the main application; impersonation tokens are refused while
+// unset.
+var impersonationChecker func(claims *auth.Claims) bool
+
+// SetImpersonationChecker sets the function that rejects impersonation
+// tokens that were ended early or whose user no longer allows impersonation
+func SetImpersonationChecker(f func(claims *auth.Claims) bool) {
+	impersonationChecker = f
+}
+
+// sessionValidator reports whether the session of a token is still active.
+// Set by the main application; while unset every valid token is accepted.
+var sessionValidator func(claims *auth.Claims) bool
+
+// SetSessionValidator sets the function that rejects tokens of revoked or
+// expired sessions
+func SetSessionValidator(f func(claims *auth.Claims) bool) {
+	sessionValidator = f
+}
+
+// permissionChecker reports whether a user has a permission. Set by the
+// main application to include custom roles; while unset the built-in roles
+// decide.
+var permissionChecker func(claims *auth.Claims, permission string) bool
+
+// SetPermissionChecker sets the function that decides whether a user has a
+// permission
+func SetPermissionChecker(f func(claims *auth.Claims, permission string) bool) {
+	permissionChecker = f
+}
+
 // AuthMiddleware validates JWT tokens and sets user context
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -46,21 +81,22 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		ctx := r.Context()
+		// Impersonation tokens live as long as the impersonator's session
+		if sessionValidator != nil && !sessionValidator(claims.RealClaims()) {
+			http.Error(w, `{"error": "session expired or revoked"}`, http.StatusUnauthorized)
+			return
+		}
 
-		// Handle impersonation - only admins can impersonate
-		impersonateUser := r.Header.Get("X-Impersonate-User")
-		if impersonateUser != "" && claims.Role == "admin" {
-			// Create modified claims for impersonated user
-			impersonatedClaims := &auth.Claims{
-				UserID:   impersonateUser,
-				Username: impersonateUser,
-				Role:     "user", // Impersonated users are always non-admin
+		ctx := context.WithValue(r.Context(), ClaimsContextKey, claims)
+
+		// Impersonation tokens act as the user and keep the real admin or
+		// reseller claims for auditing
+		if claims.Impersonator != nil {
+			if impersonationChecker == nil || !impersonationChecker(claims) {
+				http.Error(w, `{"error": "impersonation ended"}`, http.StatusUnauthorized)
+				return
 			}
-			ctx = context.WithValue(ctx, ClaimsContextKey, impersonatedClaims)
-			ctx = context.WithValue(ctx, ImpersonatingContextKey, claims) // Store real admin claims
-		} else {
-			ctx = context.WithValue(ctx, ClaimsContextKey, claims)
+			ctx = context.WithValue(ctx, ImpersonatingContextKey, claims.RealClaims())
 		}
 
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -94,11 +130,40 @@ func APIKeyMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Store validated key info in context for later use
-		ctx := context.WithValue(r.Context(), "api_key", validatedKey)
+		ctx := context.WithValue(r.Context(), APIKeyContextKey, validatedKey)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// RequirePermission ensures the user, or the API key on API key routes, has
+// a permission
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasPermission(r, permission) {
+				http.Error(w, `{"error": "permission denied: `+permission+`"}`, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HasPermission reports whether the user, or the API key on API key routes,
+// has a permission
+func HasPermission(r *http.Request, permission string) bool {
+	if claims := GetClaims(r); claims != nil {
+		if permissionChecker != nil {
+			return permissionChecker(claims, permission)
+		}
+		return rbac.Grants(rbac.BuiltInPermissions(claims.Role), permission)
+	}
+	if key := GetAPIKey(r); key != nil {
+		return rbac.Grants(key.Permissions, permission)
+	}
+	return false
+}
+
 // AdminOnlyMiddleware ensures only admin users can access
 func AdminOnlyMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -111,13 +176,31 @@ func AdminOnlyMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// UserManagerMiddleware ensures only admins and resellers can access
+func UserManagerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value(ClaimsContextKey).(*auth.Claims)
+		if !ok || (claims.Role != "admin" && claims.Role != "reseller") {
+			http.Error(w, `{"error": "admin or reseller access required"}`, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // GetClaims retrieves claims from context
 func GetClaims(r *http.Request) *auth.Claims {
 	claims, _ := r.Context().Value(ClaimsContextKey).(*auth.Claims)
 	return claims
 }
 
-// GetRealClaims retrieves the real admin claims when impersonating
+// GetAPIKey retrieves the validated API key from context
+func GetAPIKey(r *http.Request) *models.APIKey {
+	key, _ := r.Context().Value(APIKeyContextKey).(*models.APIKey)
+	return key
+}
+
+// GetRealClaims retrieves the real admin or reseller claims when impersonating
 func GetRealClaims(r *http.Request) *auth.Claims {
 	claims, ok := r.Context().Value(ImpersonatingContextKey).(*auth.Claims)
 	if ok {
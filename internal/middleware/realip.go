@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/rehmatworks/fastcp/internal/config"
+)
+
+// RealIP replaces the client address of requests that come from a trusted
+// proxy with the address the proxy forwarded. Only peers listed in the
+// trusted_proxies setting count as proxies; the X-Forwarded-For and
+// X-Real-IP headers of anyone else are ignored, so clients cannot choose
+// the address that rate limits, sessions and the audit log see.
+func RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg := config.Get(); cfg != nil && len(cfg.TrustedProxies) > 0 {
+			if ip := forwardedIP(r, parseProxies(cfg.TrustedProxies)); ip != "" {
+				r.RemoteAddr = ip
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedIP returns the client address a trusted proxy forwarded, or ""
+// when the peer is not a trusted proxy or forwarded no valid address.
+// X-Forwarded-For is read from the right, skipping further trusted
+// proxies, since hops left of the first untrusted one are client supplied.
+func forwardedIP(r *http.Request, proxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trusted(net.ParseIP(host), proxies) {
+		return ""
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			return ""
+		}
+		if i == 0 || !trusted(ip, proxies) {
+			return ip.String()
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+func trusted(ip net.IP, proxies []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxies parses IPs and CIDR ranges, skipping invalid entries
+func parseProxies(list []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				continue
+			}
+			if ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		if _, n, err := net.ParseCIDR(item); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
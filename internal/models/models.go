@@ -32,8 +32,11 @@ type Site struct {
 	SSL         bool              `json:"ssl"`
 	Status      string            `json:"status"` // active, suspended, pending
 	Environment map[string]string `json:"environment,omitempty"`
-	CreatedAt   time.Time         `json:"created_at"`
-	UpdatedAt   time.Time         `json:"updated_at"`
+	// InterceptErrors serves the custom error pages in place of error
+	// responses returned by the application, not only proxy failures
+	InterceptErrors bool      `json:"intercept_errors"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
 }
 
 // PHPInstance represents a running FrankenPHP instance for a specific PHP version
@@ -89,6 +92,136 @@ type Config struct {
 	// of /usr/sbin/chpasswd (e.g., `fastcpuser ALL=(root) NOPASSWD: /usr/sbin/chpasswd`).
 	// Default: false (disabled)
 	AllowSudoPasswordChange bool `json:"allow_sudo_password_change,omitempty"`
+
+	// SiteLogs controls rotation of per-site access and error logs
+	SiteLogs LogRotation `json:"site_logs"`
+
+	// Bandwidth controls what happens when a user exceeds their monthly transfer
+	Bandwidth BandwidthPolicy `json:"bandwidth"`
+
+	// UsageSampleSeconds is how often per-user resource usage is recorded
+	UsageSampleSeconds int `json:"usage_sample_seconds"`
+
+	// Notifications controls alerts sent when a user hits a resource limit
+	Notifications NotificationSettings `json:"notifications"`
+
+	// RequireTwoFactorAdmins makes admins enroll in two-factor
+	// authentication before they can log in
+	RequireTwoFactorAdmins bool `json:"require_2fa_admins"`
+
+	// WebAuthn sets the relying party for security key and passkey logins
+	WebAuthn WebAuthnSettings `json:"webauthn"`
+
+	// RateLimit throttles failed logins and API key requests
+	RateLimit RateLimitSettings `json:"rate_limit"`
+
+	// TrustedProxies lists the IPs and CIDR ranges of reverse proxies in
+	// front of the panel. Only their X-Forwarded-For and X-Real-IP headers
+	// are believed; by default the client is the TCP peer.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	// OIDC enables single sign-on through an OpenID Connect provider
+	OIDC OIDCSettings `json:"oidc"`
+
+	// LDAP verifies passwords against a directory before local accounts
+	LDAP LDAPSettings `json:"ldap"`
+
+	// SFTP runs a built-in SFTP server next to sshd
+	SFTP SFTPSettings `json:"sftp"`
+}
+
+// SFTPSettings configures the built-in SFTP server. Users log in with their
+// panel password or a key from their authorized_keys and only see their
+// /home/<user>/www directory. Changes apply after a restart.
+type SFTPSettings struct {
+	Enabled     bool   `json:"enabled"`
+	ListenAddr  string `json:"listen_addr,omitempty"`   // Defaults to :2222
+	HostKeyFile string `json:"host_key_file,omitempty"` // Created on first start, defaults to <data_dir>/sftp_host_ed25519_key
+}
+
+// LDAPSettings configures password logins against an LDAP directory. Users
+// are found with UserFilter under BaseDN and verified by binding as them.
+type LDAPSettings struct {
+	Enabled            bool     `json:"enabled"`
+	URL                string   `json:"url,omitempty"`                  // ldap://host:389 or ldaps://host:636
+	StartTLS           bool     `json:"start_tls,omitempty"`            // Upgrade ldap:// connections with StartTLS
+	CACertFile         string   `json:"ca_cert_file,omitempty"`         // PEM CA bundle for the server certificate, system roots if empty
+	InsecureSkipVerify bool     `json:"insecure_skip_verify,omitempty"` // Don't verify the server certificate (testing only)
+	BindDN             string   `json:"bind_dn,omitempty"`              // Service account for searches, anonymous if empty
+	BindPassword       string   `json:"bind_password,omitempty"`
+	BaseDN             string   `json:"base_dn,omitempty"`          // Where users are searched
+	UserFilter         string   `json:"user_filter,omitempty"`      // Defaults to (uid={username})
+	GroupBaseDN        string   `json:"group_base_dn,omitempty"`    // Where groups are searched, only memberOf is used if empty
+	GroupFilter        string   `json:"group_filter,omitempty"`     // Defaults to (|(member={dn})(memberUid={username}))
+	AllowedGroups      []string `json:"allowed_groups,omitempty"`   // Groups (CN or DN) that may log in (empty = all)
+	AdminGroups        []string `json:"admin_groups,omitempty"`     // Groups mapped to the admin role
+	ResellerGroups     []string `json:"reseller_groups,omitempty"`  // Groups mapped to the reseller role
+	AutoProvision      bool     `json:"auto_provision"`             // Create the Unix account on first login
+	TrustedAccounts    []string `json:"trusted_accounts,omitempty"` // Root, system or local admin accounts directory users may log in as
+	TimeoutSeconds     int      `json:"timeout_seconds,omitempty"`  // Defaults to 10
+}
+
+// OIDCSettings configures single sign-on with an OpenID Connect provider.
+// IdP users log in as the Unix account named by UsernameClaim (or mapped in
+// UsernameMap), which must exist and be allowed to use FastCP. Root, system
+// and admin accounts can only be reached through UsernameMap.
+type OIDCSettings struct {
+	Enabled        bool              `json:"enabled"`
+	Name           string            `json:"name,omitempty"`   // Shown on the login button, e.g. "Okta"
+	Issuer         string            `json:"issuer,omitempty"` // e.g. https://id.example.com/realms/main
+	ClientID       string            `json:"client_id,omitempty"`
+	ClientSecret   string            `json:"client_secret,omitempty"`   // Empty for public clients
+	RedirectURL    string            `json:"redirect_url,omitempty"`    // Panel page the provider returns to with code and state
+	Scopes         []string          `json:"scopes,omitempty"`          // Defaults to openid, profile, email and groups
+	UsernameClaim  string            `json:"username_claim,omitempty"`  // Defaults to sub; email requires email_verified
+	UsernameMap    map[string]string `json:"username_map,omitempty"`    // Claim value to Unix username, for names that differ
+	GroupsClaim    string            `json:"groups_claim,omitempty"`    // Defaults to groups; dots select nested claims
+	AllowedGroups  []string          `json:"allowed_groups,omitempty"`  // IdP groups that may log in (empty = all)
+	AdminGroups    []string          `json:"admin_groups,omitempty"`    // IdP groups mapped to the admin role
+	ResellerGroups []string          `json:"reseller_groups,omitempty"` // IdP groups mapped to the reseller role
+}
+
+// RateLimitSettings controls lockouts after failed logins or API keys and
+// the request rate of API key routes
+type RateLimitSettings struct {
+	MaxFailuresPerIP     int      `json:"max_failures_per_ip"`     // Failures from one IP before it is locked out (0 = no limit)
+	MaxFailuresPerUser   int      `json:"max_failures_per_user"`   // Failures for one username before it is locked out (0 = no limit)
+	WindowMinutes        int      `json:"window_minutes"`          // Failures older than this are forgotten
+	LockoutMinutes       int      `json:"lockout_minutes"`         // First lockout, doubled for each further one
+	MaxLockoutMinutes    int      `json:"max_lockout_minutes"`     // Longest lockout (0 = no cap)
+	BanAfterLockouts     int      `json:"ban_after_lockouts"`      // IP lockouts within a day before the IP is banned (0 = never)
+	BanMinutes           int      `json:"ban_minutes"`             // Length of a ban
+	APIRequestsPerMinute int      `json:"api_requests_per_minute"` // Requests per IP to API key routes (0 = no limit)
+	Allowlist            []string `json:"allowlist,omitempty"`     // IPs and CIDR ranges that are never limited
+}
+
+// WebAuthnSettings identifies the panel to WebAuthn authenticators. When
+// RPID is empty the domain and origin the panel is accessed on are used.
+type WebAuthnSettings struct {
+	RPID    string   `json:"rp_id,omitempty"`   // Domain credentials are bound to, e.g. panel.example.com
+	RPName  string   `json:"rp_name,omitempty"` // Shown by the browser, defaults to FastCP
+	Origins []string `json:"origins,omitempty"` // Accepted origins, e.g. https://panel.example.com:8080
+}
+
+// NotificationSettings holds where resource limit events are sent
+type NotificationSettings struct {
+	WebhookURL      string `json:"webhook_url,omitempty"` // Receives each event as a JSON POST
+	Email           string `json:"email,omitempty"`       // Admin address, sent through sendmail
+	NotifyUsers     bool   `json:"notify_users"`          // Also email users that have a notify_email
+	CooldownMinutes int    `json:"cooldown_minutes"`      // Minimum time between alerts per user and event type
+}
+
+// BandwidthPolicy holds the enforcement settings for monthly bandwidth limits
+type BandwidthPolicy struct {
+	Action          string `json:"action"`            // none, suspend or limit_cpu
+	LimitCPUPercent int    `json:"limit_cpu_percent"` // CPU limit applied by limit_cpu (100 = 1 core)
+}
+
+// LogRotation holds rotation settings for log files written by Caddy
+type LogRotation struct {
+	RollSizeMB   int `json:"roll_size_mb"`   // Rotate when the file reaches this size
+	RollKeep     int `json:"roll_keep"`      // Number of rotated files to keep
+	RollKeepDays int `json:"roll_keep_days"` // Delete rotated files older than this (0 = never)
 }
 
 // APIKey represents an API key for external integrations (WHMCS, etc.)
@@ -141,6 +274,7 @@ type Stats struct {
 type AuditLog struct {
 	ID         string    `json:"id"`
 	UserID     string    `json:"user_id"`
+	Username   string    `json:"username,omitempty"`
 	Action     string    `json:"action"`
 	Resource   string    `json:"resource"`
 	ResourceID string    `json:"resource_id"`
@@ -151,11 +285,73 @@ type AuditLog struct {
 
 // UserLimits represents resource limits for a user
 type UserLimits struct {
-	Username      string `json:"username"`
-	MaxSites      int    `json:"max_sites"`       // 0 = unlimited
-	MaxRAMMB      int64  `json:"max_ram_mb"`      // 0 = unlimited, memory limit in MB
-	MaxCPUPercent int    `json:"max_cpu_percent"` // 0 = unlimited, CPU limit (100 = 1 core)
-	MaxProcesses  int    `json:"max_processes"`   // 0 = unlimited, max concurrent processes
+	Username       string `json:"username"`
+	MaxSites       int    `json:"max_sites"`        // 0 = unlimited
+	MaxRAMMB       int64  `json:"max_ram_mb"`       // 0 = unlimited, memory limit in MB
+	MaxCPUPercent  int    `json:"max_cpu_percent"`  // 0 = unlimited, CPU limit (100 = 1 core)
+	MaxProcesses   int    `json:"max_processes"`    // 0 = unlimited, max concurrent processes
+	MaxBandwidthMB int64  `json:"max_bandwidth_mb"` // 0 = unlimited, monthly transfer in MB
+	MaxDiskMB      int64  `json:"max_disk_mb"`      // 0 = unlimited, disk space in MB
+	MemoryHighMB   int64  `json:"memory_high_mb"`   // 0 = 90% of max_ram_mb, soft limit where reclaim and throttling start
+	MaxSwapMB      int64  `json:"max_swap_mb"`      // 0 = unlimited, -1 = no swap
+	MaxIOReadMBps  int64  `json:"max_io_read_mbps"` // 0 = unlimited, disk read bandwidth
+	MaxIOWriteMBps int64  `json:"max_io_write_mbps"`
+	MaxIOReadIOPS  int    `json:"max_io_read_iops"` // 0 = unlimited
+	MaxIOWriteIOPS int    `json:"max_io_write_iops"`
+	NotifyEmail    string `json:"notify_email,omitempty"` // Receives resource limit alerts
+
+	Package      string   `json:"package,omitempty"`      // ID of the package the limits come from
+	MaxDatabases int      `json:"max_databases"`          // 0 = unlimited
+	PHPVersions  []string `json:"php_versions,omitempty"` // Allowed PHP versions, empty = all enabled
+	MaxBackupMB  int64    `json:"max_backup_mb"`          // 0 = unlimited, space for backups
+	Sandbox      bool     `json:"sandbox,omitempty"`      // PHP runs sandboxed, set by the package
+
+	Owner    string `json:"owner,omitempty"` // Reseller the account belongs to, empty = admin managed
+	MaxUsers int    `json:"max_users"`       // Resellers only: 0 = unlimited customer accounts
+
+	ImpersonationDisabled bool `json:"impersonation_disabled,omitempty"` // Admins and resellers cannot act as this user
+}
+
+// Package is a hosting plan bundling limits and features assigned to users
+type Package struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	MaxSites       int       `json:"max_sites"`        // 0 = unlimited
+	MaxRAMMB       int64     `json:"max_ram_mb"`       // 0 = unlimited
+	MaxCPUPercent  int       `json:"max_cpu_percent"`  // 0 = unlimited (100 = 1 core)
+	MaxProcesses   int       `json:"max_processes"`    // 0 = unlimited
+	MaxDiskMB      int64     `json:"max_disk_mb"`      // 0 = unlimited
+	MaxBandwidthMB int64     `json:"max_bandwidth_mb"` // 0 = unlimited, monthly transfer
+	MaxDatabases   int       `json:"max_databases"`    // 0 = unlimited
+	PHPVersions    []string  `json:"php_versions"`     // Allowed PHP versions, empty = all enabled
+	ShellAccess    bool      `json:"shell_access"`     // SSH shell instead of jailed SFTP
+	MaxBackupMB    int64     `json:"max_backup_mb"`    // 0 = unlimited
+	MaxUsers       int       `json:"max_users"`        // Reseller packages: 0 = unlimited customer accounts
+	Sandbox        bool      `json:"sandbox"`          // PHP runs in its own mount namespace with a seccomp filter
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Role is a named set of permissions. Custom roles are assigned to users in
+// addition to their built-in role (admin, reseller or user).
+type Role struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Permissions []string  `json:"permissions"` // e.g. php:manage, or sites:* for every sites permission
+	BuiltIn     bool      `json:"built_in,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty"`
+}
+
+// TeamMember is a panel user invited to help manage another user's sites
+type TeamMember struct {
+	ID         string              `json:"id"`
+	Owner      string              `json:"owner"`    // Username of the account the sites belong to
+	Username   string              `json:"username"` // Collaborator
+	Sites      map[string][]string `json:"sites"`    // Site ID -> access (files, databases, deploy, logs)
+	Accepted   bool                `json:"accepted"`
+	CreatedAt  time.Time           `json:"created_at"`
+	AcceptedAt time.Time           `json:"accepted_at,omitempty"`
 }
 
 // Database represents a MySQL or PostgreSQL database
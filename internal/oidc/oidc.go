@@ -0,0 +1,541 @@
+// Package oidc implements the OpenID Connect authorization code flow with
+// PKCE for single sign-on: provider discovery, the authorization request,
+// the code exchange and ID token verification against the provider's keys.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+var (
+	ErrNotConfigured   = errors.New("single sign-on is not configured")
+	ErrInvalidState    = errors.New("invalid or expired login state")
+	ErrProvider        = errors.New("identity provider request failed")
+	ErrInvalidIDToken  = errors.New("invalid ID token")
+	ErrNoUsername      = errors.New("ID token has no username claim")
+	ErrGroupNotAllowed = errors.New("user is not in an allowed group")
+)
+
+const (
+	// StateTTL is how long a login may take at the identity provider
+	StateTTL = 10 * time.Minute
+	// MetadataTTL is how long discovery documents and keys are cached
+	MetadataTTL = time.Hour
+	// MaxPending caps logins waiting for a callback
+	MaxPending = 1000
+)
+
+// DefaultScopes are requested when no scopes are configured
+var DefaultScopes = []string{"openid", "profile", "email", "groups"}
+
+// Identity is the verified user returned by the identity provider
+type Identity struct {
+	Subject string
+	Email   string
+	Claims  map[string]interface{}
+}
+
+// discovery is the part of the provider metadata that is used
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// pending is a login waiting for the provider's callback
+type pending struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+// Provider runs logins against the configured identity provider, caching
+// its metadata and signing keys
+type Provider struct {
+	client *http.Client
+	now    func() time.Time
+
+	mu         sync.Mutex
+	issuer     string
+	meta       *discovery
+	keys       map[string]crypto.PublicKey
+	fetchedAt  time.Time
+	keysLoaded time.Time
+	pending    map[string]pending
+}
+
+// NewProvider creates a provider client
+func NewProvider() *Provider {
+	return &Provider{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		now:     time.Now,
+		pending: make(map[string]pending),
+	}
+}
+
+// Validate checks that settings are complete enough to log in
+func Validate(settings models.OIDCSettings) error {
+	if !settings.Enabled {
+		return nil
+	}
+	if settings.ClientID == "" {
+		return errors.New("oidc client_id is required")
+	}
+	for name, raw := range map[string]string{"issuer": settings.Issuer, "redirect_url": settings.RedirectURL} {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("oidc %s must be an http or https URL", name)
+		}
+	}
+	return nil
+}
+
+// Begin starts a login and returns the provider URL to send the browser to
+// and the state that comes back with the code
+func (p *Provider) Begin(ctx context.Context, settings models.OIDCSettings) (string, string, error) {
+	if !settings.Enabled || Validate(settings) != nil {
+		return "", "", ErrNotConfigured
+	}
+	meta, err := p.metadata(ctx, settings.Issuer)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	p.mu.Lock()
+	now := p.now()
+	for s, pend := range p.pending {
+		if now.After(pend.expires) {
+			delete(p.pending, s)
+		}
+	}
+	if len(p.pending) >= MaxPending {
+		p.mu.Unlock()
+		return "", "", errors.New("too many pending logins")
+	}
+	p.pending[state] = pending{nonce: nonce, verifier: verifier, expires: now.Add(StateTTL)}
+	p.mu.Unlock()
+
+	scopes := settings.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {settings.ClientID},
+		"redirect_uri":          {settings.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), state, nil
+}
+
+// Finish exchanges the code from the provider's callback and returns the
+// verified identity. Each state can be used once.
+func (p *Provider) Finish(ctx context.Context, settings models.OIDCSettings, state, code string) (*Identity, error) {
+	if !settings.Enabled {
+		return nil, ErrNotConfigured
+	}
+
+	p.mu.Lock()
+	pend, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || p.now().After(pend.expires) || code == "" {
+		return nil, ErrInvalidState
+	}
+
+	meta, err := p.metadata(ctx, settings.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {settings.RedirectURL},
+		"code_verifier": {pend.verifier},
+		"client_id":     {settings.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if settings.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(settings.ClientID), url.QueryEscape(settings.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.do(req, &tokens); err != nil {
+		if tokens.Error != "" {
+			return nil, fmt.Errorf("%w: %s %s", ErrProvider, tokens.Error, tokens.ErrorDescription)
+		}
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in token response", ErrProvider)
+	}
+
+	claims, err := p.verify(ctx, settings, meta, tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != pend.nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	identity := &Identity{Claims: claims}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	return identity, nil
+}
+
+// Username returns the Unix username for an identity and whether
+// UsernameMap named it. By default the account is keyed on the subject,
+// which the provider keeps unique and stable; preferred_username and
+// similar claims are often editable by the user. An email claim is only
+// used once the provider has verified the address.
+func Username(settings models.OIDCSettings, identity *Identity) (string, bool, error) {
+	claim := settings.UsernameClaim
+	if claim == "" {
+		claim = "sub"
+	}
+	value, _ := lookupClaim(identity.Claims, claim).(string)
+	if value == "" {
+		return "", false, ErrNoUsername
+	}
+	if claim == "email" {
+		if verified, _ := identity.Claims["email_verified"].(bool); !verified {
+			return "", false, fmt.Errorf("%w: email address not verified", ErrNoUsername)
+		}
+	}
+	if mapped, ok := settings.UsernameMap[value]; ok {
+		return mapped, true, nil
+	}
+	return value, false, nil
+}
+
+// Role returns the role the identity's groups map to. It returns false when
+// no role groups are configured and the Unix groups decide, and an error
+// when the user is not in an allowed group.
+func Role(settings models.OIDCSettings, identity *Identity) (string, bool, error) {
+	claim := settings.GroupsClaim
+	if claim == "" {
+		claim = "groups"
+	}
+	groups := map[string]bool{}
+	switch v := lookupClaim(identity.Claims, claim).(type) {
+	case string:
+		groups[v] = true
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups[s] = true
+			}
+		}
+	}
+	inAny := func(list []string) bool {
+		for _, g := range list {
+			if groups[g] {
+				return true
+			}
+		}
+		return false
+	}
+
+	admin, reseller := inAny(settings.AdminGroups), inAny(settings.ResellerGroups)
+	if len(settings.AllowedGroups) > 0 && !admin && !reseller && !inAny(settings.AllowedGroups) {
+		return "", false, ErrGroupNotAllowed
+	}
+	if len(settings.AdminGroups) == 0 && len(settings.ResellerGroups) == 0 {
+		return "", false, nil
+	}
+	switch {
+	case admin:
+		return "admin", true, nil
+	case reseller:
+		return "reseller", true, nil
+	}
+	return "user", true, nil
+}
+
+// verify checks the signature and standard claims of an ID token
+func (p *Provider) verify(ctx context.Context, settings models.OIDCSettings, meta *discovery, raw string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, meta, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(settings.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+		jwt.WithTimeFunc(p.now),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	// With several audiences the token must be meant for us
+	if azp, ok := claims["azp"].(string); ok && azp != settings.ClientID {
+		return nil, fmt.Errorf("%w: authorized party mismatch", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// metadata returns the provider's discovery document
+func (p *Provider) metadata(ctx context.Context, issuer string) (*discovery, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	p.mu.Lock()
+	if p.issuer == issuer && p.meta != nil && p.now().Sub(p.fetchedAt) < MetadataTTL {
+		meta := p.meta
+		p.mu.Unlock()
+		return meta, nil
+	}
+	p.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var meta discovery
+	if err := p.do(req, &meta); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("%w: discovery issuer %q does not match %q", ErrProvider, meta.Issuer, issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: incomplete discovery document", ErrProvider)
+	}
+
+	p.mu.Lock()
+	if p.issuer != issuer {
+		p.keys = nil
+	}
+	p.issuer = issuer
+	p.meta = &meta
+	p.fetchedAt = p.now()
+	p.mu.Unlock()
+	return &meta, nil
+}
+
+// key returns the signing key with the given ID, reloading the key set once
+// for keys the provider rotated in
+func (p *Provider) key(ctx context.Context, meta *discovery, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	keys := p.keys
+	fresh := p.now().Sub(p.keysLoaded) < MetadataTTL
+	p.mu.Unlock()
+
+	if key := pickKey(keys, kid); key != nil && fresh {
+		return key, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.do(req, &set); err != nil {
+		return nil, err
+	}
+	keys = make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysLoaded = p.now()
+	p.mu.Unlock()
+
+	if key := pickKey(keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// pickKey returns the key with the ID, or the only key for tokens without
+// a key ID
+func pickKey(keys map[string]crypto.PublicKey, kid string) crypto.PublicKey {
+	if key, ok := keys[kid]; ok {
+		return key
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// do sends a request and decodes the JSON response into v. On an error
+// status v is still decoded so OAuth error fields can be reported.
+func (p *Provider) do(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	decodeErr := json.Unmarshal(body, v)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %d", ErrProvider, req.URL.Path, resp.StatusCode)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("%w: %v", ErrProvider, decodeErr)
+	}
+	return nil
+}
+
+// jwk is a JSON Web Key
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey converts an RSA or EC key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, errors.New("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("RSA key too short")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// lookupClaim returns a claim, following dots into nested objects (e.g.
+// realm_access.roles)
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	if v, ok := claims[path]; ok {
+		return v
+	}
+	var current interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return current
+}
+
+// randomString returns 32 random bytes, base64url encoded
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,212 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+// mockProvider is a minimal OpenID Connect provider that issues an ID token
+// for a code, checking the PKCE verifier and client secret
+type mockProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		id, secret, _ := r.BasicAuth()
+		if r.PostForm.Get("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != m.challenge ||
+			id != "fastcp" || secret != "s3cret" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss": m.server.URL, "aud": "fastcp", "sub": "u-123", "nonce": m.nonce,
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+		}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// authorize plays the browser and the provider's login page
+func (m *mockProvider) authorize(t *testing.T, authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "fastcp" {
+		t.Fatalf("unexpected authorization request: %s", authURL)
+	}
+	m.challenge = q.Get("code_challenge")
+	m.nonce = q.Get("nonce")
+}
+
+func TestLoginFlow(t *testing.T) {
+	mock := newMockProvider(t)
+	mock.claims = jwt.MapClaims{
+		"preferred_username": "jdoe",
+		"email":              "jdoe@example.com",
+		"realm_access":       map[string]interface{}{"roles": []interface{}{"staff", "panel-admins"}},
+	}
+	settings := models.OIDCSettings{
+		Enabled:      true,
+		Issuer:       mock.server.URL,
+		ClientID:     "fastcp",
+		ClientSecret: "s3cret",
+		RedirectURL:  "https://panel.example.com/login/oidc",
+		UsernameMap:  map[string]string{"u-123": "john"},
+		GroupsClaim:  "realm_access.roles",
+		AdminGroups:  []string{"panel-admins"},
+	}
+	p := NewProvider()
+	ctx := context.Background()
+
+	authURL, state, err := p.Begin(ctx, settings)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	mock.authorize(t, authURL)
+
+	identity, err := p.Finish(ctx, settings, state, "good-code")
+	if err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	if identity.Subject != "u-123" || identity.Email != "jdoe@example.com" {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+	if username, explicit, err := Username(settings, identity); err != nil || username != "john" || !explicit {
+		t.Fatalf("expected mapped username john, got %q %v %v", username, explicit, err)
+	}
+	if role, mapped, err := Role(settings, identity); err != nil || !mapped || role != "admin" {
+		t.Fatalf("expected admin role, got %q %v %v", role, mapped, err)
+	}
+
+	// A state works only once
+	if _, err := p.Finish(ctx, settings, state, "good-code"); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("expected reused state to fail, got %v", err)
+	}
+
+	// A token issued for another login (nonce) is rejected
+	authURL, state, _ = p.Begin(ctx, settings)
+	mock.authorize(t, authURL)
+	mock.nonce = "other"
+	if _, err := p.Finish(ctx, settings, state, "good-code"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("expected nonce mismatch to fail, got %v", err)
+	}
+
+	// Without the PKCE verifier of this login the code is refused
+	authURL, state, _ = p.Begin(ctx, settings)
+	mock.authorize(t, authURL)
+	mock.challenge = "stolen"
+	if _, err := p.Finish(ctx, settings, state, "good-code"); !errors.Is(err, ErrProvider) {
+		t.Fatalf("expected PKCE mismatch to fail, got %v", err)
+	}
+
+	// Tokens for another client are rejected
+	authURL, state, _ = p.Begin(ctx, settings)
+	mock.authorize(t, authURL)
+	mock.claims["aud"] = "other-client"
+	if _, err := p.Finish(ctx, settings, state, "good-code"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("expected wrong audience to fail, got %v", err)
+	}
+}
+
+func TestRole(t *testing.T) {
+	identity := &Identity{Claims: map[string]interface{}{"groups": []interface{}{"devs"}}}
+
+	if _, mapped, err := Role(models.OIDCSettings{}, identity); err != nil || mapped {
+		t.Fatalf("expected Unix groups to decide without role groups, got %v %v", mapped, err)
+	}
+	if _, _, err := Role(models.OIDCSettings{AllowedGroups: []string{"ops"}}, identity); !errors.Is(err, ErrGroupNotAllowed) {
+		t.Fatalf("expected ErrGroupNotAllowed, got %v", err)
+	}
+	settings := models.OIDCSettings{AllowedGroups: []string{"devs"}, ResellerGroups: []string{"partners"}}
+	if role, mapped, err := Role(settings, identity); err != nil || !mapped || role != "user" {
+		t.Fatalf("expected user role, got %q %v %v", role, mapped, err)
+	}
+}
+
+func TestUsername(t *testing.T) {
+	identity := &Identity{Claims: map[string]interface{}{
+		"sub":                "3f2a9c",
+		"preferred_username": "root",
+		"email":              "root@example.com",
+	}}
+	tests := []struct {
+		name     string
+		settings models.OIDCSettings
+		want     string
+		explicit bool
+		err      error
+	}{
+		{"keyed on the subject", models.OIDCSettings{}, "3f2a9c", false, nil},
+		{"mapped subject", models.OIDCSettings{UsernameMap: map[string]string{"3f2a9c": "root"}}, "root", true, nil},
+		{"configured claim", models.OIDCSettings{UsernameClaim: "preferred_username"}, "root", false, nil},
+		{"unverified email", models.OIDCSettings{UsernameClaim: "email"}, "", false, ErrNoUsername},
+		{"missing claim", models.OIDCSettings{UsernameClaim: "nickname"}, "", false, ErrNoUsername},
+	}
+	for _, tt := range tests {
+		got, explicit, err := Username(tt.settings, identity)
+		if !errors.Is(err, tt.err) || got != tt.want || explicit != tt.explicit {
+			t.Errorf("%s: got %q %v %v, want %q %v %v", tt.name, got, explicit, err, tt.want, tt.explicit, tt.err)
+		}
+	}
+
+	identity.Claims["email_verified"] = true
+	if got, _, err := Username(models.OIDCSettings{UsernameClaim: "email"}, identity); err != nil || got != "root@example.com" {
+		t.Errorf("expected verified email to be used, got %q %v", got, err)
+	}
+}
@@ -0,0 +1,244 @@
+// Package packages manages hosting packages (plans): named bundles of
+// resource limits and features that are assigned to users.
+package packages
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+var (
+	ErrPackageNotFound = errors.New("package not found")
+	ErrPackageExists   = errors.New("package already exists")
+	ErrInvalidName     = errors.New("invalid package name")
+	ErrInvalidLimits   = errors.New("package limits must not be negative")
+)
+
+// Package names are shown in WHMCS product configs, so keep them simple
+var nameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9 _.\-]{0,63}$`)
+
+// Manager stores packages in DataDir/packages.json
+type Manager struct {
+	mu       sync.RWMutex
+	path     string
+	packages map[string]*models.Package
+}
+
+// NewManager creates a package manager
+func NewManager(dataDir string) *Manager {
+	return &Manager{
+		path:     filepath.Join(dataDir, "packages.json"),
+		packages: make(map[string]*models.Package),
+	}
+}
+
+// Load reads packages from disk
+func (m *Manager) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var list []*models.Package
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for _, p := range list {
+		m.packages[p.ID] = p
+	}
+	return nil
+}
+
+// List returns all packages sorted by name
+func (m *Manager) List() []models.Package {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := make([]models.Package, 0, len(m.packages))
+	for _, p := range m.packages {
+		list = append(list, *p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+// Get returns a package by ID or, case-insensitively, by name. WHMCS
+// refers to packages by name.
+func (m *Manager) Get(idOrName string) (*models.Package, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if p := m.findUnlocked(idOrName); p != nil {
+		copied := *p
+		return &copied, nil
+	}
+	return nil, ErrPackageNotFound
+}
+
+// Create adds a new package
+func (m *Manager) Create(p *models.Package) (*models.Package, error) {
+	if err := validate(p); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.nameTakenUnlocked(p.Name, "") {
+		return nil, ErrPackageExists
+	}
+
+	created := *p
+	created.ID = uuid.New().String()
+	created.CreatedAt = time.Now()
+	created.UpdatedAt = created.CreatedAt
+	m.packages[created.ID] = &created
+
+	if err := m.saveUnlocked(); err != nil {
+		delete(m.packages, created.ID)
+		return nil, err
+	}
+	result := created
+	return &result, nil
+}
+
+// Update replaces the limits and features of a package
+func (m *Manager) Update(id string, p *models.Package) (*models.Package, error) {
+	if err := validate(p); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.packages[id]
+	if !ok {
+		return nil, ErrPackageNotFound
+	}
+	if m.nameTakenUnlocked(p.Name, id) {
+		return nil, ErrPackageExists
+	}
+
+	previous := *existing
+	updated := *p
+	updated.ID = id
+	updated.CreatedAt = existing.CreatedAt
+	updated.UpdatedAt = time.Now()
+	m.packages[id] = &updated
+
+	if err := m.saveUnlocked(); err != nil {
+		m.packages[id] = &previous
+		return nil, err
+	}
+	result := updated
+	return &result, nil
+}
+
+// Delete removes a package
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.packages[id]
+	if !ok {
+		return ErrPackageNotFound
+	}
+	delete(m.packages, id)
+
+	if err := m.saveUnlocked(); err != nil {
+		m.packages[id] = p
+		return err
+	}
+	return nil
+}
+
+// ApplyTo returns base with the limits of p applied. Limits a package does
+// not define (I/O, swap, memory.high, notification address) are kept.
+func ApplyTo(p *models.Package, base *models.UserLimits) *models.UserLimits {
+	limits := *base
+	limits.Package = p.ID
+	limits.MaxSites = p.MaxSites
+	limits.MaxRAMMB = p.MaxRAMMB
+	limits.MaxCPUPercent = p.MaxCPUPercent
+	limits.MaxProcesses = p.MaxProcesses
+	limits.MaxDiskMB = p.MaxDiskMB
+	limits.MaxBandwidthMB = p.MaxBandwidthMB
+	limits.MaxDatabases = p.MaxDatabases
+	limits.PHPVersions = append([]string(nil), p.PHPVersions...)
+	limits.MaxBackupMB = p.MaxBackupMB
+	limits.MaxUsers = p.MaxUsers
+	limits.Sandbox = p.Sandbox
+	return &limits
+}
+
+// findUnlocked looks a package up by ID or name (caller must hold lock)
+func (m *Manager) findUnlocked(idOrName string) *models.Package {
+	if p, ok := m.packages[idOrName]; ok {
+		return p
+	}
+	for _, p := range m.packages {
+		if strings.EqualFold(p.Name, idOrName) {
+			return p
+		}
+	}
+	return nil
+}
+
+// nameTakenUnlocked reports whether another package uses name (caller must hold lock)
+func (m *Manager) nameTakenUnlocked(name, exceptID string) bool {
+	for id, p := range m.packages {
+		if id != exceptID && strings.EqualFold(p.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveUnlocked writes packages to disk (caller must hold lock)
+func (m *Manager) saveUnlocked() error {
+	list := make([]*models.Package, 0, len(m.packages))
+	for _, p := range m.packages {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, data, 0644)
+}
+
+func validate(p *models.Package) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if !nameRegex.MatchString(p.Name) {
+		return ErrInvalidName
+	}
+	if p.MaxSites < 0 || p.MaxRAMMB < 0 || p.MaxCPUPercent < 0 || p.MaxProcesses < 0 ||
+		p.MaxDiskMB < 0 || p.MaxBandwidthMB < 0 || p.MaxDatabases < 0 || p.MaxBackupMB < 0 || p.MaxUsers < 0 {
+		return ErrInvalidLimits
+	}
+	return nil
+}
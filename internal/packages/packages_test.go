@@ -0,0 +1,72 @@
+package packages
+
+import (
+	"testing"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+func TestManagerCRUD(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir)
+
+	created, err := m.Create(&models.Package{Name: "Starter", MaxSites: 1, MaxRAMMB: 512, PHPVersions: []string{"8.3"}})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if created.ID == "" || created.CreatedAt.IsZero() {
+		t.Fatalf("expected ID and timestamps, got %+v", created)
+	}
+
+	if _, err := m.Create(&models.Package{Name: "starter"}); err != ErrPackageExists {
+		t.Fatalf("expected ErrPackageExists for duplicate name, got %v", err)
+	}
+	if _, err := m.Create(&models.Package{Name: "Bad", MaxSites: -1}); err != ErrInvalidLimits {
+		t.Fatalf("expected ErrInvalidLimits, got %v", err)
+	}
+	if _, err := m.Create(&models.Package{Name: "  "}); err != ErrInvalidName {
+		t.Fatalf("expected ErrInvalidName, got %v", err)
+	}
+
+	// WHMCS refers to packages by name
+	if p, err := m.Get("STARTER"); err != nil || p.ID != created.ID {
+		t.Fatalf("Get by name = %+v, %v", p, err)
+	}
+
+	updated, err := m.Update(created.ID, &models.Package{Name: "Starter", MaxSites: 3})
+	if err != nil || updated.MaxSites != 3 || !updated.CreatedAt.Equal(created.CreatedAt) {
+		t.Fatalf("Update = %+v, %v", updated, err)
+	}
+
+	// Packages survive a reload
+	reloaded := NewManager(dir)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if p, err := reloaded.Get(created.ID); err != nil || p.MaxSites != 3 {
+		t.Fatalf("reloaded package = %+v, %v", p, err)
+	}
+
+	if err := m.Delete(created.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := m.Get(created.ID); err != ErrPackageNotFound {
+		t.Fatalf("expected ErrPackageNotFound after delete, got %v", err)
+	}
+}
+
+func TestApplyTo(t *testing.T) {
+	pkg := &models.Package{ID: "p1", MaxSites: 5, MaxRAMMB: 1024, MaxDatabases: 2, PHPVersions: []string{"8.4"}, Sandbox: true}
+	base := &models.UserLimits{Username: "alice", MaxSites: 1, MaxIOReadMBps: 50, NotifyEmail: "alice@example.com"}
+
+	got := ApplyTo(pkg, base)
+	if got.Package != "p1" || got.MaxSites != 5 || got.MaxRAMMB != 1024 || got.MaxDatabases != 2 || !got.Sandbox {
+		t.Fatalf("package limits not applied: %+v", got)
+	}
+	if got.MaxIOReadMBps != 50 || got.NotifyEmail != "alice@example.com" || got.Username != "alice" {
+		t.Fatalf("limits outside the package were not kept: %+v", got)
+	}
+	if base.MaxSites != 1 {
+		t.Fatalf("base limits were modified")
+	}
+}
@@ -0,0 +1,70 @@
+package php
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+
+	"github.com/rehmatworks/fastcp/internal/jail"
+)
+
+// sandboxCommand wraps argv in the PHP sandbox of a user. The returned
+// file is the seccomp filter the command reads on start; close it once
+// the command started.
+func sandboxCommand(u *user.User, argv []string) (*exec.Cmd, *os.File, error) {
+	if _, err := os.Stat(jail.BwrapPath); err != nil {
+		return nil, nil, fmt.Errorf("bubblewrap is not installed")
+	}
+	if err := jail.PreparePHPSandbox(u); err != nil {
+		return nil, nil, err
+	}
+	filter, err := jail.SeccompFilter()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The filter is small enough for the pipe buffer
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = w.Write(filter)
+	w.Close()
+	if err != nil {
+		r.Close()
+		return nil, nil, err
+	}
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	// ExtraFiles start at descriptor 3
+	cmd := exec.Command(jail.BwrapPath, jail.PHPSandboxArgs(u, nil, 3, argv, exists)...)
+	cmd.ExtraFiles = []*os.File{r}
+	return cmd, r, nil
+}
+
+// RestartInstance stops and starts an instance, keeping its site count,
+// e.g. to move it into or out of the sandbox
+func (m *UserPHPManager) RestartInstance(username, version string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := UserInstanceKey(username, version)
+	inst, exists := m.instances[key]
+	if !exists || inst.Status != "running" {
+		return fmt.Errorf("instance not running")
+	}
+	siteCount := inst.SiteCount
+
+	if err := m.stopInstanceUnlocked(key); err != nil {
+		return err
+	}
+	if err := m.startInstanceUnlocked(username, version); err != nil {
+		return err
+	}
+	m.instances[key].SiteCount = siteCount
+	return nil
+}
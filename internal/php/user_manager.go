@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -17,7 +18,12 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/rehmatworks/fastcp/internal/caddy"
 	"github.com/rehmatworks/fastcp/internal/config"
+	"github.com/rehmatworks/fastcp/internal/jail"
+	"github.com/rehmatworks/fastcp/internal/limits"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sitelogs"
 )
 
 // UserInstance represents a FrankenPHP instance running for a specific user and PHP version
@@ -30,7 +36,8 @@ type UserInstance struct {
 	Process    *os.Process
 	Status     string
 	StartedAt  time.Time
-	SiteCount  int // Number of sites using this instance
+	SiteCount  int  // Number of sites using this instance
+	Sandboxed  bool // Runs in a bubblewrap sandbox
 }
 
 // UserInstanceKey creates a unique key for user+version
@@ -42,15 +49,50 @@ func UserInstanceKey(username, version string) string {
 type UserPHPManager struct {
 	instances map[string]*UserInstance // key: "username:version"
 	mu        sync.RWMutex
+	getSites  func() []models.Site
+	sandboxed func(username string) bool
+	limits    *limits.Manager
 }
 
 // NewUserPHPManager creates a new user PHP manager
 func NewUserPHPManager() *UserPHPManager {
 	return &UserPHPManager{
 		instances: make(map[string]*UserInstance),
+		limits:    limits.NewManager(slog.Default()),
 	}
 }
 
+// SetSitesFunc sets the function used to look up sites when generating
+// instance configs (used to split error logs per site)
+func (m *UserPHPManager) SetSitesFunc(getSites func() []models.Site) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.getSites = getSites
+}
+
+// SetSandboxFunc sets the function that reports whether the PHP instances
+// of a user run in a sandbox
+func (m *UserPHPManager) SetSandboxFunc(sandboxed func(username string) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sandboxed = sandboxed
+}
+
+// ReloadUserInstances reloads all running instances of a user so config
+// changes (e.g. added or removed sites) take effect
+func (m *UserPHPManager) ReloadUserInstances(username string) error {
+	var errs []string
+	for _, inst := range m.GetUserInstances(username) {
+		if err := m.ReloadInstance(username, inst.PHPVersion); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("errors reloading instances: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
 // GetSocketPath returns the socket path for a user and PHP version
 func GetSocketPath(username, version string) string {
 	return filepath.Join("/home", username, "run", fmt.Sprintf("php-%s.sock", version))
@@ -66,6 +108,29 @@ func GetLogPath(username, version string) string {
 	return filepath.Join("/home", username, "log", fmt.Sprintf("php-%s.log", version))
 }
 
+// ErrorLogPrependPath is the script PHP runs before each request to log
+// errors to the error log of the requested site, which the instance passes
+// in FASTCP_ERROR_LOG
+var ErrorLogPrependPath = "/usr/local/lib/fastcp/php/error-log.php"
+
+const errorLogPrepend = `<?php
+// Written by FastCP: logs PHP errors to the error log of the requested site
+if (!empty($_SERVER['FASTCP_ERROR_LOG'])) {
+    ini_set('error_log', $_SERVER['FASTCP_ERROR_LOG']);
+}
+`
+
+// writeErrorLogPrepend installs the script at ErrorLogPrependPath
+func writeErrorLogPrepend() error {
+	if current, err := os.ReadFile(ErrorLogPrependPath); err == nil && string(current) == errorLogPrepend {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(ErrorLogPrependPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(ErrorLogPrependPath, []byte(errorLogPrepend), 0644)
+}
+
 // EnsureUserDirectories creates the run/ and log/ directories for a user
 func EnsureUserDirectories(username string) error {
 	if runtime.GOOS != "linux" {
@@ -110,6 +175,13 @@ func (m *UserPHPManager) StartInstance(username, version string) error {
 		return nil
 	}
 
+	return m.startInstanceUnlocked(username, version)
+}
+
+// startInstanceUnlocked starts an instance (caller must hold lock)
+func (m *UserPHPManager) startInstanceUnlocked(username, version string) error {
+	key := UserInstanceKey(username, version)
+
 	// Ensure user directories exist
 	if err := EnsureUserDirectories(username); err != nil {
 		return err
@@ -147,6 +219,10 @@ func (m *UserPHPManager) StartInstance(username, version string) error {
 		SiteCount:  1,
 	}
 
+	if err := writeErrorLogPrepend(); err != nil {
+		return fmt.Errorf("failed to write error log script: %w", err)
+	}
+
 	// Generate Caddyfile for this user instance
 	caddyConfig := m.generateUserCaddyfile(username, version, inst.SocketPath)
 	configPath := filepath.Join("/home", username, "run", fmt.Sprintf("Caddyfile.php-%s", version))
@@ -163,7 +239,17 @@ func (m *UserPHPManager) StartInstance(username, version string) error {
 	_ = os.Chown(inst.LogFile, uid, gid)
 
 	// Start FrankenPHP process as the user
-	cmd := exec.Command(binaryPath, "run", "--config", configPath)
+	argv := []string{binaryPath, "run", "--config", configPath}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	var seccomp *os.File
+	if runtime.GOOS == "linux" && m.sandboxed != nil && m.sandboxed(username) {
+		inst.Sandboxed = true
+		if cmd, seccomp, err = sandboxCommand(u, argv); err != nil {
+			logFile.Close()
+			return fmt.Errorf("failed to sandbox FrankenPHP: %w", err)
+		}
+		defer seccomp.Close()
+	}
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
 
@@ -177,7 +263,8 @@ func (m *UserPHPManager) StartInstance(username, version string) error {
 		}
 	}
 
-	if err := cmd.Start(); err != nil {
+	// Start inside the user's cgroup so resource limits apply from the first instruction
+	if err := m.limits.StartInCgroup(cmd, username); err != nil {
 		logFile.Close()
 		return fmt.Errorf("failed to start FrankenPHP: %w", err)
 	}
@@ -255,8 +342,13 @@ func (m *UserPHPManager) stopInstanceUnlocked(key string) error {
 		return nil
 	}
 
-	// Send graceful shutdown signal
-	if err := inst.Process.Signal(syscall.SIGTERM); err != nil {
+	// Send graceful shutdown signal. bubblewrap does not pass signals on,
+	// so a sandbox gets it through its process group.
+	if inst.Sandboxed {
+		if err := syscall.Kill(-inst.Process.Pid, syscall.SIGTERM); err != nil {
+			_ = inst.Process.Kill()
+		}
+	} else if err := inst.Process.Signal(syscall.SIGTERM); err != nil {
 		_ = inst.Process.Kill()
 	}
 
@@ -343,6 +435,12 @@ func (m *UserPHPManager) ReloadInstance(username, version string) error {
 		return fmt.Errorf("instance not running")
 	}
 
+	// The sandbox setting may have changed
+	sandboxed := m.sandboxed != nil && m.sandboxed(username) && runtime.GOOS == "linux"
+	if sandboxed != inst.Sandboxed {
+		return m.RestartInstance(username, version)
+	}
+
 	// Use Unix socket admin API
 	adminSocketPath := filepath.Join("/home", username, "run", fmt.Sprintf("php-%s-admin.sock", version))
 
@@ -405,6 +503,8 @@ func (m *UserPHPManager) generateUserCaddyfile(username, version, socketPath str
 	# FrankenPHP specific settings
 	frankenphp {
 		num_threads 4
+		php_ini log_errors On
+		php_ini auto_prepend_file %s
 	}
 }
 
@@ -414,10 +514,11 @@ http:// {
 
 	# Dynamic root based on Host header - maps to /home/{user}/www/{domain}/
 	root * %s/{http.request.host}
-	
+%s
 	# PHP handling
 	php_server {
 		resolve_root_symlink
+		env FASTCP_ERROR_LOG {fastcp_error_log}
 	}
 	
 	# File server for static files
@@ -426,7 +527,43 @@ http:// {
 	# Logging
 	log
 }
-`, username, version, adminSocketPath, logPath, socketPath, wwwDir)
+`, username, version, adminSocketPath, logPath, ErrorLogPrependPath, socketPath, wwwDir, m.siteErrorLogs(username, version))
+}
+
+// userSites returns the active sites of a user on a PHP version
+func (m *UserPHPManager) userSites(username, version string) []models.Site {
+	if m.getSites == nil {
+		return nil
+	}
+
+	var list []models.Site
+	for _, site := range m.getSites() {
+		if site.Status != "active" || site.PHPVersion != version {
+			continue
+		}
+		if caddy.ExtractUsernameFromRootPath(site.RootPath) != username {
+			continue
+		}
+		list = append(list, site)
+	}
+	return list
+}
+
+// siteErrorLogs maps the hostnames of each site of the user to the site's
+// PHP error log; requests for other hosts log to the instance's error log
+func (m *UserPHPManager) siteErrorLogs(username, version string) string {
+	var b strings.Builder
+	b.WriteString("\n\t# PHP error log of each site, see ErrorLogPrependPath\n")
+	b.WriteString("\tmap {http.request.host} {fastcp_error_log} {\n")
+	for _, site := range m.userSites(username, version) {
+		path := sitelogs.ErrorLogPath(username, site.ID)
+		for _, host := range append([]string{site.Domain}, site.Aliases...) {
+			b.WriteString(fmt.Sprintf("\t\t%s %s\n", host, path))
+		}
+	}
+	b.WriteString(fmt.Sprintf("\t\tdefault %s\n", filepath.Join("/home", username, "log", fmt.Sprintf("php-%s-error.log", version))))
+	b.WriteString("\t}\n")
+	return b.String()
 }
 
 // waitForSocket waits for a Unix socket to become available
@@ -485,6 +622,13 @@ func (m *UserPHPManager) RecoverInstances() error {
 			continue
 		}
 
+		// Instances started by an older FastCP, or before the cgroup existed,
+		// may run outside the user's cgroup. Instances are started with
+		// Setpgid, so the PID is also the process group of their children.
+		if err := m.limits.AttachProcessGroup(username, pid); err != nil && err != limits.ErrCgroupUnavailable {
+			fmt.Printf("[FastCP] Warning: failed to attach PHP %s instance for user '%s' to its cgroup: %v\n", version, username, err)
+		}
+
 		// Process is running, add to our tracking
 		key := UserInstanceKey(username, version)
 		m.instances[key] = &UserInstance{
@@ -497,6 +641,7 @@ func (m *UserPHPManager) RecoverInstances() error {
 			Status:     "running",
 			StartedAt:  time.Now(), // We don't know the actual start time
 			SiteCount:  1,
+			Sandboxed:  jail.IsSandboxed(pid),
 		}
 
 		fmt.Printf("[FastCP] Recovered PHP %s instance for user '%s' (pid: %d)\n", version, username, pid)
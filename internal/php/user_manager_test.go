@@ -0,0 +1,37 @@
+package php
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+func TestUserCaddyfileErrorLogs(t *testing.T) {
+	m := NewUserPHPManager()
+	m.SetSitesFunc(func() []models.Site {
+		return []models.Site{
+			{ID: "a", Domain: "a.com", Aliases: []string{"www.a.com"}, RootPath: "/home/alice/www/a.com", PHPVersion: "8.3", Status: "active"},
+			{ID: "b", Domain: "b.com", RootPath: "/home/alice/www/b.com", PHPVersion: "8.2", Status: "active"},
+			{ID: "c", Domain: "c.com", RootPath: "/home/bob/www/c.com", PHPVersion: "8.3", Status: "active"},
+		}
+	})
+
+	out := m.generateUserCaddyfile("alice", "8.3", GetSocketPath("alice", "8.3"))
+	for _, want := range []string{
+		"php_ini auto_prepend_file " + ErrorLogPrependPath,
+		"\t\ta.com /home/alice/log/a-error.log\n",
+		"\t\twww.a.com /home/alice/log/a-error.log\n",
+		"\t\tdefault /home/alice/log/php-8.3-error.log\n",
+		"env FASTCP_ERROR_LOG {fastcp_error_log}",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in Caddyfile:\n%s", want, out)
+		}
+	}
+	// Only the user's sites on the version, and no logger writing into
+	// the proxy's log directory
+	if strings.Contains(out, "b.com") || strings.Contains(out, "c.com") || strings.Contains(out, "level ERROR") {
+		t.Errorf("unexpected site in Caddyfile:\n%s", out)
+	}
+}
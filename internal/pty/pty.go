@@ -0,0 +1,7 @@
+// Package pty starts commands on a pseudo-terminal, for the web terminal
+package pty
+
+import "errors"
+
+// ErrUnsupported is returned where pseudo-terminals are not supported
+var ErrUnsupported = errors.New("pseudo-terminals are not supported on this system")
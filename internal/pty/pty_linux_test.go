@@ -0,0 +1,46 @@
+//go:build linux
+
+package pty
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestStartAndResize(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "read line; stty size; tty")
+	master, err := Start(cmd, nil)
+	if err != nil {
+		t.Skipf("no pseudo-terminals here: %v", err)
+	}
+	defer master.Close()
+	defer cmd.Wait()
+
+	if err := Resize(master, 100, 40); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+	if _, err := master.Write([]byte("go\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	buf := make([]byte, 1024)
+	deadline := time.Now().Add(5 * time.Second)
+	for !bytes.Contains(out.Bytes(), []byte("/dev/pts/")) && time.Now().Before(deadline) {
+		master.SetReadDeadline(deadline)
+		n, err := master.Read(buf)
+		out.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+
+	// The typed line is echoed, the command sees the size and its terminal
+	for _, want := range []string{"go", "40 100", "/dev/pts/"} {
+		if !bytes.Contains(out.Bytes(), []byte(want)) {
+			t.Errorf("expected %q in output %q", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package pty
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Start is only supported on Linux
+func Start(cmd *exec.Cmd, start func(*exec.Cmd) error) (*os.File, error) {
+	return nil, ErrUnsupported
+}
+
+// Resize is only supported on Linux
+func Resize(master *os.File, cols, rows uint16) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,103 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Filesystem magic numbers from statfs(2)
+const (
+	extMagic = 0xEF53 // ext2, ext3 and ext4
+	xfsMagic = 0x58465342
+)
+
+var errQuotaUnsupported = errors.New("filesystem quotas not supported")
+
+// runCommand runs an external quota tool; replaced in tests
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// setFSQuota sets a hard block limit for the user on the filesystem holding
+// dir. It requires root and quota accounting enabled on the mount
+// (usrquota for ext4, uquota for xfs).
+func setFSQuota(dir, username string, limitMB int64) error {
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		return errQuotaUnsupported
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return err
+	}
+	mount, err := mountPoint(dir)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	switch int64(st.Type) {
+	case xfsMagic:
+		limit := fmt.Sprintf("limit -u bsoft=%dm bhard=%dm %s", limitMB, limitMB, username)
+		out, err = runCommand("xfs_quota", "-x", "-c", limit, mount)
+	case extMagic:
+		kb := strconv.FormatInt(limitMB*1024, 10)
+		out, err = runCommand("setquota", "-u", username, kb, kb, "0", "0", mount)
+	default:
+		return errQuotaUnsupported
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// readFSQuota returns the used and hard-limit blocks (KB) of a user's
+// kernel quota on the first filesystem that has one
+func readFSQuota(username string) (usedKB, hardKB int64, err error) {
+	if runtime.GOOS != "linux" {
+		return 0, 0, errQuotaUnsupported
+	}
+	out, err := runCommand("quota", "-w", "-u", username)
+	if err != nil && len(out) == 0 {
+		return 0, 0, err
+	}
+	return parseQuotaOutput(string(out))
+}
+
+// parseQuotaOutput parses `quota -w` output:
+//
+//	Disk quotas for user alice (uid 1001):
+//	     Filesystem  blocks   quota   limit   grace   files   quota   limit   grace
+//	      /dev/sda1  10244*   10240   10240   7days      12       0       0
+func parseQuotaOutput(out string) (usedKB, hardKB int64, err error) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "/") {
+			continue
+		}
+		used, err1 := strconv.ParseInt(strings.TrimSuffix(fields[1], "*"), 10, 64)
+		hard, err2 := strconv.ParseInt(fields[3], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		return used, hard, nil
+	}
+	return 0, 0, errQuotaUnsupported
+}
+
+// mountPoint returns the mount point of the filesystem holding dir
+func mountPoint(dir string) (string, error) {
+	out, err := runCommand("df", "--output=target", dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to find mount point: %w", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1]), nil
+}
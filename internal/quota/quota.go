@@ -0,0 +1,220 @@
+// Package quota enforces per-user disk limits.
+//
+// Where the home filesystem supports it (ext4 with usrquota, xfs with
+// uquota), the limit is set as a kernel user quota. Otherwise usage of
+// /home/<user> is scanned periodically and FastCP refuses writes it
+// performs itself (uploads, file edits, new sites) once a user is over quota.
+package quota
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+// Enforcement methods reported in Status
+const (
+	EnforcementFilesystem = "filesystem" // kernel quota, writes fail with EDQUOT
+	EnforcementScan       = "scan"       // periodic scan, FastCP blocks its own writes
+)
+
+const bytesPerMB = 1024 * 1024
+
+var ErrQuotaExceeded = errors.New("disk quota exceeded")
+
+// HomeBase is the directory holding user home directories
+var HomeBase = "/home"
+
+// Status is the disk quota state of a user
+type Status struct {
+	Username    string    `json:"username"`
+	LimitMB     int64     `json:"limit_mb"` // 0 = unlimited
+	UsedMB      int64     `json:"used_mb"`
+	Enforcement string    `json:"enforcement"`
+	OverQuota   bool      `json:"over_quota"`
+	CheckedAt   time.Time `json:"checked_at"`
+
+	usedBytes int64
+}
+
+// Monitor tracks disk usage of users with a disk limit
+type Monitor struct {
+	getLimit   func(username string) *models.UserLimits
+	listLimits func() []models.UserLimits
+	logger     *slog.Logger
+
+	mu     sync.RWMutex
+	status map[string]*Status
+}
+
+// NewMonitor creates a disk quota monitor
+func NewMonitor(getLimit func(username string) *models.UserLimits, listLimits func() []models.UserLimits, logger *slog.Logger) *Monitor {
+	return &Monitor{
+		getLimit:   getLimit,
+		listLimits: listLimits,
+		logger:     logger,
+		status:     make(map[string]*Status),
+	}
+}
+
+// Apply sets the filesystem quota of a user when supported and refreshes
+// the cached usage in the background. A limit of 0 removes the quota.
+func (m *Monitor) Apply(username string, limitMB int64) {
+	if err := setFSQuota(filepath.Join(HomeBase, username), username, limitMB); err != nil {
+		m.logger.Debug("filesystem quota not applied, falling back to scanning", "user", username, "error", err)
+	}
+
+	m.mu.Lock()
+	delete(m.status, username)
+	m.mu.Unlock()
+
+	go func() {
+		if _, err := m.Refresh(username); err != nil {
+			m.logger.Warn("failed to refresh disk usage", "user", username, "error", err)
+		}
+	}()
+}
+
+// Start rescans all users with a disk limit periodically until ctx is done
+func (m *Monitor) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for _, limit := range m.listLimits() {
+				if limit.MaxDiskMB <= 0 {
+					continue
+				}
+				if _, err := m.Refresh(limit.Username); err != nil {
+					m.logger.Warn("failed to refresh disk usage", "user", limit.Username, "error", err)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Refresh measures the disk usage of a user and updates the cache
+func (m *Monitor) Refresh(username string) (*Status, error) {
+	st := &Status{
+		Username:    username,
+		LimitMB:     m.getLimit(username).MaxDiskMB,
+		Enforcement: EnforcementScan,
+		CheckedAt:   time.Now(),
+	}
+
+	if usedKB, hardKB, err := readFSQuota(username); err == nil && hardKB > 0 {
+		st.Enforcement = EnforcementFilesystem
+		st.usedBytes = usedKB * 1024
+	} else {
+		used, err := scanDir(filepath.Join(HomeBase, username))
+		if err != nil {
+			return nil, err
+		}
+		st.usedBytes = used
+	}
+	st.UsedMB = st.usedBytes / bytesPerMB
+	st.OverQuota = st.LimitMB > 0 && st.usedBytes >= st.LimitMB*bytesPerMB
+
+	m.mu.Lock()
+	m.status[username] = st
+	m.mu.Unlock()
+
+	c := *st
+	return &c, nil
+}
+
+// Status returns the cached quota state of a user, measuring it first if
+// the user was never scanned
+func (m *Monitor) Status(username string) (*Status, error) {
+	m.mu.RLock()
+	st, ok := m.status[username]
+	m.mu.RUnlock()
+	if !ok {
+		return m.Refresh(username)
+	}
+
+	c := *st
+	c.LimitMB = m.getLimit(username).MaxDiskMB
+	c.OverQuota = c.LimitMB > 0 && c.usedBytes >= c.LimitMB*bytesPerMB
+	return &c, nil
+}
+
+// Check returns ErrQuotaExceeded if writing size more bytes would take the
+// user over their disk limit
+func (m *Monitor) Check(username string, size int64) error {
+	limitMB := m.getLimit(username).MaxDiskMB
+	if limitMB <= 0 {
+		return nil
+	}
+	st, err := m.Status(username)
+	if err != nil {
+		// Do not block writes because usage could not be measured
+		m.logger.Warn("failed to measure disk usage", "user", username, "error", err)
+		return nil
+	}
+	if st.usedBytes+max(size, 0) > limitMB*bytesPerMB {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Add accounts bytes written by FastCP since the last scan
+func (m *Monitor) Add(username string, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if st, ok := m.status[username]; ok {
+		st.usedBytes = max(st.usedBytes+size, 0)
+		st.UsedMB = st.usedBytes / bytesPerMB
+	}
+}
+
+// UsedMB returns the cached disk usage of a user without scanning.
+// It is safe to call while holding the site manager lock.
+func (m *Monitor) UsedMB(username string) int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if st, ok := m.status[username]; ok {
+		return st.usedBytes / bytesPerMB
+	}
+	return 0
+}
+
+// scanDir returns the disk space allocated to files under dir, like du
+func scanDir(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable entries rather than failing the whole scan
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			total += int64(st.Blocks) * 512
+		} else {
+			total += info.Size()
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	return total, err
+}
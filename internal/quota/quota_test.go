@@ -0,0 +1,75 @@
+package quota
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+func TestMonitorScan(t *testing.T) {
+	HomeBase = t.TempDir()
+	runCommand = func(string, ...string) ([]byte, error) { return nil, errors.New("not installed") }
+
+	dir := filepath.Join(HomeBase, "alice", "www")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "big.bin"), make([]byte, 3*bytesPerMB), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	limits := map[string]*models.UserLimits{"alice": {Username: "alice", MaxDiskMB: 5}}
+	getLimit := func(username string) *models.UserLimits {
+		if l, ok := limits[username]; ok {
+			return l
+		}
+		return &models.UserLimits{Username: username}
+	}
+	m := NewMonitor(getLimit, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	st, err := m.Status("alice")
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if st.UsedMB < 3 || st.Enforcement != EnforcementScan || st.OverQuota {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+
+	if err := m.Check("alice", bytesPerMB); err != nil {
+		t.Fatalf("expected 1 MB write to fit, got %v", err)
+	}
+	if err := m.Check("alice", 3*bytesPerMB); err != ErrQuotaExceeded {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+
+	// Writes accounted since the last scan count against the limit
+	m.Add("alice", 2*bytesPerMB)
+	if st, _ := m.Status("alice"); !st.OverQuota {
+		t.Fatalf("expected alice to be over quota after writes: %+v", st)
+	}
+
+	// Users without a limit are never blocked
+	if err := m.Check("bob", 1<<40); err != nil {
+		t.Fatalf("expected no limit for bob, got %v", err)
+	}
+}
+
+func TestParseQuotaOutput(t *testing.T) {
+	out := `Disk quotas for user alice (uid 1001):
+     Filesystem  blocks   quota   limit   grace   files   quota   limit   grace
+      /dev/sda1  10244*   10240   10240   7days      12       0       0
+`
+	used, hard, err := parseQuotaOutput(out)
+	if err != nil || used != 10244 || hard != 10240 {
+		t.Fatalf("parseQuotaOutput = %d, %d, %v", used, hard, err)
+	}
+
+	if _, _, err := parseQuotaOutput("Disk quotas for user bob (uid 1002): none\n"); err == nil {
+		t.Fatalf("expected an error without quota lines")
+	}
+}
@@ -0,0 +1,371 @@
+// Package ratelimit protects logins and API keys against guessing. Failed
+// attempts are counted per IP and per username; too many within the window
+// lock the IP or username out for a time that doubles with each further
+// lockout, and an IP that keeps getting locked out is banned.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+// Block types
+const (
+	TypeIP   = "ip"
+	TypeUser = "user"
+)
+
+// Block reasons
+const (
+	ReasonLockout = "lockout"
+	ReasonBan     = "ban"
+)
+
+// StrikeMemory is how long lockouts are remembered for escalating the next
+// one after the last failure
+const StrikeMemory = 24 * time.Hour
+
+var (
+	ErrNotBlocked       = errors.New("not blocked")
+	ErrInvalidAllowlist = errors.New("allowlist entries must be IP addresses or CIDR ranges")
+)
+
+// Block is an IP or username that is currently locked out or banned
+type Block struct {
+	Type     string    `json:"type"`
+	Value    string    `json:"value"`
+	Reason   string    `json:"reason"`
+	Lockouts int       `json:"lockouts"`
+	Until    time.Time `json:"until"`
+}
+
+// entry tracks the failures of one IP or username
+type entry struct {
+	failures    []time.Time // within the window
+	strikes     int         // lockouts so far
+	lockedUntil time.Time
+	banned      bool
+	lastFailure time.Time
+}
+
+// bucket counts API requests of an IP in the current minute
+type bucket struct {
+	start time.Time
+	count int
+}
+
+// Limiter tracks failed attempts and request rates in memory
+type Limiter struct {
+	mu        sync.Mutex
+	settings  models.RateLimitSettings
+	allowlist []*net.IPNet
+	entries   map[string]*entry
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// New creates a limiter with the given settings
+func New(settings models.RateLimitSettings) (*Limiter, error) {
+	l := &Limiter{
+		entries: make(map[string]*entry),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+	if err := l.Configure(settings); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Validate checks rate limit settings
+func Validate(settings models.RateLimitSettings) error {
+	for _, v := range []int{
+		settings.MaxFailuresPerIP, settings.MaxFailuresPerUser, settings.WindowMinutes,
+		settings.LockoutMinutes, settings.MaxLockoutMinutes, settings.BanAfterLockouts,
+		settings.BanMinutes, settings.APIRequestsPerMinute,
+	} {
+		if v < 0 {
+			return errors.New("rate limit values must not be negative")
+		}
+	}
+	_, err := parseAllowlist(settings.Allowlist)
+	return err
+}
+
+// Configure replaces the settings. Current lockouts and bans are kept.
+func (l *Limiter) Configure(settings models.RateLimitSettings) error {
+	if err := Validate(settings); err != nil {
+		return err
+	}
+	allowlist, _ := parseAllowlist(settings.Allowlist)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settings = settings
+	l.allowlist = allowlist
+	return nil
+}
+
+// Check returns how long an attempt from ip for username has to wait, or
+// zero if it may go ahead. username may be empty.
+func (l *Limiter) Check(ip, username string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.allowedUnlocked(ip) {
+		return 0
+	}
+	now := l.now()
+	wait := l.waitUnlocked(key(TypeIP, ip), now)
+	if username != "" {
+		if w := l.waitUnlocked(key(TypeUser, username), now); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// Fail records a failed attempt and returns the lockouts and bans it caused
+func (l *Limiter) Fail(ip, username string) []Block {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.allowedUnlocked(ip) {
+		return nil
+	}
+	now := l.now()
+	l.pruneUnlocked(now)
+
+	var blocks []Block
+	if block := l.failUnlocked(TypeIP, ip, l.settings.MaxFailuresPerIP, now); block != nil {
+		blocks = append(blocks, *block)
+	}
+	if username != "" {
+		if block := l.failUnlocked(TypeUser, username, l.settings.MaxFailuresPerUser, now); block != nil {
+			blocks = append(blocks, *block)
+		}
+	}
+	return blocks
+}
+
+// Succeed forgets the failed attempts for a username after a successful
+// login. Failures of the IP are kept, so a valid account can't be used to
+// reset them.
+func (l *Limiter) Succeed(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[key(TypeUser, username)]; ok && !l.now().Before(e.lockedUntil) {
+		delete(l.entries, key(TypeUser, username))
+	}
+}
+
+// AllowRequest counts an API key request from ip and returns how long it
+// has to wait if the per-minute limit is used up
+func (l *Limiter) AllowRequest(ip string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit := l.settings.APIRequestsPerMinute
+	if limit == 0 || l.allowedUnlocked(ip) {
+		return 0
+	}
+	now := l.now()
+	l.pruneUnlocked(now)
+
+	b, ok := l.buckets[ip]
+	if !ok || now.Sub(b.start) >= time.Minute {
+		b = &bucket{start: now}
+		l.buckets[ip] = b
+	}
+	if b.count >= limit {
+		return b.start.Add(time.Minute).Sub(now)
+	}
+	b.count++
+	return 0
+}
+
+// Blocked returns the IPs and usernames that are currently locked out or
+// banned, those blocked longest first
+func (l *Limiter) Blocked() []Block {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	blocks := []Block{}
+	for k, e := range l.entries {
+		if !now.Before(e.lockedUntil) {
+			continue
+		}
+		t, value, _ := strings.Cut(k, ":")
+		blocks = append(blocks, blockFor(t, value, e))
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Until.After(blocks[j].Until)
+	})
+	return blocks
+}
+
+// Unblock lifts a lockout or ban and forgets its history
+func (l *Limiter) Unblock(blockType, value string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	k := key(blockType, value)
+	e, ok := l.entries[k]
+	if !ok || !l.now().Before(e.lockedUntil) {
+		return ErrNotBlocked
+	}
+	delete(l.entries, k)
+	return nil
+}
+
+// waitUnlocked returns the remaining lockout of a key (caller must hold
+// lock)
+func (l *Limiter) waitUnlocked(k string, now time.Time) time.Duration {
+	if e, ok := l.entries[k]; ok && now.Before(e.lockedUntil) {
+		return e.lockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// failUnlocked records a failure for a key and locks it out once it has
+// reached max failures within the window (caller must hold lock)
+func (l *Limiter) failUnlocked(blockType, value string, max int, now time.Time) *Block {
+	if max == 0 {
+		return nil
+	}
+	k := key(blockType, value)
+	e, ok := l.entries[k]
+	if !ok {
+		e = &entry{}
+		l.entries[k] = e
+	}
+	if now.Sub(e.lastFailure) > StrikeMemory {
+		e.strikes = 0
+	}
+	e.lastFailure = now
+
+	window := time.Duration(l.settings.WindowMinutes) * time.Minute
+	kept := e.failures[:0]
+	for _, t := range e.failures {
+		if now.Sub(t) < window {
+			kept = append(kept, t)
+		}
+	}
+	e.failures = append(kept, now)
+	if len(e.failures) < max || now.Before(e.lockedUntil) {
+		return nil
+	}
+
+	e.failures = nil
+	e.strikes++
+	if blockType == TypeIP && l.settings.BanAfterLockouts > 0 && e.strikes >= l.settings.BanAfterLockouts {
+		e.banned = true
+		e.lockedUntil = now.Add(time.Duration(l.settings.BanMinutes) * time.Minute)
+	} else {
+		e.banned = false
+		e.lockedUntil = now.Add(l.lockoutDuration(e.strikes))
+	}
+	block := blockFor(blockType, value, e)
+	return &block
+}
+
+// lockoutDuration returns the lockout for the given strike, doubling from
+// LockoutMinutes up to MaxLockoutMinutes
+func (l *Limiter) lockoutDuration(strikes int) time.Duration {
+	d := time.Duration(l.settings.LockoutMinutes) * time.Minute
+	max := time.Duration(l.settings.MaxLockoutMinutes) * time.Minute
+	for i := 1; i < strikes && (max == 0 || d < max); i++ {
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+// allowedUnlocked reports whether an IP is on the allowlist (caller must
+// hold lock)
+func (l *Limiter) allowedUnlocked(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range l.allowlist {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneUnlocked drops idle entries every few minutes so the maps stay small
+// under a distributed attack (caller must hold lock)
+func (l *Limiter) pruneUnlocked(now time.Time) {
+	if now.Sub(l.lastPrune) < 5*time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for k, e := range l.entries {
+		if !now.Before(e.lockedUntil) && now.Sub(e.lastFailure) > StrikeMemory {
+			delete(l.entries, k)
+		}
+	}
+	for ip, b := range l.buckets {
+		if now.Sub(b.start) >= time.Minute {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// blockFor describes the lockout of an entry
+func blockFor(blockType, value string, e *entry) Block {
+	reason := ReasonLockout
+	if e.banned {
+		reason = ReasonBan
+	}
+	return Block{Type: blockType, Value: value, Reason: reason, Lockouts: e.strikes, Until: e.lockedUntil}
+}
+
+// key returns the map key of an IP or username. Usernames are compared
+// case-insensitively so case variations count together.
+func key(blockType, value string) string {
+	if blockType == TypeUser {
+		value = strings.ToLower(value)
+	}
+	return blockType + ":" + value
+}
+
+// parseAllowlist parses IPs and CIDR ranges
+func parseAllowlist(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidAllowlist, item)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			item = fmt.Sprintf("%s/%d", item, bits)
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAllowlist, item)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+var testSettings = models.RateLimitSettings{
+	MaxFailuresPerIP:     6,
+	MaxFailuresPerUser:   3,
+	WindowMinutes:        15,
+	LockoutMinutes:       5,
+	MaxLockoutMinutes:    15,
+	BanAfterLockouts:     3,
+	BanMinutes:           60,
+	APIRequestsPerMinute: 2,
+	Allowlist:            []string{"10.0.0.0/8", "::1"},
+}
+
+func newTestLimiter(t *testing.T) (*Limiter, *time.Time) {
+	l, err := New(testSettings)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestUserLockoutEscalates(t *testing.T) {
+	l, now := newTestLimiter(t)
+
+	for _, want := range []time.Duration{5 * time.Minute, 10 * time.Minute, 15 * time.Minute} {
+		var blocks []Block
+		// Each attempt comes from another IP, as in a distributed attack
+		for _, ip := range []string{"203.0.113.2", "203.0.113.3", "203.0.113.4"} {
+			if wait := l.Check(ip, "Alice"); wait != 0 {
+				t.Fatalf("attempt from %s blocked early for %v", ip, wait)
+			}
+			blocks = l.Fail(ip, "alice")
+		}
+		if len(blocks) != 1 || blocks[0].Type != TypeUser || blocks[0].Reason != ReasonLockout {
+			t.Fatalf("expected a user lockout, got %+v", blocks)
+		}
+		if wait := l.Check("198.51.100.1", "ALICE"); wait != want {
+			t.Fatalf("expected lockout of %v, got %v", want, wait)
+		}
+		*now = now.Add(want)
+	}
+
+	// A successful login forgets the failures but not the strikes
+	l.Fail("203.0.113.1", "alice")
+	l.Succeed("alice")
+	if l.Fail("203.0.113.1", "alice") != nil || l.Fail("203.0.113.1", "alice") != nil {
+		t.Fatal("expected failures to be reset after a successful login")
+	}
+}
+
+func TestIPBan(t *testing.T) {
+	l, now := newTestLimiter(t)
+
+	var blocks []Block
+	for lockout := 1; lockout <= 3; lockout++ {
+		for i := 0; i < 6; i++ {
+			blocks = l.Fail("203.0.113.9", "")
+		}
+		if len(blocks) != 1 || blocks[0].Lockouts != lockout {
+			t.Fatalf("expected lockout %d, got %+v", lockout, blocks)
+		}
+		if lockout < 3 {
+			*now = blocks[0].Until
+		}
+	}
+	if blocks[0].Reason != ReasonBan || l.Check("203.0.113.9", "") != 60*time.Minute {
+		t.Fatalf("expected a one hour ban, got %+v", blocks)
+	}
+
+	if list := l.Blocked(); len(list) != 1 || list[0].Value != "203.0.113.9" {
+		t.Fatalf("unexpected blocked list: %+v", list)
+	}
+	if err := l.Unblock(TypeIP, "203.0.113.9"); err != nil || l.Check("203.0.113.9", "") != 0 {
+		t.Fatalf("Unblock failed: %v", err)
+	}
+	if err := l.Unblock(TypeIP, "203.0.113.9"); !errors.Is(err, ErrNotBlocked) {
+		t.Fatalf("expected ErrNotBlocked, got %v", err)
+	}
+}
+
+func TestAllowlistAndRequestRate(t *testing.T) {
+	l, now := newTestLimiter(t)
+
+	for i := 0; i < 10; i++ {
+		if l.Fail("10.1.2.3", "alice") != nil || l.AllowRequest("::1") != 0 {
+			t.Fatal("allowlisted addresses must never be limited")
+		}
+	}
+
+	if l.AllowRequest("203.0.113.1") != 0 || l.AllowRequest("203.0.113.1") != 0 {
+		t.Fatal("expected requests within the limit to pass")
+	}
+	*now = now.Add(20 * time.Second)
+	if wait := l.AllowRequest("203.0.113.1"); wait != 40*time.Second {
+		t.Fatalf("expected to wait 40s, got %v", wait)
+	}
+	*now = now.Add(40 * time.Second)
+	if l.AllowRequest("203.0.113.1") != 0 {
+		t.Fatal("expected a new minute to allow requests again")
+	}
+
+	if _, err := New(models.RateLimitSettings{Allowlist: []string{"not-an-ip"}}); !errors.Is(err, ErrInvalidAllowlist) {
+		t.Fatalf("expected invalid allowlist to fail, got %v", err)
+	}
+}
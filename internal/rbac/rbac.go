@@ -0,0 +1,480 @@
+// Package rbac defines the permissions routes require, the built-in admin,
+// reseller and user roles, and custom roles that grant extra permissions to
+// individual users. API keys are scoped with the same permission names.
+package rbac
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+var (
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrRoleExists         = errors.New("role already exists")
+	ErrInvalidName        = errors.New("invalid role name")
+	ErrUnknownPermission  = errors.New("unknown permission")
+	ErrReservedPermission = errors.New("permission is reserved for administrators")
+)
+
+// Permissions
+const (
+	SitesRead        = "sites:read"
+	SitesWrite       = "sites:write"
+	SitesSuspend     = "sites:suspend"
+	SitesTerminal    = "sites:terminal"
+	FilesRead        = "files:read"
+	FilesWrite       = "files:write"
+	PHPRead          = "php:read"
+	PHPManage        = "php:manage"
+	PHPInstall       = "php:install"
+	DatabasesRead    = "databases:read"
+	DatabasesCreate  = "databases:create"
+	DatabasesInstall = "databases:install"
+	SSLRead          = "ssl:read"
+	SSLIssue         = "ssl:issue"
+	EventsRead       = "events:read"
+	BandwidthRead    = "bandwidth:read"
+	UsersRead        = "users:read"
+	UsersManage      = "users:manage"
+	PackagesManage   = "packages:manage"
+	SecurityManage   = "security:manage"
+	SystemManage     = "system:manage"
+	SystemUpgrade    = "system:upgrade"
+	ConfigManage     = "config:manage"
+	APIKeysManage    = "apikeys:manage"
+	RolesManage      = "roles:manage"
+
+	// All grants every permission
+	All = "*"
+)
+
+// Permission describes a permission for the role editor
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Reserved    bool   `json:"reserved,omitempty"` // Only the admin role has it
+}
+
+// Catalog lists every permission
+var Catalog = []Permission{
+	{SitesRead, "View sites, logs and analytics", false},
+	{SitesWrite, "Create, update and delete sites", false},
+	{SitesSuspend, "Suspend and unsuspend sites", true},
+	{SitesTerminal, "Open a web terminal in sites", false},
+	{FilesRead, "Browse and download site files", false},
+	{FilesWrite, "Upload, edit and delete site files", false},
+	{PHPRead, "View PHP instances and versions", false},
+	{PHPManage, "Start, stop and restart PHP instances", false},
+	{PHPInstall, "Download and install PHP versions", true},
+	{DatabasesRead, "View databases and database servers", false},
+	{DatabasesCreate, "Create, delete and reset databases", false},
+	{DatabasesInstall, "Install MySQL and PostgreSQL", true},
+	{SSLRead, "View certificates", false},
+	{SSLIssue, "Issue, renew and delete certificates", false},
+	{EventsRead, "View resource limit events", false},
+	{BandwidthRead, "View the bandwidth report of all users", false},
+	{SecurityManage, "View the audit log and lift login blocks", true},
+	{SystemManage, "Reload services, SSH settings and the suspended page", true},
+	{PackagesManage, "Manage hosting packages", true},
+	{UsersRead, "View user accounts", true},
+	{UsersManage, "Create, update and delete user accounts", true},
+	{SystemUpgrade, "Upgrade FastCP", true},
+	{ConfigManage, "Change the panel configuration", true},
+	{APIKeysManage, "Manage API keys", true},
+	{RolesManage, "Manage roles and role assignments", true},
+}
+
+var userPermissions = []string{
+	SitesRead, SitesWrite, SitesTerminal, FilesRead, FilesWrite, PHPRead,
+	DatabasesRead, DatabasesCreate, SSLRead, SSLIssue, EventsRead,
+}
+
+// builtIn holds the permissions of the built-in roles. Which users and
+// sites a role reaches is still decided by the handlers: resellers manage
+// their own customers, users their own sites.
+var builtIn = map[string][]string{
+	"admin":    {All},
+	"reseller": append(append([]string(nil), userPermissions...), UsersRead, UsersManage),
+	"user":     userPermissions,
+}
+
+// Role names are used in URLs and stored per user
+var nameRegex = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// BuiltIn returns the built-in roles
+func BuiltIn() []models.Role {
+	roles := make([]models.Role, 0, len(builtIn))
+	for _, name := range []string{"admin", "reseller", "user"} {
+		roles = append(roles, models.Role{
+			Name:        name,
+			Permissions: append([]string(nil), builtIn[name]...),
+			BuiltIn:     true,
+		})
+	}
+	return roles
+}
+
+// BuiltInPermissions returns the permissions of a built-in role
+func BuiltInPermissions(role string) []string {
+	return append([]string(nil), builtIn[role]...)
+}
+
+// Grants reports whether granted includes permission, directly or through
+// "*" or a "resource:*" wildcard
+func Grants(granted []string, permission string) bool {
+	resource, _, _ := strings.Cut(permission, ":")
+	for _, g := range granted {
+		if g == All || g == permission || g == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid reports whether permission names a known permission or wildcard
+func Valid(permission string) bool {
+	if permission == All {
+		return true
+	}
+	for _, p := range Catalog {
+		if p.Name == permission {
+			return true
+		}
+		if resource, _, _ := strings.Cut(p.Name, ":"); permission == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// reserved reports whether permission grants any reserved permission
+func reserved(permission string) bool {
+	for _, p := range Catalog {
+		if p.Reserved && Grants([]string{permission}, p.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePermissions checks permissions for an API key, which may hold any
+// permission
+func ValidatePermissions(permissions []string) error {
+	for _, p := range permissions {
+		if !Valid(p) {
+			return fmt.Errorf("%w: %s", ErrUnknownPermission, p)
+		}
+	}
+	return nil
+}
+
+type store struct {
+	Roles       []*models.Role      `json:"roles"`
+	Assignments map[string][]string `json:"assignments"`
+}
+
+// Manager stores custom roles and their assignments in
+// DataDir/roles.json
+type Manager struct {
+	mu          sync.RWMutex
+	path        string
+	roles       map[string]*models.Role
+	assignments map[string][]string // username -> role names
+}
+
+// NewManager creates a role manager
+func NewManager(dataDir string) *Manager {
+	return &Manager{
+		path:        filepath.Join(dataDir, "roles.json"),
+		roles:       make(map[string]*models.Role),
+		assignments: make(map[string][]string),
+	}
+}
+
+// Load reads roles from disk
+func (m *Manager) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var st store
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	for _, r := range st.Roles {
+		m.roles[r.Name] = r
+	}
+	for username, names := range st.Assignments {
+		m.assignments[username] = names
+	}
+	return nil
+}
+
+// List returns the custom roles sorted by name
+func (m *Manager) List() []models.Role {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := make([]models.Role, 0, len(m.roles))
+	for _, r := range m.roles {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Get returns a custom role
+func (m *Manager) Get(name string) (*models.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.roles[name]
+	if !ok {
+		return nil, ErrRoleNotFound
+	}
+	copied := *r
+	return &copied, nil
+}
+
+// Create adds a custom role
+func (m *Manager) Create(r *models.Role) (*models.Role, error) {
+	if err := validate(r); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.roles[r.Name]; ok {
+		return nil, ErrRoleExists
+	}
+	created := *r
+	created.BuiltIn = false
+	created.CreatedAt = time.Now()
+	created.UpdatedAt = created.CreatedAt
+	m.roles[created.Name] = &created
+
+	if err := m.saveUnlocked(); err != nil {
+		delete(m.roles, created.Name)
+		return nil, err
+	}
+	result := created
+	return &result, nil
+}
+
+// Update replaces the description and permissions of a custom role
+func (m *Manager) Update(name string, r *models.Role) (*models.Role, error) {
+	r.Name = name
+	if err := validate(r); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.roles[name]
+	if !ok {
+		return nil, ErrRoleNotFound
+	}
+	previous := *existing
+	updated := *r
+	updated.BuiltIn = false
+	updated.CreatedAt = existing.CreatedAt
+	updated.UpdatedAt = time.Now()
+	m.roles[name] = &updated
+
+	if err := m.saveUnlocked(); err != nil {
+		m.roles[name] = &previous
+		return nil, err
+	}
+	result := updated
+	return &result, nil
+}
+
+// Delete removes a custom role and unassigns it from every user
+func (m *Manager) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.roles[name]
+	if !ok {
+		return ErrRoleNotFound
+	}
+	previous := make(map[string][]string, len(m.assignments))
+	for username, names := range m.assignments {
+		previous[username] = names
+	}
+
+	delete(m.roles, name)
+	for username, names := range m.assignments {
+		m.assignments[username] = remove(names, name)
+		if len(m.assignments[username]) == 0 {
+			delete(m.assignments, username)
+		}
+	}
+
+	if err := m.saveUnlocked(); err != nil {
+		m.roles[name] = r
+		m.assignments = previous
+		return err
+	}
+	return nil
+}
+
+// Assigned returns the custom roles assigned to a user
+func (m *Manager) Assigned(username string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]string{}, m.assignments[username]...)
+}
+
+// Assign replaces the custom roles of a user. An empty list removes them all.
+func (m *Manager) Assign(username string, names []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var unique []string
+	for _, name := range names {
+		if _, ok := m.roles[name]; !ok {
+			return ErrRoleNotFound
+		}
+		if !contains(unique, name) {
+			unique = append(unique, name)
+		}
+	}
+	sort.Strings(unique)
+
+	previous, had := m.assignments[username]
+	if len(unique) == 0 {
+		delete(m.assignments, username)
+	} else {
+		m.assignments[username] = unique
+	}
+
+	if err := m.saveUnlocked(); err != nil {
+		if had {
+			m.assignments[username] = previous
+		} else {
+			delete(m.assignments, username)
+		}
+		return err
+	}
+	return nil
+}
+
+// Permissions returns the permissions of a user with the given built-in
+// role and any custom roles assigned to them
+func (m *Manager) Permissions(role, username string) []string {
+	perms := BuiltInPermissions(role)
+	if m == nil {
+		return perms
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, name := range m.assignments[username] {
+		if r, ok := m.roles[name]; ok {
+			perms = append(perms, customPermissions(r.Permissions)...)
+		}
+	}
+	return perms
+}
+
+// customPermissions drops reserved permissions from a custom role saved
+// before they became reserved. A wildcard keeps the permissions it covers
+// that are not reserved.
+func customPermissions(granted []string) []string {
+	var perms []string
+	for _, p := range granted {
+		if !reserved(p) {
+			perms = append(perms, p)
+			continue
+		}
+		for _, c := range Catalog {
+			if !c.Reserved && Grants([]string{p}, c.Name) {
+				perms = append(perms, c.Name)
+			}
+		}
+	}
+	return perms
+}
+
+// saveUnlocked writes roles to disk (caller must hold lock)
+func (m *Manager) saveUnlocked() error {
+	st := store{Roles: make([]*models.Role, 0, len(m.roles)), Assignments: m.assignments}
+	for _, r := range m.roles {
+		st.Roles = append(st.Roles, r)
+	}
+	sort.Slice(st.Roles, func(i, j int) bool { return st.Roles[i].Name < st.Roles[j].Name })
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, data, 0644)
+}
+
+func validate(r *models.Role) error {
+	r.Name = strings.TrimSpace(r.Name)
+	if !nameRegex.MatchString(r.Name) {
+		return ErrInvalidName
+	}
+	if _, ok := builtIn[r.Name]; ok {
+		return ErrRoleExists
+	}
+
+	var perms []string
+	for _, p := range r.Permissions {
+		if !Valid(p) {
+			return fmt.Errorf("%w: %s", ErrUnknownPermission, p)
+		}
+		// Custom roles could otherwise grant their way to full admin
+		if reserved(p) {
+			return fmt.Errorf("%w: %s", ErrReservedPermission, p)
+		}
+		if !contains(perms, p) {
+			perms = append(perms, p)
+		}
+	}
+	r.Permissions = perms
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []string, s string) []string {
+	var out []string
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
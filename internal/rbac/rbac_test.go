@@ -0,0 +1,146 @@
+package rbac
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+func TestGrants(t *testing.T) {
+	cases := []struct {
+		granted    []string
+		permission string
+		want       bool
+	}{
+		{[]string{All}, RolesManage, true},
+		{[]string{PHPManage}, PHPManage, true},
+		{[]string{PHPManage}, PHPInstall, false},
+		{[]string{"php:*"}, PHPInstall, true},
+		{[]string{"php:*"}, SitesRead, false},
+		{nil, SitesRead, false},
+	}
+	for _, c := range cases {
+		if got := Grants(c.granted, c.permission); got != c.want {
+			t.Errorf("Grants(%v, %s) = %v, want %v", c.granted, c.permission, got, c.want)
+		}
+	}
+
+	if !Grants(BuiltInPermissions("reseller"), UsersManage) {
+		t.Error("resellers should manage users")
+	}
+	if Grants(BuiltInPermissions("user"), PHPManage) {
+		t.Error("users should not manage PHP")
+	}
+	if Grants(BuiltInPermissions("unknown"), SitesRead) {
+		t.Error("unknown roles should have no permissions")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	m := NewManager(t.TempDir())
+
+	invalid := []struct {
+		role *models.Role
+		err  error
+	}{
+		{&models.Role{Name: "Bad Name"}, ErrInvalidName},
+		{&models.Role{Name: "admin"}, ErrRoleExists},
+		{&models.Role{Name: "ops", Permissions: []string{"php:fly"}}, ErrUnknownPermission},
+		{&models.Role{Name: "ops", Permissions: []string{UsersManage}}, ErrReservedPermission},
+		{&models.Role{Name: "ops", Permissions: []string{"users:*"}}, ErrReservedPermission},
+		{&models.Role{Name: "ops", Permissions: []string{All}}, ErrReservedPermission},
+		{&models.Role{Name: "ops", Permissions: []string{PHPInstall}}, ErrReservedPermission},
+		{&models.Role{Name: "ops", Permissions: []string{"sites:*"}}, ErrReservedPermission},
+		{&models.Role{Name: "ops", Permissions: []string{SystemManage}}, ErrReservedPermission},
+	}
+	for _, c := range invalid {
+		if _, err := m.Create(c.role); !errors.Is(err, c.err) {
+			t.Errorf("Create(%+v) = %v, want %v", c.role, err, c.err)
+		}
+	}
+
+	if err := ValidatePermissions([]string{SitesRead, "databases:*"}); err != nil {
+		t.Errorf("ValidatePermissions: %v", err)
+	}
+	if err := ValidatePermissions([]string{"sites:fly"}); !errors.Is(err, ErrUnknownPermission) {
+		t.Errorf("expected ErrUnknownPermission, got %v", err)
+	}
+}
+
+func TestManager(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir)
+
+	created, err := m.Create(&models.Role{
+		Name:        "php-operator",
+		Description: "Restart PHP",
+		Permissions: []string{PHPManage, PHPManage},
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if len(created.Permissions) != 1 {
+		t.Fatalf("expected duplicate permissions to be dropped, got %v", created.Permissions)
+	}
+	if _, err := m.Create(&models.Role{Name: "php-operator"}); !errors.Is(err, ErrRoleExists) {
+		t.Fatalf("expected ErrRoleExists, got %v", err)
+	}
+
+	if err := m.Assign("junior", []string{"missing"}); !errors.Is(err, ErrRoleNotFound) {
+		t.Fatalf("expected ErrRoleNotFound, got %v", err)
+	}
+	if err := m.Assign("junior", []string{"php-operator"}); err != nil {
+		t.Fatalf("Assign failed: %v", err)
+	}
+	if !Grants(m.Permissions("user", "junior"), PHPManage) {
+		t.Fatal("expected junior to manage PHP")
+	}
+	if Grants(m.Permissions("user", "other"), PHPManage) {
+		t.Fatal("expected other users not to manage PHP")
+	}
+
+	// Roles and assignments survive a restart
+	reloaded := NewManager(dir)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := reloaded.Assigned("junior"); len(got) != 1 || got[0] != "php-operator" {
+		t.Fatalf("expected assignment after reload, got %v", got)
+	}
+
+	if _, err := reloaded.Update("php-operator", &models.Role{Permissions: []string{PHPRead}}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if Grants(reloaded.Permissions("user", "junior"), PHPManage) {
+		t.Fatal("expected updated role to drop php:manage")
+	}
+
+	if err := reloaded.Delete("php-operator"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if got := reloaded.Assigned("junior"); len(got) != 0 {
+		t.Fatalf("expected deleting a role to unassign it, got %v", got)
+	}
+
+	// Roles saved before a permission became reserved lose it
+	reloaded.roles["legacy"] = &models.Role{Name: "legacy", Permissions: []string{"sites:*", SecurityManage, PHPManage}}
+	reloaded.assignments["junior"] = []string{"legacy"}
+	perms := reloaded.Permissions("user", "junior")
+	for _, p := range []string{SitesSuspend, SecurityManage} {
+		if Grants(perms, p) {
+			t.Errorf("expected a custom role not to grant %s", p)
+		}
+	}
+	for _, p := range []string{SitesWrite, PHPManage} {
+		if !Grants(perms, p) {
+			t.Errorf("expected a custom role to keep %s", p)
+		}
+	}
+
+	// A nil manager still knows the built-in roles
+	var none *Manager
+	if !Grants(none.Permissions("admin", "root"), ConfigManage) {
+		t.Fatal("expected admin permissions without a manager")
+	}
+}
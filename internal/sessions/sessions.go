@@ -0,0 +1,384 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+)
+
+const (
+	// IdleTimeout ends a session that has not been refreshed for this long
+	IdleTimeout = 7 * 24 * time.Hour
+	// MaxLifetime ends a session this long after login, however active
+	MaxLifetime = 30 * 24 * time.Hour
+)
+
+// Session is a logged in device. Access tokens carry its ID and stop
+// working once it is revoked.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	Username   string    `json:"username"`
+	Role       string    `json:"role"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Device     string    `json:"device"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+
+	// The role the user's Unix groups gave at login. Role differs from it
+	// when a directory or single sign-on mapped the role.
+	GroupRole string `json:"group_role,omitempty"`
+
+	// Set on impersonations: the admin or reseller acting as the user and
+	// the session they started it from. Impersonations have no refresh
+	// token and end with that session.
+	Impersonator string `json:"impersonator,omitempty"`
+	ParentID     string `json:"parent_id,omitempty"`
+
+	// SHA-256 of the current refresh token, and of the one it replaced so
+	// reuse of a rotated token can be detected
+	RefreshHash  string `json:"refresh_hash"`
+	PreviousHash string `json:"previous_hash,omitempty"`
+}
+
+// Manager tracks sessions and their refresh tokens
+type Manager struct {
+	mu       sync.RWMutex
+	path     string
+	sessions map[string]*Session
+	now      func() time.Time
+}
+
+// NewManager creates a session manager storing sessions in dataDir
+func NewManager(dataDir string) *Manager {
+	return &Manager{
+		path:     filepath.Join(dataDir, "sessions.json"),
+		sessions: make(map[string]*Session),
+		now:      time.Now,
+	}
+}
+
+// Load reads the stored sessions
+func (m *Manager) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var list []*Session
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	m.sessions = make(map[string]*Session, len(list))
+	for _, s := range list {
+		m.sessions[s.ID] = s
+	}
+	return nil
+}
+
+// saveUnlocked drops expired sessions and writes the rest (caller must
+// hold lock). Refresh token hashes are sensitive, so the file is only
+// readable by root.
+func (m *Manager) saveUnlocked() error {
+	now := m.now()
+	list := make([]*Session, 0, len(m.sessions))
+	for id, s := range m.sessions {
+		if now.After(s.ExpiresAt) {
+			delete(m.sessions, id)
+			continue
+		}
+		list = append(list, s)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, data, 0600)
+}
+
+// Create starts a session and returns it with its refresh token
+func (m *Manager) Create(userID, username, role, groupRole, ip, userAgent string) (*Session, string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := m.now()
+	s := &Session{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Username:    username,
+		Role:        role,
+		GroupRole:   groupRole,
+		IP:          ip,
+		UserAgent:   userAgent,
+		Device:      DescribeDevice(userAgent),
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(IdleTimeout),
+		RefreshHash: hash,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[s.ID] = s
+	if err := m.saveUnlocked(); err != nil {
+		delete(m.sessions, s.ID)
+		return nil, "", err
+	}
+	result := *s
+	return &result, token, nil
+}
+
+// CreateImpersonation records an impersonation of username by impersonator,
+// started from the impersonator's session parentID. The impersonation token
+// carries id and expires with the session.
+func (m *Manager) CreateImpersonation(id, parentID, impersonator, userID, username, role, ip, userAgent string, expires time.Time) error {
+	now := m.now()
+	s := &Session{
+		ID:           id,
+		UserID:       userID,
+		Username:     username,
+		Role:         role,
+		IP:           ip,
+		UserAgent:    userAgent,
+		Device:       DescribeDevice(userAgent),
+		CreatedAt:    now,
+		LastSeenAt:   now,
+		ExpiresAt:    expires,
+		Impersonator: impersonator,
+		ParentID:     parentID,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[s.ID] = s
+	if err := m.saveUnlocked(); err != nil {
+		delete(m.sessions, s.ID)
+		return err
+	}
+	return nil
+}
+
+// Refresh rotates a refresh token and returns the session with the new
+// token. Presenting an already rotated token means it was stolen or
+// replayed, so the whole session is revoked.
+func (m *Manager) Refresh(refreshToken, ip, userAgent string) (*Session, string, error) {
+	hash := hashToken(refreshToken)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for id, s := range m.sessions {
+		if s.PreviousHash != "" && equal(s.PreviousHash, hash) {
+			m.deleteUnlocked(id)
+			m.saveUnlocked()
+			return nil, "", ErrInvalidRefreshToken
+		}
+		if !equal(s.RefreshHash, hash) {
+			continue
+		}
+		if now.After(s.ExpiresAt) {
+			delete(m.sessions, id)
+			m.saveUnlocked()
+			return nil, "", ErrInvalidRefreshToken
+		}
+
+		token, newHash, err := newRefreshToken()
+		if err != nil {
+			return nil, "", err
+		}
+		previous := *s
+		s.PreviousHash = s.RefreshHash
+		s.RefreshHash = newHash
+		s.LastSeenAt = now
+		s.ExpiresAt = now.Add(IdleTimeout)
+		if limit := s.CreatedAt.Add(MaxLifetime); s.ExpiresAt.After(limit) {
+			s.ExpiresAt = limit
+		}
+		if ip != "" {
+			s.IP = ip
+		}
+		if userAgent != "" && userAgent != s.UserAgent {
+			s.UserAgent = userAgent
+			s.Device = DescribeDevice(userAgent)
+		}
+		if err := m.saveUnlocked(); err != nil {
+			*s = previous
+			return nil, "", err
+		}
+		result := *s
+		return &result, token, nil
+	}
+	return nil, "", ErrInvalidRefreshToken
+}
+
+// Active reports whether a login session exists, has not expired and
+// belongs to the user
+func (m *Manager) Active(id, username string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.sessions[id]
+	return ok && s.Impersonator == "" && s.Username == username && m.now().Before(s.ExpiresAt)
+}
+
+// ImpersonationActive reports whether an impersonation of username by
+// impersonator exists and has not expired
+func (m *Manager) ImpersonationActive(id, username, impersonator string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.sessions[id]
+	return ok && s.Impersonator != "" && s.Impersonator == impersonator && s.Username == username && m.now().Before(s.ExpiresAt)
+}
+
+// List returns a user's active login sessions, most recently used first
+func (m *Manager) List(username string) []Session {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.now()
+	list := []Session{}
+	for _, s := range m.sessions {
+		if s.Impersonator == "" && s.Username == username && now.Before(s.ExpiresAt) {
+			list = append(list, *s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeenAt.After(list[j].LastSeenAt)
+	})
+	return list
+}
+
+// Revoke ends one of a user's sessions
+func (m *Manager) Revoke(username, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || s.Username != username {
+		return ErrSessionNotFound
+	}
+	m.deleteUnlocked(id)
+	return m.saveUnlocked()
+}
+
+// RevokeUser ends all of a user's sessions except keep (if not empty) and
+// returns how many were revoked
+func (m *Manager) RevokeUser(username, keep string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revoked := 0
+	for id, s := range m.sessions {
+		if s.Username == username && id != keep {
+			m.deleteUnlocked(id)
+			revoked++
+		}
+	}
+	if revoked == 0 {
+		return 0, nil
+	}
+	return revoked, m.saveUnlocked()
+}
+
+// deleteUnlocked removes a session and the impersonations started from it
+// (caller must hold lock)
+func (m *Manager) deleteUnlocked(id string) {
+	delete(m.sessions, id)
+	for other, s := range m.sessions {
+		if s.ParentID == id {
+			delete(m.sessions, other)
+		}
+	}
+}
+
+// DescribeDevice returns a short "Browser on OS" description of a user agent
+func DescribeDevice(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			return browser + " on " + o.name
+		}
+	}
+	return browser
+}
+
+// newRefreshToken returns a random refresh token and its hash
+func newRefreshToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+// hashToken hashes a refresh token for storage
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// equal compares two hashes in constant time
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,151 @@
+package sessions
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRefreshRotation(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir)
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+
+	session, first, err := m.Create("1000", "alice", "user", "user", "203.0.113.7",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if session.Device != "Chrome on Linux" || !m.Active(session.ID, "alice") {
+		t.Fatalf("unexpected session: %+v", session)
+	}
+	if m.Active(session.ID, "bob") {
+		t.Fatal("session must not be active for another user")
+	}
+
+	now = now.Add(time.Hour)
+	refreshed, second, err := m.Refresh(first, "198.51.100.1", "")
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if second == first || refreshed.ID != session.ID || refreshed.IP != "198.51.100.1" {
+		t.Fatalf("expected a rotated token for the same session, got %+v", refreshed)
+	}
+
+	// Sessions and tokens survive a reload, the file is private
+	reloaded := NewManager(dir)
+	reloaded.now = m.now
+	if err := reloaded.Load(); err != nil || !reloaded.Active(session.ID, "alice") {
+		t.Fatalf("expected session after reload, got %v", err)
+	}
+	if info, err := os.Stat(m.path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected sessions file with mode 0600, got %v", err)
+	}
+
+	// Replaying the rotated token revokes the session
+	if _, _, err := m.Refresh(first, "", ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected reused token to fail, got %v", err)
+	}
+	if m.Active(session.ID, "alice") {
+		t.Fatal("expected session to be revoked after token reuse")
+	}
+	if _, _, err := m.Refresh(second, "", ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected current token of a revoked session to fail, got %v", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	m := NewManager(t.TempDir())
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+
+	session, token, err := m.Create("1000", "alice", "user", "user", "", "")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Regular refreshes keep the session alive up to its maximum lifetime
+	for i := 0; i < 4; i++ {
+		now = now.Add(6 * 24 * time.Hour)
+		if _, token, err = m.Refresh(token, "", ""); err != nil {
+			t.Fatalf("refresh %d failed: %v", i, err)
+		}
+	}
+	if s := m.List("alice"); len(s) != 1 || !s[0].ExpiresAt.Equal(session.CreatedAt.Add(MaxLifetime)) {
+		t.Fatalf("expected expiry capped at the maximum lifetime, got %+v", s)
+	}
+
+	now = session.CreatedAt.Add(MaxLifetime + time.Second)
+	if m.Active(session.ID, "alice") {
+		t.Fatal("expected session to expire")
+	}
+	if _, _, err := m.Refresh(token, "", ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected refresh of an expired session to fail, got %v", err)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	m := NewManager(t.TempDir())
+
+	a, _, _ := m.Create("1000", "alice", "user", "user", "", "")
+	b, _, _ := m.Create("1000", "alice", "user", "user", "", "")
+	c, _, _ := m.Create("1000", "alice", "user", "user", "", "")
+	other, _, _ := m.Create("1001", "bob", "user", "user", "", "")
+
+	if err := m.Revoke("bob", a.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected another user's session to be hidden, got %v", err)
+	}
+	if err := m.Revoke("alice", a.ID); err != nil || m.Active(a.ID, "alice") {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+
+	revoked, err := m.RevokeUser("alice", c.ID)
+	if err != nil || revoked != 1 || m.Active(b.ID, "alice") || !m.Active(c.ID, "alice") {
+		t.Fatalf("expected only the other session to be revoked, got %d %v", revoked, err)
+	}
+	if !m.Active(other.ID, "bob") {
+		t.Fatal("another user's sessions must be kept")
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir)
+
+	admin, _, _ := m.Create("0", "root", "admin", "admin", "", "")
+	expires := time.Now().Add(30 * time.Minute)
+	if err := m.CreateImpersonation("imp-1", admin.ID, "root", "1000", "alice", "user", "", "", expires); err != nil {
+		t.Fatalf("CreateImpersonation failed: %v", err)
+	}
+	m.CreateImpersonation("imp-2", admin.ID, "root", "1000", "alice", "user", "", "", expires)
+
+	if !m.ImpersonationActive("imp-1", "alice", "root") {
+		t.Fatal("expected the impersonation to be active")
+	}
+	if m.ImpersonationActive("imp-1", "alice", "mallory") || m.ImpersonationActive(admin.ID, "root", "root") {
+		t.Fatal("impersonations must match their impersonator and login sessions are not impersonations")
+	}
+	// An impersonation is not a login session of the user
+	if m.Active("imp-1", "alice") || len(m.List("alice")) != 0 {
+		t.Fatal("impersonations must not count as the user's login sessions")
+	}
+
+	// Impersonations survive a restart, and ending one keeps the others
+	reloaded := NewManager(dir)
+	if err := reloaded.Load(); err != nil || !reloaded.ImpersonationActive("imp-1", "alice", "root") {
+		t.Fatalf("expected the impersonation to survive a reload, got %v", err)
+	}
+	if err := reloaded.Revoke("alice", "imp-1"); err != nil || reloaded.ImpersonationActive("imp-1", "alice", "root") {
+		t.Fatalf("expected the impersonation to end, got %v", err)
+	}
+	if !reloaded.ImpersonationActive("imp-2", "alice", "root") {
+		t.Fatal("expected the other impersonation to be kept")
+	}
+
+	// Ending the admin's session ends the impersonations started from it
+	if err := reloaded.Revoke("root", admin.ID); err != nil || reloaded.ImpersonationActive("imp-2", "alice", "root") {
+		t.Fatalf("expected impersonations to end with their session, got %v", err)
+	}
+}
@@ -0,0 +1,611 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/rehmatworks/fastcp/internal/audit"
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+// Quota checks and accounts disk usage of a user, see quota.Monitor
+type Quota interface {
+	Check(username string, size int64) error
+	Add(username string, size int64)
+}
+
+// session serves the SFTP requests of one channel
+type session struct {
+	root     *root
+	username string
+	uid, gid int
+	ip       string
+	quota    Quota
+	record   func(models.AuditLog)
+
+	out     io.Writer
+	handles map[string]*handle
+	next    uint64
+}
+
+// handle is an open file or directory
+type handle struct {
+	file    *os.File
+	path    string // Client path
+	dir     bool
+	append  bool
+	read    int64
+	written int64
+}
+
+// serve answers requests until the client closes the channel
+func (s *session) serve(rw io.ReadWriter) error {
+	// Requests get the user's file permissions, not those of FastCP
+	if err := actAs(s.uid, s.gid); err != nil {
+		return err
+	}
+	s.out = rw
+	s.handles = make(map[string]*handle)
+	defer s.closeAll()
+
+	data, err := readPacket(rw)
+	if err != nil {
+		return err
+	}
+	if data[0] != fxpInit {
+		return errors.New("sftp: expected init")
+	}
+	version := &encoder{buf: make([]byte, 4, 64)}
+	version.byte(fxpVersion)
+	version.uint32(3)
+	version.string("posix-rename@openssh.com")
+	version.string("1")
+	if _, err := s.out.Write(version.packet()); err != nil {
+		return err
+	}
+
+	for {
+		data, err := readPacket(rw)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := s.handle(data); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers one request
+func (s *session) handle(data []byte) error {
+	d := &decoder{data: data}
+	packetType := d.byte()
+	id := d.uint32()
+	if d.err != nil {
+		return d.err
+	}
+
+	var reply []byte
+	switch packetType {
+	case fxpOpen:
+		reply = s.open(id, d)
+	case fxpClose:
+		reply = s.status(id, s.close(d.string()))
+	case fxpRead:
+		reply = s.read(id, d)
+	case fxpWrite:
+		reply = s.write(id, d)
+	case fxpStat, fxpLstat:
+		reply = s.stat(id, d.string(), packetType == fxpStat)
+	case fxpFstat:
+		reply = s.fstat(id, d.string())
+	case fxpSetstat:
+		p := d.string()
+		reply = s.status(id, s.setstat(p, nil, d.attrs()))
+	case fxpFsetstat:
+		h, ok := s.handles[d.string()]
+		if !ok {
+			reply = s.status(id, os.ErrInvalid)
+			break
+		}
+		reply = s.status(id, s.setstat(h.path, h, d.attrs()))
+	case fxpOpendir:
+		reply = s.opendir(id, d.string())
+	case fxpReaddir:
+		reply = s.readdir(id, d.string())
+	case fxpRemove:
+		reply = s.status(id, s.remove(d.string()))
+	case fxpMkdir:
+		p := d.string()
+		reply = s.status(id, s.mkdir(p, d.attrs()))
+	case fxpRmdir:
+		reply = s.status(id, s.rmdir(d.string()))
+	case fxpRealpath:
+		reply = s.realpath(id, d.string())
+	case fxpRename:
+		from, to := d.string(), d.string()
+		reply = s.status(id, s.rename(from, to, false))
+	case fxpReadlink:
+		reply = s.readlink(id, d.string())
+	case fxpSymlink:
+		// OpenSSH sends the target first, contrary to the draft
+		target, link := d.string(), d.string()
+		reply = s.status(id, s.symlink(target, link))
+	case fxpExtended:
+		if d.string() != "posix-rename@openssh.com" {
+			reply = s.statusCode(id, fxOpUnsupported, "unsupported extension")
+			break
+		}
+		from, to := d.string(), d.string()
+		reply = s.status(id, s.rename(from, to, true))
+	default:
+		reply = s.statusCode(id, fxOpUnsupported, "unsupported request")
+	}
+	if d.err != nil {
+		reply = s.statusCode(id, fxBadMessage, "malformed request")
+	}
+
+	_, err := s.out.Write(reply)
+	return err
+}
+
+func (s *session) open(id uint32, d *decoder) []byte {
+	p := d.string()
+	pflags := d.uint32()
+	a := d.attrs()
+	if d.err != nil {
+		return nil
+	}
+
+	flags := 0
+	switch {
+	case pflags&fxfRead != 0 && pflags&fxfWrite != 0:
+		flags |= os.O_RDWR
+	case pflags&fxfWrite != 0:
+		flags |= os.O_WRONLY
+	default:
+		flags |= os.O_RDONLY
+	}
+	if pflags&fxfAppend != 0 {
+		flags |= os.O_APPEND
+	}
+	if pflags&fxfCreat != 0 {
+		flags |= os.O_CREATE
+	}
+	if pflags&fxfTrunc != 0 {
+		flags |= os.O_TRUNC
+	}
+	if pflags&fxfExcl != 0 {
+		flags |= os.O_EXCL
+	}
+	mode := os.FileMode(0644)
+	if a.flags&attrPermissions != 0 {
+		mode = os.FileMode(a.mode & 0777)
+	}
+
+	before, statErr := s.root.fs.Stat(name(p))
+	f, err := s.root.fs.OpenFile(name(p), flags, mode)
+	if err != nil {
+		return s.status(id, err)
+	}
+	if statErr == nil && flags&os.O_TRUNC != 0 && s.quota != nil {
+		s.quota.Add(s.username, -before.Size())
+	}
+
+	return s.newHandle(id, &handle{file: f, path: clean(p), append: flags&os.O_APPEND != 0})
+}
+
+func (s *session) opendir(id uint32, p string) []byte {
+	f, err := s.root.fs.Open(name(p))
+	if err != nil {
+		return s.status(id, err)
+	}
+	if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+		f.Close()
+		return s.statusCode(id, fxFailure, "not a directory")
+	}
+	return s.newHandle(id, &handle{file: f, path: clean(p), dir: true})
+}
+
+func (s *session) newHandle(id uint32, h *handle) []byte {
+	s.next++
+	key := strconv.FormatUint(s.next, 10)
+	s.handles[key] = h
+
+	e := newPacket(fxpHandle, id)
+	e.string(key)
+	return e.packet()
+}
+
+// close closes a handle and audits the transfer it made
+func (s *session) close(key string) error {
+	h, ok := s.handles[key]
+	if !ok {
+		return os.ErrInvalid
+	}
+	delete(s.handles, key)
+	s.audit(h)
+	return h.file.Close()
+}
+
+func (s *session) closeAll() {
+	for key := range s.handles {
+		s.close(key)
+	}
+}
+
+// audit records the bytes uploaded or downloaded through a handle
+func (s *session) audit(h *handle) {
+	if s.record == nil || h.dir {
+		return
+	}
+	if h.written > 0 {
+		s.record(models.AuditLog{
+			UserID:     strconv.Itoa(s.uid),
+			Username:   s.username,
+			Action:     audit.ActionSFTPUpload,
+			Resource:   "file",
+			ResourceID: h.path,
+			Details:    fmt.Sprintf("%d bytes", h.written),
+			IP:         s.ip,
+		})
+	}
+	if h.read > 0 {
+		s.record(models.AuditLog{
+			UserID:     strconv.Itoa(s.uid),
+			Username:   s.username,
+			Action:     audit.ActionSFTPDownload,
+			Resource:   "file",
+			ResourceID: h.path,
+			Details:    fmt.Sprintf("%d bytes", h.read),
+			IP:         s.ip,
+		})
+	}
+}
+
+func (s *session) read(id uint32, d *decoder) []byte {
+	h, ok := s.handles[d.string()]
+	offset := d.uint64()
+	length := d.uint32()
+	if d.err != nil {
+		return nil
+	}
+	if !ok || h.dir {
+		return s.status(id, os.ErrInvalid)
+	}
+
+	buf := make([]byte, min(length, maxRead))
+	n, err := h.file.ReadAt(buf, int64(offset))
+	if n == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return s.status(id, err)
+	}
+	h.read += int64(n)
+
+	e := newPacket(fxpData, id)
+	e.bytes(buf[:n])
+	return e.packet()
+}
+
+func (s *session) write(id uint32, d *decoder) []byte {
+	h, ok := s.handles[d.string()]
+	offset := d.uint64()
+	data := d.bytes()
+	if d.err != nil {
+		return nil
+	}
+	if !ok || h.dir {
+		return s.status(id, os.ErrInvalid)
+	}
+
+	fi, err := h.file.Stat()
+	if err != nil {
+		return s.status(id, err)
+	}
+	end := int64(offset) + int64(len(data))
+	if h.append {
+		end = fi.Size() + int64(len(data))
+	}
+	growth := max(end-fi.Size(), 0)
+	if err := s.checkQuota(growth); err != nil {
+		return s.status(id, err)
+	}
+
+	var n int
+	if h.append {
+		n, err = h.file.Write(data)
+	} else {
+		n, err = h.file.WriteAt(data, int64(offset))
+	}
+	h.written += int64(n)
+	if s.quota != nil && growth > 0 {
+		s.quota.Add(s.username, growth)
+	}
+	return s.status(id, err)
+}
+
+func (s *session) checkQuota(growth int64) error {
+	if s.quota == nil || growth <= 0 {
+		return nil
+	}
+	return s.quota.Check(s.username, growth)
+}
+
+func (s *session) stat(id uint32, p string, follow bool) []byte {
+	stat := s.root.fs.Lstat
+	if follow {
+		stat = s.root.fs.Stat
+	}
+	fi, err := stat(name(p))
+	if err != nil {
+		return s.status(id, err)
+	}
+	e := newPacket(fxpAttrs, id)
+	e.attrs(fileAttrs(fi))
+	return e.packet()
+}
+
+func (s *session) fstat(id uint32, key string) []byte {
+	h, ok := s.handles[key]
+	if !ok {
+		return s.status(id, os.ErrInvalid)
+	}
+	fi, err := h.file.Stat()
+	if err != nil {
+		return s.status(id, err)
+	}
+	e := newPacket(fxpAttrs, id)
+	e.attrs(fileAttrs(fi))
+	return e.packet()
+}
+
+// setstat changes size, permissions and times. Ownership stays with the
+// user, so uid and gid are ignored.
+func (s *session) setstat(p string, h *handle, a attrs) error {
+	var f *os.File
+	if h != nil {
+		f = h.file
+	} else {
+		// The changes are made through a descriptor, so they apply to
+		// the file that was looked up
+		flags := os.O_RDONLY
+		if a.flags&attrSize != 0 {
+			flags = os.O_WRONLY
+		}
+		var err error
+		f, err = s.root.fs.OpenFile(name(p), flags|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	if a.flags&attrSize != 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		growth := int64(a.size) - fi.Size()
+		if err := s.checkQuota(growth); err != nil {
+			return err
+		}
+		if err := f.Truncate(int64(a.size)); err != nil {
+			return err
+		}
+		if s.quota != nil {
+			s.quota.Add(s.username, growth)
+		}
+	}
+	if a.flags&attrPermissions != 0 {
+		// No setuid or setgid files in the web root
+		if err := f.Chmod(os.FileMode(a.mode&0777) | stickyBit(a.mode)); err != nil {
+			return err
+		}
+	}
+	if a.flags&attrACModTime != 0 {
+		times := []unix.Timeval{{Sec: int64(a.atime)}, {Sec: int64(a.mtime)}}
+		if err := unix.Futimes(int(f.Fd()), times); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stickyBit(mode uint32) os.FileMode {
+	if mode&syscall.S_ISVTX != 0 {
+		return os.ModeSticky
+	}
+	return 0
+}
+
+func (s *session) readdir(id uint32, key string) []byte {
+	h, ok := s.handles[key]
+	if !ok || !h.dir {
+		return s.status(id, os.ErrInvalid)
+	}
+	entries, err := h.file.Readdir(100)
+	if len(entries) == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return s.status(id, err)
+	}
+
+	e := newPacket(fxpName, id)
+	e.uint32(uint32(len(entries)))
+	for _, fi := range entries {
+		a := fileAttrs(fi)
+		e.string(fi.Name())
+		e.string(longName(fi, a))
+		e.attrs(a)
+	}
+	return e.packet()
+}
+
+func (s *session) remove(p string) error {
+	dir, base, err := s.root.parent(p)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	var st unix.Stat_t
+	if err := unix.Fstatat(int(dir.Fd()), base, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return err
+	}
+	// Unlinking refuses directories itself
+	if err := unix.Unlinkat(int(dir.Fd()), base, 0); err != nil {
+		return err
+	}
+	if s.quota != nil && st.Mode&unix.S_IFMT == unix.S_IFREG {
+		s.quota.Add(s.username, -st.Size)
+	}
+	return nil
+}
+
+func (s *session) mkdir(p string, a attrs) error {
+	dir, base, err := s.root.parent(p)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	mode := uint32(0755)
+	if a.flags&attrPermissions != 0 {
+		mode = a.mode & 0777
+	}
+	return unix.Mkdirat(int(dir.Fd()), base, mode)
+}
+
+func (s *session) rmdir(p string) error {
+	dir, base, err := s.root.parent(p)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return unix.Unlinkat(int(dir.Fd()), base, unix.AT_REMOVEDIR)
+}
+
+func (s *session) realpath(id uint32, p string) []byte {
+	resolved, err := s.root.realPath(p)
+	if err != nil {
+		resolved = clean(p)
+	}
+	return s.name(id, resolved)
+}
+
+// rename moves a file. Plain SFTP renames never replace the target; the
+// posix-rename extension does.
+func (s *session) rename(from, to string, replace bool) error {
+	oldDir, oldBase, err := s.root.parent(from)
+	if err != nil {
+		return err
+	}
+	defer oldDir.Close()
+	newDir, newBase, err := s.root.parent(to)
+	if err != nil {
+		return err
+	}
+	defer newDir.Close()
+
+	var flags uint
+	if !replace {
+		flags = unix.RENAME_NOREPLACE
+	}
+	return unix.Renameat2(int(oldDir.Fd()), oldBase, int(newDir.Fd()), newBase, flags)
+}
+
+func (s *session) readlink(id uint32, p string) []byte {
+	dir, base, err := s.root.parent(p)
+	if err != nil {
+		return s.status(id, err)
+	}
+	defer dir.Close()
+
+	buf := make([]byte, unix.PathMax)
+	n, err := unix.Readlinkat(int(dir.Fd()), base, buf)
+	if err != nil {
+		return s.status(id, err)
+	}
+	target := string(buf[:n])
+	if filepath.IsAbs(target) && s.root.contains(target) {
+		target = s.root.client(target)
+	}
+	return s.name(id, target)
+}
+
+// symlink creates a link. Absolute targets are taken within the root and
+// stored relative to the link, so the link works for both SFTP, which does
+// not follow absolute links, and the web server.
+func (s *session) symlink(target, link string) error {
+	dir, base, err := s.root.parent(link)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	if path.IsAbs(target) {
+		rel, err := filepath.Rel(path.Dir(clean(link)), clean(target))
+		if err != nil {
+			return err
+		}
+		target = filepath.ToSlash(rel)
+	}
+	return unix.Symlinkat(target, int(dir.Fd()), base)
+}
+
+// name replies with a single name and no attributes
+func (s *session) name(id uint32, name string) []byte {
+	e := newPacket(fxpName, id)
+	e.uint32(1)
+	e.string(name)
+	e.string(name)
+	e.attrs(attrs{})
+	return e.packet()
+}
+
+// status replies with the status matching an error
+func (s *session) status(id uint32, err error) []byte {
+	switch {
+	case err == nil:
+		return s.statusCode(id, fxOK, "")
+	case errors.Is(err, io.EOF):
+		return s.statusCode(id, fxEOF, "end of file")
+	case errors.Is(err, fs.ErrNotExist):
+		return s.statusCode(id, fxNoSuchFile, "no such file")
+	case errors.Is(err, fs.ErrPermission):
+		return s.statusCode(id, fxPermissionDenied, "permission denied")
+	}
+
+	// Don't reveal host paths
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		err = linkErr.Err
+	}
+	return s.statusCode(id, fxFailure, err.Error())
+}
+
+func (s *session) statusCode(id uint32, code uint32, message string) []byte {
+	e := newPacket(fxpStatus, id)
+	e.uint32(code)
+	e.string(message)
+	e.string("en")
+	return e.packet()
+}
@@ -0,0 +1,332 @@
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Packet types of SFTP version 3 (draft-ietf-secsh-filexfer-02)
+const (
+	fxpInit          = 1
+	fxpVersion       = 2
+	fxpOpen          = 3
+	fxpClose         = 4
+	fxpRead          = 5
+	fxpWrite         = 6
+	fxpLstat         = 7
+	fxpFstat         = 8
+	fxpSetstat       = 9
+	fxpFsetstat      = 10
+	fxpOpendir       = 11
+	fxpReaddir       = 12
+	fxpRemove        = 13
+	fxpMkdir         = 14
+	fxpRmdir         = 15
+	fxpRealpath      = 16
+	fxpStat          = 17
+	fxpRename        = 18
+	fxpReadlink      = 19
+	fxpSymlink       = 20
+	fxpStatus        = 101
+	fxpHandle        = 102
+	fxpData          = 103
+	fxpName          = 104
+	fxpAttrs         = 105
+	fxpExtended      = 200
+	fxpExtendedReply = 201
+)
+
+// Status codes
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxBadMessage       = 5
+	fxOpUnsupported    = 8
+)
+
+// Open flags
+const (
+	fxfRead   = 0x01
+	fxfWrite  = 0x02
+	fxfAppend = 0x04
+	fxfCreat  = 0x08
+	fxfTrunc  = 0x10
+	fxfExcl   = 0x20
+)
+
+// Attribute flags
+const (
+	attrSize        = 0x01
+	attrUIDGID      = 0x02
+	attrPermissions = 0x04
+	attrACModTime   = 0x08
+	attrExtended    = 0x80000000
+)
+
+// Unix file type bits used in the permissions attribute
+const (
+	modeTypeMask = 0170000
+	modeSocket   = 0140000
+	modeSymlink  = 0120000
+	modeRegular  = 0100000
+	modeBlock    = 0060000
+	modeDir      = 0040000
+	modeChar     = 0020000
+	modeFIFO     = 0010000
+)
+
+// maxPacket bounds incoming packets: the largest write clients send plus
+// the request header
+const maxPacket = 256*1024 + 1024
+
+// maxRead is the most data returned for one read request
+const maxRead = 256 * 1024
+
+var errShortPacket = errors.New("sftp: packet too short")
+
+// readPacket reads one length-prefixed packet
+func readPacket(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length == 0 || length > maxPacket {
+		return nil, errors.New("sftp: invalid packet length")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// decoder reads fields from a packet
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.data) < 1 {
+		d.err = errShortPacket
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) uint32() uint32 {
+	if d.err != nil || len(d.data) < 4 {
+		d.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.data)
+	d.data = d.data[4:]
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	if d.err != nil || len(d.data) < 8 {
+		d.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.data)
+	d.data = d.data[8:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if d.err != nil || uint32(len(d.data)) < n {
+		d.err = errShortPacket
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) attrs() attrs {
+	a := attrs{flags: d.uint32()}
+	if a.flags&attrSize != 0 {
+		a.size = d.uint64()
+	}
+	if a.flags&attrUIDGID != 0 {
+		a.uid = d.uint32()
+		a.gid = d.uint32()
+	}
+	if a.flags&attrPermissions != 0 {
+		a.mode = d.uint32()
+	}
+	if a.flags&attrACModTime != 0 {
+		a.atime = d.uint32()
+		a.mtime = d.uint32()
+	}
+	if a.flags&attrExtended != 0 {
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			d.string()
+			d.string()
+		}
+	}
+	return a
+}
+
+// encoder builds a packet, reserving room for the length prefix
+type encoder struct {
+	buf []byte
+}
+
+func newPacket(packetType byte, id uint32) *encoder {
+	e := &encoder{buf: make([]byte, 4, 64)}
+	e.byte(packetType)
+	e.uint32(id)
+	return e
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint32(uint32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) attrs(a attrs) {
+	e.uint32(a.flags)
+	if a.flags&attrSize != 0 {
+		e.uint64(a.size)
+	}
+	if a.flags&attrUIDGID != 0 {
+		e.uint32(a.uid)
+		e.uint32(a.gid)
+	}
+	if a.flags&attrPermissions != 0 {
+		e.uint32(a.mode)
+	}
+	if a.flags&attrACModTime != 0 {
+		e.uint32(a.atime)
+		e.uint32(a.mtime)
+	}
+}
+
+// packet returns the encoded packet with its length prefix
+func (e *encoder) packet() []byte {
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+	return e.buf
+}
+
+// attrs are the file attributes of SFTP version 3
+type attrs struct {
+	flags uint32
+	size  uint64
+	uid   uint32
+	gid   uint32
+	mode  uint32
+	atime uint32
+	mtime uint32
+}
+
+// fileAttrs returns the attributes of a file
+func fileAttrs(fi os.FileInfo) attrs {
+	a := attrs{
+		flags: attrSize | attrPermissions | attrACModTime,
+		size:  uint64(fi.Size()),
+		mode:  unixMode(fi.Mode()),
+		atime: uint32(fi.ModTime().Unix()),
+		mtime: uint32(fi.ModTime().Unix()),
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		a.flags |= attrUIDGID
+		a.uid = st.Uid
+		a.gid = st.Gid
+	}
+	return a
+}
+
+// unixMode converts a Go file mode to Unix mode bits
+func unixMode(m os.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	switch {
+	case m&os.ModeDir != 0:
+		mode |= modeDir
+	case m&os.ModeSymlink != 0:
+		mode |= modeSymlink
+	case m&os.ModeNamedPipe != 0:
+		mode |= modeFIFO
+	case m&os.ModeSocket != 0:
+		mode |= modeSocket
+	case m&os.ModeCharDevice != 0:
+		mode |= modeChar
+	case m&os.ModeDevice != 0:
+		mode |= modeBlock
+	default:
+		mode |= modeRegular
+	}
+	if m&os.ModeSetuid != 0 {
+		mode |= syscall.S_ISUID
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= syscall.S_ISGID
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= syscall.S_ISVTX
+	}
+	return mode
+}
+
+// longName formats a directory entry like ls -l, which clients show as is
+func longName(fi os.FileInfo, a attrs) string {
+	mode := []byte(fi.Mode().String())
+	// Go marks symlinks with L and prefixes extra type letters, ls uses
+	// one character
+	kind := byte('-')
+	switch a.mode & modeTypeMask {
+	case modeDir:
+		kind = 'd'
+	case modeSymlink:
+		kind = 'l'
+	case modeFIFO:
+		kind = 'p'
+	case modeSocket:
+		kind = 's'
+	case modeChar:
+		kind = 'c'
+	case modeBlock:
+		kind = 'b'
+	}
+	perm := append([]byte{kind}, mode[len(mode)-9:]...)
+
+	date := fi.ModTime().Format("Jan _2 15:04")
+	if time.Since(fi.ModTime()) > 180*24*time.Hour {
+		date = fi.ModTime().Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s    1 %-8d %-8d %8d %s %s", perm, a.uid, a.gid, a.size, date, fi.Name())
+}
@@ -0,0 +1,176 @@
+package sftp
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// root confines paths to a user's directory. Clients see it as "/". Paths
+// are looked up by the kernel one directory at a time through an os.Root,
+// which follows symlinks only while they stay inside the directory, so a
+// directory swapped for a symlink while a request is served cannot lead
+// it outside. Operations os.Root lacks run as *at system calls on the
+// parent directory, opened the same way.
+type root struct {
+	dir string // Real path of the directory, symlinks resolved
+	fs  *os.Root
+}
+
+// newRoot opens the directory at elems below base, one element at a time.
+// base is trusted; the elements belong to the user, who could replace any
+// of them with a symlink, so symlinks are refused rather than followed,
+// and the directory itself must be owned by uid.
+func newRoot(base string, uid int, elems ...string) (*root, error) {
+	real, err := filepath.EvalSymlinks(base)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.OpenRoot(real)
+	if err != nil {
+		return nil, err
+	}
+	for _, elem := range elems {
+		child, err := openChild(dir, elem)
+		dir.Close()
+		if err != nil {
+			return nil, err
+		}
+		dir = child
+		real = filepath.Join(real, elem)
+	}
+
+	fi, err := dir.Stat(".")
+	if err != nil {
+		dir.Close()
+		return nil, err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != uid {
+		dir.Close()
+		return nil, fmt.Errorf("%s is not owned by uid %d", real, uid)
+	}
+	return &root{dir: real, fs: dir}, nil
+}
+
+// openChild opens a directory directly within parent, refusing symlinks
+func openChild(parent *os.Root, name string) (*os.Root, error) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid path element %q", name)
+	}
+	before, err := parent.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !before.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", name)
+	}
+	child, err := parent.OpenRoot(name)
+	if err != nil {
+		return nil, err
+	}
+	// A symlink swapped in after the Lstat would have been followed
+	after, err := child.Stat(".")
+	if err != nil || !os.SameFile(before, after) {
+		child.Close()
+		return nil, fmt.Errorf("%s changed while it was opened", name)
+	}
+	return child, nil
+}
+
+func (r *root) close() error {
+	return r.fs.Close()
+}
+
+// actAs makes the calling goroutine access files as uid and gid, so the
+// kernel checks permissions as it would for the user and files are
+// created owned by them. Only the filesystem IDs of the thread change:
+// the thread stays locked to the goroutine and the runtime discards it
+// when the goroutine exits, instead of reusing it for other work.
+func actAs(uid, gid int) error {
+	if uid == os.Geteuid() && gid == os.Getegid() {
+		return nil
+	}
+	runtime.LockOSThread()
+	// Unlike syscall.Setgroups, unix.Setgroups changes the calling thread only
+	if err := unix.Setgroups([]int{gid}); err != nil {
+		return err
+	}
+	if err := unix.Setfsgid(gid); err != nil {
+		return err
+	}
+	if err := unix.Setfsuid(uid); err != nil {
+		return err
+	}
+	// setfsuid reports no errors; passing an invalid ID reads the current one
+	if current, _ := unix.SetfsuidRetUid(-1); current != uid {
+		return fmt.Errorf("failed to switch to uid %d", uid)
+	}
+	if current, _ := unix.SetfsgidRetGid(-1); current != gid {
+		return fmt.Errorf("failed to switch to gid %d", gid)
+	}
+	return nil
+}
+
+// clean returns the client path as an absolute path within the root
+func clean(p string) string {
+	return path.Clean("/" + p)
+}
+
+// name returns the client path relative to the root, as os.Root expects
+func name(p string) string {
+	if cleaned := clean(p); cleaned != "/" {
+		return cleaned[1:]
+	}
+	return "."
+}
+
+// parent opens the directory holding the last element of a client path
+// and returns it with the name of that element, for lstat, readlink,
+// remove and rename, which must not follow a symlink in the last element.
+// The caller closes the directory.
+func (r *root) parent(p string) (*os.File, string, error) {
+	cleaned := clean(p)
+	if cleaned == "/" {
+		return nil, "", os.ErrPermission
+	}
+	dir, err := r.fs.Open(name(path.Dir(cleaned)))
+	if err != nil {
+		return nil, "", err
+	}
+	return dir, path.Base(cleaned), nil
+}
+
+// realPath returns the client path of an existing file with its symlinks
+// resolved
+func (r *root) realPath(p string) (string, error) {
+	f, err := r.fs.OpenFile(name(p), os.O_RDONLY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hostPath, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(int(f.Fd())))
+	if err != nil {
+		return "", err
+	}
+	return r.client(hostPath), nil
+}
+
+// client returns the client path of a host path within the root
+func (r *root) client(hostPath string) string {
+	rel, err := filepath.Rel(r.dir, hostPath)
+	if err != nil || rel == "." || !r.contains(hostPath) {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+func (r *root) contains(hostPath string) bool {
+	return hostPath == r.dir || strings.HasPrefix(hostPath, r.dir+string(filepath.Separator))
+}
@@ -0,0 +1,348 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestRoot(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "www")
+	outside := filepath.Join(base, "secret")
+	for _, d := range []string{filepath.Join(dir, "sub"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../secret", filepath.Join(dir, "relative")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(dir, "inside")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "missing"), filepath.Join(dir, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newRoot(base, os.Getuid(), "www")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+
+	tests := []struct {
+		path string
+		want string // Real client path of the result, empty when refused
+	}{
+		{"/", "/"},
+		{"", "/"},
+		{"sub", "/sub"},
+		{"/../../sub", "/sub"},
+		{"inside", "/sub"},
+		{"/escape", ""},
+		{"/escape/file", ""},
+		{"/relative/file", ""},
+		{"/dangling", ""},
+	}
+	for _, tt := range tests {
+		got, err := r.realPath(tt.path)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("realPath(%q) = %q, %v; want the path refused", tt.path, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("realPath(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+
+	// The link itself can be inspected and removed, just not followed
+	if fi, err := r.fs.Lstat(name("/escape")); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat(/escape) = %v, %v", fi, err)
+	}
+	if _, _, err := r.parent("/escape/file"); err == nil {
+		t.Errorf("parent(/escape/file) = %v, want the path refused", err)
+	}
+}
+
+// serveSession serves s over a pipe and returns a client that has sent
+// the init request
+func serveSession(t *testing.T, s *session) (*testClient, net.Conn, net.Conn) {
+	server, conn := net.Pipe()
+	go s.serve(server)
+
+	c := &testClient{t: t, in: conn, out: conn}
+	hello := &encoder{buf: make([]byte, 4)}
+	hello.byte(fxpInit)
+	hello.uint32(3)
+	conn.Write(hello.packet())
+	if data, err := readPacket(conn); err != nil || data[0] != fxpVersion {
+		t.Fatalf("expected version reply, got %v", err)
+	}
+	return c, conn, server
+}
+
+func TestNewRoot(t *testing.T) {
+	base := t.TempDir()
+	outside := t.TempDir()
+	for _, d := range []string{"alice/www", "bob/www", "carol", "dave"} {
+		if err := os.MkdirAll(filepath.Join(base, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The user replaced their web root with a link to / or to another
+	// user's web root, or their home with a link elsewhere
+	if err := os.Symlink("/", filepath.Join(base, "carol", "www")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../bob/www", filepath.Join(base, "dave", "www")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(base, "erin")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "frank"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newRoot(base, os.Getuid(), "alice", "www")
+	if err != nil {
+		t.Fatalf("newRoot(alice) failed: %v", err)
+	}
+	if want := filepath.Join(base, "alice", "www"); r.dir != want {
+		t.Errorf("expected root %s, got %s", want, r.dir)
+	}
+	r.close()
+
+	for _, username := range []string{"carol", "dave", "erin", "frank", "..", "missing"} {
+		if r, err := newRoot(base, os.Getuid(), username, "www"); err == nil {
+			r.close()
+			t.Errorf("expected the web root of %s to be refused", username)
+		}
+	}
+	if r, err := newRoot(base, os.Getuid()+1, "alice", "www"); err == nil {
+		r.close()
+		t.Error("expected a web root owned by someone else to be refused")
+	}
+}
+
+// Requests are served with the permissions of the user, not of FastCP
+func TestSessionActsAsUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root")
+	}
+	const nobody = 65534
+	base := t.TempDir()
+	www := filepath.Join(base, "www")
+	if err := os.Mkdir(www, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(www, nobody, nobody); err != nil {
+		t.Fatal(err)
+	}
+	// e.g. a file root left in the web root
+	if err := os.WriteFile(filepath.Join(www, "private.txt"), []byte("private"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newRoot(base, nobody, "www")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+	c, conn, server := serveSession(t, &session{root: r, username: "nobody", uid: nobody, gid: nobody})
+	defer conn.Close()
+
+	e := c.request(fxpOpen)
+	e.string("/private.txt")
+	e.uint32(fxfRead)
+	e.attrs(attrs{})
+	if code := status(c.send(e)); code != fxPermissionDenied {
+		t.Errorf("expected reading a root-only file to be denied, got status %d", code)
+	}
+	e = c.request(fxpSetstat)
+	e.string("/private.txt")
+	e.attrs(attrs{flags: attrPermissions, mode: 0644})
+	if code := status(c.send(e)); code != fxPermissionDenied {
+		t.Errorf("expected changing a root-owned file to be denied, got status %d", code)
+	}
+
+	// New files belong to the user
+	c.close(c.open("/upload.txt", fxfWrite|fxfCreat))
+	e = c.request(fxpMkdir)
+	e.string("/dir")
+	e.attrs(attrs{})
+	if code := status(c.send(e)); code != fxOK {
+		t.Fatalf("mkdir failed with status %d", code)
+	}
+	for _, name := range []string{"upload.txt", "dir"} {
+		fi, err := os.Stat(filepath.Join(www, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st := fi.Sys().(*syscall.Stat_t); st.Uid != nobody || st.Gid != nobody {
+			t.Errorf("expected %s to be owned by %d:%d, got %d:%d", name, nobody, nobody, st.Uid, st.Gid)
+		}
+	}
+
+	e = c.request(fxpSymlink)
+	e.string("dir")
+	e.string("/link")
+	if code := status(c.send(e)); code != fxOK {
+		t.Fatalf("symlink failed with status %d", code)
+	}
+	e = c.request(fxpRealpath)
+	e.string("/link")
+	if d := c.send(e); d.byte() != fxpName || d.uint32() != c.id || d.uint32() != 1 || d.string() != "/dir" {
+		t.Error("expected realpath to resolve links for the user")
+	}
+
+	// FastCP itself keeps its permissions
+	if _, err := os.ReadFile(filepath.Join(www, "private.txt")); err != nil {
+		t.Errorf("expected the server to keep root access, got %v", err)
+	}
+	conn.Close()
+	io.Copy(io.Discard, server)
+}
+
+// A directory swapped for a symlink during a session does not lead later
+// requests out of the root
+func TestSessionDirectorySwap(t *testing.T) {
+	base := t.TempDir()
+	www := filepath.Join(base, "www")
+	outside := filepath.Join(base, "secret")
+	for _, d := range []string{filepath.Join(www, "uploads"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secret := filepath.Join(outside, "key.txt")
+	if err := os.WriteFile(secret, []byte("private"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newRoot(base, os.Getuid(), "www")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+	c, conn, server := serveSession(t, &session{root: r, username: "alice", uid: os.Getuid(), gid: os.Getgid()})
+	defer conn.Close()
+
+	h := c.open("/uploads/a.txt", fxfWrite|fxfCreat)
+	c.close(h)
+
+	// The user replaces the directory with a link out of the root, e.g.
+	// from a shell or a PHP script
+	if err := os.Rename(filepath.Join(www, "uploads"), filepath.Join(www, "old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(www, "uploads")); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(packetType byte, args ...string) int {
+		e := c.request(packetType)
+		for _, arg := range args {
+			e.string(arg)
+		}
+		if packetType == fxpOpen {
+			e.uint32(fxfRead)
+			e.attrs(attrs{})
+		}
+		if packetType == fxpMkdir || packetType == fxpSetstat {
+			e.attrs(attrs{flags: attrPermissions, mode: 0777})
+		}
+		return status(c.send(e))
+	}
+	for _, tt := range []struct {
+		name       string
+		packetType byte
+		args       []string
+	}{
+		{"open", fxpOpen, []string{"/uploads/key.txt"}},
+		{"stat", fxpStat, []string{"/uploads/key.txt"}},
+		{"setstat", fxpSetstat, []string{"/uploads/key.txt"}},
+		{"remove", fxpRemove, []string{"/uploads/key.txt"}},
+		{"mkdir", fxpMkdir, []string{"/uploads/new"}},
+		{"rename out", fxpRename, []string{"/uploads/key.txt", "/stolen.txt"}},
+		{"rename in", fxpRename, []string{"/old/a.txt", "/uploads/planted.txt"}},
+		{"symlink", fxpSymlink, []string{"/etc", "/uploads/link"}},
+		{"readlink", fxpReadlink, []string{"/uploads/key.txt"}},
+	} {
+		if code := request(tt.packetType, tt.args...); code == fxOK || code == -1 {
+			t.Errorf("%s through the swapped directory: expected it to be refused, got status %d", tt.name, code)
+		}
+	}
+
+	entries, _ := os.ReadDir(outside)
+	if len(entries) != 1 {
+		t.Errorf("expected the outside directory to be left alone, got %d entries", len(entries))
+	}
+	if fi, err := os.Stat(secret); err != nil || fi.Mode().Perm() != 0644 {
+		t.Errorf("expected the outside file to be unchanged, got %v %v", fi, err)
+	}
+	if _, err := os.Stat(filepath.Join(www, "stolen.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected nothing to be moved into the root, got %v", err)
+	}
+
+	// Swapping back and forth while files are uploaded never puts one
+	// outside the root
+	done := make(chan struct{})
+	swapped := make(chan struct{})
+	go func() {
+		defer close(swapped)
+		link := filepath.Join(www, "uploads")
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			os.Remove(link)
+			os.Mkdir(link, 0755)
+			os.RemoveAll(link)
+			os.Symlink(outside, link)
+		}
+	}()
+	for i := 0; i < 500; i++ {
+		e := c.request(fxpOpen)
+		e.string("/uploads/race.txt")
+		e.uint32(fxfWrite | fxfCreat)
+		e.attrs(attrs{})
+		if d := c.send(e); d.byte() == fxpHandle {
+			d.uint32()
+			c.close(d.string())
+		}
+	}
+	close(done)
+	<-swapped
+	if _, err := os.Stat(filepath.Join(outside, "race.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no upload outside the root, got %v", err)
+	}
+
+	// The session goes on inside the root
+	h = c.open("/old/a.txt", fxfRead)
+	e := c.request(fxpRead)
+	e.string(h)
+	e.uint64(0)
+	e.uint32(16)
+	if code := status(c.send(e)); code != fxEOF {
+		t.Errorf("expected to read the moved file, got status %d", code)
+	}
+	c.close(h)
+	conn.Close()
+	io.Copy(io.Discard, server)
+}
@@ -0,0 +1,311 @@
+// Package sftp is an embedded SFTP server. Users log in with their FastCP
+// password or a key from their authorized_keys and are confined to their
+// web root in-process, without an sshd chroot.
+package sftp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/ratelimit"
+)
+
+// HomeBase is the directory holding user home directories
+var HomeBase = "/home"
+
+// lookupAccount finds the system account owning uploaded files
+var lookupAccount = user.Lookup
+
+// Server accepts SSH connections and serves the sftp subsystem
+type Server struct {
+	settings models.SFTPSettings
+	config   *ssh.ServerConfig
+	logger   *slog.Logger
+
+	mu      sync.RWMutex
+	quota   Quota
+	audit   func(models.AuditLog)
+	limiter *ratelimit.Limiter
+}
+
+// NewServer creates a server, generating its host key on first use
+func NewServer(settings models.SFTPSettings, dataDir string, logger *slog.Logger) (*Server, error) {
+	keyFile := settings.HostKeyFile
+	if keyFile == "" {
+		keyFile = filepath.Join(dataDir, "sftp_host_ed25519_key")
+	}
+	signer, err := loadHostKey(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load host key: %w", err)
+	}
+
+	s := &Server{settings: settings, logger: logger}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback:  s.checkPassword,
+		PublicKeyCallback: s.checkPublicKey,
+		ServerVersion:     "SSH-2.0-FastCP",
+	}
+	s.config.AddHostKey(signer)
+	return s, nil
+}
+
+// SetQuota enforces disk limits on uploads
+func (s *Server) SetQuota(q Quota) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quota = q
+}
+
+// SetAudit records transfers, e.g. with (*audit.Log).Record
+func (s *Server) SetAudit(record func(models.AuditLog) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = func(entry models.AuditLog) {
+		if err := record(entry); err != nil {
+			s.logger.Warn("failed to write audit log", "action", entry.Action, "error", err)
+		}
+	}
+}
+
+// SetLimiter applies the login rate limits to password logins
+func (s *Server) SetLimiter(l *ratelimit.Limiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limiter = l
+}
+
+// loadHostKey reads the host key, creating it if missing
+func loadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		block, err := ssh.MarshalPrivateKey(key, "fastcp sftp")
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(block)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(data)
+}
+
+func (s *Server) checkPassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	ip := remoteIP(conn.RemoteAddr())
+	username := conn.User()
+
+	s.mu.RLock()
+	limiter := s.limiter
+	s.mu.RUnlock()
+
+	if limiter != nil {
+		if wait := limiter.Check(ip, username); wait > 0 {
+			return nil, fmt.Errorf("too many failed logins, try again in %s", wait.Round(time.Second))
+		}
+	}
+	if _, err := auth.Authenticate(username, string(password)); err != nil {
+		if limiter != nil {
+			for _, block := range limiter.Fail(ip, username) {
+				s.logger.Warn("sftp login blocked", "type", block.Type, "value", block.Value, "until", block.Until)
+			}
+		}
+		return nil, errors.New("invalid credentials")
+	}
+	if limiter != nil {
+		limiter.Succeed(username)
+	}
+	return nil, nil
+}
+
+func (s *Server) checkPublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	username := conn.User()
+	if _, err := auth.LookupUser(username); err != nil {
+		return nil, errors.New("invalid credentials")
+	}
+	if !authorizedKey(filepath.Join(HomeBase, username, ".ssh", "authorized_keys"), key) {
+		return nil, errors.New("key not authorized")
+	}
+	return nil, nil
+}
+
+// authorizedKey reports whether key is listed in an authorized_keys file
+func authorizedKey(path string, key ssh.PublicKey) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	want := key.Marshal()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		authorized, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err == nil && bytes.Equal(authorized.Marshal(), want) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListenAndServe listens on the configured address until ctx is done
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	addr := s.settings.ListenAddr
+	if addr == "" {
+		addr = ":2222"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("SFTP server listening", "addr", ln.Addr().String())
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts connections on ln until ctx is done
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	defer nc.Close()
+
+	nc.SetDeadline(time.Now().Add(30 * time.Second))
+	conn, chans, reqs, err := ssh.NewServerConn(nc, s.config)
+	if err != nil {
+		s.logger.Debug("sftp handshake failed", "remote", nc.RemoteAddr().String(), "error", err)
+		return
+	}
+	defer conn.Close()
+	nc.SetDeadline(time.Time{})
+	go ssh.DiscardRequests(reqs)
+
+	sess, err := s.newSession(conn)
+	if err != nil {
+		s.logger.Warn("sftp session refused", "user", conn.User(), "error", err)
+		return
+	}
+	defer sess.root.close()
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveChannel(channel, requests, *sess)
+	}
+}
+
+// newSession prepares the root and ownership of a logged in user
+func (s *Server) newSession(conn *ssh.ServerConn) (*session, error) {
+	account, err := lookupAccount(conn.User())
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.Atoi(account.Uid)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.Atoi(account.Gid)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRoot(HomeBase, uid, conn.User(), "www")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &session{
+		root:     r,
+		username: conn.User(),
+		uid:      uid,
+		gid:      gid,
+		ip:       remoteIP(conn.RemoteAddr()),
+		quota:    s.quota,
+		record:   s.audit,
+	}, nil
+}
+
+// serveChannel runs the sftp subsystem. Shells, commands and terminals
+// are refused.
+func (s *Server) serveChannel(channel ssh.Channel, requests <-chan *ssh.Request, sess session) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "subsystem" || len(req.Payload) < 4 || string(req.Payload[4:]) != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(requests)
+
+		if err := sess.serve(channel); err != nil {
+			s.logger.Debug("sftp session ended", "user", sess.username, "error", err)
+		}
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		return
+	}
+}
+
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,275 @@
+package sftp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/rehmatworks/fastcp/internal/auth"
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+type fakeQuota struct {
+	limit int64
+	used  int64
+}
+
+var errFakeQuota = errors.New("quota exceeded")
+
+func (q *fakeQuota) Check(username string, size int64) error {
+	if q.used+size > q.limit {
+		return errFakeQuota
+	}
+	return nil
+}
+
+func (q *fakeQuota) Add(username string, size int64) {
+	q.used += size
+}
+
+// testClient speaks just enough SFTP to exercise the server
+type testClient struct {
+	t   *testing.T
+	in  io.Writer
+	out io.Reader
+	id  uint32
+}
+
+func (c *testClient) send(e *encoder) *decoder {
+	c.t.Helper()
+	if _, err := c.in.Write(e.packet()); err != nil {
+		c.t.Fatal(err)
+	}
+	data, err := readPacket(c.out)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	d := &decoder{data: data}
+	return d
+}
+
+func (c *testClient) request(packetType byte) *encoder {
+	c.id++
+	return newPacket(packetType, c.id)
+}
+
+// status returns the status code of a reply, or -1 for other replies
+func status(d *decoder) int {
+	if d.byte() != fxpStatus {
+		return -1
+	}
+	d.uint32()
+	return int(d.uint32())
+}
+
+func (c *testClient) open(p string, flags uint32) string {
+	c.t.Helper()
+	e := c.request(fxpOpen)
+	e.string(p)
+	e.uint32(flags)
+	e.attrs(attrs{})
+	d := c.send(e)
+	if d.byte() != fxpHandle {
+		c.t.Fatalf("open %s failed", p)
+	}
+	d.uint32()
+	return d.string()
+}
+
+func (c *testClient) write(handle string, offset uint64, data []byte) int {
+	e := c.request(fxpWrite)
+	e.string(handle)
+	e.uint64(offset)
+	e.bytes(data)
+	return status(c.send(e))
+}
+
+func (c *testClient) close(handle string) int {
+	e := c.request(fxpClose)
+	e.string(handle)
+	return status(c.send(e))
+}
+
+func TestServer(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("no current user")
+	}
+	HomeBase = t.TempDir()
+	lookupAccount = func(string) (*user.User, error) { return current, nil }
+	auth.SetUserLookup(func(username string) (*user.User, error) { return &user.User{Username: username}, nil })
+	auth.SetGroupChecker(func(username, group string) bool { return group == "fastcp" })
+	auth.SetPasswordVerifier(func(username, password string) bool { return password == "secret" })
+	defer func() {
+		lookupAccount = user.Lookup
+		auth.SetUserLookup(nil)
+		auth.SetGroupChecker(nil)
+		auth.SetPasswordVerifier(nil)
+	}()
+
+	www := filepath.Join(HomeBase, "alice", "www")
+	if err := os.MkdirAll(www, 0755); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(HomeBase, "secret.txt")
+	if err := os.WriteFile(secret, []byte("private"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(www, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv, err := NewServer(models.SFTPSettings{}, t.TempDir(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quota := &fakeQuota{limit: 10}
+	srv.SetQuota(quota)
+	var entries []models.AuditLog
+	recorded := make(chan struct{}, 10)
+	srv.SetAudit(func(entry models.AuditLog) error {
+		entries = append(entries, entry)
+		recorded <- struct{}{}
+		return nil
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Serve(ctx, ln)
+
+	dial := func(method ssh.AuthMethod) (*ssh.Client, error) {
+		return ssh.Dial("tcp", ln.Addr().String(), &ssh.ClientConfig{
+			User:            "alice",
+			Auth:            []ssh.AuthMethod{method},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+	}
+
+	if _, err := dial(ssh.Password("wrong")); err == nil {
+		t.Fatal("expected wrong password to be refused")
+	}
+
+	// Keys are taken from the user's authorized_keys
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dial(ssh.PublicKeys(signer)); err == nil {
+		t.Fatal("expected unknown key to be refused")
+	}
+	sshDir := filepath.Join(HomeBase, "alice", ".ssh")
+	os.MkdirAll(sshDir, 0700)
+	if err := os.WriteFile(filepath.Join(sshDir, "authorized_keys"), ssh.MarshalAuthorizedKey(signer.PublicKey()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if client, err := dial(ssh.PublicKeys(signer)); err != nil {
+		t.Fatalf("expected authorized key to log in: %v", err)
+	} else {
+		client.Close()
+	}
+
+	client, err := dial(ssh.Password("secret"))
+	if err != nil {
+		t.Fatalf("password login failed: %v", err)
+	}
+	defer client.Close()
+
+	// Shells are refused
+	shell, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shell.Shell(); err == nil {
+		t.Fatal("expected shell to be refused")
+	}
+	shell.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	in, _ := session.StdinPipe()
+	out, _ := session.StdoutPipe()
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, in: in, out: out}
+
+	hello := &encoder{buf: make([]byte, 4)}
+	hello.byte(fxpInit)
+	hello.uint32(3)
+	in.Write(hello.packet())
+	if data, err := readPacket(out); err != nil || data[0] != fxpVersion {
+		t.Fatalf("expected version reply, got %v", err)
+	}
+
+	// Uploads land in the web root and count against the quota
+	h := c.open("/../index.html", fxfWrite|fxfCreat|fxfTrunc)
+	if code := c.write(h, 0, []byte("hello")); code != fxOK {
+		t.Fatalf("write failed with status %d", code)
+	}
+	if code := c.write(h, 5, []byte(" world")); code != fxFailure {
+		t.Fatalf("expected write over quota to fail, got status %d", code)
+	}
+	if code := c.close(h); code != fxOK {
+		t.Fatalf("close failed with status %d", code)
+	}
+	<-recorded
+	if data, _ := os.ReadFile(filepath.Join(www, "index.html")); string(data) != "hello" {
+		t.Fatalf("unexpected upload content %q", data)
+	}
+	if quota.used != 5 {
+		t.Fatalf("expected 5 bytes accounted, got %d", quota.used)
+	}
+	if len(entries) != 1 || entries[0].Action != "sftp.upload" || entries[0].ResourceID != "/index.html" || entries[0].Details != "5 bytes" {
+		t.Fatalf("unexpected audit entries: %+v", entries)
+	}
+
+	// Symlinks out of the root are not followed
+	e := c.request(fxpOpen)
+	e.string("/link")
+	e.uint32(fxfRead)
+	e.attrs(attrs{})
+	if code := status(c.send(e)); code == fxOK || code == -1 {
+		t.Fatalf("expected link out of the root to be refused, got status %d", code)
+	}
+
+	// Downloads are audited too
+	h = c.open("/index.html", fxfRead)
+	e = c.request(fxpRead)
+	e.string(h)
+	e.uint64(0)
+	e.uint32(1024)
+	if d := c.send(e); d.byte() != fxpData || d.uint32() != c.id || string(d.bytes()) != "hello" {
+		t.Fatal("unexpected read reply")
+	}
+	c.close(h)
+	<-recorded
+	if len(entries) != 2 || entries[1].Action != "sftp.download" {
+		t.Fatalf("unexpected audit entries: %+v", entries)
+	}
+
+	// Removing files gives the space back
+	e = c.request(fxpRemove)
+	e.string("/index.html")
+	if code := status(c.send(e)); code != fxOK || quota.used != 0 {
+		t.Fatalf("remove failed with status %d, %d bytes accounted", code, quota.used)
+	}
+}
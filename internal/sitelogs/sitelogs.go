@@ -0,0 +1,428 @@
+// Package sitelogs locates, parses and tails per-site log files.
+//
+// The main proxy writes one JSON access log per site into a directory
+// owned by root, and each user's PHP instance writes the PHP errors of the
+// site into an error log in the user's home directory.
+package sitelogs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/rehmatworks/fastcp/internal/config"
+)
+
+// Log types
+const (
+	TypeAccess = "access"
+	TypeError  = "error"
+)
+
+var (
+	ErrInvalidLogType = errors.New("invalid log type (use access or error)")
+	ErrInvalidStatus  = errors.New("invalid status filter (use e.g. 404, 5xx or 404,500)")
+	ErrNotLogFile     = errors.New("not a log file")
+)
+
+// Dir returns the access log directory of a site
+func Dir(siteID string) string {
+	return filepath.Join(config.Get().LogDir, "sites", filepath.Base(siteID))
+}
+
+// AccessLogPath returns the access log written by the proxy for a site
+func AccessLogPath(siteID string) string {
+	return filepath.Join(Dir(siteID), "access.log")
+}
+
+// ErrorLogPath returns the PHP error log of a site, written by the PHP
+// instance of its owner
+func ErrorLogPath(username, siteID string) string {
+	return filepath.Join("/home", username, "log", filepath.Base(siteID)+"-error.log")
+}
+
+// Path returns the log file of the given type for a site of a user
+func Path(username, siteID, logType string) (string, error) {
+	switch logType {
+	case "", TypeAccess:
+		return AccessLogPath(siteID), nil
+	case TypeError:
+		return ErrorLogPath(username, siteID), nil
+	default:
+		return "", ErrInvalidLogType
+	}
+}
+
+// PrepareDir creates the access log directory of a site. The directory
+// belongs to root and the owner's group gid may only read it, so the
+// owner cannot replace the log the proxy writes as root. A log that is
+// not a plain file of root, e.g. a link left from when the owner had the
+// directory, is removed.
+func PrepareDir(siteID string, gid int) error {
+	dir := Dir(siteID)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	if err := os.Lchown(dir, 0, gid); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, 0750); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); !info.Mode().IsRegular() || !ok || st.Uid != 0 {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Open opens a log file for reading. Error logs live in directories of
+// their users, so symlinks are not followed and the file must belong to
+// the owner of its directory: a link planted there cannot make FastCP
+// read the files of someone else.
+func Open(path string) (*os.File, error) {
+	dir, err := unix.Open(filepath.Dir(path), unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filepath.Dir(path), Err: err}
+	}
+	defer unix.Close(dir)
+
+	// O_NONBLOCK keeps a FIFO from blocking the open
+	fd, err := unix.Openat(dir, filepath.Base(path), unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	var dirStat, stat unix.Stat_t
+	if err := unix.Fstat(dir, &dirStat); err != nil {
+		unix.Close(fd)
+		return nil, &os.PathError{Op: "stat", Path: filepath.Dir(path), Err: err}
+	}
+	if err := unix.Fstat(fd, &stat); err != nil {
+		unix.Close(fd)
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFREG || stat.Uid != dirStat.Uid {
+		unix.Close(fd)
+		return nil, &os.PathError{Op: "open", Path: path, Err: ErrNotLogFile}
+	}
+	if err := unix.SetNonblock(fd, false); err != nil {
+		unix.Close(fd)
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// Entry is the subset of a Caddy JSON log entry FastCP uses
+type Entry struct {
+	Level    string  `json:"level"`
+	Time     float64 `json:"ts"`
+	Message  string  `json:"msg"`
+	Duration float64 `json:"duration"` // seconds
+	Size     int64   `json:"size"`     // response body bytes
+	Status   int     `json:"status"`
+	Request  struct {
+		RemoteIP string              `json:"remote_ip"`
+		ClientIP string              `json:"client_ip"`
+		Method   string              `json:"method"`
+		Host     string              `json:"host"`
+		URI      string              `json:"uri"`
+		Headers  map[string][]string `json:"headers"`
+	} `json:"request"`
+}
+
+// ParseEntry parses a single JSON log line
+func ParseEntry(line []byte) (*Entry, error) {
+	var e Entry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Timestamp returns the entry time
+func (e *Entry) Timestamp() time.Time {
+	sec := int64(e.Time)
+	return time.Unix(sec, int64((e.Time-float64(sec))*1e9))
+}
+
+// Path returns the request path without the query string
+func (e *Entry) Path() string {
+	if i := strings.IndexByte(e.Request.URI, '?'); i >= 0 {
+		return e.Request.URI[:i]
+	}
+	return e.Request.URI
+}
+
+// Referer returns the Referer request header, if any
+func (e *Entry) Referer() string {
+	if v := e.Request.Headers["Referer"]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Filter selects log entries by status code and path
+type Filter struct {
+	statuses []statusMatch
+	Path     string // path prefix
+}
+
+type statusMatch struct {
+	code  int // exact code, or class*100 when class is set
+	class bool
+}
+
+// NewFilter builds a filter from query values. status accepts a comma
+// separated list of codes or classes (e.g. "404", "5xx", "404,500").
+func NewFilter(status, path string) (*Filter, error) {
+	f := &Filter{Path: path}
+	for _, part := range strings.Split(status, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		if len(part) == 3 && strings.HasSuffix(part, "xx") {
+			class, err := strconv.Atoi(part[:1])
+			if err != nil || class < 1 || class > 5 {
+				return nil, ErrInvalidStatus
+			}
+			f.statuses = append(f.statuses, statusMatch{code: class * 100, class: true})
+			continue
+		}
+		code, err := strconv.Atoi(part)
+		if err != nil || code < 100 || code > 599 {
+			return nil, ErrInvalidStatus
+		}
+		f.statuses = append(f.statuses, statusMatch{code: code})
+	}
+	return f, nil
+}
+
+// Empty reports whether the filter matches everything
+func (f *Filter) Empty() bool {
+	return f == nil || (len(f.statuses) == 0 && f.Path == "")
+}
+
+// Match reports whether a raw log line passes the filter.
+// Lines that are not JSON entries only pass an empty filter.
+func (f *Filter) Match(line []byte) bool {
+	if f.Empty() {
+		return true
+	}
+	e, err := ParseEntry(line)
+	if err != nil {
+		return false
+	}
+	return f.MatchEntry(e)
+}
+
+// MatchEntry reports whether a parsed entry passes the filter
+func (f *Filter) MatchEntry(e *Entry) bool {
+	if f.Empty() {
+		return true
+	}
+	if f.Path != "" && !strings.HasPrefix(e.Path(), f.Path) {
+		return false
+	}
+	if len(f.statuses) == 0 {
+		return true
+	}
+	for _, s := range f.statuses {
+		if s.class && e.Status/100*100 == s.code {
+			return true
+		}
+		if !s.class && e.Status == s.code {
+			return true
+		}
+	}
+	return false
+}
+
+// Tail returns up to n of the last lines in the file that pass the filter,
+// oldest first, together with the file size the read ended at.
+// A missing file yields no lines.
+func Tail(path string, n int, f *Filter) ([]string, int64, error) {
+	file, err := Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, 0, nil
+		}
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := info.Size()
+	lines := []string{}
+	if n <= 0 {
+		return lines, size, nil
+	}
+
+	// Read backwards in chunks until enough matching lines are collected
+	const chunkSize = 64 * 1024
+	var (
+		pos     = size
+		partial []byte
+	)
+	for pos > 0 && len(lines) < n {
+		readSize := int64(chunkSize)
+		if pos < readSize {
+			readSize = pos
+		}
+		pos -= readSize
+
+		buf := make([]byte, readSize)
+		if _, err := file.ReadAt(buf, pos); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		buf = append(buf, partial...)
+
+		parts := bytes.Split(buf, []byte{'\n'})
+		// The first part may be incomplete unless we reached the start
+		partial = parts[0]
+		if pos == 0 {
+			partial = nil
+		} else {
+			parts = parts[1:]
+		}
+
+		for i := len(parts) - 1; i >= 0 && len(lines) < n; i-- {
+			line := bytes.TrimSpace(parts[i])
+			if len(line) == 0 || !f.Match(line) {
+				continue
+			}
+			lines = append(lines, string(line))
+		}
+	}
+
+	// Reverse to chronological order
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines, size, nil
+}
+
+// PollInterval is how often Follow checks the file for new data
+var PollInterval = 500 * time.Millisecond
+
+// Follow streams lines appended to the file after offset until ctx is done.
+// fn receives each matching line and the offset just past it; idle, when
+// set, is called after every poll. When the file is rotated or truncated,
+// following restarts at the beginning of the new file.
+func Follow(ctx context.Context, path string, offset int64, f *Filter, fn func(line string, offset int64) error, idle func() error) error {
+	var (
+		file   *os.File
+		reader *bufio.Reader
+		ino    uint64
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// (Re)open the file if needed and detect rotation
+		info, err := os.Lstat(path)
+		if err == nil {
+			curIno := inode(info)
+			if file != nil && (curIno != ino || info.Size() < offset) {
+				file.Close()
+				file = nil
+				offset = 0
+			}
+			if file == nil {
+				file, err = Open(path)
+				if err != nil {
+					return err
+				}
+				if offset > info.Size() {
+					offset = 0
+				}
+				if _, err := file.Seek(offset, io.SeekStart); err != nil {
+					return err
+				}
+				reader = bufio.NewReader(file)
+				ino = curIno
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		// Drain complete lines
+		for file != nil {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				// Keep the partial line for the next poll
+				if len(line) > 0 {
+					if _, serr := file.Seek(offset, io.SeekStart); serr != nil {
+						return serr
+					}
+					reader.Reset(file)
+				}
+				break
+			}
+			offset += int64(len(line))
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 || !f.Match(line) {
+				continue
+			}
+			if err := fn(string(line), offset); err != nil {
+				return err
+			}
+		}
+
+		if idle != nil {
+			if err := idle(); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// inode returns the inode number of a file, used to detect rotation
+func inode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,212 @@
+package sitelogs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rehmatworks/fastcp/internal/config"
+)
+
+func accessLine(status int, uri string) string {
+	return fmt.Sprintf(`{"level":"info","ts":1760000000.5,"msg":"handled request","request":{"method":"GET","host":"example.com","uri":%q},"duration":0.01,"size":100,"status":%d}`, uri, status)
+}
+
+func TestFilter(t *testing.T) {
+	f, err := NewFilter("5xx,404", "/wp-")
+	if err != nil {
+		t.Fatalf("NewFilter failed: %v", err)
+	}
+
+	cases := []struct {
+		line string
+		want bool
+	}{
+		{accessLine(502, "/wp-login.php?x=1"), true},
+		{accessLine(404, "/wp-admin/"), true},
+		{accessLine(200, "/wp-admin/"), false},
+		{accessLine(500, "/index.php"), false},
+		{"not json", false},
+	}
+	for _, c := range cases {
+		if got := f.Match([]byte(c.line)); got != c.want {
+			t.Fatalf("Match(%s) = %v, want %v", c.line, got, c.want)
+		}
+	}
+
+	if _, err := NewFilter("6xx", ""); err != ErrInvalidStatus {
+		t.Fatalf("expected ErrInvalidStatus, got %v", err)
+	}
+	if !(&Filter{}).Match([]byte("not json")) {
+		t.Fatalf("empty filter should match any line")
+	}
+}
+
+func TestTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	var b strings.Builder
+	for i := 0; i < 5000; i++ {
+		status := 200
+		if i%10 == 0 {
+			status = 500
+		}
+		b.WriteString(accessLine(status, fmt.Sprintf("/page/%d", i)) + "\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, _ := NewFilter("5xx", "")
+	lines, offset, err := Tail(path, 3, f)
+	if err != nil {
+		t.Fatalf("Tail failed: %v", err)
+	}
+	if offset != int64(b.Len()) {
+		t.Fatalf("expected offset %d, got %d", b.Len(), offset)
+	}
+	if len(lines) != 3 || !strings.Contains(lines[0], "/page/4970") || !strings.Contains(lines[2], "/page/4990") {
+		t.Fatalf("unexpected tail: %v", lines)
+	}
+
+	lines, _, err = Tail(filepath.Join(t.TempDir(), "missing.log"), 10, nil)
+	if err != nil || len(lines) != 0 {
+		t.Fatalf("expected no lines for a missing file, got %v (%v)", lines, err)
+	}
+}
+
+func TestFollowRotation(t *testing.T) {
+	PollInterval = 10 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(path, []byte(accessLine(200, "/old")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, offset, _ := Tail(path, 0, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	got := make(chan string, 10)
+	go func() {
+		_ = Follow(ctx, path, offset, nil, func(line string, _ int64) error {
+			got <- line
+			return nil
+		}, nil)
+	}()
+
+	appendLine := func(p, line string) {
+		f, err := os.OpenFile(p, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		f.WriteString(line + "\n")
+	}
+
+	appendLine(path, accessLine(200, "/first"))
+	if line := <-got; !strings.Contains(line, "/first") {
+		t.Fatalf("expected /first, got %s", line)
+	}
+
+	// Simulate rotation: move the file away and start a new one
+	time.Sleep(50 * time.Millisecond)
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLine(path, accessLine(200, "/after-rotate"))
+
+	select {
+	case line := <-got:
+		if !strings.Contains(line, "/after-rotate") {
+			t.Fatalf("expected /after-rotate, got %s", line)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for line after rotation")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "site-error.log")
+	if err := os.WriteFile(path, []byte("line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	f.Close()
+
+	// Links planted in the log directory are not followed
+	secret := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secret, []byte("secret\n"), 0600)
+	link := filepath.Join(dir, "link-error.log")
+	if err := os.Symlink(secret, link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(link); err == nil {
+		t.Fatal("expected a symlinked log to be refused")
+	}
+	linkDir := filepath.Join(t.TempDir(), "log")
+	if err := os.Symlink(filepath.Dir(secret), linkDir); err != nil {
+		t.Fatal(err)
+	}
+	if lines, _, err := Tail(filepath.Join(linkDir, "secret"), 10, nil); err == nil {
+		t.Fatalf("expected a symlinked log directory to be refused, got %v", lines)
+	}
+
+	if os.Geteuid() != 0 {
+		return
+	}
+	// A file of another user, e.g. a hard link, is refused
+	if err := os.Chown(dir, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); !errors.Is(err, ErrNotLogFile) {
+		t.Fatalf("expected ErrNotLogFile, got %v", err)
+	}
+	os.Chown(path, 65534, 65534)
+	if f, err := Open(path); err != nil {
+		t.Fatalf("expected the owner's log to open: %v", err)
+	} else {
+		f.Close()
+	}
+}
+
+func TestPrepareDir(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	loaded, _ := config.Load("")
+	cfg := *loaded
+	cfg.LogDir = t.TempDir()
+	config.Update(&cfg)
+
+	// A directory the owner had, with a link in place of the log
+	dir := Dir("site-1")
+	os.MkdirAll(dir, 0755)
+	os.Chown(dir, 65534, 65534)
+	if err := os.Symlink("/etc/shadow", AccessLogPath("site-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PrepareDir("site-1", 65534); err != nil {
+		t.Fatalf("PrepareDir failed: %v", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if st.Uid != 0 || st.Gid != 65534 || info.Mode().Perm() != 0750 {
+		t.Fatalf("expected root:65534 0750, got %d:%d %v", st.Uid, st.Gid, info.Mode().Perm())
+	}
+	if _, err := os.Lstat(AccessLogPath("site-1")); !os.IsNotExist(err) {
+		t.Fatalf("expected the planted link to be removed, got %v", err)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,6 +25,7 @@ import (
 
 	"github.com/rehmatworks/fastcp/internal/config"
 	"github.com/rehmatworks/fastcp/internal/models"
+	"github.com/rehmatworks/fastcp/internal/sitelogs"
 )
 
 var (
@@ -33,6 +35,8 @@ var (
 	ErrInvalidSiteName   = errors.New("invalid site name")
 	ErrInvalidPHPVersion = errors.New("invalid PHP version")
 	ErrSiteLimitReached  = errors.New("site limit reached")
+	ErrDiskQuotaExceeded = errors.New("disk quota exceeded")
+	ErrPHPVersionDenied  = errors.New("PHP version not allowed by the user's package")
 
 	// Domain validation regex - allows letters, numbers, hyphens, dots
 	// Must start and end with alphanumeric, no consecutive dots/hyphens
@@ -123,6 +127,9 @@ type Manager struct {
 	userLimits map[string]*models.UserLimits // username -> limits
 	mu         sync.RWMutex
 	dataPath   string
+
+	// diskUsage returns a user's cached disk usage in MB (see SetDiskUsageFunc)
+	diskUsage func(username string) int64
 }
 
 // NewManager creates a new site manager
@@ -135,6 +142,15 @@ func NewManager(dataPath string) *Manager {
 	}
 }
 
+// SetDiskUsageFunc sets the function reporting a user's disk usage in MB,
+// used to refuse new sites for users over their disk limit. It is called
+// with the manager lock held and must not call back into the manager.
+func (m *Manager) SetDiskUsageFunc(fn func(username string) int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.diskUsage = fn
+}
+
 // Load loads sites and user limits from storage
 func (m *Manager) Load() error {
 	m.mu.Lock()
@@ -263,6 +279,14 @@ func (m *Manager) Create(site *models.Site) (*models.Site, error) {
 				return nil, ErrSiteLimitReached
 			}
 		}
+		if limit, ok := m.userLimits[username]; ok && limit.MaxDiskMB > 0 && m.diskUsage != nil {
+			if m.diskUsage(username) >= limit.MaxDiskMB {
+				return nil, ErrDiskQuotaExceeded
+			}
+		}
+		if !m.phpVersionAllowedUnlocked(username, site.PHPVersion) {
+			return nil, ErrPHPVersionDenied
+		}
 	}
 
 	// Validate PHP version
@@ -427,6 +451,9 @@ func (m *Manager) Update(id string, updates *models.Site) (*models.Site, error)
 		if !validPHP {
 			return nil, ErrInvalidPHPVersion
 		}
+		if updates.PHPVersion != site.PHPVersion && !m.phpVersionAllowedUnlocked(getUsernameFromID(site.UserID), updates.PHPVersion) {
+			return nil, ErrPHPVersionDenied
+		}
 		site.PHPVersion = updates.PHPVersion
 	}
 	newAliases := site.Aliases
@@ -569,11 +596,39 @@ func (m *Manager) Unsuspend(id string) error {
 	return m.saveUnlocked()
 }
 
+// SetInterceptErrors sets whether the custom error pages of a site replace
+// error responses returned by the application
+func (m *Manager) SetInterceptErrors(id string, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	site, ok := m.sites[id]
+	if !ok {
+		return ErrSiteNotFound
+	}
+
+	site.InterceptErrors = enabled
+	site.UpdatedAt = time.Now()
+
+	return m.saveUnlocked()
+}
+
+// SecureLogDirectories gives the access log directories of all sites to
+// root, including those created when the owner had them
+func (m *Manager) SecureLogDirectories() error {
+	var errs []error
+	for _, site := range m.GetAll() {
+		_, gid := getUIDGID(site.UserID)
+		if err := sitelogs.PrepareDir(site.ID, gid); err != nil {
+			errs = append(errs, fmt.Errorf("site %s: %w", site.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // createSiteDirectories creates the directory structure for a site with proper ownership
 // IMPORTANT: This function must NEVER modify site.RootPath - paths are immutable after creation
 func (m *Manager) createSiteDirectories(site *models.Site) error {
-	cfg := config.Get()
-
 	// Get owner info
 	username := getUsernameFromID(site.UserID)
 	uid, gid := getUIDGID(site.UserID)
@@ -603,7 +658,6 @@ func (m *Manager) createSiteDirectories(site *models.Site) error {
 	dirs := []string{
 		site.RootPath,
 		filepath.Join(site.RootPath, site.PublicPath),
-		filepath.Join(cfg.LogDir, "sites", site.ID),
 	}
 
 	for _, dir := range dirs {
@@ -616,6 +670,11 @@ func (m *Manager) createSiteDirectories(site *models.Site) error {
 		}
 	}
 
+	// The proxy writes the access log as root, the owner may only read it
+	if err := sitelogs.PrepareDir(site.ID, gid); err != nil {
+		return err
+	}
+
 	// Create default index.php with beautiful FastCP landing page
 	indexPath := filepath.Join(site.RootPath, site.PublicPath, "index.php")
 	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
@@ -937,6 +996,28 @@ func (m *Manager) GetUserLimit(username string) *models.UserLimits {
 	return &models.UserLimits{Username: username, MaxSites: 0}
 }
 
+// phpVersionAllowedUnlocked reports whether the user's limits allow a PHP
+// version (caller must hold lock)
+func (m *Manager) phpVersionAllowedUnlocked(username, version string) bool {
+	limit, ok := m.userLimits[username]
+	if !ok || len(limit.PHPVersions) == 0 {
+		return true
+	}
+	return slices.Contains(limit.PHPVersions, version)
+}
+
+// ListUserLimits returns the limits of all users that have any
+func (m *Manager) ListUserLimits() []models.UserLimits {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	limits := make([]models.UserLimits, 0, len(m.userLimits))
+	for _, limit := range m.userLimits {
+		limits = append(limits, *limit)
+	}
+	return limits
+}
+
 // SetUserLimit sets the site limit for a user
 func (m *Manager) SetUserLimit(limit *models.UserLimits) error {
 	m.mu.Lock()
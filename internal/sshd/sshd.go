@@ -0,0 +1,433 @@
+// Package sshd manages the parts of the OpenSSH server configuration owned
+// by FastCP. Global settings go to a drop-in file when sshd_config includes
+// sshd_config.d, and to sshd_config itself otherwise. The jail Match block
+// always goes at the end of sshd_config: a Match block in a drop-in would
+// also capture the directives that follow the Include. Every change is
+// checked with `sshd -t` before sshd is reloaded and rolled back when sshd
+// rejects it, so a bad edit never reaches the running server.
+package sshd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// ConfigPath is the main sshd configuration
+	ConfigPath = "/etc/ssh/sshd_config"
+	// DropInDir holds drop-in files included by sshd_config
+	DropInDir = "/etc/ssh/sshd_config.d"
+	// SSHDPath is the sshd binary used to validate the configuration
+	SSHDPath = "/usr/sbin/sshd"
+
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		return exec.Command(name, args...).CombinedOutput()
+	}
+)
+
+const (
+	// DropInName sorts before other drop-ins: sshd uses the first value
+	// it reads for a setting
+	DropInName = "00-fastcp.conf"
+
+	// JailStart and JailEnd enclose the Match block for jailed users
+	JailStart = "# FastCP Jail Configuration"
+	JailEnd   = "# End FastCP Jail Configuration"
+
+	dropInHeader = "# Managed by FastCP - DO NOT EDIT MANUALLY\n"
+)
+
+var (
+	// ErrInvalidConfig is returned when sshd rejects a change, which was
+	// rolled back
+	ErrInvalidConfig = errors.New("sshd rejected the configuration")
+	// ErrOverridden is returned when a setting was written but another
+	// file sets it first
+	ErrOverridden = errors.New("setting is overridden by another sshd config file")
+	// ErrReloadFailed is returned when a valid change was written but sshd
+	// could not be reloaded, so it still runs with the previous settings
+	ErrReloadFailed = errors.New("sshd configuration saved but sshd did not reload")
+)
+
+// mu serializes changes to the sshd configuration
+var mu sync.Mutex
+
+// Managed are the sshd settings FastCP owns
+type Managed struct {
+	PasswordAuthentication string // "yes" or "no", empty leaves sshd's own setting
+	Jail                   string // Match block between JailStart and JailEnd, empty for none
+}
+
+// Status reports where FastCP writes its settings and what sshd uses
+type Status struct {
+	ConfigFile          string            `json:"config_file"`
+	DropIn              bool              `json:"drop_in"`
+	PasswordAuthEnabled bool              `json:"password_auth_enabled"`
+	Effective           map[string]string `json:"effective,omitempty"` // From sshd -T, empty when sshd is unavailable
+}
+
+// effectiveKeys are the settings reported from sshd -T
+var effectiveKeys = []string{
+	"port",
+	"permitrootlogin",
+	"passwordauthentication",
+	"pubkeyauthentication",
+	"kbdinteractiveauthentication",
+	"usepam",
+	"maxauthtries",
+}
+
+// Update changes the managed settings, validates the result and reloads
+// sshd. Nothing is written or reloaded when the settings are unchanged.
+func Update(change func(*Managed)) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	config, err := os.ReadFile(ConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read sshd_config: %w", err)
+	}
+
+	files := make(map[string]string)
+	if dropInSupported(string(config)) {
+		path := filepath.Join(DropInDir, DropInName)
+		current, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		m := parseManaged(string(current))
+
+		// The jail block lives in sshd_config; older versions kept it in
+		// the drop-in, from where it moves
+		rest, jail := cutJail(string(config))
+		if jail != "" {
+			m.Jail = jail
+		}
+
+		change(&m)
+		files[path] = renderDropIn(m)
+		files[ConfigPath] = appendJail(rest, m.Jail)
+	} else {
+		m := parseManaged(string(config))
+		change(&m)
+		files[ConfigPath] = editConfig(string(config), m)
+	}
+
+	return apply(files)
+}
+
+// SetPasswordAuth enables or disables password logins
+func SetPasswordAuth(enabled bool) error {
+	value := "no"
+	if enabled {
+		value = "yes"
+	}
+	if err := Update(func(m *Managed) { m.PasswordAuthentication = value }); err != nil {
+		return err
+	}
+
+	effective, err := Effective()
+	if err == nil && effective["passwordauthentication"] != "" && effective["passwordauthentication"] != value {
+		return fmt.Errorf("%w: PasswordAuthentication is %s", ErrOverridden, effective["passwordauthentication"])
+	}
+	return nil
+}
+
+// GetStatus returns the managed file and the settings sshd uses
+func GetStatus() (*Status, error) {
+	config, err := os.ReadFile(ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sshd_config: %w", err)
+	}
+
+	st := &Status{ConfigFile: ConfigPath, PasswordAuthEnabled: true}
+	m := parseManaged(string(config))
+	if dropInSupported(string(config)) {
+		st.DropIn = true
+		st.ConfigFile = filepath.Join(DropInDir, DropInName)
+		if data, err := os.ReadFile(st.ConfigFile); err == nil {
+			if dropIn := parseManaged(string(data)); dropIn.PasswordAuthentication != "" {
+				m.PasswordAuthentication = dropIn.PasswordAuthentication
+			}
+		}
+	}
+	if m.PasswordAuthentication != "" {
+		st.PasswordAuthEnabled = m.PasswordAuthentication == "yes"
+	}
+
+	// sshd knows best, including files FastCP doesn't manage
+	if effective, err := Effective(); err == nil {
+		st.Effective = effective
+		if v, ok := effective["passwordauthentication"]; ok {
+			st.PasswordAuthEnabled = v == "yes"
+		}
+	}
+	return st, nil
+}
+
+// Effective returns the settings sshd uses, from sshd -T
+func Effective() (map[string]string, error) {
+	out, err := runCommand(SSHDPath, "-T", "-f", ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("sshd -T failed: %s", strings.TrimSpace(string(out)))
+	}
+
+	all := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if ok {
+			all[strings.ToLower(key)] = value
+		}
+	}
+	effective := make(map[string]string)
+	for _, key := range effectiveKeys {
+		if v, ok := all[key]; ok {
+			effective[key] = v
+		}
+	}
+	return effective, nil
+}
+
+// apply writes the changed files, keeping a backup of each, and reloads
+// sshd. If sshd rejects the result every file is restored.
+func apply(files map[string]string) error {
+	type change struct {
+		path    string
+		old     []byte
+		existed bool
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var changes []change
+	for _, path := range paths {
+		old, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && string(old) == files[path] {
+			continue
+		}
+		changes = append(changes, change{path: path, old: old, existed: err == nil})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	restore := func() {
+		for _, c := range changes {
+			if c.existed {
+				writeFile(c.path, c.old)
+			} else {
+				os.Remove(c.path)
+			}
+		}
+	}
+
+	for _, c := range changes {
+		if c.existed {
+			if err := os.WriteFile(backupPath(c.path), c.old, 0600); err != nil {
+				return fmt.Errorf("failed to back up %s: %w", c.path, err)
+			}
+		}
+	}
+	for _, c := range changes {
+		if err := writeFile(c.path, []byte(files[c.path])); err != nil {
+			restore()
+			return fmt.Errorf("failed to write %s: %w", c.path, err)
+		}
+	}
+
+	if out, err := runCommand(SSHDPath, "-t", "-f", ConfigPath); err != nil {
+		restore()
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, commandError(out, err))
+	}
+
+	// The files are valid, so they stay even if sshd can't be reloaded now;
+	// sshd picks them up on its next start
+	if err := reload(); err != nil {
+		return fmt.Errorf("%w: %v", ErrReloadFailed, err)
+	}
+	return nil
+}
+
+// commandError returns the output of a failed command, or the error when
+// it printed nothing
+func commandError(out []byte, err error) string {
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return msg
+	}
+	return err.Error()
+}
+
+// backupPath keeps backups next to sshd_config, where include globs of
+// sshd_config.d don't pick them up
+func backupPath(path string) string {
+	return filepath.Join(filepath.Dir(ConfigPath), filepath.Base(path)+".fastcp.bak")
+}
+
+// writeFile replaces a file atomically, keeping the mode of the file it
+// replaces
+func writeFile(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	// WriteFile applies the umask
+	if err := os.Chmod(tmp, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// reload makes sshd read its configuration, the unit is named ssh on
+// Debian and sshd elsewhere
+func reload() error {
+	if _, err := runCommand("systemctl", "reload", "ssh"); err == nil {
+		return nil
+	}
+	if out, err := runCommand("systemctl", "reload", "sshd"); err != nil {
+		return errors.New(commandError(out, err))
+	}
+	return nil
+}
+
+// dropInSupported reports whether sshd_config includes the drop-in file
+func dropInSupported(config string) bool {
+	if fi, err := os.Stat(DropInDir); err != nil || !fi.IsDir() {
+		return false
+	}
+	for _, line := range strings.Split(config, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "include") {
+			continue
+		}
+		for _, pattern := range fields[1:] {
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(filepath.Dir(ConfigPath), pattern)
+			}
+			if filepath.Dir(pattern) != DropInDir {
+				continue
+			}
+			if ok, _ := filepath.Match(filepath.Base(pattern), DropInName); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseManaged reads the managed settings from a config file: the jail
+// block and the global PasswordAuthentication
+func parseManaged(content string) Managed {
+	rest, jail := cutJail(content)
+	m := Managed{Jail: jail}
+	for _, line := range strings.Split(rest, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.EqualFold(fields[0], "match") {
+			break
+		}
+		if strings.EqualFold(fields[0], "passwordauthentication") && len(fields) >= 2 {
+			m.PasswordAuthentication = strings.ToLower(fields[1])
+			break
+		}
+	}
+	return m
+}
+
+// cutJail removes the jail block from content and returns both
+func cutJail(content string) (rest, jail string) {
+	start := strings.Index(content, JailStart)
+	if start < 0 {
+		return content, ""
+	}
+	end := len(content)
+	if i := strings.Index(content[start:], JailEnd); i >= 0 {
+		end = start + i + len(JailEnd)
+	}
+	jail = strings.TrimSpace(content[start:end])
+	rest = strings.TrimRight(content[:start], "\n")
+	if after := strings.TrimLeft(content[end:], "\n"); after != "" {
+		rest += "\n\n" + after
+	} else if rest != "" {
+		rest += "\n"
+	}
+	return rest, jail
+}
+
+// renderDropIn returns the drop-in file for the managed global settings
+func renderDropIn(m Managed) string {
+	var b strings.Builder
+	b.WriteString(dropInHeader)
+	if m.PasswordAuthentication != "" {
+		b.WriteString("PasswordAuthentication " + m.PasswordAuthentication + "\n")
+	}
+	return b.String()
+}
+
+// appendJail puts the jail block at the end of sshd_config, where its Match
+// applies to nothing after it
+func appendJail(config, jail string) string {
+	if jail = strings.TrimSpace(jail); jail != "" {
+		config = strings.TrimRight(config, "\n") + "\n\n" + jail + "\n"
+	}
+	return config
+}
+
+// editConfig applies the managed settings to sshd_config itself. The
+// global PasswordAuthentication is replaced in place or added before the
+// first Match block, and the jail block goes at the end.
+func editConfig(config string, m Managed) string {
+	config, _ = cutJail(config)
+
+	if m.PasswordAuthentication != "" {
+		directive := "PasswordAuthentication " + m.PasswordAuthentication
+		lines := strings.Split(config, "\n")
+		index, replace := len(lines), false
+		for i, line := range lines {
+			lower := strings.ToLower(strings.TrimSpace(line))
+			if strings.HasPrefix(lower, "match ") {
+				index = i
+				break
+			}
+			if strings.HasPrefix(lower, "passwordauthentication") || strings.HasPrefix(lower, "#passwordauthentication") {
+				index, replace = i, true
+				break
+			}
+		}
+		if replace {
+			lines[index] = directive
+		} else {
+			if index == len(lines) && index > 0 && lines[index-1] == "" {
+				index-- // Keep the trailing newline last
+			}
+			lines = append(lines[:index], append([]string{directive}, lines[index:]...)...)
+		}
+		config = strings.Join(lines, "\n")
+	}
+
+	return appendJail(config, m.Jail)
+}
@@ -0,0 +1,259 @@
+package sshd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const jail = `
+# FastCP Jail Configuration - DO NOT EDIT MANUALLY
+
+Match Group fastcp-jail
+    ForceCommand internal-sftp -d /www
+
+# End FastCP Jail Configuration
+`
+
+// setup points the package at a temporary sshd configuration and records
+// the commands run
+func setup(t *testing.T, config string, dropIn bool) *[]string {
+	t.Helper()
+	dir := t.TempDir()
+	ConfigPath = filepath.Join(dir, "sshd_config")
+	DropInDir = filepath.Join(dir, "sshd_config.d")
+	if dropIn {
+		if err := os.Mkdir(DropInDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(ConfigPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var commands []string
+	origRun := runCommand
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		return nil, nil
+	}
+	t.Cleanup(func() {
+		ConfigPath = "/etc/ssh/sshd_config"
+		DropInDir = "/etc/ssh/sshd_config.d"
+		runCommand = origRun
+	})
+	return &commands
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestUpdateDropIn(t *testing.T) {
+	commands := setup(t, "Include sshd_config.d/*.conf\nPort 22\n", true)
+	// Older versions kept the jail block in the drop-in
+	dropInPath := filepath.Join(DropInDir, DropInName)
+	legacy := dropInHeader + "PasswordAuthentication yes\n" + jail
+	os.WriteFile(dropInPath, []byte(legacy), 0644)
+
+	if err := Update(func(m *Managed) { m.Jail = jail; m.PasswordAuthentication = "no" }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	// The drop-in is included before the rest of sshd_config, so it holds
+	// no Match block that would capture the directives after the Include
+	if dropIn := readFile(t, dropInPath); dropIn != dropInHeader+"PasswordAuthentication no\n" {
+		t.Fatalf("unexpected drop-in:\n%s", dropIn)
+	}
+	want := "Include sshd_config.d/*.conf\nPort 22\n\n" + strings.TrimSpace(jail) + "\n"
+	if config := readFile(t, ConfigPath); config != want {
+		t.Fatalf("expected the jail block at the end of sshd_config, got:\n%s", config)
+	}
+	if backup := readFile(t, backupPath(dropInPath)); backup != legacy {
+		t.Fatalf("expected backup of the original drop-in, got:\n%s", backup)
+	}
+	if len(*commands) != 2 || !strings.Contains((*commands)[0], "-t -f "+ConfigPath) || !strings.Contains((*commands)[1], "reload") {
+		t.Fatalf("expected validation then reload, got %v", *commands)
+	}
+
+	// Nothing changes, nothing is reloaded
+	*commands = nil
+	if err := Update(func(m *Managed) { m.Jail = jail }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if len(*commands) != 0 {
+		t.Fatalf("expected no commands for an unchanged config, got %v", *commands)
+	}
+
+	st, err := GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.DropIn || st.ConfigFile != filepath.Join(DropInDir, DropInName) || st.PasswordAuthEnabled {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+}
+
+func TestUpdateConfigFile(t *testing.T) {
+	// Without an include of the drop-in directory sshd_config is edited
+	setup(t, "Port 22\n#PasswordAuthentication yes\n\nMatch User backup\n    PasswordAuthentication yes\n", true)
+
+	if err := Update(func(m *Managed) { m.Jail = jail; m.PasswordAuthentication = "no" }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	config := readFile(t, ConfigPath)
+	want := "Port 22\nPasswordAuthentication no\n\nMatch User backup\n    PasswordAuthentication yes\n\n" + strings.TrimSpace(jail) + "\n"
+	if config != want {
+		t.Fatalf("unexpected sshd_config:\n%s\nwant:\n%s", config, want)
+	}
+	if _, err := os.Stat(filepath.Join(DropInDir, DropInName)); !os.IsNotExist(err) {
+		t.Fatal("expected no drop-in file")
+	}
+
+	// Editing again replaces the setting and keeps a single jail block
+	if err := Update(func(m *Managed) { m.PasswordAuthentication = "yes" }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	config = readFile(t, ConfigPath)
+	if strings.Count(config, JailStart) != 1 || !strings.Contains(config, "Port 22\nPasswordAuthentication yes\n") {
+		t.Fatalf("unexpected sshd_config:\n%s", config)
+	}
+}
+
+func TestUpdateRollback(t *testing.T) {
+	original := "Include /etc/ssh/sshd_config.d/*.conf\nPort 22\n" + jail
+	commands := setup(t, original, true)
+	// The include is absolute, point it at the test directory
+	original = strings.Replace(original, "/etc/ssh/sshd_config.d", DropInDir, 1)
+	os.WriteFile(ConfigPath, []byte(original), 0644)
+
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		*commands = append(*commands, name+" "+strings.Join(args, " "))
+		if len(args) > 0 && args[0] == "-t" {
+			return []byte("Bad configuration option: Foo"), errors.New("exit status 255")
+		}
+		return nil, nil
+	}
+
+	err := SetPasswordAuth(false)
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "Bad configuration option") {
+		t.Fatalf("expected ErrInvalidConfig with sshd output, got %v", err)
+	}
+	if config := readFile(t, ConfigPath); config != original {
+		t.Fatalf("expected sshd_config restored, got:\n%s", config)
+	}
+	if _, err := os.Stat(filepath.Join(DropInDir, DropInName)); !os.IsNotExist(err) {
+		t.Fatal("expected new drop-in file removed")
+	}
+	for _, c := range *commands {
+		if strings.Contains(c, "reload") {
+			t.Fatalf("sshd must not be reloaded after a rejected change: %v", *commands)
+		}
+	}
+}
+
+func TestSetPasswordAuthOverridden(t *testing.T) {
+	setup(t, "Include sshd_config.d/*.conf\n", true)
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		if len(args) > 0 && args[0] == "-T" {
+			return []byte("port 22\npasswordauthentication yes\npermitrootlogin no\n"), nil
+		}
+		return nil, nil
+	}
+
+	if err := SetPasswordAuth(false); !errors.Is(err, ErrOverridden) {
+		t.Fatalf("expected ErrOverridden, got %v", err)
+	}
+	effective, err := Effective()
+	if err != nil || effective["permitrootlogin"] != "no" || effective["port"] != "22" {
+		t.Fatalf("unexpected effective settings %v, %v", effective, err)
+	}
+}
+
+func TestUpdateReloadFailure(t *testing.T) {
+	setup(t, "Port 22\n", false)
+	// A restrictive mode survives the rewrite
+	os.Chmod(ConfigPath, 0600)
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		if name == "systemctl" {
+			return []byte("Unit " + args[1] + ".service not loaded."), errors.New("exit status 5")
+		}
+		return nil, nil
+	}
+
+	err := SetPasswordAuth(false)
+	if !errors.Is(err, ErrReloadFailed) || !strings.Contains(err.Error(), "sshd.service not loaded") {
+		t.Fatalf("expected ErrReloadFailed with systemctl output, got %v", err)
+	}
+	// The valid change is kept for sshd's next start
+	if config := readFile(t, ConfigPath); config != "Port 22\nPasswordAuthentication no\n" {
+		t.Fatalf("expected the change to be kept, got:\n%s", config)
+	}
+	if fi, err := os.Stat(ConfigPath); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected sshd_config to keep mode 0600, got %v %v", fi.Mode(), err)
+	}
+}
+
+// TestUpdateSSHD validates the managed files with the real sshd, where one
+// is installed: the jail Match block must not capture settings that
+// sshd_config makes after including the drop-ins
+func TestUpdateSSHD(t *testing.T) {
+	sshdPath, err := exec.LookPath("sshd")
+	if err != nil {
+		sshdPath = "/usr/sbin/sshd"
+		if _, err := os.Stat(sshdPath); err != nil {
+			t.Skip("sshd not installed")
+		}
+	}
+
+	dir := t.TempDir()
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey := filepath.Join(dir, "host_key")
+	if err := os.WriteFile(hostKey, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	setup(t, "", true)
+	config := "HostKey " + hostKey + "\nInclude " + DropInDir + "/*.conf\nAllowTcpForwarding no\nSubsystem sftp internal-sftp\n"
+	os.WriteFile(ConfigPath, []byte(config), 0644)
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		if name == "systemctl" {
+			return nil, nil
+		}
+		return exec.Command(sshdPath, args...).CombinedOutput()
+	}
+	if out, err := runCommand(sshdPath, "-t", "-f", ConfigPath); err != nil {
+		t.Skipf("sshd cannot validate a configuration here: %s", commandError(out, err))
+	}
+
+	if err := Update(func(m *Managed) { m.Jail = jail; m.PasswordAuthentication = "no" }); err != nil {
+		t.Fatalf("sshd rejected the managed configuration: %v", err)
+	}
+
+	out, err := runCommand(sshdPath, "-T", "-f", ConfigPath, "-C", "user=nobody,host=client,addr=192.0.2.1")
+	if err != nil {
+		t.Skipf("sshd -T unavailable here: %s", commandError(out, err))
+	}
+	for _, want := range []string{"allowtcpforwarding no", "passwordauthentication no"} {
+		if !strings.Contains(string(out), want+"\n") {
+			t.Fatalf("expected %q for users outside the jail, got:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+// Package teams lets users invite other panel users to help manage their
+// sites. Each collaborator gets per-site access to files, databases,
+// deployments or logs, without the account's Unix password.
+package teams
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/rehmatworks/fastcp/internal/models"
+)
+
+var (
+	ErrMemberNotFound = errors.New("team member not found")
+	ErrAlreadyMember  = errors.New("user is already a team member")
+	ErrSelfInvite     = errors.New("cannot invite yourself")
+	ErrInvalidAccess  = errors.New("invalid site access")
+	ErrNoSites        = errors.New("at least one site is required")
+)
+
+// Site access a collaborator can be given
+const (
+	AccessFiles     = "files"     // Browse and edit site files
+	AccessDatabases = "databases" // View databases linked to the site and reset their passwords
+	AccessDeploy    = "deploy"    // Change site settings, error pages and restart workers
+	AccessLogs      = "logs"      // Read logs and analytics
+)
+
+var validAccess = map[string]bool{
+	AccessFiles:     true,
+	AccessDatabases: true,
+	AccessDeploy:    true,
+	AccessLogs:      true,
+}
+
+// Manager stores team members in DataDir/teams.json
+type Manager struct {
+	mu      sync.RWMutex
+	path    string
+	members map[string]*models.TeamMember
+	now     func() time.Time
+}
+
+// NewManager creates a team manager
+func NewManager(dataDir string) *Manager {
+	return &Manager{
+		path:    filepath.Join(dataDir, "teams.json"),
+		members: make(map[string]*models.TeamMember),
+		now:     time.Now,
+	}
+}
+
+// Load reads team members from disk
+func (m *Manager) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var list []*models.TeamMember
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for _, tm := range list {
+		m.members[tm.ID] = tm
+	}
+	return nil
+}
+
+// Invite adds a collaborator to an owner's team. The invitation grants
+// nothing until the collaborator accepts it.
+func (m *Manager) Invite(owner, username string, sites map[string][]string) (*models.TeamMember, error) {
+	if owner == username {
+		return nil, ErrSelfInvite
+	}
+	cleaned, err := validate(sites)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, tm := range m.members {
+		if tm.Owner == owner && tm.Username == username {
+			return nil, ErrAlreadyMember
+		}
+	}
+
+	tm := &models.TeamMember{
+		ID:        uuid.New().String(),
+		Owner:     owner,
+		Username:  username,
+		Sites:     cleaned,
+		CreatedAt: m.now(),
+	}
+	m.members[tm.ID] = tm
+
+	if err := m.saveUnlocked(); err != nil {
+		delete(m.members, tm.ID)
+		return nil, err
+	}
+	return copyMember(tm), nil
+}
+
+// Update replaces the site access of one of an owner's team members
+func (m *Manager) Update(owner, id string, sites map[string][]string) (*models.TeamMember, error) {
+	cleaned, err := validate(sites)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tm, ok := m.members[id]
+	if !ok || tm.Owner != owner {
+		return nil, ErrMemberNotFound
+	}
+	previous := tm.Sites
+	tm.Sites = cleaned
+
+	if err := m.saveUnlocked(); err != nil {
+		tm.Sites = previous
+		return nil, err
+	}
+	return copyMember(tm), nil
+}
+
+// Accept accepts an invitation sent to username
+func (m *Manager) Accept(username, id string) (*models.TeamMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tm, ok := m.members[id]
+	if !ok || tm.Username != username {
+		return nil, ErrMemberNotFound
+	}
+	if tm.Accepted {
+		return copyMember(tm), nil
+	}
+	tm.Accepted = true
+	tm.AcceptedAt = m.now()
+
+	if err := m.saveUnlocked(); err != nil {
+		tm.Accepted = false
+		tm.AcceptedAt = time.Time{}
+		return nil, err
+	}
+	return copyMember(tm), nil
+}
+
+// Remove deletes a team membership. Both the owner and the collaborator
+// may end it.
+func (m *Manager) Remove(username, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tm, ok := m.members[id]
+	if !ok || (tm.Owner != username && tm.Username != username) {
+		return ErrMemberNotFound
+	}
+	delete(m.members, id)
+
+	if err := m.saveUnlocked(); err != nil {
+		m.members[id] = tm
+		return err
+	}
+	return nil
+}
+
+// ByOwner returns the collaborators an owner invited
+func (m *Manager) ByOwner(owner string) []models.TeamMember {
+	return m.list(func(tm *models.TeamMember) bool { return tm.Owner == owner })
+}
+
+// ByMember returns the teams a user was invited to
+func (m *Manager) ByMember(username string) []models.TeamMember {
+	return m.list(func(tm *models.TeamMember) bool { return tm.Username == username })
+}
+
+// Can reports whether username has accepted access to a site. An empty
+// access matches any access, for viewing the site itself.
+func (m *Manager) Can(username, siteID, access string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, tm := range m.members {
+		if tm.Username != username || !tm.Accepted {
+			continue
+		}
+		for _, a := range tm.Sites[siteID] {
+			if access == "" || a == access {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SharedSites returns the IDs of the sites username has accepted access to
+func (m *Manager) SharedSites(username string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	for _, tm := range m.members {
+		if tm.Username != username || !tm.Accepted {
+			continue
+		}
+		for id := range tm.Sites {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// RemoveSite drops a deleted site from every team
+func (m *Manager) RemoveSite(siteID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changed := false
+	for id, tm := range m.members {
+		if _, ok := tm.Sites[siteID]; !ok {
+			continue
+		}
+		delete(tm.Sites, siteID)
+		if len(tm.Sites) == 0 {
+			delete(m.members, id)
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return m.saveUnlocked()
+}
+
+// RemoveUser drops a deleted user's team and memberships
+func (m *Manager) RemoveUser(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changed := false
+	for id, tm := range m.members {
+		if tm.Owner == username || tm.Username == username {
+			delete(m.members, id)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return m.saveUnlocked()
+}
+
+func (m *Manager) list(match func(*models.TeamMember) bool) []models.TeamMember {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := make([]models.TeamMember, 0)
+	for _, tm := range m.members {
+		if match(tm) {
+			list = append(list, *copyMember(tm))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// saveUnlocked writes team members to disk (caller must hold lock)
+func (m *Manager) saveUnlocked() error {
+	list := make([]*models.TeamMember, 0, len(m.members))
+	for _, tm := range m.members {
+		list = append(list, tm)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, data, 0644)
+}
+
+// validate checks site access and drops duplicates
+func validate(sites map[string][]string) (map[string][]string, error) {
+	if len(sites) == 0 {
+		return nil, ErrNoSites
+	}
+	cleaned := make(map[string][]string, len(sites))
+	for siteID, access := range sites {
+		if len(access) == 0 {
+			return nil, fmt.Errorf("%w: no access for site %s", ErrInvalidAccess, siteID)
+		}
+		seen := make(map[string]bool)
+		for _, a := range access {
+			if !validAccess[a] {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidAccess, a)
+			}
+			if !seen[a] {
+				seen[a] = true
+				cleaned[siteID] = append(cleaned[siteID], a)
+			}
+		}
+		sort.Strings(cleaned[siteID])
+	}
+	return cleaned, nil
+}
+
+func copyMember(tm *models.TeamMember) *models.TeamMember {
+	copied := *tm
+	copied.Sites = make(map[string][]string, len(tm.Sites))
+	for id, access := range tm.Sites {
+		copied.Sites[id] = append([]string(nil), access...)
+	}
+	return &copied
+}
@@ -0,0 +1,109 @@
+package teams
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInviteAndAccept(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir)
+
+	if _, err := m.Invite("alice", "alice", map[string][]string{"site-1": {AccessFiles}}); !errors.Is(err, ErrSelfInvite) {
+		t.Fatalf("expected ErrSelfInvite, got %v", err)
+	}
+	if _, err := m.Invite("alice", "bob", nil); !errors.Is(err, ErrNoSites) {
+		t.Fatalf("expected ErrNoSites, got %v", err)
+	}
+	if _, err := m.Invite("alice", "bob", map[string][]string{"site-1": {"root"}}); !errors.Is(err, ErrInvalidAccess) {
+		t.Fatalf("expected ErrInvalidAccess, got %v", err)
+	}
+
+	member, err := m.Invite("alice", "bob", map[string][]string{"site-1": {AccessLogs, AccessFiles, AccessFiles}})
+	if err != nil {
+		t.Fatalf("Invite failed: %v", err)
+	}
+	if got := member.Sites["site-1"]; len(got) != 2 {
+		t.Fatalf("expected duplicate access to be dropped, got %v", got)
+	}
+	if _, err := m.Invite("alice", "bob", map[string][]string{"site-2": {AccessLogs}}); !errors.Is(err, ErrAlreadyMember) {
+		t.Fatalf("expected ErrAlreadyMember, got %v", err)
+	}
+
+	// Pending invitations grant nothing
+	if m.Can("bob", "site-1", AccessFiles) {
+		t.Fatal("expected no access before accepting")
+	}
+	if _, err := m.Accept("carol", member.ID); !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("expected only the invitee to accept, got %v", err)
+	}
+	if _, err := m.Accept("bob", member.ID); err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+
+	if !m.Can("bob", "site-1", AccessFiles) || !m.Can("bob", "site-1", "") {
+		t.Fatal("expected file access after accepting")
+	}
+	if m.Can("bob", "site-1", AccessDatabases) {
+		t.Fatal("expected no database access")
+	}
+	if m.Can("bob", "site-2", "") {
+		t.Fatal("expected no access to other sites")
+	}
+
+	// Memberships survive a restart
+	reloaded := NewManager(dir)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := reloaded.SharedSites("bob"); len(got) != 1 || got[0] != "site-1" {
+		t.Fatalf("expected shared site after reload, got %v", got)
+	}
+}
+
+func TestUpdateAndRemove(t *testing.T) {
+	m := NewManager(t.TempDir())
+
+	member, _ := m.Invite("alice", "bob", map[string][]string{"site-1": {AccessFiles}, "site-2": {AccessLogs}})
+	m.Accept("bob", member.ID)
+
+	if _, err := m.Update("carol", member.ID, map[string][]string{"site-1": {AccessDeploy}}); !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("expected only the owner to update, got %v", err)
+	}
+	if _, err := m.Update("alice", member.ID, map[string][]string{"site-1": {AccessDeploy}, "site-2": {AccessLogs}}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if m.Can("bob", "site-1", AccessFiles) || !m.Can("bob", "site-1", AccessDeploy) {
+		t.Fatal("expected updated access to replace the old one")
+	}
+
+	// Deleting a site drops it from the team, the last site ends the membership
+	if err := m.RemoveSite("site-1"); err != nil {
+		t.Fatalf("RemoveSite failed: %v", err)
+	}
+	if got := m.ByOwner("alice"); len(got) != 1 || len(got[0].Sites) != 1 {
+		t.Fatalf("expected one remaining site, got %+v", got)
+	}
+	m.RemoveSite("site-2")
+	if got := m.ByMember("bob"); len(got) != 0 {
+		t.Fatalf("expected membership to end with its last site, got %+v", got)
+	}
+
+	// Either side may end a membership
+	member, _ = m.Invite("alice", "bob", map[string][]string{"site-3": {AccessFiles}})
+	if err := m.Remove("carol", member.ID); !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("expected ErrMemberNotFound, got %v", err)
+	}
+	if err := m.Remove("bob", member.ID); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	m.Invite("alice", "bob", map[string][]string{"site-3": {AccessFiles}})
+	m.Invite("bob", "carol", map[string][]string{"site-4": {AccessFiles}})
+	if err := m.RemoveUser("bob"); err != nil {
+		t.Fatalf("RemoveUser failed: %v", err)
+	}
+	if len(m.ByOwner("alice")) != 0 || len(m.ByMember("carol")) != 0 {
+		t.Fatal("expected deleting a user to end their team and memberships")
+	}
+}
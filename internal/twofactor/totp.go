@@ -0,0 +1,92 @@
+package twofactor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238 defaults, supported by every authenticator app)
+const (
+	Period = 30 // seconds
+	Digits = 6
+	// Skew is the number of periods accepted before and after the current
+	// one to allow for clock drift
+	Skew = 1
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32 encoded 160-bit secret
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(buf), nil
+}
+
+// ProvisioningURI returns the otpauth:// URI shown as a QR code when
+// enrolling an authenticator app
+func ProvisioningURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(Period))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// step returns the TOTP time step for t
+func step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// codeAt computes the code for a time step (RFC 4226 HOTP)
+func codeAt(secret string, counter int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000), nil
+}
+
+// Code returns the current code for a secret
+func Code(secret string, t time.Time) (string, error) {
+	return codeAt(secret, step(t))
+}
+
+// matchStep returns the time step a code is valid for, or -1 when it does
+// not match within the allowed skew
+func matchStep(secret, code string, t time.Time) int64 {
+	if len(code) != Digits {
+		return -1
+	}
+	now := step(t)
+	for i := -Skew; i <= Skew; i++ {
+		expected, err := codeAt(secret, now+int64(i))
+		if err != nil {
+			return -1
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return now + int64(i)
+		}
+	}
+	return -1
+}
@@ -0,0 +1,321 @@
+package twofactor
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrNoEnrollment   = errors.New("no pending two-factor enrollment")
+	ErrInvalidCode    = errors.New("invalid authentication code")
+	ErrLockedOut      = errors.New("too many failed attempts, try again later")
+)
+
+const (
+	// Issuer is shown in authenticator apps next to the account name
+	Issuer = "FastCP"
+	// RecoveryCodeCount is the number of single-use recovery codes issued
+	RecoveryCodeCount = 10
+	// MaxFailures failed codes in a row lock the user out for LockoutDuration
+	MaxFailures     = 5
+	LockoutDuration = 5 * time.Minute
+)
+
+// record is the stored two-factor state of a user
+type record struct {
+	Secret        string     `json:"secret"`
+	Enabled       bool       `json:"enabled"`
+	RecoveryCodes []string   `json:"recovery_codes,omitempty"` // SHA-256 hashes of unused codes
+	LastStep      int64      `json:"last_step"`                // Last accepted time step, codes are single-use
+	EnabledAt     *time.Time `json:"enabled_at,omitempty"`
+}
+
+// failures tracks failed verification attempts of a user
+type failures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// Status is the two-factor state reported to the user
+type Status struct {
+	Enabled           bool       `json:"enabled"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+}
+
+// Manager stores TOTP secrets and recovery codes per user
+type Manager struct {
+	mu       sync.Mutex
+	path     string
+	users    map[string]*record
+	failures map[string]*failures
+	now      func() time.Time
+}
+
+// NewManager creates a two-factor manager storing its state in dataDir
+func NewManager(dataDir string) *Manager {
+	return &Manager{
+		path:     filepath.Join(dataDir, "twofactor.json"),
+		users:    make(map[string]*record),
+		failures: make(map[string]*failures),
+		now:      time.Now,
+	}
+}
+
+// Load reads the stored two-factor state
+func (m *Manager) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	users := make(map[string]*record)
+	if err := json.Unmarshal(data, &users); err != nil {
+		return err
+	}
+	m.users = users
+	return nil
+}
+
+// saveUnlocked writes the state (caller must hold lock). The file holds
+// TOTP secrets, so it is only readable by root.
+func (m *Manager) saveUnlocked() error {
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, data, 0600)
+}
+
+// Enabled reports whether a user has confirmed two-factor authentication
+func (m *Manager) Enabled(username string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.users[username]
+	return ok && rec.Enabled
+}
+
+// Status returns the two-factor state of a user
+func (m *Manager) Status(username string) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.users[username]
+	if !ok || !rec.Enabled {
+		return Status{}
+	}
+	return Status{Enabled: true, RecoveryCodesLeft: len(rec.RecoveryCodes), EnabledAt: rec.EnabledAt}
+}
+
+// Begin starts an enrollment with a new secret and returns the secret and
+// its provisioning URI. Two-factor stays off until Confirm succeeds.
+func (m *Manager) Begin(username, account string) (string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rec, ok := m.users[username]; ok && rec.Enabled {
+		return "", "", ErrAlreadyEnabled
+	}
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	m.users[username] = &record{Secret: secret}
+	if err := m.saveUnlocked(); err != nil {
+		delete(m.users, username)
+		return "", "", err
+	}
+	return secret, ProvisioningURI(Issuer, account, secret), nil
+}
+
+// Confirm enables two-factor authentication once the user proves the
+// authenticator works, and returns the recovery codes. They are only
+// shown once; only their hashes are stored.
+func (m *Manager) Confirm(username, code string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.users[username]
+	if !ok {
+		return nil, ErrNoEnrollment
+	}
+	if rec.Enabled {
+		return nil, ErrAlreadyEnabled
+	}
+	if err := m.checkLockoutUnlocked(username); err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	st := matchStep(rec.Secret, normalize(code), now)
+	if st < 0 {
+		m.failUnlocked(username)
+		return nil, ErrInvalidCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	previous := *rec
+	rec.Enabled = true
+	rec.LastStep = st
+	rec.RecoveryCodes = hashes
+	rec.EnabledAt = &now
+	if err := m.saveUnlocked(); err != nil {
+		*rec = previous
+		return nil, err
+	}
+	delete(m.failures, username)
+	return codes, nil
+}
+
+// Verify checks a TOTP code or an unused recovery code. Recovery codes are
+// consumed, TOTP codes cannot be reused.
+func (m *Manager) Verify(username, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.users[username]
+	if !ok || !rec.Enabled {
+		return ErrNotEnabled
+	}
+	if err := m.checkLockoutUnlocked(username); err != nil {
+		return err
+	}
+
+	code = normalize(code)
+	if st := matchStep(rec.Secret, code, m.now()); st > rec.LastStep {
+		rec.LastStep = st
+		delete(m.failures, username)
+		return m.saveUnlocked()
+	}
+
+	sum := hashCode(code)
+	for i, h := range rec.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(sum)) == 1 {
+			rec.RecoveryCodes = append(rec.RecoveryCodes[:i:i], rec.RecoveryCodes[i+1:]...)
+			delete(m.failures, username)
+			return m.saveUnlocked()
+		}
+	}
+
+	m.failUnlocked(username)
+	return ErrInvalidCode
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after verifying a code
+func (m *Manager) RegenerateRecoveryCodes(username, code string) ([]string, error) {
+	if err := m.Verify(username, code); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.users[username]
+	if !ok || !rec.Enabled {
+		return nil, ErrNotEnabled
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	rec.RecoveryCodes = hashes
+	if err := m.saveUnlocked(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable removes a user's two-factor state. Callers verify a code first,
+// or are an admin resetting a user's lost authenticator.
+func (m *Manager) Disable(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.users[username]
+	if !ok {
+		return ErrNotEnabled
+	}
+	delete(m.users, username)
+	delete(m.failures, username)
+	if err := m.saveUnlocked(); err != nil {
+		m.users[username] = rec
+		return err
+	}
+	return nil
+}
+
+// checkLockoutUnlocked returns ErrLockedOut while a user is locked out
+// (caller must hold lock)
+func (m *Manager) checkLockoutUnlocked(username string) error {
+	if f, ok := m.failures[username]; ok && m.now().Before(f.lockedUntil) {
+		return ErrLockedOut
+	}
+	return nil
+}
+
+// failUnlocked records a failed attempt (caller must hold lock)
+func (m *Manager) failUnlocked(username string) {
+	f, ok := m.failures[username]
+	if !ok {
+		f = &failures{}
+		m.failures[username] = f
+	}
+	f.count++
+	if f.count >= MaxFailures {
+		f.count = 0
+		f.lockedUntil = m.now().Add(LockoutDuration)
+	}
+}
+
+// generateRecoveryCodes returns new recovery codes and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(buf)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashCode(code)
+	}
+	return codes, hashes, nil
+}
+
+// normalize strips the spaces and dashes users type in codes
+func normalize(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+// hashCode hashes a normalized recovery code for storage
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,118 @@
+package twofactor
+
+import (
+	"encoding/base32"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCodeRFC6238(t *testing.T) {
+	// RFC 6238 appendix B SHA1 vectors, truncated to 6 digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		got, err := Code(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("Code failed: %v", err)
+		}
+		if got != want {
+			t.Fatalf("at %d: expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestEnrollAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir)
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+
+	secret, uri, err := m.Begin("alice", "alice@host")
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if !strings.HasPrefix(uri, "otpauth://totp/FastCP:alice@host?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("unexpected provisioning URI: %s", uri)
+	}
+	if m.Enabled("alice") {
+		t.Fatal("expected 2FA to stay off until confirmed")
+	}
+
+	code, _ := Code(secret, now)
+	codes, err := m.Confirm("alice", code)
+	if err != nil {
+		t.Fatalf("Confirm failed: %v", err)
+	}
+	if len(codes) != RecoveryCodeCount || !m.Enabled("alice") {
+		t.Fatalf("expected 2FA enabled with %d recovery codes, got %d", RecoveryCodeCount, len(codes))
+	}
+
+	// The code used to confirm cannot be replayed
+	if err := m.Verify("alice", code); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("expected replayed code to fail, got %v", err)
+	}
+	now = now.Add(Period * time.Second)
+	next, _ := Code(secret, now)
+	if err := m.Verify("alice", next); err != nil {
+		t.Fatalf("expected next code to verify, got %v", err)
+	}
+
+	// Recovery codes work once
+	if err := m.Verify("alice", strings.ToUpper(codes[0])); err != nil {
+		t.Fatalf("expected recovery code to verify, got %v", err)
+	}
+	if err := m.Verify("alice", codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("expected used recovery code to fail, got %v", err)
+	}
+
+	// State survives a reload and is only readable by its owner
+	info, err := os.Stat(m.path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected 0600 state file, got %v %v", info, err)
+	}
+	reloaded := NewManager(dir)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if st := reloaded.Status("alice"); !st.Enabled || st.RecoveryCodesLeft != RecoveryCodeCount-1 {
+		t.Fatalf("unexpected status after reload: %+v", st)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	m := NewManager(t.TempDir())
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+
+	secret, _, _ := m.Begin("bob", "bob")
+	code, _ := Code(secret, now)
+	if _, err := m.Confirm("bob", code); err != nil {
+		t.Fatalf("Confirm failed: %v", err)
+	}
+
+	for i := 0; i < MaxFailures; i++ {
+		if err := m.Verify("bob", "000000"); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("attempt %d: expected ErrInvalidCode, got %v", i, err)
+		}
+	}
+
+	now = now.Add(Period * time.Second)
+	valid, _ := Code(secret, now)
+	if err := m.Verify("bob", valid); !errors.Is(err, ErrLockedOut) {
+		t.Fatalf("expected lockout, got %v", err)
+	}
+
+	now = now.Add(LockoutDuration)
+	valid, _ = Code(secret, now)
+	if err := m.Verify("bob", valid); err != nil {
+		t.Fatalf("expected code to verify after lockout, got %v", err)
+	}
+}
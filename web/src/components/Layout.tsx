@@ -126,8 +126,8 @@ export function Layout({ children }: { children: React.ReactNode }) {
     navigate('/login')
   }
 
-  const handleStopImpersonating = () => {
-    stopImpersonating()
+  const handleStopImpersonating = async () => {
+    await stopImpersonating()
     navigate('/')
   }
 
@@ -1,6 +1,6 @@
 import { createContext, useContext, useState, useEffect, useCallback, ReactNode } from 'react'
 import { api } from '@/lib/api'
-import type { User } from '@/types'
+import type { User, LoginResponse, LoginChallenge } from '@/types'
 
 interface AuthContextType {
   user: User | null
@@ -8,11 +8,12 @@ interface AuthContextType {
   isAuthenticated: boolean
   isLoading: boolean
   isImpersonating: boolean
-  login: (username: string, password: string) => Promise<void>
+  login: (username: string, password: string) => Promise<LoginChallenge | null>
+  completeLogin: (response: LoginResponse) => void
   logout: () => void
   checkAuth: () => Promise<void>
-  impersonate: (username: string) => void
-  stopImpersonating: () => void
+  impersonate: (username: string) => Promise<void>
+  stopImpersonating: () => Promise<void>
 }
 
 const AuthContext = createContext<AuthContextType | undefined>(undefined)
@@ -22,7 +23,7 @@ export function AuthProvider({ children }: { children: ReactNode }) {
   const [realUser, setRealUser] = useState<User | null>(null)
   const [isLoading, setIsLoading] = useState(true)
 
-  const isImpersonating = realUser !== null && user?.id !== realUser.id
+  const isImpersonating = !!user?.impersonator
 
   const checkAuth = useCallback(async () => {
     const token = api.getToken()
@@ -35,10 +36,17 @@ export function AuthProvider({ children }: { children: ReactNode }) {
 
     try {
       const userData = await api.getCurrentUser()
-      setUser(userData as User)
-      // Only set realUser if not already impersonating
-      if (!realUser) {
-        setRealUser(userData as User)
+      setUser(userData)
+      // An impersonation token names the admin or reseller behind it
+      if (userData.impersonator) {
+        setRealUser({
+          id: userData.impersonator.user_id,
+          username: userData.impersonator.username,
+          email: `${userData.impersonator.username}@localhost`,
+          role: userData.impersonator.role,
+        })
+      } else {
+        setRealUser(userData)
       }
     } catch {
       api.logout()
@@ -47,14 +55,24 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     } finally {
       setIsLoading(false)
     }
-  }, [realUser])
+  }, [])
 
   useEffect(() => {
     checkAuth()
   }, [])
 
+  // login returns the challenge for the second step when two-factor
+  // authentication applies, which completeLogin finishes
   const login = async (username: string, password: string) => {
     const response = await api.login(username, password)
+    if ('challenge' in response) {
+      return response
+    }
+    completeLogin(response)
+    return null
+  }
+
+  const completeLogin = (response: LoginResponse) => {
     setUser(response.user)
     setRealUser(response.user)
   }
@@ -65,37 +83,19 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     setRealUser(null)
   }
 
-  const impersonate = (username: string) => {
-    // Store the real user and switch to impersonated user
-    // This is client-side only - API calls still use the real token
-    // The backend filters data based on impersonated user header
-    setUser({
-      id: username,
-      username: username,
-      email: `${username}@localhost`,
-      role: 'user', // Impersonated users are always non-admin
-    })
-    // Store impersonation in session
-    sessionStorage.setItem('impersonating', username)
-    api.setImpersonating(username)
+  const impersonate = async (username: string) => {
+    // The server issues a short-lived token acting as the user
+    await api.startImpersonation(username)
+    setUser(await api.getCurrentUser())
   }
 
-  const stopImpersonating = () => {
+  const stopImpersonating = async () => {
+    await api.stopImpersonation()
     if (realUser) {
       setUser(realUser)
     }
-    sessionStorage.removeItem('impersonating')
-    api.setImpersonating(null)
   }
 
-  // Restore impersonation from session on load
-  useEffect(() => {
-    const impersonating = sessionStorage.getItem('impersonating')
-    if (impersonating && realUser && realUser.role === 'admin') {
-      impersonate(impersonating)
-    }
-  }, [realUser])
-
   return (
     <AuthContext.Provider
       value={{
@@ -105,6 +105,7 @@ export function AuthProvider({ children }: { children: ReactNode }) {
         isLoading,
         isImpersonating,
         login,
+        completeLogin,
         logout,
         checkAuth,
         impersonate,
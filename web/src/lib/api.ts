@@ -1,14 +1,17 @@
-import type { Site, PHPInstance, Stats, LoginResponse, APIKey } from '@/types'
+import type { Site, PHPInstance, Stats, LoginResponse, LoginChallenge, TwoFactorSetup, APIKey, User } from '@/types'
 
 const API_BASE = '/api/v1'
 
 class APIClient {
   private token: string | null = null
-  private impersonating: string | null = null
+  private refreshToken: string | null = null
+  private refreshing: Promise<boolean> | null = null
+  private impersonationToken: string | null = null
 
   constructor() {
     this.token = localStorage.getItem('fastcp_token')
-    this.impersonating = sessionStorage.getItem('impersonating')
+    this.refreshToken = localStorage.getItem('fastcp_refresh_token')
+    this.impersonationToken = sessionStorage.getItem('fastcp_impersonation_token')
   }
 
   setToken(token: string | null) {
@@ -20,34 +23,75 @@ class APIClient {
     }
   }
 
+  setRefreshToken(token: string | null) {
+    this.refreshToken = token
+    if (token) {
+      localStorage.setItem('fastcp_refresh_token', token)
+    } else {
+      localStorage.removeItem('fastcp_refresh_token')
+    }
+  }
+
+  // Exchange the refresh token for a new access token, once for all
+  // requests that failed at the same time
+  private refresh(): Promise<boolean> {
+    if (!this.refreshToken) {
+      return Promise.resolve(false)
+    }
+    if (!this.refreshing) {
+      this.refreshing = fetch(`${API_BASE}/auth/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: this.refreshToken }),
+      })
+        .then(async (response) => {
+          if (!response.ok) {
+            return false
+          }
+          const data = await response.json()
+          this.setToken(data.token)
+          this.setRefreshToken(data.refresh_token)
+          return true
+        })
+        .catch(() => false)
+        .finally(() => {
+          this.refreshing = null
+        })
+    }
+    return this.refreshing
+  }
+
   getToken(): string | null {
     return this.token
   }
 
-  setImpersonating(username: string | null) {
-    this.impersonating = username
+  setImpersonationToken(token: string | null) {
+    this.impersonationToken = token
+    if (token) {
+      sessionStorage.setItem('fastcp_impersonation_token', token)
+    } else {
+      sessionStorage.removeItem('fastcp_impersonation_token')
+    }
   }
 
-  getImpersonating(): string | null {
-    return this.impersonating
+  isImpersonating(): boolean {
+    return this.impersonationToken !== null
   }
 
   private async request<T>(
     endpoint: string,
-    options: RequestInit = {}
+    options: RequestInit = {},
+    retry = true
   ): Promise<T> {
     const headers: Record<string, string> = {
       'Content-Type': 'application/json',
       ...((options.headers as Record<string, string>) || {}),
     }
 
-    if (this.token) {
-      headers['Authorization'] = `Bearer ${this.token}`
-    }
-
-    // Add impersonation header if impersonating
-    if (this.impersonating) {
-      headers['X-Impersonate-User'] = this.impersonating
+    // While impersonating, requests act as the user with the impersonation token
+    const token = this.impersonationToken || this.token
+    if (token) {
+      headers['Authorization'] = `Bearer ${token}`
     }
 
     const response = await fetch(`${API_BASE}${endpoint}`, {
@@ -55,8 +99,19 @@ class APIClient {
       headers,
     })
 
+    if (response.status === 401 && this.impersonationToken) {
+      // The impersonation expired or was ended, return to the real account
+      this.setImpersonationToken(null)
+      window.location.href = '/'
+      throw new Error('Impersonation ended')
+    }
+
     if (response.status === 401) {
+      if (retry && this.token && (await this.refresh())) {
+        return this.request<T>(endpoint, options, false)
+      }
       this.setToken(null)
+      this.setRefreshToken(null)
       window.location.href = '/login'
       throw new Error('Unauthorized')
     }
@@ -70,22 +125,81 @@ class APIClient {
     return data
   }
 
-  // Auth
-  async login(username: string, password: string): Promise<LoginResponse> {
-    const data = await this.request<LoginResponse>('/auth/login', {
+  // Login steps run without a session, so a 401 is a wrong password or
+  // code to show on the login page rather than an expired session
+  private async loginStep<T>(endpoint: string, body: object): Promise<T> {
+    const response = await fetch(`${API_BASE}${endpoint}`, {
       method: 'POST',
-      body: JSON.stringify({ username, password }),
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body),
     })
+    const data = await response.json()
+    if (!response.ok) {
+      throw new Error(data.error || 'Request failed')
+    }
+    return data
+  }
+
+  private startSession(data: LoginResponse): LoginResponse {
     this.setToken(data.token)
+    this.setRefreshToken(data.refresh_token ?? null)
     return data
   }
 
+  // Auth
+  async login(username: string, password: string): Promise<LoginResponse | LoginChallenge> {
+    const data = await this.loginStep<LoginResponse | LoginChallenge>('/auth/login', { username, password })
+    // With two-factor authentication the password only earns a challenge
+    if ('challenge' in data) {
+      return data
+    }
+    return this.startSession(data)
+  }
+
+  async verifyTwoFactor(challenge: string, code: string): Promise<LoginResponse> {
+    return this.startSession(await this.loginStep<LoginResponse>('/auth/2fa/verify', { challenge, code }))
+  }
+
+  async setupTwoFactor(challenge: string): Promise<TwoFactorSetup> {
+    return this.loginStep<TwoFactorSetup>('/auth/2fa/setup', { challenge })
+  }
+
+  async confirmTwoFactorSetup(challenge: string, code: string): Promise<LoginResponse> {
+    return this.startSession(await this.loginStep<LoginResponse>('/auth/2fa/setup/confirm', { challenge, code }))
+  }
+
   logout() {
+    if (this.token) {
+      // End the session on the server too; the local tokens go either way
+      fetch(`${API_BASE}/auth/logout`, {
+        method: 'POST',
+        headers: { Authorization: `Bearer ${this.token}` },
+      }).catch(() => {})
+    }
     this.setToken(null)
+    this.setRefreshToken(null)
+    this.setImpersonationToken(null)
   }
 
   async getCurrentUser() {
-    return this.request<{ id: string; username: string; role: string }>('/me')
+    return this.request<User>('/me')
+  }
+
+  // Impersonation
+  async startImpersonation(username: string) {
+    const data = await this.request<{ token: string; expires_at: string }>(
+      `/admin/impersonate/${encodeURIComponent(username)}`,
+      { method: 'POST' }
+    )
+    this.setImpersonationToken(data.token)
+  }
+
+  async stopImpersonation() {
+    try {
+      await this.request('/me/impersonation', { method: 'DELETE' })
+    } finally {
+      this.setImpersonationToken(null)
+    }
   }
 
   // Sites
@@ -442,6 +556,7 @@ export interface FastCPUser {
   enabled: boolean
   is_jailed: boolean
   shell_access: boolean
+  jailed_shell: boolean
   site_limit: number
   ram_limit_mb: number
   cpu_percent: number
@@ -467,6 +582,7 @@ export interface UpdateUserRequest {
   password?: string
   enabled?: boolean
   shell_access?: boolean
+  jailed_shell?: boolean
   site_limit?: number
   ram_limit_mb?: number
   cpu_percent?: number
@@ -494,6 +610,9 @@ export interface SSHKeyItem {
 
 export interface SSHServerSettings {
   password_auth_enabled: boolean
+  config_file?: string
+  drop_in?: boolean
+  effective?: Record<string, string>
 }
 
 // File Manager types
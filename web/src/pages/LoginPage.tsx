@@ -1,32 +1,41 @@
 import { useState } from 'react'
 import { useNavigate } from 'react-router-dom'
-import { Eye, EyeOff, Loader2, Zap, ArrowRight, Sun, Moon } from 'lucide-react'
+import { Eye, EyeOff, Loader2, Zap, ArrowRight, Sun, Moon, ShieldCheck } from 'lucide-react'
 import { useAuth } from '@/hooks/useAuth'
 import { useTheme } from '@/hooks/useTheme'
+import { api } from '@/lib/api'
+import type { LoginResponse, TwoFactorSetup } from '@/types'
+
+// The password step, the authenticator code of an enrolled account, the
+// enrollment of an account that must use two-factor authentication, and
+// the recovery codes handed out by the enrollment
+type Step = 'password' | 'code' | 'setup' | 'recovery'
 
 export function LoginPage() {
   const navigate = useNavigate()
-  const { login, isAuthenticated } = useAuth()
+  const { login, completeLogin, isAuthenticated } = useAuth()
   const { resolvedTheme, setTheme } = useTheme()
   const [username, setUsername] = useState('')
   const [password, setPassword] = useState('')
   const [showPassword, setShowPassword] = useState(false)
   const [isLoading, setIsLoading] = useState(false)
   const [error, setError] = useState('')
+  const [step, setStep] = useState<Step>('password')
+  const [challenge, setChallenge] = useState('')
+  const [code, setCode] = useState('')
+  const [setup, setSetup] = useState<TwoFactorSetup | null>(null)
+  const [enrolled, setEnrolled] = useState<LoginResponse | null>(null)
 
   if (isAuthenticated) {
     navigate('/', { replace: true })
     return null
   }
 
-  const handleSubmit = async (e: React.FormEvent) => {
-    e.preventDefault()
+  const run = async (action: () => Promise<void>) => {
     setError('')
     setIsLoading(true)
-
     try {
-      await login(username, password)
-      navigate('/')
+      await action()
     } catch (err) {
       setError(err instanceof Error ? err.message : 'Login failed')
     } finally {
@@ -34,6 +43,56 @@ export function LoginPage() {
     }
   }
 
+  const handleSubmit = (e: React.FormEvent) => {
+    e.preventDefault()
+    run(async () => {
+      const pending = await login(username, password)
+      if (!pending) {
+        navigate('/')
+        return
+      }
+      setChallenge(pending.challenge)
+      setCode('')
+      if (pending.two_factor_setup_required) {
+        setSetup(await api.setupTwoFactor(pending.challenge))
+        setStep('setup')
+      } else {
+        setStep('code')
+      }
+    })
+  }
+
+  const handleCode = (e: React.FormEvent) => {
+    e.preventDefault()
+    run(async () => {
+      if (step === 'setup') {
+        const response = await api.confirmTwoFactorSetup(challenge, code)
+        // Show the recovery codes before entering the panel
+        setEnrolled(response)
+        setStep('recovery')
+        return
+      }
+      completeLogin(await api.verifyTwoFactor(challenge, code))
+      navigate('/')
+    })
+  }
+
+  const finishEnrollment = () => {
+    if (enrolled) {
+      completeLogin(enrolled)
+      navigate('/')
+    }
+  }
+
+  const restart = () => {
+    setStep('password')
+    setChallenge('')
+    setCode('')
+    setSetup(null)
+    setPassword('')
+    setError('')
+  }
+
   const toggleTheme = () => {
     setTheme(resolvedTheme === 'dark' ? 'light' : 'dark')
   }
@@ -65,77 +124,173 @@ export function LoginPage() {
 
         {/* Login form */}
         <div className="bg-card border border-border rounded-2xl p-6 card-shadow">
-          <form onSubmit={handleSubmit} className="space-y-5">
-            {error && (
-              <div className="flex items-center gap-2 bg-red-500/10 border border-red-500/20 text-red-600 dark:text-red-400 px-4 py-3 rounded-xl text-sm">
-                <div className="w-1.5 h-1.5 rounded-full bg-red-500 flex-shrink-0" />
-                {error}
-              </div>
-            )}
-
-            <div className="space-y-2">
-              <label htmlFor="username" className="block text-sm font-medium">
-                Username
-              </label>
-              <input
-                id="username"
-                type="text"
-                value={username}
-                onChange={(e) => setUsername(e.target.value)}
-                className="w-full px-4 py-3 bg-secondary/50 border border-border rounded-xl focus:outline-none focus:ring-2 focus:ring-primary/50 focus:border-primary transition-colors"
-                placeholder="Enter your username"
-                required
-                autoComplete="username"
-              />
+          {error && (
+            <div className="flex items-center gap-2 bg-red-500/10 border border-red-500/20 text-red-600 dark:text-red-400 px-4 py-3 rounded-xl text-sm mb-5">
+              <div className="w-1.5 h-1.5 rounded-full bg-red-500 flex-shrink-0" />
+              {error}
             </div>
+          )}
 
-            <div className="space-y-2">
-              <label htmlFor="password" className="block text-sm font-medium">
-                Password
-              </label>
-              <div className="relative">
+          {step === 'password' && (
+            <form onSubmit={handleSubmit} className="space-y-5">
+              <div className="space-y-2">
+                <label htmlFor="username" className="block text-sm font-medium">
+                  Username
+                </label>
                 <input
-                  id="password"
-                  type={showPassword ? 'text' : 'password'}
-                  value={password}
-                  onChange={(e) => setPassword(e.target.value)}
-                  className="w-full px-4 py-3 pr-12 bg-secondary/50 border border-border rounded-xl focus:outline-none focus:ring-2 focus:ring-primary/50 focus:border-primary transition-colors"
-                  placeholder="Enter your password"
+                  id="username"
+                  type="text"
+                  value={username}
+                  onChange={(e) => setUsername(e.target.value)}
+                  className="w-full px-4 py-3 bg-secondary/50 border border-border rounded-xl focus:outline-none focus:ring-2 focus:ring-primary/50 focus:border-primary transition-colors"
+                  placeholder="Enter your username"
                   required
-                  autoComplete="current-password"
+                  autoComplete="username"
                 />
-                <button
-                  type="button"
-                  onClick={() => setShowPassword(!showPassword)}
-                  className="absolute right-3 top-1/2 -translate-y-1/2 p-1.5 text-muted-foreground hover:text-foreground rounded-lg transition-colors"
-                >
-                  {showPassword ? (
-                    <EyeOff className="w-4 h-4" />
-                  ) : (
-                    <Eye className="w-4 h-4" />
-                  )}
-                </button>
               </div>
-            </div>
 
-            <button
-              type="submit"
-              disabled={isLoading}
-              className="w-full py-3 px-4 bg-primary hover:bg-primary/90 text-primary-foreground font-medium rounded-xl transition-colors disabled:opacity-50 disabled:cursor-not-allowed flex items-center justify-center gap-2"
-            >
-              {isLoading ? (
-                <>
-                  <Loader2 className="w-4 h-4 animate-spin" />
-                  Signing in...
-                </>
-              ) : (
-                <>
-                  Sign In
-                  <ArrowRight className="w-4 h-4" />
-                </>
+              <div className="space-y-2">
+                <label htmlFor="password" className="block text-sm font-medium">
+                  Password
+                </label>
+                <div className="relative">
+                  <input
+                    id="password"
+                    type={showPassword ? 'text' : 'password'}
+                    value={password}
+                    onChange={(e) => setPassword(e.target.value)}
+                    className="w-full px-4 py-3 pr-12 bg-secondary/50 border border-border rounded-xl focus:outline-none focus:ring-2 focus:ring-primary/50 focus:border-primary transition-colors"
+                    placeholder="Enter your password"
+                    required
+                    autoComplete="current-password"
+                  />
+                  <button
+                    type="button"
+                    onClick={() => setShowPassword(!showPassword)}
+                    className="absolute right-3 top-1/2 -translate-y-1/2 p-1.5 text-muted-foreground hover:text-foreground rounded-lg transition-colors"
+                  >
+                    {showPassword ? (
+                      <EyeOff className="w-4 h-4" />
+                    ) : (
+                      <Eye className="w-4 h-4" />
+                    )}
+                  </button>
+                </div>
+              </div>
+
+              <button
+                type="submit"
+                disabled={isLoading}
+                className="w-full py-3 px-4 bg-primary hover:bg-primary/90 text-primary-foreground font-medium rounded-xl transition-colors disabled:opacity-50 disabled:cursor-not-allowed flex items-center justify-center gap-2"
+              >
+                {isLoading ? (
+                  <>
+                    <Loader2 className="w-4 h-4 animate-spin" />
+                    Signing in...
+                  </>
+                ) : (
+                  <>
+                    Sign In
+                    <ArrowRight className="w-4 h-4" />
+                  </>
+                )}
+              </button>
+            </form>
+          )}
+
+          {(step === 'code' || step === 'setup') && (
+            <form onSubmit={handleCode} className="space-y-5">
+              <div className="flex items-center gap-2 text-sm font-medium">
+                <ShieldCheck className="w-4 h-4 text-primary" />
+                Two-factor authentication
+              </div>
+
+              {step === 'setup' && setup && (
+                <div className="space-y-2 text-sm text-muted-foreground">
+                  <p>
+                    Your account requires two-factor authentication. Add it to an
+                    authenticator app with this key, or{' '}
+                    <a href={setup.provisioning_uri} className="text-primary hover:underline">
+                      open it in the app
+                    </a>
+                    :
+                  </p>
+                  <code className="block px-3 py-2 bg-secondary/50 border border-border rounded-lg font-mono text-xs text-foreground break-all select-all">
+                    {setup.secret}
+                  </code>
+                </div>
               )}
-            </button>
-          </form>
+
+              <div className="space-y-2">
+                <label htmlFor="code" className="block text-sm font-medium">
+                  {step === 'setup' ? 'Code from your app' : 'Authentication code'}
+                </label>
+                <input
+                  id="code"
+                  type="text"
+                  value={code}
+                  onChange={(e) => setCode(e.target.value.trim())}
+                  className="w-full px-4 py-3 bg-secondary/50 border border-border rounded-xl font-mono tracking-widest focus:outline-none focus:ring-2 focus:ring-primary/50 focus:border-primary transition-colors"
+                  placeholder={step === 'setup' ? '123456' : '123456 or recovery code'}
+                  required
+                  autoFocus
+                  autoComplete="one-time-code"
+                />
+              </div>
+
+              <button
+                type="submit"
+                disabled={isLoading}
+                className="w-full py-3 px-4 bg-primary hover:bg-primary/90 text-primary-foreground font-medium rounded-xl transition-colors disabled:opacity-50 disabled:cursor-not-allowed flex items-center justify-center gap-2"
+              >
+                {isLoading ? (
+                  <>
+                    <Loader2 className="w-4 h-4 animate-spin" />
+                    Verifying...
+                  </>
+                ) : (
+                  <>
+                    {step === 'setup' ? 'Enable and Sign In' : 'Verify'}
+                    <ArrowRight className="w-4 h-4" />
+                  </>
+                )}
+              </button>
+
+              <button
+                type="button"
+                onClick={restart}
+                className="w-full text-sm text-muted-foreground hover:text-foreground transition-colors"
+              >
+                Back to sign in
+              </button>
+            </form>
+          )}
+
+          {step === 'recovery' && enrolled && (
+            <div className="space-y-5">
+              <div className="flex items-center gap-2 text-sm font-medium">
+                <ShieldCheck className="w-4 h-4 text-primary" />
+                Save your recovery codes
+              </div>
+              <p className="text-sm text-muted-foreground">
+                Each code signs you in once if you lose your authenticator. They
+                are not shown again.
+              </p>
+              <div className="grid grid-cols-2 gap-2 px-3 py-3 bg-secondary/50 border border-border rounded-lg font-mono text-sm select-all">
+                {(enrolled.recovery_codes ?? []).map((recoveryCode) => (
+                  <span key={recoveryCode}>{recoveryCode}</span>
+                ))}
+              </div>
+              <button
+                type="button"
+                onClick={finishEnrollment}
+                className="w-full py-3 px-4 bg-primary hover:bg-primary/90 text-primary-foreground font-medium rounded-xl transition-colors flex items-center justify-center gap-2"
+              >
+                Continue
+                <ArrowRight className="w-4 h-4" />
+              </button>
+            </div>
+          )}
 
           <div className="mt-6 pt-5 border-t border-border">
             <div className="flex items-center justify-center gap-2 text-xs text-muted-foreground">
@@ -256,10 +256,11 @@ export function SettingsPage() {
     setIsUpdatingSSHSettings(true)
     try {
       await api.updateSSHSettings({ password_auth_enabled: enabled })
-      setSSHServerSettings({ password_auth_enabled: enabled })
     } catch (error) {
       console.error('Failed to update SSH settings:', error)
     } finally {
+      // Show what sshd uses, the change may have been rolled back
+      await fetchSSHServerSettings()
       setIsUpdatingSSHSettings(false)
     }
   }
@@ -525,6 +526,13 @@ export function SettingsPage() {
                 </button>
               </div>
               
+              {sshServerSettings.config_file && (
+                <p className="mt-3 px-4 text-xs text-muted-foreground">
+                  Managed in <code>{sshServerSettings.config_file}</code>
+                  {sshServerSettings.effective?.port && <> &middot; sshd listening on port {sshServerSettings.effective.port}</>}
+                </p>
+              )}
+
               {!sshServerSettings.password_auth_enabled && (
                 <div className="mt-3 flex items-start gap-2 px-4 py-3 bg-amber-500/10 border border-amber-500/20 rounded-xl">
                   <AlertCircle className="w-4 h-4 text-amber-500 flex-shrink-0 mt-0.5" />
@@ -98,6 +98,7 @@ export function UsersPage() {
   const [deleting, setDeleting] = useState(false)
   const [openMenu, setOpenMenu] = useState<string | null>(null)
 
+  const [jailedShell, setJailedShell] = useState(false)
   const [form, setForm] = useState<CreateUserRequest>({
     username: '',
     password: '',
@@ -161,6 +162,7 @@ export function UsersPage() {
         password: form.password || undefined,
         enabled: true,
         shell_access: form.shell_access,
+        jailed_shell: !form.shell_access && jailedShell,
         site_limit: form.site_limit,
         ram_limit_mb: form.ram_limit_mb,
         cpu_percent: form.cpu_percent,
@@ -219,14 +221,19 @@ export function UsersPage() {
       cpu_percent: user.cpu_percent,
       max_processes: user.max_processes,
     })
+    setJailedShell(user.jailed_shell)
     setShowEditModal(true)
     setError('')
     setOpenMenu(null)
   }
 
-  const handleImpersonate = (username: string) => {
-    impersonate(username)
-    navigate('/')
+  const handleImpersonate = async (username: string) => {
+    try {
+      await impersonate(username)
+      navigate('/')
+    } catch (err: any) {
+      alert(err.message || 'Failed to impersonate user')
+    }
   }
 
   const handleFixPermissions = async () => {
@@ -685,7 +692,29 @@ export function UsersPage() {
                     <p className="text-xs text-muted-foreground">
                       {form.shell_access 
                         ? "User can SSH with full shell access" 
-                        : "SFTP only - user is jailed to their home directory"}
+                        : jailedShell
+                          ? "Jailed shell - PHP, Composer, Git, WP-CLI and MySQL in a sandbox"
+                          : "SFTP only - user is jailed to their home directory"}
+                    </p>
+                  </div>
+                </div>
+              )}
+
+              {!selectedUser?.is_admin && !form.shell_access && (
+                <div className="flex items-center gap-3 p-3 bg-secondary/50 rounded-xl">
+                  <input
+                    type="checkbox"
+                    id="edit_jailed_shell"
+                    checked={jailedShell}
+                    onChange={(e) => setJailedShell(e.target.checked)}
+                    className="w-4 h-4 rounded border-border bg-secondary text-primary focus:ring-primary"
+                  />
+                  <div>
+                    <label htmlFor="edit_jailed_shell" className="text-sm font-medium">
+                      Allow jailed shell
+                    </label>
+                    <p className="text-xs text-muted-foreground">
+                      Interactive shell that only sees the user's home directory
                     </p>
                   </div>
                 </div>
@@ -3,6 +3,9 @@ export interface User {
   username: string
   email: string
   role: string
+  permissions?: string[]
+  impersonator?: { user_id: string; username: string; role: string }
+  impersonation_expires_at?: string
 }
 
 export interface Site {
@@ -22,6 +25,7 @@ export interface Site {
   ssl: boolean
   status: 'active' | 'suspended' | 'pending'
   environment: Record<string, string>
+  intercept_errors: boolean
   created_at: string
   updated_at: string
 }
@@ -65,8 +69,23 @@ export interface APIKey {
 
 export interface LoginResponse {
   token: string
+  refresh_token?: string
   expires_in: number
   user: User
+  recovery_codes?: string[]
+}
+
+// Answer to a correct password when a second login step is needed
+export interface LoginChallenge {
+  challenge: string
+  expires_in: number
+  two_factor_required?: boolean
+  two_factor_setup_required?: boolean
+}
+
+export interface TwoFactorSetup {
+  secret: string
+  provisioning_uri: string
 }
 
 export interface APIResponse<T> {